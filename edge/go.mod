@@ -10,6 +10,7 @@ require (
 	github.com/dgraph-io/badger v1.6.0
 	github.com/distatus/battery v0.10.0
 	github.com/dustin/go-humanize v1.0.0
+	github.com/eclipse/paho.golang v0.11.0
 	github.com/eclipse/paho.mqtt.golang v1.3.2
 	github.com/fxamacker/cbor/v2 v2.2.0
 	github.com/getsentry/sentry-go v0.10.0
@@ -17,7 +18,9 @@ require (
 	github.com/gin-gonic/gin v1.6.3
 	github.com/go-xmlfmt/xmlfmt v0.0.0-20191208150333-d5b6f63a941b
 	github.com/godbus/dbus/v5 v5.0.4
+	github.com/golang/snappy v0.0.4
 	github.com/google/uuid v1.2.0
+	github.com/gorilla/websocket v1.4.2
 	github.com/json-iterator/go v1.1.10
 	github.com/looplab/fsm v0.2.0
 	github.com/mitchellh/mapstructure v1.1.2
@@ -26,6 +29,8 @@ require (
 	github.com/sirupsen/logrus v1.7.0
 	github.com/spf13/viper v1.7.1
 	github.com/stretchr/testify v1.7.0
+	github.com/xeipuuv/gojsonschema v1.2.0
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
 	gopkg.in/guregu/null.v4 v4.0.0
 	gopkg.in/natefinch/lumberjack.v2 v2.0.0
 	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c
@@ -0,0 +1,44 @@
+package kronos
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"devais.it/kronos/internal/pkg/security"
+	"github.com/rotisserie/eris"
+)
+
+type generateKeyCmd struct {
+	Type     string `kong:"arg,name=type,enum='signing,encryption',help='Key type (signing,encryption)'"`
+	Filename string `kong:"arg,name=file,help='Private key file. The public key is saved to the same path with a .pub extension',type=file"`
+}
+
+func (c *generateKeyCmd) Run(*Context) error {
+	privatePEM, publicPEM, err := security.GenerateKey(security.KeyType(strings.ToUpper(c.Type)))
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(c.Filename, privatePEM, 0600)
+	if err != nil {
+		return eris.Wrapf(err, "failed to save private key to '%s'", c.Filename)
+	}
+
+	publicFilename := c.Filename + ".pub"
+	err = ioutil.WriteFile(publicFilename, publicPEM, 0644)
+	if err != nil {
+		return eris.Wrapf(err, "failed to save public key to '%s'", publicFilename)
+	}
+
+	keyID, err := security.PEMKeyID(publicPEM)
+	if err != nil {
+		return eris.Wrap(err, "failed to parse generated public key")
+	}
+
+	fmt.Printf("Private key saved to %s\n", c.Filename)
+	fmt.Printf("Public key saved to %s\n", publicFilename)
+	fmt.Printf("Key ID: %s\n", keyID)
+
+	return nil
+}
@@ -19,6 +19,7 @@ var (
 		PrintMessageSchema printMessageSchemaCmd `kong:"cmd,help='Print synchronization messages as JSON schema'"`
 		SaveMessageSchema  saveMessageSchemaCmd  `kong:"cmd,help='Save synchronization messages to JSON schema'"`
 		DumpDbusIntro      dumpDbusIntroCmd      `kong:"cmd,help='Print DBus introspectable XML file'"`
+		GenerateKey        generateKeyCmd        `kong:"cmd,help='Generate a key pair for messages signing or encryption'"`
 	}
 )
 
@@ -29,6 +29,10 @@ func messageFromString(messageStr string) (interface{}, error) {
 		message = &messages.CommandResponse{}
 	case "sync":
 		message = &messages.Sync{}
+	case "sync_ack":
+		message = &messages.SyncAck{}
+	case "frame":
+		message = &messages.Frame{}
 	default:
 		return nil, eris.Errorf("Invalid message: '%s'", messageStr)
 	}
@@ -69,7 +73,7 @@ func writeMessageSchema(w io.Writer, message interface{}, ident int) error {
 }
 
 type printMessageSchemaCmd struct {
-	Message string `kong:"arg,name=message,enum='connected,disconnected,event,versions,command,command_response,sync',help='Message type'"`
+	Message string `kong:"arg,name=message,enum='connected,disconnected,event,versions,command,command_response,sync,sync_ack,frame',help='Message type'"`
 	Ident   int    `kong:"arg,name=ident,optional,default=0,help=JSON ident"`
 }
 
@@ -83,7 +87,7 @@ func (c *printMessageSchemaCmd) Run(*Context) error {
 
 type saveMessageSchemaCmd struct {
 	Filename string `kong:"arg,name=file,help='Schema file',type=file"`
-	Message  string `kong:"arg,name=message,enum='connected,disconnected,event,versions,command,command_response,sync',help='Message type'"`
+	Message  string `kong:"arg,name=message,enum='connected,disconnected,event,versions,command,command_response,sync,sync_ack,frame',help='Message type'"`
 	Ident    int    `kong:"arg,name=ident,optional,default=0,help:'JSON ident'"`
 }
 
@@ -18,6 +18,7 @@ import (
 	"devais.it/kronos/internal/pkg/db"
 	"devais.it/kronos/internal/pkg/logging"
 	"devais.it/kronos/internal/pkg/prometheus"
+	"devais.it/kronos/internal/pkg/services"
 	"devais.it/kronos/internal/pkg/sync"
 	"devais.it/kronos/internal/pkg/version"
 	log "github.com/sirupsen/logrus"
@@ -96,6 +97,11 @@ func run(configFile string, verbose bool) int {
 
 	log.Info("Database ready")
 
+	err = services.LoadSchemaRegistry()
+	if err != nil {
+		logging.Panic(err, "Failed to load schema registry")
+	}
+
 	defer func() {
 		if err := db.Close(); err != nil {
 			logging.Error(err, "Failed to close database")
@@ -151,6 +157,7 @@ func run(configFile string, verbose bool) int {
 	// Set sync callback
 	if dbusServer != nil {
 		syncWorker.AddSyncCallback(dbusServer.SignalSyncEvent)
+		syncWorker.AddConflictCallback(dbusServer.SignalConflict)
 	}
 
 	err = syncWorker.Start()
@@ -1,6 +1,8 @@
 package dbus
 
 import (
+	"time"
+
 	"devais.it/kronos/internal/pkg/constants"
 	"devais.it/kronos/internal/pkg/db/models"
 	"devais.it/kronos/internal/pkg/serialization"
@@ -104,6 +106,40 @@ func (m *attributeMethods) GetValue(attributeID string) (messageType, *dbus.Erro
 	return m.serialize(value)
 }
 
+func (m *attributeMethods) GetTypedValue(attributeID string) (messageType, *dbus.Error) {
+	value, err := services.GetAttributeTypedValue(attributeID)
+	if err != nil {
+		return nilMessage, m.makeDbError(err)
+	}
+	return m.serialize(value)
+}
+
+func (m *attributeMethods) FindByValue(attributeType, op, value string, page, pageSize int) (messageType, *dbus.Error) {
+	attributes, err := services.FindAttributesByValue(attributeType, services.ValueOp(op), value, page, pageSize)
+	if err != nil {
+		return nilMessage, m.makeDbError(err)
+	}
+	return m.serialize(attributes)
+}
+
+func (m *attributeMethods) GetHistory(attributeID string, from, to uint64, page, pageSize int) (messageType, *dbus.Error) {
+	history, err := services.GetAttributeHistory(attributeID, from, to, page, pageSize)
+	if err != nil {
+		return nilMessage, m.makeDbError(err)
+	}
+	return m.serialize(history)
+}
+
+// GetHistoryBuckets downsamples the history of an attribute in buckets lasting bucketMs milliseconds
+func (m *attributeMethods) GetHistoryBuckets(attributeID string, from, to, bucketMs uint64) (messageType, *dbus.Error) {
+	bucket := time.Duration(bucketMs) * time.Millisecond
+	buckets, err := services.GetAttributeHistoryBuckets(attributeID, from, to, bucket)
+	if err != nil {
+		return nilMessage, m.makeDbError(err)
+	}
+	return m.serialize(buckets)
+}
+
 func (m *attributeMethods) Update(msg messageType) (messageType, *dbus.Error) {
 	var patch map[string]interface{}
 	return m.withSerializer(msg, &patch, func() (interface{}, *dbus.Error) {
@@ -119,6 +155,22 @@ func (m *attributeMethods) Update(msg messageType) (messageType, *dbus.Error) {
 	})
 }
 
+// Upsert updates an attribute, creating it if it doesn't exist
+func (m *attributeMethods) Upsert(msg messageType) (messageType, *dbus.Error) {
+	var patch map[string]interface{}
+	return m.withSerializer(msg, &patch, func() (interface{}, *dbus.Error) {
+		err := services.UpsertAttribute(patch, constants.ModifiedByDBusAPIName)
+		if err != nil {
+			return nil, m.makeDbError(err)
+		}
+		attribute, err := services.GetAttributeByID(patch["id"].(string))
+		if err != nil {
+			return nil, m.makeDbError(err)
+		}
+		return attribute, nil
+	})
+}
+
 func (m *attributeMethods) DeleteByID(attributeID string) (messageType, *dbus.Error) {
 	err := services.DeleteAttributeByID(attributeID, constants.ModifiedByDBusAPIName)
 	if err != nil {
@@ -143,6 +195,22 @@ func (m *attributeMethods) GetAll(page, pageSize int) (messageType, *dbus.Error)
 	return m.serialize(attributes)
 }
 
+func (m *attributeMethods) GetAllPage(cursor string, pageSize int) (messageType, *dbus.Error) {
+	return m.serializePage(services.GetAllAttributesPage(cursor, pageSize))
+}
+
+func (m *attributeMethods) GetByTypePage(attributeType, cursor string, pageSize int) (messageType, *dbus.Error) {
+	return m.serializePage(services.GetAttributesByTypePage(attributeType, cursor, pageSize))
+}
+
+func (m *attributeMethods) FindByNamePage(name, cursor string, pageSize int) (messageType, *dbus.Error) {
+	return m.serializePage(services.FindAttributesByNamePage(name, cursor, pageSize))
+}
+
+func (m *attributeMethods) Query(msg messageType) (messageType, *dbus.Error) {
+	return m.query(msg, services.QueryAttributes)
+}
+
 func (m *attributeMethods) Count() (int64, *dbus.Error) {
 	count, err := services.GetAttributesCount()
 	if err != nil {
@@ -0,0 +1,92 @@
+package dbus
+
+import (
+	"devais.it/kronos/internal/pkg/serialization"
+	"devais.it/kronos/internal/pkg/services"
+	"devais.it/kronos/internal/pkg/types"
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+)
+
+const (
+	onConflictSignalName = "OnConflict"
+)
+
+type conflictMethods struct {
+	methodsBase
+}
+
+func newConflictMethods(
+	interfaceName string,
+	serializer serialization.Serializer,
+	deserializer serialization.Deserializer) *conflictMethods {
+	return &conflictMethods{
+		methodsBase{
+			InterfaceName: interfaceName,
+			Serializer:    serializer,
+			Deserializer:  deserializer,
+		},
+	}
+}
+
+func (conflictMethods) getSignals() []introspect.Signal {
+	return []introspect.Signal{
+		{
+			Name: onConflictSignalName,
+			Args: []introspect.Arg{
+				{Name: "entity_id", Type: "s", Direction: "out"},
+				{Name: "entity_type", Type: "s", Direction: "out"},
+				{Name: "resolution", Type: "s", Direction: "out"},
+			},
+			Annotations: nil,
+		},
+	}
+}
+
+func (m *conflictMethods) GetByID(conflictID uint64) (messageType, *dbus.Error) {
+	conflict, err := services.GetConflictByID(uint(conflictID))
+	if err != nil {
+		return nilMessage, m.makeDbError(err)
+	}
+	return m.serialize(conflict)
+}
+
+func (m *conflictMethods) GetAll(page, pageSize int) (messageType, *dbus.Error) {
+	conflicts, err := services.GetAllConflicts(page, pageSize)
+	if err != nil {
+		return nilMessage, m.makeDbError(err)
+	}
+	return m.serialize(conflicts)
+}
+
+func (m *conflictMethods) GetByEntity(entityType, entityID string) (messageType, *dbus.Error) {
+	conflicts, err := services.GetEntityConflicts(types.EntityType(entityType), entityID)
+	if err != nil {
+		return nilMessage, m.makeDbError(err)
+	}
+	return m.serialize(conflicts)
+}
+
+func (m *conflictMethods) Count() (int64, *dbus.Error) {
+	count, err := services.GetConflictsCount()
+	if err != nil {
+		return 0, m.makeDbError(err)
+	}
+	return count, nil
+}
+
+func (m *conflictMethods) DeleteByID(conflictID uint64) (messageType, *dbus.Error) {
+	err := services.DeleteConflictByID(uint(conflictID))
+	if err != nil {
+		return nilMessage, m.makeDbError(err)
+	}
+	return m.serialize(conflictID)
+}
+
+func (m *conflictMethods) DeleteAll() *dbus.Error {
+	err := services.DeleteAllConflicts()
+	if err != nil {
+		return m.makeDbError(err)
+	}
+	return nil
+}
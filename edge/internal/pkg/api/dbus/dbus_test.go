@@ -2,13 +2,19 @@ package dbus
 
 import (
 	"devais.it/kronos/internal/pkg/config"
+	"devais.it/kronos/internal/pkg/constants"
 	"devais.it/kronos/internal/pkg/db"
 	"devais.it/kronos/internal/pkg/db/models"
 	"devais.it/kronos/internal/pkg/serialization"
+	"devais.it/kronos/internal/pkg/services"
+	"devais.it/kronos/internal/pkg/types"
 	"devais.it/kronos/internal/pkg/util"
 	"github.com/godbus/dbus/v5"
 	"github.com/google/uuid"
+	"github.com/rotisserie/eris"
 	"github.com/stretchr/testify/suite"
+	"gorm.io/gorm"
+	"strings"
 	"testing"
 )
 
@@ -200,6 +206,130 @@ func (s *DBusTestSuite) TestItems() {
 	s.AssertCount(iface, len(items)-1)
 }
 
+func (s *DBusTestSuite) TestConflicts() {
+	assert := s.Require()
+
+	iface := s.dbusConf.ConflictsInterfaceName
+
+	s.AssertCount(iface, 0)
+
+	conflict := &models.Conflict{
+		EntityType: types.EntityTypeItem,
+		EntityID:   "FakeItem00-ID",
+		Strategy:   types.ConflictStrategyServerWins,
+		Resolution: types.ConflictResolutionServer,
+	}
+
+	err := db.DB().Transaction(func(tx *gorm.DB) error {
+		return services.CreateConflictTx(&db.TxContext{Tx: tx}, conflict)
+	})
+	assert.NoError(err)
+
+	s.AssertCount(iface, 1)
+
+	var conflicts []models.Conflict
+	s.GetAll(iface, 1, 10, &conflicts)
+	assert.Len(conflicts, 1)
+
+	var resMsg messageType
+	s.CallMethod(iface, "GetByEntity", &resMsg, string(conflict.EntityType), conflict.EntityID)
+	assert.NoError(s.deserializer.Deserialize([]byte(resMsg), &conflicts))
+	assert.Len(conflicts, 1)
+	assert.Equal(types.ConflictResolutionServer, conflicts[0].Resolution)
+
+	s.CallMethod(iface, "DeleteByID", &resMsg, uint64(conflict.ID))
+	s.AssertCount(iface, 0)
+}
+
+func (s *DBusTestSuite) TestEvents() {
+	assert := s.Require()
+
+	iface := s.dbusConf.EventsInterfaceName
+
+	first := newItem()
+	second := newItem()
+	assert.NoError(services.CreateItem(first, constants.ModifiedByDBusAPIName))
+	assert.NoError(services.CreateItem(second, constants.ModifiedByDBusAPIName))
+
+	s.AssertCount(iface, 2)
+
+	filterBytes, err := s.serializer.Serialize(&services.EventsFilter{EntityID: second.ID})
+	assert.NoError(err)
+	filter := messageType(filterBytes)
+
+	var count int64
+	s.CallMethod(iface, "CountFiltered", &count, filter)
+	assert.EqualValues(1, count)
+
+	var events []models.Event
+	var resMsg messageType
+	s.CallMethod(iface, "GetAll", &resMsg, filter, 1, 10)
+	assert.NoError(s.deserializer.Deserialize([]byte(resMsg), &events))
+	assert.Len(events, 1)
+	assert.Equal(second.ID, events[0].EntityID)
+
+	event := models.Event{}
+	s.CallMethod(iface, "GetByID", &resMsg, uint64(events[0].ID))
+	assert.NoError(s.deserializer.Deserialize([]byte(resMsg), &event))
+	assert.Equal(events[0].ID, event.ID)
+
+	var ndjson string
+	s.CallMethod(iface, "Export", &ndjson, nilMessage)
+	assert.Equal(2, strings.Count(ndjson, "\n"))
+
+	s.CallMethod(iface, "PurgeEntity", &count, string(types.EntityTypeItem), first.ID)
+	assert.EqualValues(1, count)
+	s.AssertCount(iface, 1)
+
+	// Requeue the exported events
+	s.CallMethod(iface, "Requeue", &count, ndjson)
+	assert.EqualValues(2, count)
+	s.AssertCount(iface, 3)
+}
+
+func (s *DBusTestSuite) TestTransaction() {
+	assert := s.Require()
+
+	iface := s.dbusConf.TransactionInterfaceName
+
+	item := newItem()
+	operations := []services.TxOperation{
+		{
+			Action:     services.TxActionCreate,
+			EntityType: types.EntityTypeItem,
+			Entity:     map[string]interface{}{"id": item.ID, "name": item.Name, "type": item.Type},
+		},
+		{
+			Action:     services.TxActionUpdate,
+			EntityType: types.EntityTypeItem,
+			Entity:     map[string]interface{}{"id": item.ID, "name": "Renamed"},
+		},
+	}
+
+	var result services.TransactionResult
+	s.Create(iface, "Apply", operations, &result)
+	assert.True(result.Committed)
+	assert.Len(result.Results, 2)
+
+	created, err := services.GetItemByID(item.ID)
+	assert.NoError(err)
+	assert.Equal("Renamed", created.Name)
+}
+
+func TestMakeDbError(t *testing.T) {
+	iface := config.DefaultDBusConfig().ItemsInterfaceName
+
+	err := makeDbError(iface, eris.Wrap(services.ErrEventsQueueFull, "failed to publish event"))
+	if err.Name != iface+".Error.QueueFull" {
+		t.Errorf("unexpected error name '%s'", err.Name)
+	}
+
+	err = makeDbError(iface, eris.Wrap(gorm.ErrRecordNotFound, "missing"))
+	if err.Name != iface+".Error.NotFound" {
+		t.Errorf("unexpected error name '%s'", err.Name)
+	}
+}
+
 func TestDBusServer(t *testing.T) {
 	// Skip tests if running inside a Docker container as DBus
 	// is not supported
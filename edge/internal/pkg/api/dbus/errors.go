@@ -1,6 +1,10 @@
 package dbus
 
 import (
+	"devais.it/kronos/internal/pkg/db"
+	"devais.it/kronos/internal/pkg/db/models"
+	"devais.it/kronos/internal/pkg/schema"
+	"devais.it/kronos/internal/pkg/services"
 	"github.com/godbus/dbus/v5"
 	"github.com/rotisserie/eris"
 	"github.com/spf13/viper"
@@ -20,9 +24,22 @@ func makeDbError(iface string, err error) *dbus.Error {
 	if eris.Is(err, gorm.ErrRecordNotFound) {
 		return makeNotFoundError(iface, err)
 	}
-	if eris.Is(err, gorm.ErrInvalidData) {
+	if eris.Is(err, gorm.ErrInvalidData) ||
+		eris.Is(err, db.ErrInvalidPagination) ||
+		eris.Is(err, services.ErrInvalidValueOp) ||
+		eris.Is(err, services.ErrInvalidQuery) ||
+		eris.Is(err, services.ErrHistoryNotNumeric) ||
+		eris.Is(err, services.ErrInvalidHistoryBucket) ||
+		eris.Is(err, services.ErrInvalidTransaction) ||
+		eris.Is(err, models.ErrInvalidRelationType) {
 		return makeError(iface, "InvalidData", err)
 	}
+	if eris.Is(err, schema.ErrValidation) {
+		return makeError(iface, "ValidationFailed", err)
+	}
+	if eris.Is(err, services.ErrEventsQueueFull) {
+		return makeError(iface, "QueueFull", err)
+	}
 	return makeError(iface, "DbError", err)
 }
 
@@ -1,8 +1,11 @@
 package dbus
 
 import (
+	"strings"
+
 	"devais.it/kronos/internal/pkg/serialization"
 	"devais.it/kronos/internal/pkg/services"
+	"devais.it/kronos/internal/pkg/types"
 	"github.com/godbus/dbus/v5"
 	"github.com/godbus/dbus/v5/introspect"
 )
@@ -65,3 +68,90 @@ func (m *eventMethods) Count() (int64, *dbus.Error) {
 	}
 	return count, nil
 }
+
+// deserializeFilter deserializes an events filter, an empty message matches all events
+func (m *eventMethods) deserializeFilter(msg messageType) (*services.EventsFilter, *dbus.Error) {
+	filter := &services.EventsFilter{}
+	if msg == nilMessage {
+		return filter, nil
+	}
+	if dErr := m.deserialize(msg, filter); dErr != nil {
+		return nil, dErr
+	}
+	return filter, nil
+}
+
+func (m *eventMethods) GetAll(filterMsg messageType, page, pageSize int) (messageType, *dbus.Error) {
+	filter, dErr := m.deserializeFilter(filterMsg)
+	if dErr != nil {
+		return nilMessage, dErr
+	}
+	events, err := services.GetEvents(filter, page, pageSize)
+	if err != nil {
+		return nilMessage, m.makeDbError(err)
+	}
+	return m.serialize(events)
+}
+
+func (m *eventMethods) CountFiltered(filterMsg messageType) (int64, *dbus.Error) {
+	filter, dErr := m.deserializeFilter(filterMsg)
+	if dErr != nil {
+		return 0, dErr
+	}
+	count, err := services.GetEventsFilteredCount(filter)
+	if err != nil {
+		return 0, m.makeDbError(err)
+	}
+	return count, nil
+}
+
+func (m *eventMethods) GetByID(eventID uint64) (messageType, *dbus.Error) {
+	event, err := services.GetEventByID(uint(eventID))
+	if err != nil {
+		return nilMessage, m.makeDbError(err)
+	}
+	return m.serialize(event)
+}
+
+func (m *eventMethods) PurgeEntity(entityType, entityID string) (int64, *dbus.Error) {
+	count, err := services.PurgeEntityEvents(types.EntityType(entityType), entityID)
+	if err != nil {
+		return 0, m.makeDbError(err)
+	}
+	return count, nil
+}
+
+func (m *eventMethods) RequeueByID(eventID uint64) (messageType, *dbus.Error) {
+	event, err := services.RequeueEventByID(uint(eventID))
+	if err != nil {
+		return nilMessage, m.makeDbError(err)
+	}
+	return m.serialize(event)
+}
+
+// Requeue appends NDJSON events to the queue
+func (m *eventMethods) Requeue(ndjson string) (int64, *dbus.Error) {
+	events, err := services.ImportEvents(strings.NewReader(ndjson))
+	if err != nil {
+		return 0, m.makeDeserializationError(err)
+	}
+	count, err := services.RequeueEvents(events)
+	if err != nil {
+		return 0, m.makeDbError(err)
+	}
+	return int64(count), nil
+}
+
+// Export returns the events matching the filter as NDJSON
+func (m *eventMethods) Export(filterMsg messageType) (string, *dbus.Error) {
+	filter, dErr := m.deserializeFilter(filterMsg)
+	if dErr != nil {
+		return "", dErr
+	}
+	var builder strings.Builder
+	_, err := services.ExportEvents(&builder, filter)
+	if err != nil {
+		return "", m.makeDbError(err)
+	}
+	return builder.String(), nil
+}
@@ -120,6 +120,26 @@ func (m *itemMethods) GetAll(page, pageSize int) (messageType, *dbus.Error) {
 	return m.serialize(items)
 }
 
+func (m *itemMethods) GetAllPage(cursor string, pageSize int) (messageType, *dbus.Error) {
+	return m.serializePage(services.GetAllItemsPage(cursor, pageSize))
+}
+
+func (m *itemMethods) GetByTypePage(itemType, cursor string, pageSize int) (messageType, *dbus.Error) {
+	return m.serializePage(services.GetItemsByTypePage(itemType, cursor, pageSize))
+}
+
+func (m *itemMethods) FindByNamePage(name, cursor string, pageSize int) (messageType, *dbus.Error) {
+	return m.serializePage(services.FindItemsByNamePage(name, cursor, pageSize))
+}
+
+func (m *itemMethods) FindByTypePage(typeStr, cursor string, pageSize int) (messageType, *dbus.Error) {
+	return m.serializePage(services.FindItemsByTypePage(typeStr, cursor, pageSize))
+}
+
+func (m *itemMethods) Query(msg messageType) (messageType, *dbus.Error) {
+	return m.query(msg, services.QueryItems)
+}
+
 func (m *itemMethods) Update(msg messageType) (messageType, *dbus.Error) {
 	var patch map[string]interface{}
 	return m.withSerializer(msg, &patch, func() (interface{}, *dbus.Error) {
@@ -135,6 +155,22 @@ func (m *itemMethods) Update(msg messageType) (messageType, *dbus.Error) {
 	})
 }
 
+// Upsert updates an item, creating it if it doesn't exist
+func (m *itemMethods) Upsert(msg messageType) (messageType, *dbus.Error) {
+	var patch map[string]interface{}
+	return m.withSerializer(msg, &patch, func() (interface{}, *dbus.Error) {
+		err := services.UpsertItem(patch, constants.ModifiedByDBusAPIName)
+		if err != nil {
+			return nil, m.makeDbError(err)
+		}
+		item, err := services.GetItemByID(patch["id"].(string))
+		if err != nil {
+			return nil, m.makeDbError(err)
+		}
+		return item, nil
+	})
+}
+
 func (m *itemMethods) DeleteByID(itemID string) (messageType, *dbus.Error) {
 	err := services.DeleteItemByID(itemID, constants.ModifiedByDBusAPIName)
 	if err != nil {
@@ -143,6 +179,16 @@ func (m *itemMethods) DeleteByID(itemID string) (messageType, *dbus.Error) {
 	return m.serialize(itemID)
 }
 
+// PreviewDeleteByID returns the relations and attributes that would be deleted
+// along with an item, without deleting anything
+func (m *itemMethods) PreviewDeleteByID(itemID string) (messageType, *dbus.Error) {
+	cascade, err := services.PreviewDeleteItem(itemID)
+	if err != nil {
+		return nilMessage, m.makeDbError(err)
+	}
+	return m.serialize(cascade)
+}
+
 func (m *itemMethods) HardDeleteByID(itemID string) (messageType, *dbus.Error) {
 	err := services.HardDeleteItemByID(itemID, constants.ModifiedByDBusAPIName)
 	if err != nil {
@@ -199,6 +245,33 @@ func (m *itemMethods) GetChildrenByID(itemID string) (messageType, *dbus.Error)
 	return m.serialize(children)
 }
 
+// GetChildrenByIDAndType returns the children of an item through relations with the given type
+func (m *itemMethods) GetChildrenByIDAndType(itemID, relationType string) (messageType, *dbus.Error) {
+	children, err := services.GetItemChildrenByType(itemID, relationType)
+	if err != nil {
+		return nilMessage, m.makeDbError(err)
+	}
+	return m.serialize(children)
+}
+
+// GetParentsByIDAndType returns the parents of an item through relations with the given type
+func (m *itemMethods) GetParentsByIDAndType(itemID, relationType string) (messageType, *dbus.Error) {
+	parents, err := services.GetItemParentsByType(itemID, relationType)
+	if err != nil {
+		return nilMessage, m.makeDbError(err)
+	}
+	return m.serialize(parents)
+}
+
+// GetRelationsByIDAndType returns the relations of an item with the given type
+func (m *itemMethods) GetRelationsByIDAndType(itemID, relationType string) (messageType, *dbus.Error) {
+	relations, err := services.GetItemRelationsByType(itemID, relationType)
+	if err != nil {
+		return nilMessage, m.makeDbError(err)
+	}
+	return m.serialize(relations)
+}
+
 func (m *itemMethods) GetParentsByID(itemID string) (messageType, *dbus.Error) {
 	parents, err := services.GetItemParents(itemID)
 	if err != nil {
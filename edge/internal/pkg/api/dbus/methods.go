@@ -1,7 +1,9 @@
 package dbus
 
 import (
+	"devais.it/kronos/internal/pkg/db"
 	"devais.it/kronos/internal/pkg/serialization"
+	"devais.it/kronos/internal/pkg/services"
 	"github.com/godbus/dbus/v5"
 	"github.com/godbus/dbus/v5/introspect"
 	"github.com/spf13/viper"
@@ -73,6 +75,34 @@ func (m *methodsBase) withSerializer(
 	return m.serialize(result)
 }
 
+// query runs a structured entity query
+func (m *methodsBase) query(
+	msg messageType,
+	run func(query *services.EntityQuery) (interface{}, error)) (messageType, *dbus.Error) {
+	query := &services.EntityQuery{}
+	return m.withSerializer(msg, query, func() (interface{}, *dbus.Error) {
+		results, err := run(query)
+		if err != nil {
+			return nil, m.makeDbError(err)
+		}
+		return results, nil
+	})
+}
+
+// pageMessage is a page of results paginated by cursor, along with its metadata
+type pageMessage struct {
+	Results interface{} `json:"results"`
+	db.Page
+}
+
+// serializePage serializes a page of results paginated by cursor
+func (m *methodsBase) serializePage(results interface{}, page *db.Page, err error) (messageType, *dbus.Error) {
+	if err != nil {
+		return nilMessage, m.makeDbError(err)
+	}
+	return m.serialize(&pageMessage{Results: results, Page: *page})
+}
+
 func (m *methodsBase) makeSerializationError(err error) *dbus.Error {
 	return makeSerializationError(m.InterfaceName, err)
 }
@@ -5,6 +5,7 @@ import (
 	"devais.it/kronos/internal/pkg/db/models"
 	"devais.it/kronos/internal/pkg/serialization"
 	"devais.it/kronos/internal/pkg/services"
+	"devais.it/kronos/internal/pkg/util"
 	"github.com/godbus/dbus/v5"
 )
 
@@ -33,7 +34,7 @@ func (m *relationMethods) Create(msg messageType) (messageType, *dbus.Error) {
 			return nilMessage, m.makeDbError(err)
 		}
 		if m.replyCreatedData() {
-			relation, err = services.GetRelation(relation.ParentID, relation.ChildID)
+			relation, err = services.GetRelation(relation.ParentID, relation.ChildID, relation.Type)
 			if err != nil {
 				return nil, m.makeDbError(err)
 			}
@@ -52,28 +53,87 @@ func (m *relationMethods) GetAll(page, pageSize int) (messageType, *dbus.Error)
 	return m.serialize(relations)
 }
 
-func (m *relationMethods) Get(parentID, childID string) (messageType, *dbus.Error) {
-	relation, err := services.GetRelation(parentID, childID)
+func (m *relationMethods) GetAllPage(cursor string, pageSize int) (messageType, *dbus.Error) {
+	return m.serializePage(services.GetAllRelationsPage(cursor, pageSize))
+}
+
+func (m *relationMethods) Query(msg messageType) (messageType, *dbus.Error) {
+	return m.query(msg, services.QueryRelations)
+}
+
+// GetByType returns the relations with the given type, the empty one for untyped relations
+func (m *relationMethods) GetByType(relationType string, page, pageSize int) (messageType, *dbus.Error) {
+	relations, err := services.GetRelationsByType(relationType, page, pageSize)
+	if err != nil {
+		return nilMessage, m.makeDbError(err)
+	}
+	return m.serialize(relations)
+}
+
+func (m *relationMethods) CountByType(relationType string) (int64, *dbus.Error) {
+	count, err := services.GetRelationsByTypeCount(relationType)
+	if err != nil {
+		return 0, m.makeDbError(err)
+	}
+	return count, nil
+}
+
+func (m *relationMethods) Get(parentID, childID, relationType string) (messageType, *dbus.Error) {
+	relation, err := services.GetRelation(parentID, childID, relationType)
 	if err != nil {
 		return nilMessage, m.makeDbError(err)
 	}
 	return m.serialize(relation)
 }
 
-func (m *relationMethods) Delete(parentID, childID string) (messageType, *dbus.Error) {
-	err := services.DeleteRelation(parentID, childID, constants.ModifiedByDBusAPIName)
+// Update updates the relation identified by the parent_id, child_id and type fields of the patch
+func (m *relationMethods) Update(msg messageType) (messageType, *dbus.Error) {
+	var patch map[string]interface{}
+	return m.withSerializer(msg, &patch, func() (interface{}, *dbus.Error) {
+		key := &models.Relation{}
+		err := util.JSONToStruct(patch, key)
+		if err != nil {
+			return nil, m.makeDbError(err)
+		}
+		err = services.UpdateRelation(patch, constants.ModifiedByDBusAPIName)
+		if err != nil {
+			return nil, m.makeDbError(err)
+		}
+		relation, err := services.GetRelation(key.ParentID, key.ChildID, key.Type)
+		if err != nil {
+			return nil, m.makeDbError(err)
+		}
+		return relation, nil
+	})
+}
+
+func (m *relationMethods) Delete(parentID, childID, relationType string) (messageType, *dbus.Error) {
+	err := services.DeleteRelation(parentID, childID, relationType, constants.ModifiedByDBusAPIName)
 	if err != nil {
 		return nilMessage, m.makeDbError(err)
 	}
-	return m.serialize(&models.Relation{ParentID: parentID, ChildID: childID})
+	return m.serialize(&models.Relation{ParentID: parentID, ChildID: childID, Type: relationType})
 }
 
-func (m *relationMethods) HardDelete(parentID, childID string) (messageType, *dbus.Error) {
-	err := services.HardDeleteRelation(parentID, childID, constants.ModifiedByDBusAPIName)
+func (m *relationMethods) HardDelete(parentID, childID, relationType string) (messageType, *dbus.Error) {
+	err := services.HardDeleteRelation(parentID, childID, relationType, constants.ModifiedByDBusAPIName)
 	if err != nil {
 		return nilMessage, m.makeDbError(err)
 	}
-	return m.serialize(&models.Relation{ParentID: parentID, ChildID: childID})
+	return m.serialize(&models.Relation{ParentID: parentID, ChildID: childID, Type: relationType})
+}
+
+// Move moves an item from a parent to another one, returning the new relation
+func (m *relationMethods) Move(parentID, childID, relationType, newParentID string) (messageType, *dbus.Error) {
+	err := services.MoveItem(parentID, childID, relationType, newParentID, constants.ModifiedByDBusAPIName)
+	if err != nil {
+		return nilMessage, m.makeDbError(err)
+	}
+	relation, err := services.GetRelation(newParentID, childID, relationType)
+	if err != nil {
+		return nilMessage, m.makeDbError(err)
+	}
+	return m.serialize(relation)
 }
 
 func (m *relationMethods) Count() (int64, *dbus.Error) {
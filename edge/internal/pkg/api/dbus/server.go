@@ -103,6 +103,19 @@ func (s *Server) SignalSyncEvent(sync messages.Sync) {
 	}
 }
 
+func (s *Server) SignalConflict(entry messages.SyncAckEntry) {
+	err := s.conn.Emit(
+		dbus.ObjectPath(s.conf.PathName),
+		s.conf.ConflictsInterfaceName+"."+onConflictSignalName,
+		entry.EntityID,
+		entry.EntityType,
+		entry.Conflict,
+	)
+	if err != nil {
+		logging.Error(err, "failed to emit signal")
+	}
+}
+
 // exportMethods is a wrapper around godbus export functions.
 // It is used to export methods of a methods interface.
 func (s *Server) exportMethods(methods ...methods) error {
@@ -158,5 +171,7 @@ func buildAllMethods(
 		newAttributeMethods(conf.AttributesInterfaceName, serializer, deserializer),
 		newEventMethods(conf.EventsInterfaceName, serializer, deserializer),
 		newConfigMethods(conf.ConfigInterfaceName, serializer, deserializer),
+		newConflictMethods(conf.ConflictsInterfaceName, serializer, deserializer),
+		newTransactionMethods(conf.TransactionInterfaceName, serializer, deserializer),
 	}
 }
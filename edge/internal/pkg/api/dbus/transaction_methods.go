@@ -0,0 +1,39 @@
+package dbus
+
+import (
+	"devais.it/kronos/internal/pkg/constants"
+	"devais.it/kronos/internal/pkg/serialization"
+	"devais.it/kronos/internal/pkg/services"
+	"github.com/godbus/dbus/v5"
+)
+
+type transactionMethods struct {
+	methodsBase
+}
+
+func newTransactionMethods(
+	interfaceName string,
+	serializer serialization.Serializer,
+	deserializer serialization.Deserializer) *transactionMethods {
+	return &transactionMethods{
+		methodsBase{
+			InterfaceName: interfaceName,
+			Serializer:    serializer,
+			Deserializer:  deserializer,
+		},
+	}
+}
+
+// Apply applies a list of operations in a single transaction.
+// Rolled back transactions aren't reported as errors: the replied result
+// is not committed and reports which operations failed.
+func (m *transactionMethods) Apply(msg messageType) (messageType, *dbus.Error) {
+	var operations []services.TxOperation
+	return m.withSerializer(msg, &operations, func() (interface{}, *dbus.Error) {
+		result, err := services.ApplyTransaction(operations, constants.ModifiedByDBusAPIName)
+		if result == nil {
+			return nil, m.makeDbError(err)
+		}
+		return result, nil
+	})
+}
@@ -66,7 +66,15 @@ func (m *attributeMethods) updateByID(c *gin.Context) {
 
 	patch["id"] = id
 
-	err = services.UpdateAttribute(patch, constants.ModifiedByHTTPAPIName)
+	// Upsert creates the attribute if it doesn't exist
+	_, upsert := c.GetQuery("upsert")
+
+	if upsert {
+		err = services.UpsertAttribute(patch, constants.ModifiedByHTTPAPIName)
+	} else {
+		err = services.UpdateAttribute(patch, constants.ModifiedByHTTPAPIName)
+	}
+
 	if err != nil {
 		m.writeServiceError(c, err)
 		return
@@ -109,6 +117,12 @@ func (m *attributeMethods) getAll(c *gin.Context) {
 		return
 	}
 
+	if pagination.Cursor != nil {
+		results, page, err := services.GetAllAttributesPage(*pagination.Cursor, pagination.PageSize)
+		m.writePage(c, results, page, err)
+		return
+	}
+
 	attributes, err := services.GetAllAttributes(pagination.Page, pagination.PageSize)
 	if err != nil {
 		m.writeServiceError(c, err)
@@ -130,7 +144,17 @@ func (m *attributeMethods) count(c *gin.Context) {
 
 func (m *attributeMethods) getValue(c *gin.Context) {
 	attributeID := c.Param("id")
-	value, err := services.GetAttributeValue(attributeID)
+	_, typed := c.GetQuery("typed")
+
+	var value interface{}
+	var err error
+
+	if typed {
+		value, err = services.GetAttributeTypedValue(attributeID)
+	} else {
+		value, err = services.GetAttributeValue(attributeID)
+	}
+
 	if err != nil {
 		m.writeServiceError(c, err)
 		return
@@ -149,6 +173,12 @@ func (m *attributeMethods) getByType(c *gin.Context) {
 		return
 	}
 
+	if pagination.Cursor != nil {
+		results, page, err := services.GetAttributesByTypePage(attributeType, *pagination.Cursor, pagination.PageSize)
+		m.writePage(c, results, page, err)
+		return
+	}
+
 	attributes, err := services.GetAttributesByType(
 		attributeType,
 		pagination.Page,
@@ -162,6 +192,72 @@ func (m *attributeMethods) getByType(c *gin.Context) {
 	c.JSON(http.StatusOK, attributes)
 }
 
+func (m *attributeMethods) findByValue(c *gin.Context) {
+	attributeType := c.Param("attribute_type")
+
+	var query valueQuery
+	err := c.BindQuery(&query)
+	if err != nil {
+		m.writeError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	attributes, err := services.FindAttributesByValue(
+		attributeType,
+		services.ValueOp(query.Op),
+		query.Value,
+		query.Page,
+		query.PageSize,
+	)
+	if err != nil {
+		m.writeServiceError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, attributes)
+}
+
+func (m *attributeMethods) getHistory(c *gin.Context) {
+	attributeID := c.Param("id")
+
+	var query historyQuery
+	err := c.BindQuery(&query)
+	if err != nil {
+		m.writeError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	var history interface{}
+
+	if query.Bucket != 0 {
+		history, err = services.GetAttributeHistoryBuckets(
+			attributeID,
+			query.From,
+			query.To,
+			query.Bucket,
+		)
+	} else {
+		history, err = services.GetAttributeHistory(
+			attributeID,
+			query.From,
+			query.To,
+			query.Page,
+			query.PageSize,
+		)
+	}
+
+	if err != nil {
+		m.writeServiceError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, history)
+}
+
+func (m *attributeMethods) queryAttributes(c *gin.Context) {
+	m.query(c, services.QueryAttributes)
+}
+
 func newAttributeMethods(engine *gin.Engine, conf *config.HTTPConfig, rootPath string) *attributeMethods {
 	g := engine.Group(rootPath)
 
@@ -173,9 +269,12 @@ func newAttributeMethods(engine *gin.Engine, conf *config.HTTPConfig, rootPath s
 		POST("/attributes", m.create).
 		GET("/attributes", m.getAll).
 		GET("/attributes/type/:attribute_type", m.getByType).
+		GET("/attributes/type/:attribute_type/value", m.findByValue).
+		POST("/attributes/query", m.queryAttributes).
 		GET("/attribute/:id", m.getByID).
 		PUT("/attribute/:id", m.updateByID).
 		GET("/attribute/:id/value", m.getValue).
+		GET("/attribute/:id/history", m.getHistory).
 		DELETE("/attribute/:id", m.deleteByID).
 		GET("/attributes/count", m.count)
 
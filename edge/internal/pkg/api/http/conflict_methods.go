@@ -0,0 +1,119 @@
+package http
+
+import (
+	"devais.it/kronos/internal/pkg/config"
+	"devais.it/kronos/internal/pkg/db/models"
+	"devais.it/kronos/internal/pkg/services"
+	"devais.it/kronos/internal/pkg/types"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+type conflictMethods struct {
+	methods
+}
+
+type conflictURI struct {
+	ID uint `uri:"conflict_id" binding:"required"`
+}
+
+type conflictsQuery struct {
+	paginationQuery
+	EntityType string `form:"entity_type"`
+	EntityID   string `form:"entity_id"`
+}
+
+func (m *conflictMethods) getAll(c *gin.Context) {
+	var query conflictsQuery
+	err := c.BindQuery(&query)
+	if err != nil {
+		m.writeError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	var conflicts []models.Conflict
+
+	if query.EntityType != "" && query.EntityID != "" {
+		conflicts, err = services.GetEntityConflicts(types.EntityType(query.EntityType), query.EntityID)
+	} else {
+		conflicts, err = services.GetAllConflicts(query.Page, query.PageSize)
+	}
+
+	if err != nil {
+		m.writeServiceError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, conflicts)
+}
+
+func (m *conflictMethods) count(c *gin.Context) {
+	count, err := services.GetConflictsCount()
+	if err != nil {
+		m.writeServiceError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"count": count})
+}
+
+func (m *conflictMethods) getByID(c *gin.Context) {
+	var uri conflictURI
+	err := c.ShouldBindUri(&uri)
+	if err != nil {
+		m.writeError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	conflict, err := services.GetConflictByID(uri.ID)
+	if err != nil {
+		m.writeServiceError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, conflict)
+}
+
+func (m *conflictMethods) deleteByID(c *gin.Context) {
+	var uri conflictURI
+	err := c.ShouldBindUri(&uri)
+	if err != nil {
+		m.writeError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	err = services.DeleteConflictByID(uri.ID)
+	if err != nil {
+		m.writeServiceError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"id": uri.ID})
+}
+
+func (m *conflictMethods) deleteAll(c *gin.Context) {
+	err := services.DeleteAllConflicts()
+	if err != nil {
+		m.writeServiceError(c, err)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+func newConflictMethods(engine *gin.Engine, conf *config.HTTPConfig, rootPath string) *conflictMethods {
+	g := engine.Group(rootPath)
+
+	m := &conflictMethods{
+		methods{router: g, conf: conf},
+	}
+
+	g.
+		GET("/conflicts", m.getAll).
+		GET("/conflicts/count", m.count).
+		DELETE("/conflicts", m.deleteAll).
+		GET("/conflict/:conflict_id", m.getByID).
+		DELETE("/conflict/:conflict_id", m.deleteByID)
+
+	return m
+}
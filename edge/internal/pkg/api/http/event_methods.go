@@ -1,18 +1,63 @@
 package http
 
 import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
 	"devais.it/kronos/internal/pkg/config"
+	"devais.it/kronos/internal/pkg/db/models"
+	"devais.it/kronos/internal/pkg/logging"
 	"devais.it/kronos/internal/pkg/services"
+	"devais.it/kronos/internal/pkg/types"
 	"github.com/gin-gonic/gin"
-	"net/http"
 )
 
+const ndjsonContentType = "application/x-ndjson"
+
 type eventMethods struct {
 	methods
 }
 
+type eventURI struct {
+	ID uint `uri:"event_id" binding:"required"`
+}
+
+type eventsQuery struct {
+	paginationQuery
+	EntityType string `form:"entity_type"`
+	EntityID   string `form:"entity_id"`
+	EventType  string `form:"event_type"`
+	TxUUID     string `form:"tx_uuid"`
+	From       uint64 `form:"from"`
+	To         uint64 `form:"to"`
+}
+
+type entityEventsQuery struct {
+	EntityType string `form:"entity_type" binding:"required"`
+	EntityID   string `form:"entity_id" binding:"required"`
+}
+
+func (q *eventsQuery) filter() *services.EventsFilter {
+	return &services.EventsFilter{
+		EntityType: types.EntityType(q.EntityType),
+		EntityID:   q.EntityID,
+		EventType:  types.EventType(q.EventType),
+		TxUUID:     q.TxUUID,
+		From:       q.From,
+		To:         q.To,
+	}
+}
+
 func (m *eventMethods) count(c *gin.Context) {
-	count, err := services.GetEventsCount()
+	var query eventsQuery
+	err := c.BindQuery(&query)
+	if err != nil {
+		m.writeError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	count, err := services.GetEventsFilteredCount(query.filter())
 	if err != nil {
 		m.writeServiceError(c, err)
 		return
@@ -39,6 +84,121 @@ func (m *eventMethods) getLast(c *gin.Context) {
 	c.JSON(http.StatusOK, event)
 }
 
+func (m *eventMethods) getAll(c *gin.Context) {
+	var query eventsQuery
+	err := c.BindQuery(&query)
+	if err != nil {
+		m.writeError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	events, err := services.GetEvents(query.filter(), query.Page, query.PageSize)
+	if err != nil {
+		m.writeServiceError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, events)
+}
+
+func (m *eventMethods) getByID(c *gin.Context) {
+	var uri eventURI
+	err := c.ShouldBindUri(&uri)
+	if err != nil {
+		m.writeError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	event, err := services.GetEventByID(uri.ID)
+	if err != nil {
+		m.writeServiceError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, event)
+}
+
+func (m *eventMethods) purge(c *gin.Context) {
+	var query entityEventsQuery
+	err := c.BindQuery(&query)
+	if err != nil {
+		m.writeError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	count, err := services.PurgeEntityEvents(types.EntityType(query.EntityType), query.EntityID)
+	if err != nil {
+		m.writeServiceError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"count": count})
+}
+
+func (m *eventMethods) requeueByID(c *gin.Context) {
+	var uri eventURI
+	err := c.ShouldBindUri(&uri)
+	if err != nil {
+		m.writeError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	event, err := services.RequeueEventByID(uri.ID)
+	if err != nil {
+		m.writeServiceError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, event)
+}
+
+// requeue appends events to the queue.
+// Events are read as NDJSON, unless the request content is a JSON array.
+func (m *eventMethods) requeue(c *gin.Context) {
+	var events []models.Event
+	var err error
+
+	if c.ContentType() == gin.MIMEJSON {
+		err = json.NewDecoder(c.Request.Body).Decode(&events)
+		if err != nil {
+			m.writeError(c, http.StatusBadRequest, err)
+			return
+		}
+	} else {
+		events, err = services.ImportEvents(c.Request.Body)
+		if err != nil {
+			m.writeServiceError(c, err)
+			return
+		}
+	}
+
+	count, err := services.RequeueEvents(events)
+	if err != nil {
+		m.writeServiceError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"count": count})
+}
+
+func (m *eventMethods) export(c *gin.Context) {
+	var query eventsQuery
+	err := c.BindQuery(&query)
+	if err != nil {
+		m.writeError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.Header("Content-Type", ndjsonContentType)
+	c.Status(http.StatusOK)
+
+	count, err := services.ExportEvents(c.Writer, query.filter())
+	if err != nil {
+		// Headers are already sent, the truncated stream is the only signal left
+		logging.Error(err, fmt.Sprintf("Events export failed after %d events", count))
+	}
+}
+
 func newEventMethods(engine *gin.Engine, conf *config.HTTPConfig, rootPath string) *eventMethods {
 	g := engine.Group(rootPath)
 
@@ -47,9 +207,15 @@ func newEventMethods(engine *gin.Engine, conf *config.HTTPConfig, rootPath strin
 	}
 
 	g.
+		GET("/events", m.getAll).
+		DELETE("/events", m.purge).
 		GET("/events/first", m.getFirst).
 		GET("/events/last", m.getLast).
-		GET("/events/count", m.count)
+		GET("/events/count", m.count).
+		GET("/events/export", m.export).
+		POST("/events/requeue", m.requeue).
+		GET("/event/:event_id", m.getByID).
+		POST("/event/:event_id/requeue", m.requeueByID)
 
 	return m
 }
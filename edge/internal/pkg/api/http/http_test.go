@@ -7,11 +7,15 @@ import (
 	"devais.it/kronos/internal/pkg/db"
 	"devais.it/kronos/internal/pkg/db/models"
 	"devais.it/kronos/internal/pkg/services"
+	"devais.it/kronos/internal/pkg/types"
 	"encoding/json"
+	"fmt"
 	"github.com/stretchr/testify/suite"
+	"gorm.io/gorm"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 )
@@ -280,6 +284,474 @@ func (s *HTTPSuite) TestDeleteItems() {
 	assert.Equal(http.StatusNotFound, resp.StatusCode)
 }
 
+func (s *HTTPSuite) TestConflicts() {
+	assert := s.Require()
+
+	resp := s.Delete("/conflict/1")
+	assert.Equal(http.StatusNotFound, resp.StatusCode)
+	assert.NoError(resp.Body.Close())
+
+	conflict := &models.Conflict{
+		EntityType: types.EntityTypeItem,
+		EntityID:   "FakeItem00-ID",
+		Action:     "UPDATE",
+		Strategy:   types.ConflictStrategyEdgeWins,
+		Resolution: types.ConflictResolutionEdge,
+	}
+
+	err := db.DB().Transaction(func(tx *gorm.DB) error {
+		return services.CreateConflictTx(&db.TxContext{Tx: tx}, conflict)
+	})
+	assert.NoError(err)
+
+	var count map[string]int64
+	s.GetJSON("/conflicts/count", &count)
+	assert.Equal(int64(1), count["count"])
+
+	var conflicts []models.Conflict
+	s.GetJSON("/conflicts?entity_type=ITEM&entity_id=FakeItem00-ID", &conflicts)
+	assert.Len(conflicts, 1)
+	assert.Equal(types.ConflictResolutionEdge, conflicts[0].Resolution)
+
+	gotConflict := &models.Conflict{}
+	s.GetJSON(fmt.Sprintf("/conflict/%d", conflict.ID), gotConflict)
+	assert.Equal(conflict.EntityID, gotConflict.EntityID)
+
+	resp = s.Delete(fmt.Sprintf("/conflict/%d", conflict.ID))
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	assert.NoError(resp.Body.Close())
+
+	s.GetJSON("/conflicts", &conflicts)
+	assert.Empty(conflicts)
+}
+
+func (s *HTTPSuite) TestEvents() {
+	assert := s.Require()
+
+	first := &models.Item{ID: "FakeItem00-ID", Name: "FakeItem00", Type: "FakeItem"}
+	second := &models.Item{ID: "FakeItem01-ID", Name: "FakeItem01", Type: "FakeItem"}
+	assert.NoError(services.CreateItem(first, constants.ModifiedByHTTPAPIName))
+	assert.NoError(services.CreateItem(second, constants.ModifiedByHTTPAPIName))
+
+	var count map[string]int64
+	s.GetJSON("/events/count", &count)
+	assert.EqualValues(2, count["count"])
+
+	s.GetJSON("/events/count?entity_id="+second.ID, &count)
+	assert.EqualValues(1, count["count"])
+
+	var events []models.Event
+	s.GetJSON("/events?event_type=ENTITY_CREATED&page=1&page_size=1", &events)
+	assert.Len(events, 1)
+	assert.Equal(first.ID, events[0].EntityID)
+	firstEvent := events[0]
+
+	s.GetJSON("/events?entity_type=ITEM&entity_id="+second.ID, &events)
+	assert.Len(events, 1)
+	assert.Equal(second.ID, events[0].EntityID)
+
+	var event models.Event
+	s.GetJSON(fmt.Sprintf("/event/%d", events[0].ID), &event)
+	assert.Equal(events[0].ID, event.ID)
+
+	resp, err := http.Get(s.url + "/event/123456")
+	assert.NoError(err)
+	assert.NoError(resp.Body.Close())
+	assert.Equal(http.StatusNotFound, resp.StatusCode)
+
+	// Requeue moves the event to the end of the queue
+	s.PostJSON(fmt.Sprintf("/event/%d/requeue", firstEvent.ID), nil, &event)
+	assert.Equal(first.ID, event.EntityID)
+	s.GetJSON("/events", &events)
+	assert.Len(events, 2)
+	assert.Equal(second.ID, events[0].EntityID)
+	assert.Equal(first.ID, events[1].EntityID)
+
+	// Export
+	ndjson := s.GetString("/events/export")
+	assert.Equal(2, strings.Count(ndjson, "\n"))
+
+	// Purge
+	resp = s.Delete("/events?entity_type=ITEM&entity_id=" + first.ID)
+	assert.NoError(resp.Body.Close())
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	s.GetJSON("/events/count", &count)
+	assert.EqualValues(1, count["count"])
+
+	resp = s.Delete("/events")
+	assert.NoError(resp.Body.Close())
+	assert.Equal(http.StatusBadRequest, resp.StatusCode)
+
+	// Requeue exported events
+	resp, err = http.Post(s.url+"/events/requeue", ndjsonContentType, strings.NewReader(ndjson))
+	assert.NoError(err)
+	assert.NoError(resp.Body.Close())
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	s.GetJSON("/events/count", &count)
+	assert.EqualValues(3, count["count"])
+
+	resp, err = http.Post(s.url+"/events/requeue", ndjsonContentType, strings.NewReader("{invalid"))
+	assert.NoError(err)
+	assert.NoError(resp.Body.Close())
+	assert.Equal(http.StatusBadRequest, resp.StatusCode)
+}
+
+func (s *HTTPSuite) TestEventsQueueFull() {
+	assert := s.Require()
+
+	queueConf := &db.Config().EventsQueue
+	defer func(conf config.EventsQueueConfig) {
+		*queueConf = conf
+	}(*queueConf)
+
+	queueConf.MaxEvents = 1
+	queueConf.OverflowPolicy = types.QueueOverflowReject
+
+	var created []models.Item
+	s.PostJSON("/items", []models.Item{{ID: "Queued-ID", Name: "Queued", Type: "FakeItem"}}, &created)
+
+	body, err := json.Marshal([]models.Item{{ID: "Rejected-ID", Name: "Rejected", Type: "FakeItem"}})
+	assert.NoError(err)
+
+	resp, err := http.Post(s.url+"/items", "application/json", bytes.NewBuffer(body))
+	assert.NoError(err)
+	assert.NoError(resp.Body.Close())
+	assert.Equal(http.StatusInsufficientStorage, resp.StatusCode)
+
+	// The write is rolled back along with its event
+	_, err = services.GetItemByID("Rejected-ID")
+	assert.ErrorIs(err, gorm.ErrRecordNotFound)
+}
+
+func (s *HTTPSuite) TestAttributeValues() {
+	assert := s.Require()
+
+	item := models.Item{
+		ID:   "Sensor-ID",
+		Name: "Sensor",
+		Type: "FakeItem",
+		Attributes: []models.Attribute{{
+			ID:        "Temperature-ID",
+			Name:      "temperature",
+			Type:      "temperature",
+			Value:     "35.5",
+			ValueType: "float",
+		}},
+	}
+	assert.NoError(services.CreateItem(&item, constants.ModifiedByHTTPAPIName))
+
+	var value map[string]interface{}
+	s.GetJSON("/attribute/Temperature-ID/value", &value)
+	assert.Equal("35.5", value["value"])
+
+	s.GetJSON("/attribute/Temperature-ID/value?typed", &value)
+	assert.Equal(35.5, value["value"])
+	assert.Equal("float", value["base_type"])
+
+	s.GetJSON("/item/Sensor-ID/attribute/name/temperature/value?typed", &value)
+	assert.Equal(35.5, value["value"])
+
+	var attributes []models.Attribute
+	s.GetJSON("/attributes/type/temperature/value?op=gt&value=30", &attributes)
+	assert.Len(attributes, 1)
+	assert.Equal("Temperature-ID", attributes[0].ID)
+
+	s.GetJSON("/attributes/type/temperature/value?op=lt&value=30", &attributes)
+	assert.Empty(attributes)
+
+	resp, err := http.Get(s.url + "/attributes/type/temperature/value?op=like&value=30")
+	assert.NoError(err)
+	assert.NoError(resp.Body.Close())
+	assert.Equal(http.StatusBadRequest, resp.StatusCode)
+}
+
+func (s *HTTPSuite) TestAttributeHistory() {
+	assert := s.Require()
+
+	historyConf := &db.Config().History
+	defer func(conf config.HistoryConfig) {
+		*historyConf = conf
+	}(*historyConf)
+
+	historyConf.Attributes = []string{"Humidity-ID"}
+	historyConf.MaxAge = 0
+
+	item := models.Item{
+		ID:   "Hygrometer-ID",
+		Name: "Hygrometer",
+		Type: "FakeItem",
+		Attributes: []models.Attribute{{
+			ID:        "Humidity-ID",
+			Name:      "humidity",
+			Type:      "humidity",
+			Value:     "40",
+			ValueType: "int",
+			SyncModel: models.SyncModel{BaseModel: models.BaseModel{SourceTimestamp: 1000}},
+		}},
+	}
+	assert.NoError(services.CreateItem(&item, constants.ModifiedByHTTPAPIName))
+
+	err := services.UpdateAttribute(map[string]interface{}{
+		"id":               "Humidity-ID",
+		"value":            "60",
+		"source_timestamp": 1500,
+	}, constants.ModifiedByHTTPAPIName)
+	assert.NoError(err)
+
+	var history []models.AttributeHistory
+	s.GetJSON("/attribute/Humidity-ID/history?from=1200", &history)
+	assert.Len(history, 1)
+	assert.Equal("60", history[0].Value)
+
+	var buckets []models.HistoryBucket
+	s.GetJSON("/attribute/Humidity-ID/history?bucket=1s", &buckets)
+	assert.Len(buckets, 1)
+	assert.Equal(50.0, buckets[0].Avg)
+	assert.Equal(int64(2), buckets[0].Count)
+}
+
+func (s *HTTPSuite) TestQuery() {
+	assert := s.Require()
+
+	items := []models.Item{
+		{ID: "Lamp-ID", Name: "Lamp", Type: "FakeItem"},
+		{ID: "Switch-ID", Name: "Switch", Type: "FakeSwitch"},
+	}
+	assert.NoError(services.BatchCreateItems(items, constants.ModifiedByHTTPAPIName))
+
+	query := services.EntityQuery{
+		Filter: &services.Filter{
+			Field: "type",
+			Op:    services.ValueOpIn,
+			Value: []string{"FakeItem", "FakeSwitch"},
+		},
+		Sort:   []string{"-name"},
+		Fields: []string{"id"},
+	}
+
+	var results []map[string]interface{}
+	s.PostJSON("/items/query", query, &results)
+	assert.Equal([]map[string]interface{}{{"id": "Switch-ID"}, {"id": "Lamp-ID"}}, results)
+
+	resp, err := http.Post(s.url+"/items/query", "application/json", strings.NewReader(`{"sort": ["unknown"]}`))
+	assert.NoError(err)
+	assert.NoError(resp.Body.Close())
+	assert.Equal(http.StatusBadRequest, resp.StatusCode)
+}
+
+func (s *HTTPSuite) TestCursorPagination() {
+	assert := s.Require()
+
+	items := []models.Item{
+		{ID: "Page-1", Name: "Page 1", Type: "FakePage"},
+		{ID: "Page-2", Name: "Page 2", Type: "FakePage"},
+		{ID: "Page-3", Name: "Page 3", Type: "FakePage"},
+	}
+	assert.NoError(services.BatchCreateItems(items, constants.ModifiedByHTTPAPIName))
+
+	getPage := func(cursor string) ([]models.Item, *http.Response) {
+		resp, err := http.Get(s.url + "/items/type/FakePage?page_size=2&cursor=" + cursor)
+		assert.NoError(err)
+		defer func() {
+			assert.NoError(resp.Body.Close())
+		}()
+		assert.Equal(http.StatusOK, resp.StatusCode)
+
+		var page []models.Item
+		assert.NoError(json.NewDecoder(resp.Body).Decode(&page))
+		return page, resp
+	}
+
+	page, resp := getPage("")
+	assert.Len(page, 2)
+	assert.Equal("3", resp.Header.Get("X-Total-Count"))
+	cursor := resp.Header.Get("X-Next-Cursor")
+	assert.NotEmpty(cursor)
+
+	page, resp = getPage(cursor)
+	assert.Len(page, 1)
+	assert.Equal("Page-3", page[0].ID)
+	assert.Empty(resp.Header.Get("X-Next-Cursor"))
+	assert.Empty(resp.Header.Get("X-Total-Count"))
+}
+
+func (s *HTTPSuite) TestTransaction() {
+	assert := s.Require()
+
+	assert.NoError(services.CreateItem(&models.Item{ID: "Old-ID", Name: "Old", Type: "FakeItem"}, constants.ModifiedByHTTPAPIName))
+
+	body := map[string]interface{}{
+		"operations": []map[string]interface{}{
+			{"action": "CREATE", "entity_type": "ITEM", "entity": map[string]interface{}{"id": "Room-ID", "name": "Room", "type": "FakeRoom"}},
+			{"action": "CREATE", "entity_type": "ITEM", "entity": map[string]interface{}{"id": "Light-ID", "name": "Light", "type": "FakeItem"}},
+			{"action": "CREATE", "entity_type": "RELATION", "entity": map[string]interface{}{"parent_id": "Room-ID", "child_id": "Light-ID"}},
+			{"action": "DELETE", "entity_type": "ITEM", "entity": map[string]interface{}{"id": "Old-ID"}},
+		},
+	}
+
+	var result services.TransactionResult
+	s.PostJSON("/transactions", body, &result)
+	assert.True(result.Committed)
+	assert.Len(result.Results, 4)
+	assert.Equal("Room-ID->Light-ID", result.Results[2].EntityID)
+	for _, r := range result.Results {
+		assert.Equal(services.TxOperationApplied, r.Status)
+	}
+
+	_, err := services.GetRelation("Room-ID", "Light-ID", "")
+	assert.NoError(err)
+	_, err = services.GetItemByID("Old-ID")
+	assert.ErrorIs(err, gorm.ErrRecordNotFound)
+
+	// A failed operation rolls back the whole transaction
+	data, err := json.Marshal(map[string]interface{}{
+		"operations": []map[string]interface{}{
+			{"action": "CREATE", "entity_type": "ITEM", "entity": map[string]interface{}{"id": "Door-ID", "name": "Door", "type": "FakeItem"}},
+			{"action": "DELETE", "entity_type": "ITEM", "entity": map[string]interface{}{"id": "Missing-ID"}},
+		},
+	})
+	assert.NoError(err)
+
+	resp, err := http.Post(s.url+"/transactions", "application/json", bytes.NewBuffer(data))
+	assert.NoError(err)
+	assert.Equal(http.StatusUnprocessableEntity, resp.StatusCode)
+	result = services.TransactionResult{}
+	assert.NoError(json.NewDecoder(resp.Body).Decode(&result))
+	assert.NoError(resp.Body.Close())
+	assert.False(result.Committed)
+	assert.Equal(services.TxOperationRolledBack, result.Results[0].Status)
+	assert.Equal(services.TxOperationFailed, result.Results[1].Status)
+
+	_, err = services.GetItemByID("Door-ID")
+	assert.ErrorIs(err, gorm.ErrRecordNotFound)
+
+	resp, err = http.Post(s.url+"/transactions", "application/json", strings.NewReader(`{"operations": []}`))
+	assert.NoError(err)
+	assert.NoError(resp.Body.Close())
+	assert.Equal(http.StatusBadRequest, resp.StatusCode)
+}
+
+func (s *HTTPSuite) TestUpsertAndMove() {
+	assert := s.Require()
+
+	var item models.Item
+	s.PutJSON("/item/Room-ID?upsert", map[string]interface{}{"name": "Room", "type": "FakeRoom"}, &item)
+	assert.Equal("Room", item.Name)
+	s.PutJSON("/item/Room-ID?upsert", map[string]interface{}{"name": "Kitchen"}, &item)
+	assert.Equal("Kitchen", item.Name)
+
+	var attribute models.Attribute
+	s.PutJSON("/attribute/Power-ID?upsert", map[string]interface{}{"item_id": "Room-ID", "name": "Power", "type": "FakeAttr"}, &attribute)
+	assert.Equal("Room-ID", attribute.ItemID)
+
+	items := []models.Item{
+		{ID: "Hall-ID", Name: "Hall", Type: "FakeRoom"},
+		{ID: "Lamp-ID", Name: "Lamp", Type: "FakeItem"},
+	}
+	assert.NoError(services.BatchCreateItems(items, constants.ModifiedByHTTPAPIName))
+	assert.NoError(services.CreateRelation(&models.Relation{ParentID: "Room-ID", ChildID: "Lamp-ID"}, constants.ModifiedByHTTPAPIName))
+
+	var relation models.Relation
+	s.PutJSON("/relation/move?parent_id=Room-ID&child_id=Lamp-ID&new_parent_id=Hall-ID", nil, &relation)
+	assert.Equal("Hall-ID", relation.ParentID)
+	assert.NotEmpty(relation.Version)
+
+	_, err := services.GetRelation("Room-ID", "Lamp-ID", "")
+	assert.ErrorIs(err, gorm.ErrRecordNotFound)
+
+	s.PutJSON("/relation?parent_id=Hall-ID&child_id=Lamp-ID", map[string]interface{}{"sync_policy": constants.SyncPolicyDontSync}, &relation)
+	assert.Equal(constants.SyncPolicyDontSync, relation.SyncPolicy.ValueOrZero())
+	assert.Equal("Lamp-ID", relation.ChildID)
+}
+
+func (s *HTTPSuite) TestDeletePreview() {
+	assert := s.Require()
+
+	items := []models.Item{
+		{ID: "Desk-ID", Name: "Desk", Type: "FakeItem"},
+		{ID: "Drawer-ID", Name: "Drawer", Type: "FakeItem"},
+	}
+	assert.NoError(services.BatchCreateItems(items, constants.ModifiedByHTTPAPIName))
+	assert.NoError(services.CreateRelation(&models.Relation{ParentID: "Desk-ID", ChildID: "Drawer-ID"}, constants.ModifiedByHTTPAPIName))
+
+	resp := s.Delete("/item/Desk-ID?dry_run")
+	assert.Equal(http.StatusOK, resp.StatusCode)
+
+	var cascade services.CascadeDelete
+	assert.NoError(json.NewDecoder(resp.Body).Decode(&cascade))
+	assert.NoError(resp.Body.Close())
+	assert.Equal([]string{"Desk-ID->Drawer-ID"}, cascade.Relations)
+	assert.Empty(cascade.Attributes)
+
+	_, err := services.GetRelation("Desk-ID", "Drawer-ID", "")
+	assert.NoError(err)
+}
+
+func (s *HTTPSuite) TestTypedRelations() {
+	assert := s.Require()
+
+	items := []models.Item{
+		{ID: "Boiler-ID", Name: "Boiler", Type: "FakeItem"},
+		{ID: "Probe-ID", Name: "Probe", Type: "FakeItem"},
+		{ID: "Gauge-ID", Name: "Gauge", Type: "FakeItem"},
+	}
+	assert.NoError(services.BatchCreateItems(items, constants.ModifiedByHTTPAPIName))
+	assert.NoError(services.CreateRelation(&models.Relation{ParentID: "Boiler-ID", ChildID: "Probe-ID"}, constants.ModifiedByHTTPAPIName))
+
+	var created map[string]interface{}
+	s.PostJSON("/relations", &models.Relation{
+		ParentID:   "Boiler-ID",
+		ChildID:    "Probe-ID",
+		Type:       "monitored_by",
+		Properties: models.RelationProperties{"channel": "A"},
+		SortIndex:  1,
+	}, &created)
+	s.PostJSON("/relations", &models.Relation{
+		ParentID:  "Boiler-ID",
+		ChildID:   "Gauge-ID",
+		Type:      "monitored_by",
+		SortIndex: 0,
+	}, &created)
+
+	var relation models.Relation
+	s.GetJSON("/relation?parent_id=Boiler-ID&child_id=Probe-ID&type=monitored_by", &relation)
+	assert.Equal("monitored_by", relation.Type)
+	assert.Equal("A", relation.Properties["channel"])
+	s.GetJSON("/relation?parent_id=Boiler-ID&child_id=Probe-ID", &relation)
+	assert.Equal("", relation.Type)
+
+	var relations []models.Relation
+	s.GetJSON("/relations/type/monitored_by", &relations)
+	assert.Len(relations, 2)
+	var count map[string]int64
+	s.GetJSON("/relations/type/monitored_by/count", &count)
+	assert.Equal(int64(2), count["count"])
+
+	var children []models.Item
+	s.GetJSON("/item/Boiler-ID/children?type=monitored_by", &children)
+	assert.Len(children, 2)
+	assert.Equal("Gauge-ID", children[0].ID)
+	assert.Equal("Probe-ID", children[1].ID)
+
+	s.PutJSON("/relation?parent_id=Boiler-ID&child_id=Probe-ID&type=monitored_by", map[string]interface{}{"sort_index": -1}, &relation)
+	assert.Equal(-1, relation.SortIndex)
+	s.GetJSON("/item/Boiler-ID/children?type=monitored_by", &children)
+	assert.Equal("Probe-ID", children[0].ID)
+
+	resp := s.Delete("/relation?parent_id=Boiler-ID&child_id=Probe-ID&type=monitored_by")
+	assert.NoError(resp.Body.Close())
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	_, err := services.GetRelation("Boiler-ID", "Probe-ID", "")
+	assert.NoError(err)
+
+	resp, err = http.Post(s.url+"/relations", "application/json", strings.NewReader(
+		`{"parent_id": "Boiler-ID", "child_id": "Gauge-ID", "type": "bad->type"}`,
+	))
+	assert.NoError(err)
+	assert.NoError(resp.Body.Close())
+	assert.Equal(http.StatusBadRequest, resp.StatusCode)
+}
+
 func TestHTTPServer(t *testing.T) {
 	suite.Run(t, new(HTTPSuite))
 }
@@ -81,7 +81,15 @@ func (m *itemMethods) updateByID(c *gin.Context) {
 
 	patch["id"] = id
 
-	err = services.UpdateItem(patch, constants.ModifiedByHTTPAPIName)
+	// Upsert creates the item if it doesn't exist
+	_, upsert := c.GetQuery("upsert")
+
+	if upsert {
+		err = services.UpsertItem(patch, constants.ModifiedByHTTPAPIName)
+	} else {
+		err = services.UpdateItem(patch, constants.ModifiedByHTTPAPIName)
+	}
+
 	if err != nil {
 		m.writeServiceError(c, err)
 		return
@@ -99,6 +107,18 @@ func (m *itemMethods) updateByID(c *gin.Context) {
 func (m *itemMethods) deleteByID(c *gin.Context) {
 	id := c.Param("item_id")
 	_, hard := c.GetQuery("hard")
+	_, dryRun := c.GetQuery("dry_run")
+
+	if dryRun {
+		// Reply the entities that would be deleted, without deleting them
+		cascade, err := services.PreviewDeleteItem(id)
+		if err != nil {
+			m.writeServiceError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, cascade)
+		return
+	}
 
 	var err error
 
@@ -124,6 +144,12 @@ func (m *itemMethods) getAll(c *gin.Context) {
 		return
 	}
 
+	if pagination.Cursor != nil {
+		results, page, err := services.GetAllItemsPage(*pagination.Cursor, pagination.PageSize)
+		m.writePage(c, results, page, err)
+		return
+	}
+
 	items, err := services.GetAllItems(pagination.Page, pagination.PageSize)
 	if err != nil {
 		m.writeServiceError(c, err)
@@ -143,7 +169,13 @@ func (m *itemMethods) getAllByType(c *gin.Context) {
 
 	itemType := c.Param("item_type")
 
-	items, err := services.GetItemsByType(itemType, pagination.PageSize, pagination.PageSize)
+	if pagination.Cursor != nil {
+		results, page, err := services.GetItemsByTypePage(itemType, *pagination.Cursor, pagination.PageSize)
+		m.writePage(c, results, page, err)
+		return
+	}
+
+	items, err := services.GetItemsByType(itemType, pagination.Page, pagination.PageSize)
 	if err != nil {
 		m.writeServiceError(c, err)
 		return
@@ -161,6 +193,12 @@ func (m *itemMethods) findByName(c *gin.Context) {
 	}
 	name := c.Param("item_name")
 
+	if pagination.Cursor != nil {
+		results, page, err := services.FindItemsByNamePage(name, *pagination.Cursor, pagination.PageSize)
+		m.writePage(c, results, page, err)
+		return
+	}
+
 	items, err := services.FindItemsByName(name, pagination.Page, pagination.PageSize)
 	if err != nil {
 		m.writeServiceError(c, err)
@@ -179,6 +217,12 @@ func (m *itemMethods) findByType(c *gin.Context) {
 	}
 	itemType := c.Param("item_type")
 
+	if pagination.Cursor != nil {
+		results, page, err := services.FindItemsByTypePage(itemType, *pagination.Cursor, pagination.PageSize)
+		m.writePage(c, results, page, err)
+		return
+	}
+
 	items, err := services.FindItemsByType(itemType, pagination.Page, pagination.PageSize)
 	if err != nil {
 		m.writeServiceError(c, err)
@@ -254,7 +298,20 @@ func (m *itemMethods) getCustomer(c *gin.Context) {
 
 func (m *itemMethods) getChildren(c *gin.Context) {
 	id := c.Param("item_id")
-	children, err := services.GetItemChildren(id)
+
+	var query relationTypeQuery
+	err := c.BindQuery(&query)
+	if err != nil {
+		m.writeError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	var children []models.Item
+	if query.Type != nil {
+		children, err = services.GetItemChildrenByType(id, *query.Type)
+	} else {
+		children, err = services.GetItemChildren(id)
+	}
 	if err != nil {
 		m.writeServiceError(c, err)
 		return
@@ -265,7 +322,20 @@ func (m *itemMethods) getChildren(c *gin.Context) {
 
 func (m *itemMethods) getParents(c *gin.Context) {
 	id := c.Param("item_id")
-	parents, err := services.GetItemParents(id)
+
+	var query relationTypeQuery
+	err := c.BindQuery(&query)
+	if err != nil {
+		m.writeError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	var parents []models.Item
+	if query.Type != nil {
+		parents, err = services.GetItemParentsByType(id, *query.Type)
+	} else {
+		parents, err = services.GetItemParents(id)
+	}
 	if err != nil {
 		m.writeServiceError(c, err)
 		return
@@ -276,7 +346,20 @@ func (m *itemMethods) getParents(c *gin.Context) {
 
 func (m *itemMethods) getRelations(c *gin.Context) {
 	id := c.Param("item_id")
-	relations, err := services.GetItemRelations(id)
+
+	var query relationTypeQuery
+	err := c.BindQuery(&query)
+	if err != nil {
+		m.writeError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	var relations []models.Relation
+	if query.Type != nil {
+		relations, err = services.GetItemRelationsByType(id, *query.Type)
+	} else {
+		relations, err = services.GetItemRelations(id)
+	}
 	if err != nil {
 		m.writeServiceError(c, err)
 		return
@@ -323,7 +406,17 @@ func (m *itemMethods) getAttributeIDByName(c *gin.Context) {
 func (m *itemMethods) getAttributeValueByName(c *gin.Context) {
 	itemID := c.Param("item_id")
 	attributeName := c.Param("attribute_name")
-	attributeValue, err := services.GetItemAttributeValueByName(itemID, attributeName)
+	_, typed := c.GetQuery("typed")
+
+	var attributeValue interface{}
+	var err error
+
+	if typed {
+		attributeValue, err = services.GetItemAttributeTypedValueByName(itemID, attributeName)
+	} else {
+		attributeValue, err = services.GetItemAttributeValueByName(itemID, attributeName)
+	}
+
 	if err != nil {
 		m.writeServiceError(c, err)
 		return
@@ -344,6 +437,10 @@ func (m *itemMethods) getAttributesByType(c *gin.Context) {
 	c.JSON(http.StatusOK, attributes)
 }
 
+func (m *itemMethods) queryItems(c *gin.Context) {
+	m.query(c, services.QueryItems)
+}
+
 func newItemMethods(engine *gin.Engine, conf *config.HTTPConfig, rootPath string) *itemMethods {
 	g := engine.Group(rootPath)
 
@@ -362,6 +459,7 @@ func newItemMethods(engine *gin.Engine, conf *config.HTTPConfig, rootPath string
 		GET("/items/type/:item_type", m.getAllByType).
 		GET("/items/findByName/:item_name", m.findByName).
 		GET("/items/findByType/:item_type", m.findByType).
+		POST("/items/query", m.queryItems).
 		GET("/item/:item_id/mac", m.getMac).
 		GET("/item/:item_id/version", m.getVersion).
 		GET("/item/:item_id/modified_by", m.getModifiedBy).
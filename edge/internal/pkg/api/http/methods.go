@@ -3,13 +3,22 @@ package http
 import (
 	"devais.it/kronos/internal/pkg/config"
 	"devais.it/kronos/internal/pkg/db"
+	"devais.it/kronos/internal/pkg/db/models"
+	"devais.it/kronos/internal/pkg/schema"
+	"devais.it/kronos/internal/pkg/services"
 	"github.com/gin-gonic/gin"
 	"github.com/rotisserie/eris"
 	"gorm.io/gorm"
 	"net/http"
+	"strconv"
 	"strings"
 )
 
+const (
+	totalCountHeader = "X-Total-Count"
+	nextCursorHeader = "X-Next-Cursor"
+)
+
 type methods struct {
 	conf   *config.HTTPConfig
 	router *gin.RouterGroup
@@ -37,11 +46,23 @@ func (m *methods) writeServiceError(c *gin.Context, err error) {
 	if eris.Is(err, gorm.ErrInvalidData) ||
 		eris.Is(err, gorm.ErrInvalidField) ||
 		eris.Is(err, db.ErrMissingID) ||
-		eris.Is(err, db.ErrInvalidPagination) {
+		eris.Is(err, db.ErrInvalidPagination) ||
+		eris.Is(err, schema.ErrValidation) ||
+		eris.Is(err, services.ErrInvalidValueOp) ||
+		eris.Is(err, services.ErrInvalidQuery) ||
+		eris.Is(err, services.ErrHistoryNotNumeric) ||
+		eris.Is(err, services.ErrInvalidHistoryBucket) ||
+		eris.Is(err, services.ErrInvalidTransaction) ||
+		eris.Is(err, models.ErrInvalidRelationType) {
 		m.writeError(c, http.StatusBadRequest, err)
 		return
 	}
 
+	if eris.Is(err, services.ErrEventsQueueFull) {
+		m.writeError(c, http.StatusInsufficientStorage, err)
+		return
+	}
+
 	if strings.Contains(eris.ToString(err, true), "UNIQUE constraint failed") {
 		m.writeError(c, http.StatusBadRequest, err)
 		return
@@ -49,3 +70,40 @@ func (m *methods) writeServiceError(c *gin.Context, err error) {
 
 	m.writeError(c, http.StatusInternalServerError, err)
 }
+
+// query runs the structured entity query found in the request body
+func (m *methods) query(c *gin.Context, run func(query *services.EntityQuery) (interface{}, error)) {
+	query := &services.EntityQuery{}
+
+	err := c.BindJSON(query)
+	if err != nil {
+		m.writeError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	results, err := run(query)
+	if err != nil {
+		m.writeServiceError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, results)
+}
+
+// writePage renders a page of results paginated by cursor,
+// along with its metadata in the response headers
+func (m *methods) writePage(c *gin.Context, results interface{}, page *db.Page, err error) {
+	if err != nil {
+		m.writeServiceError(c, err)
+		return
+	}
+
+	if page.Total != nil {
+		c.Header(totalCountHeader, strconv.FormatInt(*page.Total, 10))
+	}
+	if page.NextCursor != "" {
+		c.Header(nextCursorHeader, page.NextCursor)
+	}
+
+	c.JSON(http.StatusOK, results)
+}
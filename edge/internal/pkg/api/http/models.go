@@ -1,11 +1,40 @@
 package http
 
+import "time"
+
 type paginationQuery struct {
 	Page     int `form:"page"`
 	PageSize int `form:"page_size"`
+	// Cursor enables cursor pagination if present, even empty for the first page
+	Cursor *string `form:"cursor"`
 }
 
 type relationQuery struct {
 	ParentID string `form:"parent_id" binding:"required"`
 	ChildID  string `form:"child_id" binding:"required"`
+	// Type is the relation type, empty for untyped relations
+	Type string `form:"type"`
+}
+
+// relationTypeQuery optionally filters item relations by type
+type relationTypeQuery struct {
+	Type *string `form:"type"`
+}
+
+type moveQuery struct {
+	relationQuery
+	NewParentID string `form:"new_parent_id" binding:"required"`
+}
+
+type valueQuery struct {
+	paginationQuery
+	Op    string `form:"op" binding:"required"`
+	Value string `form:"value" binding:"required"`
+}
+
+type historyQuery struct {
+	paginationQuery
+	From   uint64        `form:"from"`
+	To     uint64        `form:"to"`
+	Bucket time.Duration `form:"bucket"`
 }
@@ -29,7 +29,7 @@ func (m *relationMethods) create(c *gin.Context) {
 	}
 
 	if m.replyCreatedData() {
-		relation, err = services.GetRelation(relation.ParentID, relation.ChildID)
+		relation, err = services.GetRelation(relation.ParentID, relation.ChildID, relation.Type)
 		if err != nil {
 			m.writeServiceError(c, err)
 			return
@@ -39,6 +39,7 @@ func (m *relationMethods) create(c *gin.Context) {
 		c.JSON(http.StatusCreated, gin.H{
 			"parent_id": relation.ParentID,
 			"child_id":  relation.ChildID,
+			"type":      relation.Type,
 		})
 	}
 }
@@ -52,7 +53,7 @@ func (m *relationMethods) getByID(c *gin.Context) {
 		return
 	}
 
-	relation, err := services.GetRelation(query.ParentID, query.ChildID)
+	relation, err := services.GetRelation(query.ParentID, query.ChildID, query.Type)
 	if err != nil {
 		m.writeServiceError(c, err)
 		return
@@ -73,9 +74,9 @@ func (m *relationMethods) deleteByID(c *gin.Context) {
 	_, hard := c.GetQuery("hard")
 
 	if hard {
-		err = services.HardDeleteRelation(query.ParentID, query.ChildID, constants.ModifiedByHTTPAPIName)
+		err = services.HardDeleteRelation(query.ParentID, query.ChildID, query.Type, constants.ModifiedByHTTPAPIName)
 	} else {
-		err = services.DeleteRelation(query.ParentID, query.ChildID, constants.ModifiedByHTTPAPIName)
+		err = services.DeleteRelation(query.ParentID, query.ChildID, query.Type, constants.ModifiedByHTTPAPIName)
 	}
 
 	if err != nil {
@@ -83,7 +84,67 @@ func (m *relationMethods) deleteByID(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"parent_id": query.ParentID, "child_id": query.ChildID})
+	c.JSON(http.StatusOK, gin.H{"parent_id": query.ParentID, "child_id": query.ChildID, "type": query.Type})
+}
+
+func (m *relationMethods) update(c *gin.Context) {
+	var query relationQuery
+
+	err := c.ShouldBindWith(&query, binding.Query)
+	if err != nil {
+		m.writeError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	var patch map[string]interface{}
+	err = c.BindJSON(&patch)
+	if err != nil {
+		m.writeError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	patch[constants.ParentIDField] = query.ParentID
+	patch[constants.ChildIDField] = query.ChildID
+	patch[constants.RelationTypeField] = query.Type
+
+	err = services.UpdateRelation(patch, constants.ModifiedByHTTPAPIName)
+	if err != nil {
+		m.writeServiceError(c, err)
+		return
+	}
+
+	relation, err := services.GetRelation(query.ParentID, query.ChildID, query.Type)
+	if err != nil {
+		m.writeServiceError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, relation)
+}
+
+// move moves the child of a relation to a new parent
+func (m *relationMethods) move(c *gin.Context) {
+	var query moveQuery
+
+	err := c.ShouldBindWith(&query, binding.Query)
+	if err != nil {
+		m.writeError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	err = services.MoveItem(query.ParentID, query.ChildID, query.Type, query.NewParentID, constants.ModifiedByHTTPAPIName)
+	if err != nil {
+		m.writeServiceError(c, err)
+		return
+	}
+
+	relation, err := services.GetRelation(query.NewParentID, query.ChildID, query.Type)
+	if err != nil {
+		m.writeServiceError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, relation)
 }
 
 func (m *relationMethods) getAll(c *gin.Context) {
@@ -94,6 +155,12 @@ func (m *relationMethods) getAll(c *gin.Context) {
 		return
 	}
 
+	if pagination.Cursor != nil {
+		results, page, err := services.GetAllRelationsPage(*pagination.Cursor, pagination.PageSize)
+		m.writePage(c, results, page, err)
+		return
+	}
+
 	relations, err := services.GetAllRelations(pagination.Page, pagination.PageSize)
 	if err != nil {
 		m.writeServiceError(c, err)
@@ -103,6 +170,42 @@ func (m *relationMethods) getAll(c *gin.Context) {
 	c.JSON(http.StatusOK, relations)
 }
 
+// getByType returns the relations with the given type
+func (m *relationMethods) getByType(c *gin.Context) {
+	relationType := c.Param("relation_type")
+
+	var pagination paginationQuery
+	err := c.BindQuery(&pagination)
+	if err != nil {
+		m.writeError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if pagination.Cursor != nil {
+		results, page, err := services.GetRelationsByTypePage(relationType, *pagination.Cursor, pagination.PageSize)
+		m.writePage(c, results, page, err)
+		return
+	}
+
+	relations, err := services.GetRelationsByType(relationType, pagination.Page, pagination.PageSize)
+	if err != nil {
+		m.writeServiceError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, relations)
+}
+
+func (m *relationMethods) countByType(c *gin.Context) {
+	count, err := services.GetRelationsByTypeCount(c.Param("relation_type"))
+	if err != nil {
+		m.writeServiceError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"count": count})
+}
+
 func (m *relationMethods) count(c *gin.Context) {
 	count, err := services.GetRelationsCount()
 	if err != nil {
@@ -113,6 +216,10 @@ func (m *relationMethods) count(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"count": count})
 }
 
+func (m *relationMethods) queryRelations(c *gin.Context) {
+	m.query(c, services.QueryRelations)
+}
+
 func newRelationMethods(engine *gin.Engine, conf *config.HTTPConfig, rootPath string) *relationMethods {
 	g := engine.Group(rootPath)
 
@@ -123,9 +230,14 @@ func newRelationMethods(engine *gin.Engine, conf *config.HTTPConfig, rootPath st
 	g.
 		POST("/relations", m.create).
 		GET("/relations", m.getAll).
+		POST("/relations/query", m.queryRelations).
 		GET("/relation", m.getByID).
+		PUT("/relation", m.update).
 		DELETE("/relation", m.deleteByID).
-		GET("/relations/count", m.count)
+		PUT("/relation/move", m.move).
+		GET("/relations/count", m.count).
+		GET("/relations/type/:relation_type", m.getByType).
+		GET("/relations/type/:relation_type/count", m.countByType)
 
 	return m
 }
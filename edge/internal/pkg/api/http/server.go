@@ -54,6 +54,8 @@ func NewServer(conf *config.HTTPConfig) *Server {
 	newRelationMethods(engine, conf, "/")
 	newAttributeMethods(engine, conf, "/")
 	newEventMethods(engine, conf, "/")
+	newConflictMethods(engine, conf, "/")
+	newTransactionMethods(engine, conf, "/")
 
 	engine.GET("/ping", func(c *gin.Context) {
 		c.String(http.StatusOK, "pong")
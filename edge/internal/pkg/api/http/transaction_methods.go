@@ -0,0 +1,52 @@
+package http
+
+import (
+	"devais.it/kronos/internal/pkg/config"
+	"devais.it/kronos/internal/pkg/constants"
+	"devais.it/kronos/internal/pkg/services"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+type transactionMethods struct {
+	methods
+}
+
+type transactionBody struct {
+	Operations []services.TxOperation `json:"operations" binding:"required"`
+}
+
+func (m *transactionMethods) apply(c *gin.Context) {
+	var body transactionBody
+	err := c.BindJSON(&body)
+	if err != nil {
+		m.writeError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	result, err := services.ApplyTransaction(body.Operations, constants.ModifiedByHTTPAPIName)
+	if result == nil {
+		m.writeServiceError(c, err)
+		return
+	}
+
+	// Results of rolled back transactions report which operations failed
+	if !result.Committed {
+		c.JSON(http.StatusUnprocessableEntity, result)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+func newTransactionMethods(engine *gin.Engine, conf *config.HTTPConfig, rootPath string) *transactionMethods {
+	g := engine.Group(rootPath)
+
+	m := &transactionMethods{
+		methods{router: g, conf: conf},
+	}
+
+	g.POST("/transactions", m.apply)
+
+	return m
+}
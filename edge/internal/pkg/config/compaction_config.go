@@ -0,0 +1,31 @@
+package config
+
+import "time"
+
+const (
+	defaultCompactionInterval  = 5 * time.Minute
+	defaultCompactionThreshold = 1000
+)
+
+type CompactionConfig struct {
+	// Enabled if set to true, the events queue is periodically compacted,
+	// folding create, update and delete events of the same entity.
+	Enabled bool
+
+	// Interval is the time between two scheduled compactions.
+	// Zero disables scheduled compactions.
+	Interval time.Duration
+
+	// Threshold is the number of events queued since the last compaction
+	// which triggers a new one.
+	// Zero disables threshold compactions.
+	Threshold int64
+}
+
+func DefaultCompactionConfig() CompactionConfig {
+	return CompactionConfig{
+		Enabled:   false,
+		Interval:  defaultCompactionInterval,
+		Threshold: defaultCompactionThreshold,
+	}
+}
@@ -167,6 +167,7 @@ func Parse(v *viper.Viper, configFile string) (*Config, error) {
 		stringToFileSizeHookFunc(),
 		stringToVersionAlgoFunc(),
 		stringToSerializationTypeFunc(),
+		stringToCompressionFunc(),
 		mapstructure.StringToTimeDurationHookFunc(),
 		mapstructure.StringToIPHookFunc(),
 		mapstructure.StringToSliceHookFunc(","),
@@ -378,3 +379,14 @@ func stringToSerializationTypeFunc() mapstructure.DecodeHookFunc {
 		return serialization.TypeFromString(data.(string))
 	}
 }
+
+// stringToCompressionFunc is a mapstructure decode hook
+// which decodes strings to compression algorithms
+func stringToCompressionFunc() mapstructure.DecodeHookFunc {
+	return func(f reflect.Type, t reflect.Type, data interface{}) (interface{}, error) {
+		if f.Kind() != reflect.String || t != reflect.TypeOf(serialization.CompressionNone) {
+			return data, nil
+		}
+		return serialization.CompressionFromString(data.(string))
+	}
+}
@@ -82,6 +82,15 @@ type DBConfig struct {
 	// problems on platforms where usleep is not available (HAVE_USLEEP=0).
 	// https://www.sqlite.org/pragma.html#pragma_busy_timeout
 	BusyTimeout time.Duration
+
+	// EventsQueue is the configuration of the events queue limits
+	EventsQueue EventsQueueConfig
+
+	// Schema is the configuration of the schema registry
+	Schema SchemaConfig
+
+	// History is the configuration of the attributes history
+	History HistoryConfig
 }
 
 // DefaultDBConfig creates a new database configuration structure
@@ -103,5 +112,8 @@ func DefaultDBConfig() DBConfig {
 		SynchronousFull:        false,
 		//LockExclusive:        false,
 		BusyTimeout: 0,
+		EventsQueue: DefaultEventsQueueConfig(),
+		Schema:      DefaultSchemaConfig(),
+		History:     DefaultHistoryConfig(),
 	}
 }
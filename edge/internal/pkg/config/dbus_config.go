@@ -1,13 +1,15 @@
 package config
 
 const (
-	defaultDBusPathName                = "/it/devais/kronos"
-	defaultDBusInterfaceName           = "it.devais.kronos"
-	defaultDBusItemsInterfaceName      = "it.devais.kronos.Items"
-	defaultDBusRelationsInterfaceName  = "it.devais.kronos.Relations"
-	defaultDBusAttributesInterfaceName = "it.devais.kronos.Attributes"
-	defaultDBusEventsInterfaceName     = "it.devais.kronos.Events"
-	defaultDBusConfigInterfaceName     = "it.devais.kronos.Config"
+	defaultDBusPathName                 = "/it/devais/kronos"
+	defaultDBusInterfaceName            = "it.devais.kronos"
+	defaultDBusItemsInterfaceName       = "it.devais.kronos.Items"
+	defaultDBusRelationsInterfaceName   = "it.devais.kronos.Relations"
+	defaultDBusAttributesInterfaceName  = "it.devais.kronos.Attributes"
+	defaultDBusEventsInterfaceName      = "it.devais.kronos.Events"
+	defaultDBusConfigInterfaceName      = "it.devais.kronos.Config"
+	defaultDBusConflictsInterfaceName   = "it.devais.kronos.Conflicts"
+	defaultDBusTransactionInterfaceName = "it.devais.kronos.Transaction"
 )
 
 type DBusConfig struct {
@@ -50,23 +52,31 @@ type DBusConfig struct {
 
 	// ConfigInterfaceName is the DBus interface name for configuration
 	ConfigInterfaceName string
+
+	// ConflictsInterfaceName is the DBus interface name for synchronization conflicts
+	ConflictsInterfaceName string
+
+	// TransactionInterfaceName is the DBus interface name for transactions
+	TransactionInterfaceName string
 }
 
 // DefaultDBusConfig creates a new DBus configuration structure
 // filled with default options
 func DefaultDBusConfig() DBusConfig {
 	return DBusConfig{
-		Enabled:                 false,
-		UseSystemBus:            false,
-		Serialization:           DefaultSerializationConfig(),
-		ErrorsWithTrace:         false,
-		ReplyCreatedData:        false,
-		PathName:                defaultDBusPathName,
-		InterfaceName:           defaultDBusInterfaceName,
-		ItemsInterfaceName:      defaultDBusItemsInterfaceName,
-		RelationsInterfaceName:  defaultDBusRelationsInterfaceName,
-		AttributesInterfaceName: defaultDBusAttributesInterfaceName,
-		EventsInterfaceName:     defaultDBusEventsInterfaceName,
-		ConfigInterfaceName:     defaultDBusConfigInterfaceName,
+		Enabled:                  false,
+		UseSystemBus:             false,
+		Serialization:            DefaultSerializationConfig(),
+		ErrorsWithTrace:          false,
+		ReplyCreatedData:         false,
+		PathName:                 defaultDBusPathName,
+		InterfaceName:            defaultDBusInterfaceName,
+		ItemsInterfaceName:       defaultDBusItemsInterfaceName,
+		RelationsInterfaceName:   defaultDBusRelationsInterfaceName,
+		AttributesInterfaceName:  defaultDBusAttributesInterfaceName,
+		EventsInterfaceName:      defaultDBusEventsInterfaceName,
+		ConfigInterfaceName:      defaultDBusConfigInterfaceName,
+		ConflictsInterfaceName:   defaultDBusConflictsInterfaceName,
+		TransactionInterfaceName: defaultDBusTransactionInterfaceName,
 	}
 }
@@ -0,0 +1,24 @@
+package config
+
+import "time"
+
+const defaultDeliveryAckTimeout = 30 * time.Second
+
+type DeliveryConfig struct {
+	// AckEnabled if set to true, published events are kept in the queue
+	// as in-flight until the server application acknowledges their batch.
+	// Batches not acknowledged in time are delivered again, and the server
+	// application should deduplicate events by their dedup key.
+	AckEnabled bool
+
+	// AckTimeout is the maximum time to wait for the acknowledgement
+	// of a batch before delivering its events again
+	AckTimeout time.Duration
+}
+
+func DefaultDeliveryConfig() DeliveryConfig {
+	return DeliveryConfig{
+		AckEnabled: false,
+		AckTimeout: defaultDeliveryAckTimeout,
+	}
+}
@@ -0,0 +1,36 @@
+package config
+
+import "devais.it/kronos/internal/pkg/types"
+
+const (
+	defaultEventsQueueArchiveDir = "./events-archive"
+)
+
+type EventsQueueConfig struct {
+	// MaxEvents is the maximum number of events in the queue.
+	// Zero means no limit.
+	MaxEvents int64
+
+	// MaxSize is the maximum size of the events in the queue.
+	// The size of an event is approximated by the size of its body
+	// plus a fixed row overhead.
+	// Zero means no limit.
+	MaxSize types.FileSize
+
+	// OverflowPolicy is the policy applied when a limit is reached.
+	// Supported policies are: "DROP_OLDEST", "DROP_DUPLICATES", "REJECT" and "SPILL".
+	OverflowPolicy types.QueueOverflowPolicy
+
+	// ArchiveDir is the directory where events are spilled with the "SPILL" policy.
+	// Every spill creates a gzip compressed file of JSON lines.
+	ArchiveDir string
+}
+
+func DefaultEventsQueueConfig() EventsQueueConfig {
+	return EventsQueueConfig{
+		MaxEvents:      0,
+		MaxSize:        0,
+		OverflowPolicy: types.QueueOverflowReject,
+		ArchiveDir:     defaultEventsQueueArchiveDir,
+	}
+}
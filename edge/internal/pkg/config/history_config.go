@@ -0,0 +1,65 @@
+package config
+
+import "time"
+
+const (
+	defaultHistoryMaxAge    = 7 * 24 * time.Hour
+	defaultHistoryMaxPoints = 10000
+
+	defaultHistoryRetentionInterval = 10 * time.Minute
+)
+
+type HistoryConfig struct {
+	// AttributeTypes are the types of the attributes whose values
+	// are recorded in the attributes history
+	AttributeTypes []string
+
+	// Attributes are the IDs of single attributes whose values
+	// are recorded in the attributes history
+	Attributes []string
+
+	// MaxAge is the maximum age of recorded values.
+	// Zero means no limit.
+	MaxAge time.Duration
+
+	// MaxPoints is the maximum number of values recorded for each attribute.
+	// Zero means no limit.
+	MaxPoints int
+
+	// RetentionInterval is the interval between two applications of MaxAge
+	// and MaxPoints to the attributes history by the sync worker, so values
+	// exceeding the limits are kept for up to RetentionInterval.
+	// Zero means the limits are never applied.
+	RetentionInterval time.Duration
+}
+
+func DefaultHistoryConfig() HistoryConfig {
+	return HistoryConfig{
+		AttributeTypes: []string{},
+		Attributes:     []string{},
+		MaxAge:         defaultHistoryMaxAge,
+		MaxPoints:      defaultHistoryMaxPoints,
+
+		RetentionInterval: defaultHistoryRetentionInterval,
+	}
+}
+
+// Enabled determines if the history of any attribute is recorded
+func (c *HistoryConfig) Enabled() bool {
+	return len(c.AttributeTypes) > 0 || len(c.Attributes) > 0
+}
+
+// IsRecorded determines if the history of an attribute is recorded
+func (c *HistoryConfig) IsRecorded(attributeID, attributeType string) bool {
+	for _, t := range c.AttributeTypes {
+		if t == attributeType {
+			return true
+		}
+	}
+	for _, id := range c.Attributes {
+		if id == attributeID {
+			return true
+		}
+	}
+	return false
+}
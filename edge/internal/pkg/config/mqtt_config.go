@@ -19,14 +19,24 @@ const (
 	defaultMQTTStorageType           = "memory"
 	defaultMQTTStoragePath           = "./paho-messages"
 	defaultMQTTConnectedTopic        = "/kronos/device/{deviceId}/connected"
+	defaultMQTTVersionsDigestTopic   = "/kronos/device/{deviceId}/versions/digest"
 	defaultMQTTDisconnectedTopic     = "/kronos/device/{deviceId}/disconnected"
 	defaultMQTTEventsTopic           = "/kronos/device/{deviceId}/events"
+	defaultMQTTEventsAckTopic        = "/kronos/device/{deviceId}/events/ack"
 	defaultMQTTSyncTopicGlobal       = "/kronos/sync"
 	defaultMQTTSyncTopicSpecific     = "/kronos/device/{deviceId}/sync"
+	defaultMQTTSyncAckTopic          = "/kronos/device/{deviceId}/sync/ack"
 	defaultMQTTCommandsTopic         = "/kronos/device/{deviceId}/commands"
 	defaultMQTTCommandsResponseTopic = "/kronos/device/{deviceId}/commands/{uuid}/response"
 )
 
+type MQTTProtocolVersion uint
+
+var (
+	MQTTProtocolV311 MQTTProtocolVersion = 4
+	MQTTProtocolV5   MQTTProtocolVersion = 5
+)
+
 type MQTTConfig struct {
 	// EnablePahoLogging determines if the Paho library logging should be enabled
 	EnablePahoLogging bool
@@ -40,6 +50,14 @@ type MQTTConfig struct {
 	// Port is the MQTT broker port
 	Port int
 
+	// ProtocolVersion is the MQTT protocol version used by the client.
+	// Supported versions are 4 (MQTT v3.1.1) and 5 (MQTT v5).
+	// With MQTT v5 command responses are published on the response topic of
+	// each command, along with its correlation data, falling back to
+	// CommandsResponseTopic if the command has no response topic.
+	// MQTT v5 only supports the "tcp" and "ssl" schemes, and ignores StorageType.
+	ProtocolVersion MQTTProtocolVersion
+
 	// ClientID is the MQTT client ID.
 	ClientID string
 
@@ -71,6 +89,16 @@ type MQTTConfig struct {
 	// the messages with a QoS >= 1 that the client missed while offline.
 	CleanSession bool
 
+	// SessionExpiry is the time the broker keeps the session of this client
+	// after a disconnection. If 0, the session ends with the connection.
+	// Only used by MQTT v5.
+	SessionExpiry time.Duration
+
+	// MessageExpiry is the time after which published messages not yet
+	// delivered by the broker are discarded. If 0, messages never expire.
+	// Only used by MQTT v5.
+	MessageExpiry time.Duration
+
 	// KeepAlive is the amount of time that the client should wait before sending a PING
 	// request to the broker. This will allow the client to know that a connection has
 	// not been lost with the server.
@@ -122,6 +150,11 @@ type MQTTConfig struct {
 	// Supports variables
 	ConnectedTopic string
 
+	// VersionsDigestTopic is the MQTT topic where versions digest messages
+	// are sent when reconciliation is enabled.
+	// Supports variables
+	VersionsDigestTopic string
+
 	// DisconnectedTopic is the MQTT topic where disconnection messages are sent.
 	// This is implemented with MQTT's last will.
 	// Supports variables
@@ -131,6 +164,11 @@ type MQTTConfig struct {
 	// Supports variables
 	EventsTopic string
 
+	// EventsAckTopic is the MQTT topic where acknowledgements of event batches
+	// are received from the server, when delivery acknowledgements are enabled.
+	// Supports variables
+	EventsAckTopic string
+
 	// SyncTopicGlobal is a MQTT topic where synchronization messages are received from the server.
 	// On this topic global synchronization messages are received.
 	// Supports variables.
@@ -141,6 +179,10 @@ type MQTTConfig struct {
 	// Supports variables.
 	SyncTopicSpecific string
 
+	// SyncAckTopic is the MQTT topic where acknowledgements of synchronization messages are sent.
+	// Supports variables.
+	SyncAckTopic string
+
 	// CommandsTopic is the MQTT topic where command messages are received from the server.
 	// Supports variables.
 	CommandsTopic string
@@ -162,6 +204,7 @@ func DefaultMQTTConfig() MQTTConfig {
 		Scheme:                defaultMQTTScheme,
 		Host:                  defaultMQTTHost,
 		Port:                  defaultMQTTPort,
+		ProtocolVersion:       MQTTProtocolV311,
 		ClientID:              defaultMQTTClientID,
 		RandomizeClientID:     false,
 		Username:              "",
@@ -171,6 +214,8 @@ func DefaultMQTTConfig() MQTTConfig {
 		PubQoS:                defaultMQTTQoS,
 		KeepAlive:             defaultMQTTKeepAlive,
 		CleanSession:          defaultMQTTCleanSession,
+		SessionExpiry:         0,
+		MessageExpiry:         0,
 		CommunicationTimeout:  defaultMQTTCommunicationTimeout,
 		LastWillEnabled:       defaultMQTTLastWillEnabled,
 		Serialization:         DefaultSerializationConfig(),
@@ -181,10 +226,13 @@ func DefaultMQTTConfig() MQTTConfig {
 		StorageType:           defaultMQTTStorageType,
 		StoragePath:           defaultMQTTStoragePath,
 		ConnectedTopic:        defaultMQTTConnectedTopic,
+		VersionsDigestTopic:   defaultMQTTVersionsDigestTopic,
 		DisconnectedTopic:     defaultMQTTDisconnectedTopic,
 		EventsTopic:           defaultMQTTEventsTopic,
+		EventsAckTopic:        defaultMQTTEventsAckTopic,
 		SyncTopicGlobal:       defaultMQTTSyncTopicGlobal,
 		SyncTopicSpecific:     defaultMQTTSyncTopicSpecific,
+		SyncAckTopic:          defaultMQTTSyncAckTopic,
 		CommandsTopic:         defaultMQTTCommandsTopic,
 		CommandsResponseTopic: defaultMQTTCommandsResponseTopic,
 	}
@@ -0,0 +1,34 @@
+package config
+
+import "time"
+
+const (
+	defaultReconciliationBuckets = 64
+	defaultReconciliationTimeout = 1 * time.Minute
+)
+
+type ReconciliationConfig struct {
+	// Enabled if set to true, a digest of entity versions is published
+	// in place of the full versions dump when PublishVersions is enabled.
+	// The server application can then request the hashes of single buckets
+	// and the versions of the divergent ones only.
+	Enabled bool
+
+	// Buckets is the number of buckets the versions of every entity type
+	// are split into.
+	// More buckets mean smaller exchanges for few divergent entities,
+	// at the cost of larger bucket hashes messages.
+	Buckets int
+
+	// Timeout is the maximum time to wait for the server application
+	// to complete the reconciliation before dequeueing events.
+	Timeout time.Duration
+}
+
+func DefaultReconciliationConfig() ReconciliationConfig {
+	return ReconciliationConfig{
+		Enabled: false,
+		Buckets: defaultReconciliationBuckets,
+		Timeout: defaultReconciliationTimeout,
+	}
+}
@@ -0,0 +1,20 @@
+package config
+
+type SchemaConfig struct {
+	// File is the path of a JSON schema registry, used to validate
+	// items and attributes before they are written.
+	// A registry received from the server application takes precedence.
+	// Validation is disabled if empty and no registry was received.
+	File string
+
+	// SyncEnabled determines if the server application is allowed
+	// to replace the schema registry.
+	SyncEnabled bool
+}
+
+func DefaultSchemaConfig() SchemaConfig {
+	return SchemaConfig{
+		File:        "",
+		SyncEnabled: true,
+	}
+}
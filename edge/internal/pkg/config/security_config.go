@@ -0,0 +1,32 @@
+package config
+
+import "time"
+
+type SecurityConfig struct {
+	// SigningKeyFile is the PEM file of the Ed25519 private key used to sign
+	// outgoing events. If empty, events are sent without an envelope.
+	SigningKeyFile string
+
+	// EncryptionKeyFile is the PEM file of the X25519 public key of the
+	// server application, used to encrypt outgoing events.
+	// Encryption requires a signing key.
+	EncryptionKeyFile string
+
+	// ServerKeyFiles are the PEM files of the Ed25519 public keys of the server
+	// application. If not empty, incoming messages are rejected unless they
+	// are wrapped in an envelope signed by one of these keys.
+	ServerKeyFiles []string
+
+	// MaxMessageAge is the maximum age of incoming signed messages.
+	// Older messages, and messages received twice, are rejected.
+	MaxMessageAge time.Duration
+}
+
+func DefaultSecurityConfig() SecurityConfig {
+	return SecurityConfig{
+		SigningKeyFile:    "",
+		EncryptionKeyFile: "",
+		ServerKeyFiles:    []string{},
+		MaxMessageAge:     5 * time.Minute,
+	}
+}
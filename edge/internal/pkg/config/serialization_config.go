@@ -1,10 +1,14 @@
 package config
 
 import (
+	"strings"
+
 	"devais.it/kronos/internal/pkg/serialization"
 	"github.com/rotisserie/eris"
 )
 
+const defaultCompressionThreshold = 1024
+
 type SerializationConfig struct {
 	// Type is the type of serialization to use.
 	Type serialization.Type
@@ -14,6 +18,20 @@ type SerializationConfig struct {
 
 	// JSONIdent is the ident string to use for JSON serialization
 	JSONIdent string
+
+	// Compression is the compression algorithm used for outgoing messages.
+	// Supported algorithms are: "NONE", "GZIP" and "SNAPPY".
+	// Compressed payloads are wrapped in an envelope which records the algorithm,
+	// and incoming compressed messages are always decompressed.
+	Compression serialization.Compression
+
+	// CompressionThreshold is the minimum size in bytes of a serialized
+	// message to be compressed
+	CompressionThreshold int
+
+	// TopicCompression overrides Compression for specific types of messages,
+	// like "EVENTS", "VERSIONS", "CONNECTED", "SYNC_ACK" or "COMMAND_RESPONSE"
+	TopicCompression map[string]serialization.Compression
 }
 
 func DefaultSerializationConfig() SerializationConfig {
@@ -21,6 +39,28 @@ func DefaultSerializationConfig() SerializationConfig {
 		Type:       serialization.TypeJSON,
 		JSONPrefix: "",
 		JSONIdent:  "",
+		// Compression is disabled by default, since the server application
+		// must be able to decode compression envelopes
+		Compression:          serialization.CompressionNone,
+		CompressionThreshold: defaultCompressionThreshold,
+		TopicCompression:     map[string]serialization.Compression{},
+	}
+}
+
+// NewCompressor constructs the compressor of outgoing messages of the given type
+func (c *SerializationConfig) NewCompressor(messageType string) *serialization.Compressor {
+	compression := c.Compression
+	// Keys are compared ignoring case, since viper lowercases them
+	for key, topicCompression := range c.TopicCompression {
+		if strings.EqualFold(key, messageType) {
+			compression = topicCompression
+			break
+		}
+	}
+
+	return &serialization.Compressor{
+		Compression: compression,
+		Threshold:   c.CompressionThreshold,
 	}
 }
 
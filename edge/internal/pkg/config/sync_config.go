@@ -1,22 +1,29 @@
 package config
 
-import "time"
+import (
+	"time"
+
+	"devais.it/kronos/internal/pkg/types"
+)
 
 const (
 	defaultSyncTelemetryEnabled         = true
 	defaultSyncNotifyGracefulDisconnect = true
 	defaultSyncMaxEvents                = 100
 	defaultSyncStopTimeout              = 10 * time.Second
+	defaultSyncMaxConflicts             = 1000
 )
 
 type SyncClientType string
 
 var (
-	SyncClientMQTT SyncClientType = "MQTT"
+	SyncClientMQTT      SyncClientType = "MQTT"
+	SyncClientWebSocket SyncClientType = "WEBSOCKET"
 )
 
 type SyncConfig struct {
-	// ClientType is the type of synchronization client to use
+	// ClientType is the type of synchronization client to use.
+	// Supported types are: "MQTT" and "WEBSOCKET".
 	ClientType SyncClientType
 
 	// PublishVersions if set to true, version of all entities will
@@ -25,14 +32,46 @@ type SyncConfig struct {
 	// This is not recommended for most cases
 	PublishVersions bool
 
+	// Reconciliation is the configuration of the hash-tree reconciliation
+	// protocol, used in place of the full versions dump when enabled
+	Reconciliation ReconciliationConfig
+
 	// TelemetryEnabled if set to true, telemetry data will be reported
 	// after a successful connection.
 	TelemetryEnabled bool
 
+	// SyncAckEnabled determines if an acknowledgement should be sent
+	// after each sync message, reporting the outcome of every entry.
+	SyncAckEnabled bool
+
+	// ConflictStrategy is the strategy used to resolve conflicts between
+	// local edits and changes coming from the server application.
+	// Supported strategies are: "SERVER_WINS", "EDGE_WINS", "LAST_MODIFIED_WINS",
+	// "LAST_SOURCE_TIMESTAMP_WINS" and "MERGE_FIELDS".
+	ConflictStrategy types.ConflictStrategy
+
+	// RecordConflicts determines if conflicts should be detected and recorded
+	// in the conflicts log with the default "SERVER_WINS" strategy, which
+	// always applies server changes. Conflicts are always recorded with other strategies.
+	RecordConflicts bool
+
+	// MaxConflicts is the maximum number of conflicts kept in the conflicts log.
+	// The oldest conflicts are deleted when exceeded. If 0, conflicts are never deleted.
+	MaxConflicts int
+
 	// NotifyGracefulDisconnect determines if a disconnect message should
 	// be sent before a graceful disconnection.
 	NotifyGracefulDisconnect bool
 
+	// Compaction is the configuration of the events queue compaction
+	Compaction CompactionConfig
+
+	// Delivery is the configuration of events delivery acknowledgements
+	Delivery DeliveryConfig
+
+	// Security is the configuration of messages signing and encryption
+	Security SecurityConfig
+
 	// MaxEvents determines the maximum number of events to send in a single message
 	MaxEvents int
 
@@ -55,20 +94,34 @@ type SyncConfig struct {
 	// MQTT is the configuration of the MQTT client.
 	// The MQTT client will be used to communicate with the server application
 	MQTT MQTTConfig
+
+	// WebSocket is the configuration of the WebSocket client.
+	// The WebSocket client will be used to communicate with the server application
+	// when ClientType is "WEBSOCKET"
+	WebSocket WebSocketConfig
 }
 
 func DefaultSyncConfig() SyncConfig {
 	return SyncConfig{
 		ClientType:               SyncClientMQTT,
 		PublishVersions:          false,
+		Reconciliation:           DefaultReconciliationConfig(),
 		TelemetryEnabled:         defaultSyncTelemetryEnabled,
+		SyncAckEnabled:           false,
+		ConflictStrategy:         types.ConflictStrategyServerWins,
+		RecordConflicts:          false,
+		MaxConflicts:             defaultSyncMaxConflicts,
 		NotifyGracefulDisconnect: defaultSyncNotifyGracefulDisconnect,
+		Compaction:               DefaultCompactionConfig(),
+		Delivery:                 DefaultDeliveryConfig(),
+		Security:                 DefaultSecurityConfig(),
 		MaxEvents:                defaultSyncMaxEvents,
 		StopTimeout:              defaultSyncStopTimeout,
 		MinSleepTime:             0,
 		Backoff:                  DefaultBackoffConfig(),
 		Sentry:                   DefaultSyncSentryConfig(),
 		MQTT:                     DefaultMQTTConfig(),
+		WebSocket:                DefaultWebSocketConfig(),
 	}
 }
 
@@ -95,3 +148,12 @@ func DefaultSyncSentryConfig() SyncSentryConfig {
 		DeliveryTimeout: time.Duration(0),
 	}
 }
+
+// MaxEntitiesPerMessage returns the maximum number of entities
+// in a single message for the configured client type
+func (c *SyncConfig) MaxEntitiesPerMessage() int {
+	if c.ClientType == SyncClientWebSocket {
+		return c.WebSocket.MaxEntitiesPerMessage
+	}
+	return c.MQTT.MaxEntitiesPerMessage
+}
@@ -0,0 +1,82 @@
+package config
+
+import (
+	"time"
+)
+
+const (
+	defaultWebSocketURL                   = "ws://localhost:8080/kronos/device/{deviceId}/ws"
+	defaultWebSocketHandshakeTimeout      = 30 * time.Second
+	defaultWebSocketCommunicationTimeout  = 30 * time.Second
+	defaultWebSocketKeepAlive             = time.Minute
+	defaultWebSocketMaxMessageSize        = 0
+	defaultWebSocketMaxEntitiesPerMessage = 50
+)
+
+type WebSocketConfig struct {
+	// URL is the WebSocket endpoint of the server application.
+	// It should use the "ws" or "wss" scheme.
+	// Supports variables.
+	URL string
+
+	// Username is the HTTP basic authentication username sent
+	// with the handshake request. Basic authentication is disabled if empty.
+	Username string
+
+	// Password is the HTTP basic authentication password
+	Password string
+
+	// Headers are additional HTTP headers sent with the handshake request.
+	// Header values support variables.
+	Headers map[string]string
+
+	// TLS are the WSS configuration options
+	TLS TLSConfig
+
+	// HandshakeTimeout is the maximum duration of the WebSocket handshake
+	HandshakeTimeout time.Duration
+
+	// CommunicationTimeout is the timeout for network communication
+	CommunicationTimeout time.Duration
+
+	// KeepAlive is the interval between ping messages sent to the server.
+	// If the server doesn't answer with a pong message before the next ping,
+	// the connection is considered lost.
+	KeepAlive time.Duration
+
+	// MaxMessageSize is the maximum size in bytes of a message read from the server,
+	// and of its payload once decompressed.
+	// If 0, no limit is applied to messages, and decompressed payloads are
+	// limited to serialization.DefaultMaxDecompressedSize.
+	MaxMessageSize int64
+
+	// Serialization is the configuration for messages serialization
+	Serialization SerializationConfig
+
+	// MaxRetries is the maximum number of tries for blocking operations (like publishing of commands response).
+	// If 0, operations are retried until the backoff maximum interval is reached.
+	MaxRetries int
+
+	// MaxEntitiesPerMessage determines the maximum number of entities in a single messages.
+	// Messages with a higher number of entities will be split into multiple messages.
+	MaxEntitiesPerMessage int
+}
+
+// DefaultWebSocketConfig creates a new WebSocket configuration structure
+// filled with default options
+func DefaultWebSocketConfig() WebSocketConfig {
+	return WebSocketConfig{
+		URL:                   defaultWebSocketURL,
+		Username:              "",
+		Password:              "",
+		Headers:               map[string]string{},
+		TLS:                   DefaultTLSConfig(),
+		HandshakeTimeout:      defaultWebSocketHandshakeTimeout,
+		CommunicationTimeout:  defaultWebSocketCommunicationTimeout,
+		KeepAlive:             defaultWebSocketKeepAlive,
+		MaxMessageSize:        defaultWebSocketMaxMessageSize,
+		Serialization:         DefaultSerializationConfig(),
+		MaxRetries:            0,
+		MaxEntitiesPerMessage: defaultWebSocketMaxEntitiesPerMessage,
+	}
+}
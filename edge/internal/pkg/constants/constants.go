@@ -5,8 +5,16 @@ const (
 
 	IDField = "id"
 
+	ParentIDField = "parent_id"
+	ChildIDField  = "child_id"
+	// RelationTypeField is part of the key of relations, along with parent and child IDs
+	RelationTypeField = "type"
+	PropertiesField   = "properties"
+
 	AttributesField = "attributes"
 
+	ChangedFieldsField = "changed_fields"
+
 	CreatedByField  = "created_by"
 	ModifiedByField = "modified_by"
 
@@ -23,6 +31,7 @@ var (
 	MetaFields = []string{
 		"version",
 		"sync_version",
+		"changed_fields",
 		"created_at",
 		"modified_at",
 		"deleted_at",
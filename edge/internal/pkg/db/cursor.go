@@ -0,0 +1,124 @@
+package db
+
+import (
+	"encoding/base64"
+	"reflect"
+	"strings"
+
+	"github.com/rotisserie/eris"
+	"gorm.io/gorm"
+)
+
+// CursorKeyer is implemented by models that can be paginated by cursor.
+// CursorKey returns the value of the unique key ordering the pages.
+type CursorKeyer interface {
+	CursorKey() string
+}
+
+// CursorKey describes the key ordering the pages of results paginated by cursor
+type CursorKey struct {
+	// Columns are the columns of the key, in the order of an index covering them
+	Columns []string
+	// Split splits a key returned by CursorKey into the values of Columns.
+	// It can be nil if the key has a single column.
+	Split func(key string) ([]interface{}, error)
+}
+
+// IDCursorKey is the cursor key of models identified by the id column
+var IDCursorKey = CursorKey{Columns: []string{"id"}}
+
+func (k CursorKey) values(key string) ([]interface{}, error) {
+	if k.Split == nil {
+		return []interface{}{key}, nil
+	}
+	values, err := k.Split(key)
+	if err != nil {
+		return nil, eris.Wrapf(ErrInvalidPagination, "invalid cursor key '%s'", key)
+	}
+	return values, nil
+}
+
+// Page contains the metadata of a page of results paginated by cursor
+type Page struct {
+	// Total is the number of results of the query, across all pages.
+	// It is only counted for the first page.
+	Total *int64 `json:"total,omitempty"`
+	// NextCursor is the cursor of the next page, empty if this is the last one
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// EncodeCursor returns the opaque cursor of the page following the given key
+func EncodeCursor(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+// DecodeCursor returns the key encoded by a cursor.
+// An empty cursor is the cursor of the first page.
+func DecodeCursor(cursor string) (string, error) {
+	key, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", eris.Wrapf(ErrInvalidPagination, "invalid cursor '%s'", cursor)
+	}
+	return string(key), nil
+}
+
+// FindPage finds a page of results paginated by cursor.
+// Unlike offset pagination, rows are never skipped or repeated when the table
+// changes between pages.
+// Results are ordered by the columns of key, the key returned by the CursorKey
+// method of the models in dest, which must be a pointer to a slice.
+// The total number of results is only counted for the first page, the one of
+// the empty cursor.
+// If pageSize is 0, default pagination is applied.
+func FindPage(tx *gorm.DB, dest interface{}, key CursorKey, cursor string, pageSize int) (*Page, error) {
+	if pageSize < 0 {
+		return nil, ErrInvalidPagination
+	}
+	pageSize = pageSizeOrDefault(pageSize)
+
+	lastKey, err := DecodeCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	if tx.Statement.Model == nil {
+		tx = tx.Model(dest)
+	}
+	tx = tx.Session(&gorm.Session{})
+	page := &Page{}
+
+	columns := strings.Join(key.Columns, ", ")
+	if lastKey == "" {
+		var total int64
+		if err = tx.Select("COUNT(*)").Row().Scan(&total); err != nil {
+			return nil, eris.Wrap(err, "count query failed")
+		}
+		page.Total = &total
+	} else {
+		values, err := key.values(lastKey)
+		if err != nil {
+			return nil, err
+		}
+		// Row values comparison, so that an index on the key columns can be used
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(values)), ", ")
+		tx = tx.Where("("+columns+") > ("+placeholders+")", values...)
+	}
+
+	// An additional row determines if a following page exists
+	err = tx.Order(columns).Limit(pageSize + 1).Find(dest).Error
+	if err != nil {
+		return nil, eris.Wrap(err, "page query failed")
+	}
+
+	results := reflect.ValueOf(dest).Elem()
+	if results.Len() > pageSize {
+		results.Set(results.Slice(0, pageSize))
+		last, ok := results.Index(pageSize - 1).Addr().Interface().(CursorKeyer)
+		if !ok {
+			return nil, eris.Errorf("%s can't be paginated by cursor", results.Type().Elem())
+		}
+		page.NextCursor = EncodeCursor(last.CursorKey())
+	}
+
+	return page, nil
+}
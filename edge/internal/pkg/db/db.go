@@ -1,6 +1,8 @@
 package db
 
 import (
+	"database/sql"
+
 	"devais.it/kronos/internal/pkg/config"
 	"devais.it/kronos/internal/pkg/logging"
 	"devais.it/kronos/internal/pkg/util"
@@ -12,7 +14,9 @@ import (
 )
 
 var (
-	db *gorm.DB
+	db     *gorm.DB
+	sqlDB  *sql.DB
+	dbConf *config.DBConfig
 
 	ErrForeignKeysDisabled = eris.New("Foreign keys are disabled")
 	ErrInvalidPagination   = eris.New("Invalid pagination")
@@ -36,10 +40,24 @@ func OpenDB(conf *config.DBConfig) error {
 		gormConfig.NowFunc = util.NowFuncLocal
 	}
 
-	db, err = gorm.Open(sqlite.Open(conf.URL), gormConfig)
+	sqlDB, err = sql.Open(sqlite.DriverName, conf.URL)
+	if err != nil {
+		return eris.Wrap(err, "failed to open db")
+	}
+
+	// Transactions keep the cached events queue stats consistent
+	dialector := queueStatsDialector{&sqlite.Dialector{
+		DSN:  conf.URL,
+		Conn: &queueStatsConnPool{sqlDB},
+	}}
+
+	db, err = gorm.Open(dialector, gormConfig)
 	if err != nil {
+		_ = sqlDB.Close()
 		return eris.Wrap(err, "failed to init db")
 	}
+	dbConf = conf
+	InvalidateEventsQueueStats(nil)
 
 	errRef := &err
 
@@ -71,12 +89,16 @@ func DB() *gorm.DB {
 	return db
 }
 
+// Config returns the configuration the database was opened with
+func Config() *config.DBConfig {
+	return dbConf
+}
+
 func Close() error {
-	sqlDb, err := db.DB()
-	if err != nil {
-		return eris.Wrap(err, "failed to get sql.DB")
+	if sqlDB == nil {
+		return eris.New("failed to get sql.DB")
 	}
-	return sqlDb.Close()
+	return sqlDB.Close()
 }
 
 // Size returns the database size
@@ -146,17 +168,23 @@ func Paginate(tx *gorm.DB, page, pageSize int) (*gorm.DB, error) {
 		page = 1
 	}
 
+	pageSize = pageSizeOrDefault(pageSize)
+
+	offset = (page - 1) * pageSize
+	limit = pageSize
+
+	return tx.Offset(offset).Limit(limit), nil
+}
+
+// pageSizeOrDefault returns the configured page size if pageSize is 0
+func pageSizeOrDefault(pageSize int) int {
 	if pageSize == 0 {
 		pageSize = viper.GetInt("db.paginationSize")
 		if pageSize <= 0 {
 			pageSize = config.DefaultDBPaginationSize
 		}
 	}
-
-	offset = (page - 1) * pageSize
-	limit = pageSize
-
-	return tx.Offset(offset).Limit(limit), nil
+	return pageSize
 }
 
 // GetAll returns all the record of a table applying some pagination first
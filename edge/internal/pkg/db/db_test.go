@@ -1,10 +1,12 @@
 package db
 
 import (
+	"errors"
+	"testing"
+
 	"devais.it/kronos/internal/pkg/db/models"
 	"github.com/stretchr/testify/suite"
 	"gorm.io/gorm"
-	"testing"
 )
 
 type DbTestSuite struct {
@@ -70,6 +72,92 @@ func (s *DbTestSuite) TestCrud() {
 	assert.LessOrEqual(tx.RowsAffected, int64(0), "Failed to delete created item")
 }
 
+func (s *DbTestSuite) TestEventsQueueStats() {
+	assert := s.Require()
+
+	newEvent := func() *models.Event {
+		return &models.Event{EntityID: "test-item-uuid", TriggeredBy: "TEST", Body: `{}`}
+	}
+
+	assert.NoError(db.Create(newEvent()).Error)
+
+	count, size, err := CachedEventsQueueStats(db)
+	assert.NoError(err)
+	assert.EqualValues(1, count)
+
+	// Cached stats are updated without scanning the queue
+	assert.NoError(db.Create(newEvent()).Error)
+	AddEventsQueueStats(db, 1, 2+EventRowOverhead)
+
+	count, cachedSize, err := CachedEventsQueueStats(db)
+	assert.NoError(err)
+	assert.EqualValues(2, count)
+	assert.Equal(2*size, cachedSize)
+
+	assert.NoError(db.Create(newEvent()).Error)
+
+	count, _, err = CachedEventsQueueStats(db)
+	assert.NoError(err)
+	assert.EqualValues(2, count)
+
+	InvalidateEventsQueueStats(nil)
+
+	count, _, err = CachedEventsQueueStats(db)
+	assert.NoError(err)
+	assert.EqualValues(3, count)
+
+	addEvent := func(tx *gorm.DB) error {
+		if err := tx.Create(newEvent()).Error; err != nil {
+			return err
+		}
+		AddEventsQueueStats(tx, 1, 2+EventRowOverhead)
+		return nil
+	}
+
+	// Changes of a transaction are applied once committed,
+	// but its own changes are visible inside it
+	assert.NoError(db.Transaction(func(tx *gorm.DB) error {
+		assert.NoError(addEvent(tx))
+		count, _, err := CachedEventsQueueStats(tx)
+		assert.NoError(err)
+		assert.EqualValues(4, count)
+
+		count, _, err = CachedEventsQueueStats(db)
+		assert.NoError(err)
+		assert.EqualValues(3, count)
+		return nil
+	}))
+
+	count, _, err = CachedEventsQueueStats(db)
+	assert.NoError(err)
+	assert.EqualValues(4, count)
+
+	// Changes of a rolled back transaction are discarded
+	failure := errors.New("rollback")
+	assert.ErrorIs(db.Transaction(func(tx *gorm.DB) error {
+		assert.NoError(addEvent(tx))
+		return failure
+	}), failure)
+
+	count, _, err = CachedEventsQueueStats(db)
+	assert.NoError(err)
+	assert.EqualValues(4, count)
+
+	// Stats are scanned again when a transaction is rolled back to a savepoint
+	assert.NoError(db.Transaction(func(tx *gorm.DB) error {
+		assert.NoError(addEvent(tx))
+		assert.ErrorIs(tx.Transaction(func(savepointTx *gorm.DB) error {
+			assert.NoError(addEvent(savepointTx))
+			return failure
+		}), failure)
+		return nil
+	}))
+
+	count, _, err = CachedEventsQueueStats(db)
+	assert.NoError(err)
+	assert.EqualValues(5, count)
+}
+
 func TestCrud(t *testing.T) {
 	suite.Run(t, new(DbTestSuite))
 }
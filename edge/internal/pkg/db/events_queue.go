@@ -0,0 +1,209 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"devais.it/kronos/internal/pkg/db/models"
+	"github.com/rotisserie/eris"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// EventRowOverhead is the approximate size of an event row, excluding its body
+const EventRowOverhead = 128
+
+// eventsQueueStatsMaxAge is the maximum age of cached events queue stats,
+// which approximate the size of event rows
+const eventsQueueStatsMaxAge = 10 * time.Second
+
+// queueStats caches the events queue stats between scans of the whole queue
+var queueStats struct {
+	sync.Mutex
+	valid       bool
+	count       int64
+	size        int64
+	refreshedAt time.Time
+}
+
+// EventsQueueStats returns the number of events in the queue and their approximate size
+func EventsQueueStats(tx *gorm.DB) (count int64, size int64, err error) {
+	row := tx.
+		Table(models.EventsTableName).
+		Select("COUNT(*), COALESCE(SUM(LENGTH(body)), 0)").
+		Row()
+	if err = row.Scan(&count, &size); err != nil {
+		return 0, 0, eris.Wrap(err, "failed to get events queue stats")
+	}
+	size += count * EventRowOverhead
+	return count, size, nil
+}
+
+// CachedEventsQueueStats returns the events queue stats like EventsQueueStats,
+// scanning the queue only when cached stats are missing or too old.
+// Cached stats are kept up to date with AddEventsQueueStats.
+// Inside a transaction, its own uncommitted changes are included.
+func CachedEventsQueueStats(tx *gorm.DB) (count int64, size int64, err error) {
+	statsTx := queueStatsTxOf(tx)
+	var pendingCount, pendingSize int64
+	if statsTx != nil {
+		pendingCount, pendingSize = statsTx.pending()
+	}
+
+	queueStats.Lock()
+	if queueStats.valid && time.Since(queueStats.refreshedAt) < eventsQueueStatsMaxAge {
+		count, size = queueStats.count, queueStats.size
+		queueStats.Unlock()
+		return count + pendingCount, size + pendingSize, nil
+	}
+	queueStats.Unlock()
+
+	count, size, err = EventsQueueStats(tx)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// The scan of a transaction sees its uncommitted changes,
+	// so it's discarded when the transaction ends
+	if statsTx != nil {
+		statsTx.invalidate()
+	}
+
+	queueStats.Lock()
+	queueStats.valid = true
+	queueStats.count = count - pendingCount
+	queueStats.size = size - pendingSize
+	queueStats.refreshedAt = time.Now()
+	queueStats.Unlock()
+
+	return count, size, nil
+}
+
+func addEventsQueueStats(count, size int64) {
+	queueStats.Lock()
+	defer queueStats.Unlock()
+	queueStats.count += count
+	queueStats.size += size
+}
+
+func invalidateEventsQueueStats() {
+	queueStats.Lock()
+	defer queueStats.Unlock()
+	queueStats.valid = false
+}
+
+// AddEventsQueueStats updates cached events queue stats with the number
+// and size of events added by tx, negative for removed ones.
+// Changes of a transaction are applied only once it's committed.
+func AddEventsQueueStats(tx *gorm.DB, count, size int64) {
+	if statsTx := queueStatsTxOf(tx); statsTx != nil {
+		statsTx.add(count, size)
+		return
+	}
+	addEventsQueueStats(count, size)
+}
+
+// InvalidateEventsQueueStats discards cached events queue stats,
+// when events are removed by tx without knowing their size.
+// Inside a transaction, stats are discarded again once it ends.
+func InvalidateEventsQueueStats(tx *gorm.DB) {
+	if statsTx := queueStatsTxOf(tx); statsTx != nil {
+		statsTx.invalidate()
+	}
+	invalidateEventsQueueStats()
+}
+
+// queueStatsConnPool is the connection pool of the database,
+// starting transactions which track their changes to the events queue stats
+type queueStatsConnPool struct {
+	*sql.DB
+}
+
+func (p *queueStatsConnPool) BeginTx(ctx context.Context, opts *sql.TxOptions) (gorm.ConnPool, error) {
+	tx, err := p.DB.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &queueStatsTx{Tx: tx}, nil
+}
+
+// queueStatsTx is a transaction applying its changes to the events queue stats
+// when committed, and discarding them when rolled back
+type queueStatsTx struct {
+	*sql.Tx
+
+	sync.Mutex
+	count       int64
+	size        int64
+	invalidated bool
+}
+
+func queueStatsTxOf(tx *gorm.DB) *queueStatsTx {
+	if tx == nil {
+		return nil
+	}
+	statsTx, _ := tx.Statement.ConnPool.(*queueStatsTx)
+	return statsTx
+}
+
+func (t *queueStatsTx) add(count, size int64) {
+	t.Lock()
+	defer t.Unlock()
+	t.count += count
+	t.size += size
+}
+
+func (t *queueStatsTx) pending() (int64, int64) {
+	t.Lock()
+	defer t.Unlock()
+	return t.count, t.size
+}
+
+// invalidate discards cached stats once the transaction ends,
+// since its changes can't be tracked
+func (t *queueStatsTx) invalidate() {
+	t.Lock()
+	defer t.Unlock()
+	t.invalidated = true
+}
+
+func (t *queueStatsTx) Commit() error {
+	if err := t.Tx.Commit(); err != nil {
+		invalidateEventsQueueStats()
+		return err
+	}
+	t.Lock()
+	defer t.Unlock()
+	if t.invalidated {
+		invalidateEventsQueueStats()
+	} else {
+		addEventsQueueStats(t.count, t.size)
+	}
+	return nil
+}
+
+func (t *queueStatsTx) Rollback() error {
+	t.Lock()
+	invalidated := t.invalidated
+	t.Unlock()
+	if invalidated {
+		invalidateEventsQueueStats()
+	}
+	return t.Tx.Rollback()
+}
+
+// queueStatsDialector is the SQLite dialector, invalidating events queue stats
+// when a transaction is rolled back to a savepoint, since changes made after
+// the savepoint are unknown
+type queueStatsDialector struct {
+	*sqlite.Dialector
+}
+
+func (d queueStatsDialector) RollbackTo(tx *gorm.DB, name string) error {
+	if statsTx := queueStatsTxOf(tx); statsTx != nil {
+		statsTx.invalidate()
+	}
+	return d.Dialector.RollbackTo(tx, name)
+}
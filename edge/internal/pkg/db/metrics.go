@@ -10,6 +10,11 @@ import (
 
 type Metrics struct {
 	dbSize prometheus.Gauge
+
+	eventsQueueLength    prometheus.Gauge
+	eventsQueueSize      prometheus.Gauge
+	eventsQueueMaxLength prometheus.Gauge
+	eventsQueueMaxSize   prometheus.Gauge
 }
 
 func NewMetrics() *Metrics {
@@ -20,12 +25,40 @@ func NewMetrics() *Metrics {
 				Help: "The SQLite database size",
 			},
 		),
+		eventsQueueLength: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "kronos_events_queue_length",
+				Help: "The number of events in the queue",
+			},
+		),
+		eventsQueueSize: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "kronos_events_queue_size",
+				Help: "The approximate size of the events in the queue",
+			},
+		),
+		eventsQueueMaxLength: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "kronos_events_queue_max_length",
+				Help: "The maximum number of events in the queue, 0 if unlimited",
+			},
+		),
+		eventsQueueMaxSize: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "kronos_events_queue_max_size",
+				Help: "The maximum size of the events in the queue, 0 if unlimited",
+			},
+		),
 	}
 }
 
 func (m *Metrics) Collectors() []prometheus.Collector {
 	return []prometheus.Collector{
 		m.dbSize,
+		m.eventsQueueLength,
+		m.eventsQueueSize,
+		m.eventsQueueMaxLength,
+		m.eventsQueueMaxSize,
 	}
 }
 
@@ -36,6 +69,18 @@ func (m *Metrics) RefreshMetrics() error {
 	}
 	m.dbSize.Set(float64(size))
 
+	count, queueSize, err := EventsQueueStats(db)
+	if err != nil {
+		return err
+	}
+	m.eventsQueueLength.Set(float64(count))
+	m.eventsQueueSize.Set(float64(queueSize))
+
+	if dbConf != nil {
+		m.eventsQueueMaxLength.Set(float64(dbConf.EventsQueue.MaxEvents))
+		m.eventsQueueMaxSize.Set(float64(dbConf.EventsQueue.MaxSize))
+	}
+
 	return nil
 }
 
@@ -1,6 +1,8 @@
 package db
 
 import (
+	"strings"
+
 	"devais.it/kronos/internal/pkg/config"
 	"devais.it/kronos/internal/pkg/db/models"
 	"github.com/rotisserie/eris"
@@ -21,6 +23,13 @@ func runMigrations(db *gorm.DB, conf *config.DBConfig) (err error) {
 		}
 	}
 
+	// Must run before auto migration, which would add the relation type
+	// column without making it part of the primary key
+	err = migrateRelationTypes(db)
+	if err != nil {
+		return eris.Wrap(err, "failed to migrate relation types")
+	}
+
 	if shouldMigrate {
 		err = db.AutoMigrate(models.GetAllModels()...)
 		if err != nil {
@@ -37,7 +46,74 @@ func runMigrations(db *gorm.DB, conf *config.DBConfig) (err error) {
 	return
 }
 
+// migrateRelationTypes recreates the relations table created before relation
+// types were introduced, since the type is part of the primary key and SQLite
+// can't alter it. Existing relations become untyped relations.
+func migrateRelationTypes(db *gorm.DB) error {
+	migrator := db.Migrator()
+	if !migrator.HasTable(&models.Relation{}) || migrator.HasColumn(&models.Relation{}, "Type") {
+		return nil
+	}
+
+	const untypedTableName = models.RelationsTableName + "_untyped"
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		migrator := tx.Migrator()
+
+		columnTypes, err := migrator.ColumnTypes(&models.Relation{})
+		if err != nil {
+			return eris.Wrap(err, "failed to get relations columns")
+		}
+		columns := make([]string, len(columnTypes))
+		for i, columnType := range columnTypes {
+			columns[i] = columnType.Name()
+		}
+		columnList := strings.Join(columns, ", ")
+
+		if err = migrator.RenameTable(models.RelationsTableName, untypedTableName); err != nil {
+			return eris.Wrap(err, "failed to rename relations table")
+		}
+		if err = migrator.CreateTable(&models.Relation{}); err != nil {
+			return eris.Wrap(err, "failed to create typed relations table")
+		}
+		err = tx.Exec(
+			"INSERT INTO " + models.RelationsTableName + " (" + columnList + ") " +
+				"SELECT " + columnList + " FROM " + untypedTableName,
+		).Error
+		if err != nil {
+			return eris.Wrap(err, "failed to copy untyped relations")
+		}
+		if err = migrator.DropTable(untypedTableName); err != nil {
+			return eris.Wrap(err, "failed to drop untyped relations table")
+		}
+
+		return nil
+	})
+}
+
 func runManualMigrations(migrator gorm.Migrator) error {
 	// Add manual migrations here
+
+	// Events delivery acknowledgement and transaction parts
+	for _, field := range []string{"BatchID", "SentAt", "TxPart"} {
+		if !migrator.HasColumn(&models.Event{}, field) {
+			if err := migrator.AddColumn(&models.Event{}, field); err != nil {
+				return eris.Wrapf(err, "failed to add events column '%s'", field)
+			}
+		}
+	}
+	if !migrator.HasIndex(&models.Event{}, "BatchID") {
+		if err := migrator.CreateIndex(&models.Event{}, "BatchID"); err != nil {
+			return eris.Wrap(err, "failed to create events batch index")
+		}
+	}
+
+	// Fields edited locally when a conflict was detected
+	if !migrator.HasColumn(&models.Conflict{}, "ChangedFields") {
+		if err := migrator.AddColumn(&models.Conflict{}, "ChangedFields"); err != nil {
+			return eris.Wrap(err, "failed to add conflicts column 'ChangedFields'")
+		}
+	}
+
 	return nil
 }
@@ -0,0 +1,68 @@
+package db
+
+import (
+	"testing"
+
+	"devais.it/kronos/internal/pkg/db/models"
+	"github.com/stretchr/testify/suite"
+)
+
+// untypedRelation is a relation as stored before relation types were introduced
+type untypedRelation struct {
+	ParentID string `gorm:"type:char(128);primaryKey"`
+	ChildID  string `gorm:"type:char(128);primaryKey"`
+	models.SyncModel
+}
+
+func (untypedRelation) TableName() string {
+	return models.RelationsTableName
+}
+
+type MigrationsSuite struct {
+	SuiteBase
+}
+
+func (s *MigrationsSuite) TestRelationTypes() {
+	assert := s.Require()
+
+	for _, id := range []string{"Parent-ID", "Child-ID"} {
+		item := &models.Item{ID: id, Name: id, Type: "TestItem"}
+		item.CreatedBy = "TEST"
+		item.ModifiedBy = "TEST"
+		assert.NoError(db.Create(item).Error)
+	}
+
+	assert.NoError(db.Migrator().DropTable(&models.Relation{}))
+	assert.NoError(db.Migrator().CreateTable(&untypedRelation{}))
+
+	legacy := &untypedRelation{ParentID: "Parent-ID", ChildID: "Child-ID"}
+	legacy.CreatedBy = "TEST"
+	legacy.ModifiedBy = "TEST"
+	legacy.Version = "legacy-version"
+	assert.NoError(db.Create(legacy).Error)
+
+	assert.NoError(runMigrations(db, &s.dbConf))
+	assert.True(db.Migrator().HasColumn(&models.Relation{}, "Type"))
+
+	relation := &models.Relation{}
+	assert.NoError(db.First(relation, "parent_id = ? AND child_id = ?", "Parent-ID", "Child-ID").Error)
+	assert.Equal("", relation.Type)
+	assert.Equal("legacy-version", relation.Version)
+	assert.Equal("TEST", relation.CreatedBy)
+
+	// The type is part of the primary key
+	typed := &models.Relation{ParentID: "Parent-ID", ChildID: "Child-ID", Type: "powered_by"}
+	typed.CreatedBy = "TEST"
+	typed.ModifiedBy = "TEST"
+	assert.NoError(db.Create(typed).Error)
+
+	// Migrations run again on the typed table are a no-op
+	assert.NoError(runMigrations(db, &s.dbConf))
+	var count int64
+	assert.NoError(db.Model(&models.Relation{}).Count(&count).Error)
+	assert.Equal(int64(2), count)
+}
+
+func TestMigrations(t *testing.T) {
+	suite.Run(t, new(MigrationsSuite))
+}
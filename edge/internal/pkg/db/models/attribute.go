@@ -25,6 +25,10 @@ func (Attribute) TableName() string {
 	return AttributesTableName
 }
 
+func (a *Attribute) CursorKey() string {
+	return a.ID
+}
+
 func (a *Attribute) AfterFind(*gorm.DB) error {
 	return nil
 }
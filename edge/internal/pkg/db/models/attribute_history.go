@@ -0,0 +1,25 @@
+package models
+
+// AttributeHistory is a value recorded in the history of an attribute
+type AttributeHistory struct {
+	ID          uint       `gorm:"primaryKey" json:"-"`
+	AttributeID string     `gorm:"type:char(128);not null;index:idx_history_attribute_ts" json:"attribute_id"`
+	Attribute   *Attribute `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	Value       string     `json:"value"`
+	Timestamp   uint64     `gorm:"index:idx_history_attribute_ts" json:"timestamp"`
+}
+
+func (h *AttributeHistory) TableName() string {
+	return AttributesHistoryTableName
+}
+
+// HistoryBucket is the summary of the numeric values
+// recorded in the history of an attribute during a time interval
+type HistoryBucket struct {
+	// Timestamp is the start of the interval
+	Timestamp uint64  `json:"timestamp"`
+	Min       float64 `json:"min"`
+	Max       float64 `json:"max"`
+	Avg       float64 `json:"avg"`
+	Count     int64   `json:"count"`
+}
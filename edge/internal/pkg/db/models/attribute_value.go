@@ -6,3 +6,11 @@ type AttributeValue struct {
 	Value     string `json:"value"`
 	ValueType string `json:"value_type"`
 }
+
+// TypedAttributeValue is the value of an attribute converted
+// to the native type of its value type
+type TypedAttributeValue struct {
+	Value     interface{} `json:"value"`
+	ValueType string      `json:"value_type"`
+	BaseType  string      `json:"base_type"`
+}
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"devais.it/kronos/internal/pkg/types"
+)
+
+// Conflict is a conflict between local edits and changes
+// coming from the server application, detected during synchronization
+type Conflict struct {
+	ID            uint                     `gorm:"primaryKey" json:"id"`
+	EntityType    types.EntityType         `gorm:"type:char(20);index:idx_conflict_entity" json:"entity_type"`
+	EntityID      string                   `gorm:"type:char(128);index:idx_conflict_entity" json:"entity_id"`
+	Action        string                   `gorm:"type:char(20)" json:"action"`
+	Strategy      types.ConflictStrategy   `gorm:"type:char(40)" json:"strategy"`
+	Resolution    types.ConflictResolution `gorm:"type:char(20)" json:"resolution"`
+	LocalVersion  string                   `gorm:"type:char(40)" json:"local_version"`
+	SyncVersion   string                   `gorm:"type:char(40)" json:"sync_version"`
+	RemoteVersion string                   `gorm:"type:char(40)" json:"remote_version"`
+	ChangedFields string                   `json:"changed_fields,omitempty"`
+	LocalBody     string                   `json:"local_body,omitempty"`
+	RemoteBody    string                   `json:"remote_body,omitempty"`
+	Timestamp     uint64                   `json:"timestamp"`
+}
+
+func (c *Conflict) TableName() string {
+	return ConflictsTableName
+}
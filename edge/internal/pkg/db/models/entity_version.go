@@ -9,3 +9,7 @@ type EntityVersion struct {
 	ModifiedAt  uint64 `json:"modified_at"`
 	ModifiedBy  string `json:"modified_by"`
 }
+
+func (v *EntityVersion) CursorKey() string {
+	return v.ID
+}
@@ -14,8 +14,13 @@ type Event struct {
 	TxUUID      string           `gorm:"type:char(64);" json:"tx_uuid,omitempty"`
 	TxLen       int              `json:"tx_len,omitempty"`
 	TxIndex     int              `json:"tx_index,omitempty"`
-	Timestamp   uint64           `json:"timestamp"`
-	Body        string           `json:"body,omitempty"`
+	// TxPart is the part the event was dequeued in, for transactions
+	// dequeued in parts, see TxParts
+	TxPart    int    `json:"tx_part,omitempty"`
+	Timestamp uint64 `json:"timestamp"`
+	Body      string `json:"body,omitempty"`
+	BatchID   string `gorm:"type:char(64);index" json:"batch_id,omitempty"`
+	SentAt    uint64 `json:"sent_at,omitempty"`
 }
 
 // UnmarshalBody deserializes the event's JSON body to a given model
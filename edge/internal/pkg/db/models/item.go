@@ -20,6 +20,10 @@ func (Item) TableName() string {
 	return ItemsTableName
 }
 
+func (i *Item) CursorKey() string {
+	return i.ID
+}
+
 //=============================================================================
 // Hooks
 //=============================================================================
@@ -15,6 +15,11 @@ const (
 	AttributesTableName = "attributes"
 	RelationsTableName  = "relations"
 	EventsTableName     = "events_queue"
+	ConflictsTableName  = "sync_conflicts"
+	TxPartsTableName    = "events_tx_parts"
+
+	SchemaRegistryTableName    = "schema_registry"
+	AttributesHistoryTableName = "attributes_history"
 )
 
 // GetAllModels returns an empty list of all database models
@@ -24,6 +29,10 @@ func GetAllModels() []interface{} {
 		&Attribute{},
 		&Relation{},
 		&Event{},
+		&Conflict{},
+		&TxParts{},
+		&SchemaRegistry{},
+		&AttributeHistory{},
 	}
 }
 
@@ -34,6 +43,10 @@ func GetTableNames() []string {
 		AttributesTableName,
 		RelationsTableName,
 		EventsTableName,
+		ConflictsTableName,
+		TxPartsTableName,
+		SchemaRegistryTableName,
+		AttributesHistoryTableName,
 	}
 }
 
@@ -59,18 +72,24 @@ func GetEntityType(entity interface{}) types.EntityType {
 		return types.EntityTypeItem
 	case []Item:
 		return types.EntityTypeItem
+	case *[]Item:
+		return types.EntityTypeItem
 	case Attribute:
 		return types.EntityTypeAttribute
 	case *Attribute:
 		return types.EntityTypeAttribute
 	case []Attribute:
 		return types.EntityTypeAttribute
+	case *[]Attribute:
+		return types.EntityTypeAttribute
 	case Relation:
 		return types.EntityTypeRelation
 	case *Relation:
 		return types.EntityTypeRelation
 	case []Relation:
 		return types.EntityTypeRelation
+	case *[]Relation:
+		return types.EntityTypeRelation
 	default:
 		log.Panicf("Unknown entity type: %v", reflect.TypeOf(entity))
 		return ""
@@ -97,6 +116,20 @@ func GetEntityID(entity interface{}) string {
 	}
 }
 
+// GetEntitySyncModel returns the synchronization fields of a given entity
+func GetEntitySyncModel(entity interface{}) (*SyncModel, error) {
+	switch e := entity.(type) {
+	case *Item:
+		return &e.SyncModel, nil
+	case *Attribute:
+		return &e.SyncModel, nil
+	case *Relation:
+		return &e.SyncModel, nil
+	default:
+		return nil, eris.Errorf("Unknown entity type: %v", reflect.TypeOf(entity))
+	}
+}
+
 func GetEntitySyncPolicy(entity interface{}) (null.String, error) {
 	switch e := entity.(type) {
 	case Item:
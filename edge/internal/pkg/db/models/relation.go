@@ -1,15 +1,28 @@
 package models
 
 import (
+	"database/sql/driver"
+	"strings"
+
+	jsoniter "github.com/json-iterator/go"
 	"github.com/rotisserie/eris"
 	"gorm.io/gorm"
-	"strings"
 )
 
 const (
 	relationCompositeIDSeparator = "->"
+	relationTypePrefix           = "-["
+	relationTypeSuffix           = "]"
+
+	// RelationCompositeIDColumn is the SQL expression of the relation composite ID
+	RelationCompositeIDColumn = "parent_id || " +
+		"CASE WHEN type = '' THEN '' " +
+		"ELSE '" + relationTypePrefix + "' || type || '" + relationTypeSuffix + "' END || " +
+		"'" + relationCompositeIDSeparator + "' || child_id"
 )
 
+var ErrInvalidRelationType = eris.New("Invalid relation type")
+
 type Relation struct {
 	ParentID string `gorm:"<-:create;type:char(128);primaryKey" json:"parent_id"`
 	Parent   *Item  `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
@@ -17,27 +30,97 @@ type Relation struct {
 	ChildID string `gorm:"<-:create;type:char(128);primaryKey" json:"child_id"`
 	Child   *Item  `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
 
+	// Type is the label of the relation, e.g. "monitored_by" or "powered_by".
+	// The empty type is the plain containment relation.
+	Type string `gorm:"<-:create;type:char(64);primaryKey;not null;default:''" json:"type"`
+	// Properties are the attributes of the relation itself
+	Properties RelationProperties `gorm:"type:text;default:null" json:"properties,omitempty"`
+	// SortIndex orders the children of a parent
+	SortIndex int `gorm:"not null;default:0" json:"sort_index"`
+
 	SyncModel
 }
 
+// RelationProperties are free-form relation properties, stored as JSON
+type RelationProperties map[string]interface{}
+
+func (p RelationProperties) Value() (driver.Value, error) {
+	if p == nil {
+		return nil, nil
+	}
+	json := jsoniter.ConfigCompatibleWithStandardLibrary
+	bytes, err := json.Marshal(p)
+	if err != nil {
+		return nil, eris.Wrap(err, "failed to marshal relation properties")
+	}
+	return string(bytes), nil
+}
+
+func (p *RelationProperties) Scan(value interface{}) error {
+	var bytes []byte
+	switch v := value.(type) {
+	case nil:
+		*p = nil
+		return nil
+	case string:
+		bytes = []byte(v)
+	case []byte:
+		bytes = v
+	default:
+		return eris.Errorf("failed to scan relation properties of type %T", value)
+	}
+	json := jsoniter.ConfigCompatibleWithStandardLibrary
+	return json.Unmarshal(bytes, p)
+}
+
+// ValidateRelationType checks that a relation type can be part of a composite ID
+func ValidateRelationType(relationType string) error {
+	if strings.Contains(relationType, relationCompositeIDSeparator) ||
+		strings.ContainsAny(relationType, "[]") {
+		return eris.Wrapf(ErrInvalidRelationType, "relation type '%s' contains reserved characters", relationType)
+	}
+	return nil
+}
+
 func (Relation) TableName() string {
 	return RelationsTableName
 }
 
-// CompositeID get the composite relation ID.
+func (r *Relation) CursorKey() string {
+	return r.CompositeID()
+}
+
+// CompositeID get the composite relation ID: "parent->child" for untyped
+// relations, "parent-[type]->child" for typed ones.
 func (r *Relation) CompositeID() string {
-	return r.ParentID + relationCompositeIDSeparator + r.ChildID
+	if r.Type == "" {
+		return r.ParentID + relationCompositeIDSeparator + r.ChildID
+	}
+	return r.ParentID + relationTypePrefix + r.Type + relationTypeSuffix +
+		relationCompositeIDSeparator + r.ChildID
 }
 
-// SetCompositeID sets the relation ParentID and ChildID from a composite ID
+// SetCompositeID sets the relation ParentID, ChildID and Type from a composite ID
 func (r *Relation) SetCompositeID(id string) error {
 	parts := strings.SplitN(id, relationCompositeIDSeparator, 2)
 	if len(parts) != 2 {
 		return eris.Errorf("failed to parse relation composite ID: '%s'", id)
 	}
 
-	r.ParentID = parts[0]
+	parent := parts[0]
+	relationType := ""
+	if strings.HasSuffix(parent, relationTypeSuffix) {
+		i := strings.LastIndex(parent, relationTypePrefix)
+		if i < 0 {
+			return eris.Errorf("failed to parse relation composite ID: '%s'", id)
+		}
+		relationType = parent[i+len(relationTypePrefix) : len(parent)-len(relationTypeSuffix)]
+		parent = parent[:i]
+	}
+
+	r.ParentID = parent
 	r.ChildID = parts[1]
+	r.Type = relationType
 	return nil
 }
 
@@ -50,6 +133,9 @@ func (r *Relation) AfterFind(*gorm.DB) error {
 }
 
 func (r *Relation) BeforeCreate(*gorm.DB) error {
+	if err := ValidateRelationType(r.Type); err != nil {
+		return err
+	}
 	return r.updateVersion(r)
 }
 
@@ -0,0 +1,14 @@
+package models
+
+// SchemaRegistry is the schema registry received from the server application.
+// A single row is stored, which takes precedence over the configured registry file.
+type SchemaRegistry struct {
+	ID        uint   `gorm:"primaryKey" json:"id"`
+	Version   string `gorm:"type:char(64)" json:"version"`
+	Content   string `json:"content"`
+	UpdatedAt uint64 `json:"updated_at"`
+}
+
+func (r *SchemaRegistry) TableName() string {
+	return SchemaRegistryTableName
+}
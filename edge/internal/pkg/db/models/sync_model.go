@@ -12,6 +12,16 @@ type SyncModel struct {
 	SyncPolicy  null.String `gorm:"default:null" json:"sync_policy,omitempty"`
 	Version     string      `gorm:"type:char(40);not null;default:null" json:"version"`
 	SyncVersion null.String `gorm:"type:char(40);default:null" json:"sync_version"`
+	// ChangedFields is the comma separated list of fields edited locally
+	// since the last synchronization
+	ChangedFields null.String `gorm:"default:null" json:"-"`
+}
+
+// RefreshVersion computes the version of a model again,
+// after its content was changed locally
+func (s *SyncModel) RefreshVersion(model interface{}) error {
+	s.Version = ""
+	return s.updateVersion(model)
 }
 
 func (s *SyncModel) updateVersion(model interface{}) error {
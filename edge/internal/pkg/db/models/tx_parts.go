@@ -0,0 +1,12 @@
+package models
+
+// TxParts counts the parts of a transaction already dequeued, for transactions
+// larger than an events batch which are dequeued in parts
+type TxParts struct {
+	TxUUID string `gorm:"type:char(64);primaryKey" json:"tx_uuid"`
+	Parts  int    `json:"parts"`
+}
+
+func (p *TxParts) TableName() string {
+	return TxPartsTableName
+}
@@ -48,6 +48,7 @@ func (s *SuiteBase) SetupTest() {
 		tx := db.Exec("DELETE FROM " + tableName)
 		assert.NoError(tx.Error, "Failed to clear table")
 	}
+	InvalidateEventsQueueStats(nil)
 	s.T().Log("Tables deleted")
 	// Reconfigure SQLite, just to be sure
 	//assert.NoError(
@@ -29,3 +29,9 @@ func InitPahoLogger() {
 	MQTT.WARN = PahoLogger{level: log.WarnLevel}
 	MQTT.DEBUG = PahoLogger{level: log.DebugLevel}
 }
+
+// NewPahoLogger creates a logger for the Paho MQTT v5 client,
+// which is configured per client instead of globally
+func NewPahoLogger(level log.Level) PahoLogger {
+	return PahoLogger{level: level}
+}
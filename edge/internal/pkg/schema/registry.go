@@ -0,0 +1,213 @@
+/*
+Package schema contains the registry of item and attribute types,
+used to validate entities before they are written to the database.
+*/
+package schema
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"regexp"
+
+	"github.com/rotisserie/eris"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// BaseType is the native type of attribute values
+type BaseType string
+
+const (
+	BaseString BaseType = "string"
+	BaseInt    BaseType = "int"
+	BaseFloat  BaseType = "float"
+	BaseBool   BaseType = "bool"
+	// BaseTimestamp values are either RFC 3339 dates or milliseconds since epoch
+	BaseTimestamp BaseType = "timestamp"
+	BaseJSON      BaseType = "json"
+)
+
+var baseTypes = []BaseType{
+	BaseString,
+	BaseInt,
+	BaseFloat,
+	BaseBool,
+	BaseTimestamp,
+	BaseJSON,
+}
+
+// builtinValueTypes are the value types always available, one for each base type,
+// without constraints
+var builtinValueTypes = func() map[string]*ValueType {
+	valueTypes := make(map[string]*ValueType, len(baseTypes))
+	for _, base := range baseTypes {
+		valueTypes[string(base)] = &ValueType{Base: base}
+	}
+	return valueTypes
+}()
+
+// ValueType describes the values accepted by attributes with a given value type
+type ValueType struct {
+	// Base is the native type of values. Defaults to "string"
+	Base BaseType `json:"base,omitempty"`
+
+	// Min and Max limit the range of "int" and "float" values
+	Min *float64 `json:"min,omitempty"`
+	Max *float64 `json:"max,omitempty"`
+
+	// Enum is the list of allowed values
+	Enum []string `json:"enum,omitempty"`
+
+	// Pattern is a regular expression values must match
+	Pattern string `json:"pattern,omitempty"`
+
+	// JSONSchema is a JSON schema "json" values must comply with
+	JSONSchema json.RawMessage `json:"json_schema,omitempty"`
+
+	pattern    *regexp.Regexp
+	jsonSchema *gojsonschema.Schema
+}
+
+// AttributeSpec describes an attribute allowed on items of a given type
+type AttributeSpec struct {
+	// Type is the required attribute type, any type is allowed if empty
+	Type string `json:"type,omitempty"`
+
+	// ValueType is the required attribute value type, any value type is allowed if empty
+	ValueType string `json:"value_type,omitempty"`
+
+	// Required attributes must be created along with their item
+	Required bool `json:"required,omitempty"`
+}
+
+// ItemType describes the attributes allowed on items of a given type
+type ItemType struct {
+	// Attributes are the allowed attributes, by name
+	Attributes map[string]AttributeSpec `json:"attributes,omitempty"`
+
+	// AdditionalAttributes determines if attributes not listed
+	// in Attributes are allowed
+	AdditionalAttributes bool `json:"additional_attributes,omitempty"`
+}
+
+// Registry is the registry of item types and attribute value types.
+//
+// Items with a registered type may only have the attributes listed by their type.
+// Attributes are validated against their value type, either registered
+// or named after a base type, i.g.: "int".
+// Items and value types not found in the registry are accepted,
+// unless Strict is true.
+//
+// A nil registry accepts any entity.
+type Registry struct {
+	Version    string                `json:"version,omitempty"`
+	Strict     bool                  `json:"strict,omitempty"`
+	ItemTypes  map[string]*ItemType  `json:"item_types,omitempty"`
+	ValueTypes map[string]*ValueType `json:"value_types,omitempty"`
+}
+
+// Parse parses and compiles a JSON encoded registry
+func Parse(data []byte) (*Registry, error) {
+	registry := &Registry{}
+	if err := json.Unmarshal(data, registry); err != nil {
+		return nil, eris.Wrap(err, "failed to parse schema registry")
+	}
+	if err := registry.Compile(); err != nil {
+		return nil, err
+	}
+	return registry, nil
+}
+
+// Load loads a JSON encoded registry from file
+func Load(path string) (*Registry, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to read schema registry '%s'", path)
+	}
+	registry, err := Parse(data)
+	if err != nil {
+		return nil, eris.Wrapf(err, "invalid schema registry '%s'", path)
+	}
+	return registry, nil
+}
+
+// Compile checks the registry definitions, compiling patterns and JSON schemas.
+// It must be called before using registries not created by Parse or Load.
+func (r *Registry) Compile() error {
+	for name, valueType := range r.ValueTypes {
+		if valueType == nil {
+			return eris.Errorf("Value type '%s' is empty", name)
+		}
+		if err := valueType.compile(); err != nil {
+			return eris.Wrapf(err, "invalid value type '%s'", name)
+		}
+	}
+
+	for name, itemType := range r.ItemTypes {
+		if itemType == nil {
+			return eris.Errorf("Item type '%s' is empty", name)
+		}
+		for attrName, spec := range itemType.Attributes {
+			if spec.ValueType == "" {
+				continue
+			}
+			if _, ok := r.valueType(spec.ValueType); !ok {
+				return eris.Errorf(
+					"Attribute '%s' of item type '%s' has unknown value type '%s'",
+					attrName,
+					name,
+					spec.ValueType,
+				)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (t *ValueType) compile() error {
+	if t.Base == "" {
+		t.Base = BaseString
+	}
+	if _, ok := builtinValueTypes[string(t.Base)]; !ok {
+		return eris.Errorf("Unknown base type: '%s'", t.Base)
+	}
+
+	if t.Min != nil || t.Max != nil {
+		if t.Base != BaseInt && t.Base != BaseFloat {
+			return eris.Errorf("Ranges are not supported by '%s' values", t.Base)
+		}
+		if t.Min != nil && t.Max != nil && *t.Min > *t.Max {
+			return eris.New("Min is greater than max")
+		}
+	}
+
+	if t.Pattern != "" {
+		pattern, err := regexp.Compile(t.Pattern)
+		if err != nil {
+			return eris.Wrap(err, "invalid pattern")
+		}
+		t.pattern = pattern
+	}
+
+	if len(t.JSONSchema) > 0 {
+		if t.Base != BaseJSON {
+			return eris.Errorf("JSON schemas are not supported by '%s' values", t.Base)
+		}
+		jsonSchema, err := gojsonschema.NewSchema(gojsonschema.NewBytesLoader(t.JSONSchema))
+		if err != nil {
+			return eris.Wrap(err, "invalid JSON schema")
+		}
+		t.jsonSchema = jsonSchema
+	}
+
+	return nil
+}
+
+// valueType returns a registered value type, or the builtin one with the given name
+func (r *Registry) valueType(name string) (*ValueType, bool) {
+	if valueType, ok := r.ValueTypes[name]; ok {
+		return valueType, true
+	}
+	valueType, ok := builtinValueTypes[name]
+	return valueType, ok
+}
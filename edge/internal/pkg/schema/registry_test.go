@@ -0,0 +1,220 @@
+package schema
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"devais.it/kronos/internal/pkg/db/models"
+	"github.com/stretchr/testify/suite"
+)
+
+const testRegistry = `{
+	"version": "1",
+	"value_types": {
+		"temperature": {"base": "float", "min": -40, "max": 125},
+		"mode": {"enum": ["AUTO", "MANUAL"]},
+		"serial": {"pattern": "^[A-Z0-9]{8}$"},
+		"config": {
+			"base": "json",
+			"json_schema": {
+				"type": "object",
+				"properties": {"interval": {"type": "integer"}},
+				"required": ["interval"]
+			}
+		}
+	},
+	"item_types": {
+		"sensor": {
+			"attributes": {
+				"temperature": {"type": "measure", "value_type": "temperature", "required": true},
+				"mode": {"value_type": "mode"},
+				"config": {"value_type": "config"}
+			}
+		},
+		"gateway": {
+			"attributes": {
+				"serial": {"value_type": "serial", "required": true}
+			},
+			"additional_attributes": true
+		}
+	}
+}`
+
+type SchemaSuite struct {
+	suite.Suite
+	registry *Registry
+}
+
+func (s *SchemaSuite) SetupTest() {
+	registry, err := Parse([]byte(testRegistry))
+	s.Require().NoError(err)
+	s.registry = registry
+}
+
+func (s *SchemaSuite) TestParse() {
+	assert := s.Require()
+
+	assert.Equal("1", s.registry.Version)
+	assert.Equal(BaseString, s.registry.ValueTypes["mode"].Base)
+
+	invalid := []string{
+		`{"value_types": {"a": {"base": "complex"}}}`,
+		`{"value_types": {"a": {"base": "string", "min": 1}}}`,
+		`{"value_types": {"a": {"base": "int", "min": 2, "max": 1}}}`,
+		`{"value_types": {"a": {"pattern": "("}}}`,
+		`{"value_types": {"a": {"base": "json", "json_schema": {"type": 1}}}}`,
+		`{"item_types": {"a": {"attributes": {"b": {"value_type": "unknown"}}}}}`,
+		`{"item_types": []}`,
+	}
+	for _, data := range invalid {
+		_, err := Parse([]byte(data))
+		assert.Error(err, data)
+	}
+}
+
+func (s *SchemaSuite) TestValueTypes() {
+	assert := s.Require()
+
+	cases := []struct {
+		valueType string
+		value     string
+		valid     bool
+	}{
+		{"int", "42", true},
+		{"int", "abc", false},
+		{"int", "4.2", false},
+		{"float", "4.2", true},
+		{"float", "NaN", false},
+		{"bool", "true", true},
+		{"bool", "yes", false},
+		{"timestamp", "1700000000000", true},
+		{"timestamp", "2021-06-01T10:00:00Z", true},
+		{"timestamp", "yesterday", false},
+		{"json", `{"a": 1}`, true},
+		{"json", `{"a": 1`, false},
+		{"temperature", "25.5", true},
+		{"temperature", "-41", false},
+		{"temperature", "200", false},
+		{"mode", "AUTO", true},
+		{"mode", "OFF", false},
+		{"serial", "AB12CD34", true},
+		{"serial", "ab12", false},
+		{"config", `{"interval": 10}`, true},
+		{"config", `{"interval": "10"}`, false},
+		{"config", `{}`, false},
+		// Empty values are unset values
+		{"int", "", true},
+	}
+
+	for _, c := range cases {
+		valueType, ok := s.registry.valueType(c.valueType)
+		assert.True(ok, c.valueType)
+		err := valueType.Validate(c.value)
+		if c.valid {
+			assert.NoError(err, "%s: '%s'", c.valueType, c.value)
+		} else {
+			assert.ErrorIs(err, ErrValidation, "%s: '%s'", c.valueType, c.value)
+		}
+	}
+}
+
+func (s *SchemaSuite) TestValidateAttribute() {
+	assert := s.Require()
+
+	attribute := &models.Attribute{Name: "temperature", Type: "measure", Value: "20"}
+	assert.NoError(s.registry.ValidateAttribute("sensor", attribute))
+
+	// The value type of the item type is used when missing
+	attribute.Value = "hot"
+	assert.ErrorIs(s.registry.ValidateAttribute("sensor", attribute), ErrValidation)
+
+	attribute.Value = "20"
+	attribute.Type = "setpoint"
+	assert.ErrorIs(s.registry.ValidateAttribute("sensor", attribute), ErrValidation)
+
+	attribute.Type = "measure"
+	attribute.ValueType = "int"
+	assert.ErrorIs(s.registry.ValidateAttribute("sensor", attribute), ErrValidation)
+
+	// Attributes not listed by the item type
+	unknown := &models.Attribute{Name: "color", Type: "label", Value: "red"}
+	assert.ErrorIs(s.registry.ValidateAttribute("sensor", unknown), ErrValidation)
+	assert.NoError(s.registry.ValidateAttribute("gateway", unknown))
+	assert.NoError(s.registry.ValidateAttribute("unknown", unknown))
+
+	// Value types are enforced on any item
+	unknown.ValueType = "int"
+	assert.ErrorIs(s.registry.ValidateAttribute("gateway", unknown), ErrValidation)
+	assert.ErrorIs(s.registry.ValidateAttribute("unknown", unknown), ErrValidation)
+
+	unknown.ValueType = "color"
+	assert.NoError(s.registry.ValidateAttribute("unknown", unknown))
+
+	s.registry.Strict = true
+	assert.ErrorIs(s.registry.ValidateAttribute("unknown", unknown), ErrValidation)
+}
+
+func (s *SchemaSuite) TestValidateItem() {
+	assert := s.Require()
+
+	item := &models.Item{ID: "sensor-1", Type: "sensor"}
+	attributes := []models.Attribute{
+		{Name: "temperature", Type: "measure", Value: "20"},
+		{Name: "mode", Value: "AUTO"},
+	}
+
+	assert.NoError(s.registry.ValidateItem(item, attributes, true))
+
+	// Missing required attributes
+	assert.ErrorIs(s.registry.ValidateItem(item, attributes[1:], true), ErrValidation)
+	assert.NoError(s.registry.ValidateItem(item, attributes[1:], false))
+
+	// Invalid attributes
+	attributes[1].Value = "OFF"
+	assert.ErrorIs(s.registry.ValidateItem(item, attributes, true), ErrValidation)
+
+	// Unknown item types are rejected only by strict registries
+	item.Type = "unknown"
+	assert.NoError(s.registry.ValidateItem(item, nil, true))
+	s.registry.Strict = true
+	assert.ErrorIs(s.registry.ValidateItem(item, nil, true), ErrValidation)
+
+	// Nil registries accept everything
+	var registry *Registry
+	assert.NoError(registry.ValidateItem(item, attributes, true))
+	assert.False(registry.IsRequiredAttribute("sensor", "temperature"))
+	assert.True(s.registry.IsRequiredAttribute("sensor", "temperature"))
+}
+
+func (s *SchemaSuite) TestParseValue() {
+	assert := s.Require()
+
+	assert.Equal(BaseFloat, s.registry.BaseType("temperature"))
+	assert.Equal(BaseInt, s.registry.BaseType("int"))
+	assert.Equal(BaseString, s.registry.BaseType("unknown"))
+	assert.Equal([]string{"float", "int", "temperature"}, s.registry.NumericValueTypes())
+
+	var registry *Registry
+	assert.Equal(BaseBool, registry.BaseType("bool"))
+	assert.Equal([]string{"float", "int"}, registry.NumericValueTypes())
+
+	value, err := ParseValue(BaseTimestamp, "2021-06-01T10:00:00Z")
+	assert.NoError(err)
+	assert.Equal(time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC), value)
+
+	value, err = ParseValue(BaseJSON, `[1, 2]`)
+	assert.NoError(err)
+	assert.Equal(json.RawMessage(`[1, 2]`), value)
+
+	value, err = ParseValue(BaseFloat, "")
+	assert.NoError(err)
+	assert.Nil(value)
+
+	_, err = ParseValue(BaseBool, "maybe")
+	assert.ErrorIs(err, ErrValidation)
+}
+
+func TestSchema(t *testing.T) {
+	suite.Run(t, new(SchemaSuite))
+}
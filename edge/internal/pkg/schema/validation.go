@@ -0,0 +1,226 @@
+package schema
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+
+	"devais.it/kronos/internal/pkg/db/models"
+	"github.com/rotisserie/eris"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+var ErrValidation = eris.New("Validation failed")
+
+func validationErrorf(format string, args ...interface{}) error {
+	return eris.Wrapf(ErrValidation, format, args...)
+}
+
+// Validate checks a value against the value type.
+// Empty values are always accepted, as they represent unset values.
+func (t *ValueType) Validate(value string) error {
+	if value == "" {
+		return nil
+	}
+
+	switch t.Base {
+	case BaseInt:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return validationErrorf("'%s' is not an integer", value)
+		}
+		if err := t.checkRange(float64(n)); err != nil {
+			return err
+		}
+	case BaseFloat:
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+			return validationErrorf("'%s' is not a number", value)
+		}
+		if err := t.checkRange(n); err != nil {
+			return err
+		}
+	case BaseBool:
+		if _, err := strconv.ParseBool(value); err != nil {
+			return validationErrorf("'%s' is not a boolean", value)
+		}
+	case BaseTimestamp:
+		if _, err := parseTimestamp(value); err != nil {
+			return validationErrorf("'%s' is not a timestamp", value)
+		}
+	case BaseJSON:
+		if err := t.validateJSON(value); err != nil {
+			return err
+		}
+	}
+
+	if len(t.Enum) > 0 && !t.inEnum(value) {
+		return validationErrorf("'%s' is not one of: %s", value, strings.Join(t.Enum, ", "))
+	}
+
+	if t.pattern != nil && !t.pattern.MatchString(value) {
+		return validationErrorf("'%s' doesn't match pattern '%s'", value, t.Pattern)
+	}
+
+	return nil
+}
+
+func (t *ValueType) checkRange(n float64) error {
+	if t.Min != nil && n < *t.Min {
+		return validationErrorf("%v is less than %v", n, *t.Min)
+	}
+	if t.Max != nil && n > *t.Max {
+		return validationErrorf("%v is greater than %v", n, *t.Max)
+	}
+	return nil
+}
+
+func (t *ValueType) inEnum(value string) bool {
+	for _, allowed := range t.Enum {
+		if value == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *ValueType) validateJSON(value string) error {
+	if t.jsonSchema == nil {
+		if !json.Valid([]byte(value)) {
+			return validationErrorf("Value is not a valid JSON document")
+		}
+		return nil
+	}
+
+	result, err := t.jsonSchema.Validate(gojsonschema.NewStringLoader(value))
+	if err != nil {
+		return validationErrorf("Value is not a valid JSON document: %v", err)
+	}
+	if !result.Valid() {
+		details := make([]string, len(result.Errors()))
+		for i, resultErr := range result.Errors() {
+			details[i] = resultErr.String()
+		}
+		return validationErrorf("Value doesn't match JSON schema: %s", strings.Join(details, "; "))
+	}
+	return nil
+}
+
+// ValidateItemType checks if items of the given type are allowed
+func (r *Registry) ValidateItemType(itemType string) error {
+	if r == nil {
+		return nil
+	}
+	if _, ok := r.ItemTypes[itemType]; !ok && r.Strict {
+		return validationErrorf("Unknown item type '%s'", itemType)
+	}
+	return nil
+}
+
+// ValidateItem validates an item along with its attributes.
+// If checkRequired is true, the required attributes of the item type
+// must be found among the given ones.
+func (r *Registry) ValidateItem(item *models.Item, attributes []models.Attribute, checkRequired bool) error {
+	if r == nil {
+		return nil
+	}
+
+	if err := r.ValidateItemType(item.Type); err != nil {
+		return err
+	}
+
+	for i := range attributes {
+		if err := r.ValidateAttribute(item.Type, &attributes[i]); err != nil {
+			return err
+		}
+	}
+
+	itemType, ok := r.ItemTypes[item.Type]
+	if !checkRequired || !ok {
+		return nil
+	}
+
+	names := make(map[string]struct{}, len(attributes))
+	for _, attribute := range attributes {
+		names[attribute.Name] = struct{}{}
+	}
+	for name, spec := range itemType.Attributes {
+		if _, found := names[name]; spec.Required && !found {
+			return validationErrorf(
+				"Item '%s' of type '%s' is missing required attribute '%s'",
+				item.ID,
+				item.Type,
+				name,
+			)
+		}
+	}
+
+	return nil
+}
+
+// ValidateAttribute validates an attribute of an item with the given type
+func (r *Registry) ValidateAttribute(itemType string, attribute *models.Attribute) error {
+	if r == nil {
+		return nil
+	}
+
+	valueTypeName := attribute.ValueType
+
+	if itemSpec, ok := r.ItemTypes[itemType]; ok {
+		spec, ok := itemSpec.Attributes[attribute.Name]
+		if !ok && !itemSpec.AdditionalAttributes {
+			return validationErrorf(
+				"Attribute '%s' is not allowed on items of type '%s'",
+				attribute.Name,
+				itemType,
+			)
+		}
+		if spec.Type != "" && attribute.Type != spec.Type {
+			return validationErrorf(
+				"Attribute '%s' must have type '%s', got '%s'",
+				attribute.Name,
+				spec.Type,
+				attribute.Type,
+			)
+		}
+		if spec.ValueType != "" {
+			if valueTypeName != "" && valueTypeName != spec.ValueType {
+				return validationErrorf(
+					"Attribute '%s' must have value type '%s', got '%s'",
+					attribute.Name,
+					spec.ValueType,
+					valueTypeName,
+				)
+			}
+			valueTypeName = spec.ValueType
+		}
+	}
+
+	if valueTypeName == "" {
+		return nil
+	}
+
+	valueType, ok := r.valueType(valueTypeName)
+	if !ok {
+		if r.Strict {
+			return validationErrorf("Unknown value type '%s'", valueTypeName)
+		}
+		return nil
+	}
+
+	if err := valueType.Validate(attribute.Value); err != nil {
+		return eris.Wrapf(err, "invalid value of attribute '%s'", attribute.Name)
+	}
+
+	return nil
+}
+
+// IsRequiredAttribute determines if an attribute is required on items of the given type
+func (r *Registry) IsRequiredAttribute(itemType, name string) bool {
+	if r == nil {
+		return false
+	}
+	itemSpec, ok := r.ItemTypes[itemType]
+	return ok && itemSpec.Attributes[name].Required
+}
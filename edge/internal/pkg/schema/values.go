@@ -0,0 +1,83 @@
+package schema
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/rotisserie/eris"
+)
+
+// BaseType returns the base type of a value type, either registered or builtin.
+// Values of unknown value types are strings.
+func (r *Registry) BaseType(valueType string) BaseType {
+	if r != nil {
+		if t, ok := r.valueType(valueType); ok {
+			return t.Base
+		}
+	}
+	if t, ok := builtinValueTypes[valueType]; ok {
+		return t.Base
+	}
+	return BaseString
+}
+
+// NumericValueTypes returns the names of the value types with "int"
+// or "float" base type, ordered by name
+func (r *Registry) NumericValueTypes() []string {
+	names := []string{string(BaseInt), string(BaseFloat)}
+	if r != nil {
+		for name, t := range r.ValueTypes {
+			if (t.Base == BaseInt || t.Base == BaseFloat) && builtinValueTypes[name] == nil {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseValue converts a value to the native type of its base type:
+// int64, float64, bool, time.Time, json.RawMessage or string.
+// Empty values are converted to nil.
+func ParseValue(base BaseType, value string) (interface{}, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	var parsed interface{}
+	var err error
+
+	switch base {
+	case BaseInt:
+		parsed, err = strconv.ParseInt(value, 10, 64)
+	case BaseFloat:
+		parsed, err = strconv.ParseFloat(value, 64)
+	case BaseBool:
+		parsed, err = strconv.ParseBool(value)
+	case BaseTimestamp:
+		parsed, err = parseTimestamp(value)
+	case BaseJSON:
+		if !json.Valid([]byte(value)) {
+			err = eris.New("invalid JSON document")
+		}
+		parsed = json.RawMessage(value)
+	default:
+		parsed = value
+	}
+
+	if err != nil {
+		return nil, eris.Wrapf(ErrValidation, "'%s' is not a valid %s value", value, base)
+	}
+
+	return parsed, nil
+}
+
+// parseTimestamp parses RFC 3339 dates and milliseconds since epoch
+func parseTimestamp(value string) (time.Time, error) {
+	if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(0, ms*int64(time.Millisecond)).UTC(), nil
+	}
+	return time.Parse(time.RFC3339Nano, value)
+}
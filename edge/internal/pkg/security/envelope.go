@@ -0,0 +1,363 @@
+package security
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/rotisserie/eris"
+	"golang.org/x/crypto/curve25519"
+)
+
+const (
+	envelopeVersion = 2
+	// envelopeContext separates envelope signatures and keys from other uses of the same keys
+	envelopeContext = "kronos-envelope-v2"
+
+	// DefaultEnvelopeMaxAge is the default maximum age of opened envelopes
+	DefaultEnvelopeMaxAge = 5 * time.Minute
+)
+
+var (
+	ErrUnknownKey        = eris.New("Envelope signed by an unknown key")
+	ErrInvalidSignature  = eris.New("Invalid envelope signature")
+	ErrUnexpectedType    = eris.New("Unexpected envelope message type")
+	ErrStaleEnvelope     = eris.New("Stale envelope")
+	ErrDuplicateEnvelope = eris.New("Duplicate envelope")
+)
+
+// Envelope wraps a serialized message, signing it with Ed25519 and
+// optionally encrypting it with AES-256-GCM.
+//
+// The encryption key is derived from an X25519 key agreement, between an
+// ephemeral key and the recipient public key, as the SHA-256 of the envelope
+// context, the shared secret and both public keys.
+// The signature covers all other fields, so the payload is signed after encryption.
+// Envelopes are bound to the type of the wrapped message and to their sealing time,
+// so that they can't be replayed, or delivered as messages of another type.
+type Envelope struct {
+	Version int `json:"version"`
+	// KeyID identifies the signing key, see KeyID
+	KeyID string `json:"key_id"`
+	// Type is the type of the wrapped message
+	Type string `json:"type"`
+	// Timestamp is the sealing time in milliseconds, strictly increasing
+	// across envelopes sealed by the same Sealer
+	Timestamp uint64 `json:"timestamp"`
+	Encrypted bool   `json:"encrypted,omitempty"`
+	// EphemeralKey is the X25519 ephemeral public key of encrypted envelopes
+	EphemeralKey []byte `json:"ephemeral_key,omitempty"`
+	Nonce        []byte `json:"nonce,omitempty"`
+	Payload      []byte `json:"payload"`
+	Signature    []byte `json:"signature"`
+}
+
+// signedData returns the data covered by the envelope signature,
+// made of the length-prefixed envelope fields
+func (e *Envelope) signedData() []byte {
+	var timestamp [8]byte
+	binary.BigEndian.PutUint64(timestamp[:], e.Timestamp)
+
+	fields := [][]byte{
+		[]byte(envelopeContext),
+		{byte(e.Version)},
+		[]byte(e.KeyID),
+		[]byte(e.Type),
+		timestamp[:],
+		{boolToByte(e.Encrypted)},
+		e.EphemeralKey,
+		e.Nonce,
+		e.Payload,
+	}
+
+	size := 0
+	for _, field := range fields {
+		size += 4 + len(field)
+	}
+
+	data := make([]byte, 0, size)
+	var length [4]byte
+	for _, field := range fields {
+		binary.BigEndian.PutUint32(length[:], uint32(len(field)))
+		data = append(data, length[:]...)
+		data = append(data, field...)
+	}
+
+	return data
+}
+
+// nowMs returns the time of a clock in milliseconds, time.Now by default
+func nowMs(now func() time.Time) uint64 {
+	if now == nil {
+		now = time.Now
+	}
+	return uint64(now().UnixNano() / int64(time.Millisecond))
+}
+
+func boolToByte(b bool) byte {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// deriveKey derives the AES-256 key shared between an ephemeral and a recipient key
+func deriveKey(secret, ephemeralKey []byte, recipient EncryptionKey) []byte {
+	h := sha256.New()
+	h.Write([]byte(envelopeContext))
+	h.Write(secret)
+	h.Write(ephemeralKey)
+	h.Write(recipient)
+	return h.Sum(nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Sealer wraps outgoing payloads inside signed envelopes,
+// encrypting them if EncryptionKey is set
+type Sealer struct {
+	SigningKey    ed25519.PrivateKey
+	EncryptionKey EncryptionKey
+
+	keyID string
+	now   func() time.Time
+
+	mu            sync.Mutex
+	lastTimestamp uint64
+}
+
+func NewSealer(signingKey ed25519.PrivateKey, encryptionKey EncryptionKey) (*Sealer, error) {
+	keyID, err := KeyID(signingKey.Public())
+	if err != nil {
+		return nil, err
+	}
+	return &Sealer{
+		SigningKey:    signingKey,
+		EncryptionKey: encryptionKey,
+		keyID:         keyID,
+		now:           time.Now,
+	}, nil
+}
+
+// timestamp returns the current time in milliseconds,
+// incremented if needed to stay strictly increasing
+func (s *Sealer) timestamp() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	timestamp := nowMs(s.now)
+	if timestamp <= s.lastTimestamp {
+		timestamp = s.lastTimestamp + 1
+	}
+	s.lastTimestamp = timestamp
+	return timestamp
+}
+
+func (s *Sealer) encrypt(envelope *Envelope, payload []byte) error {
+	ephemeral := make(DecryptionKey, curve25519.ScalarSize)
+	if _, err := rand.Read(ephemeral); err != nil {
+		return eris.Wrap(err, "failed to generate ephemeral key")
+	}
+	ephemeralKey, err := ephemeral.Public()
+	if err != nil {
+		return eris.Wrap(err, "failed to generate ephemeral key")
+	}
+	envelope.EphemeralKey = ephemeralKey
+
+	secret, err := curve25519.X25519(ephemeral, s.EncryptionKey)
+	if err != nil {
+		return eris.Wrap(err, "invalid encryption key")
+	}
+	gcm, err := newGCM(deriveKey(secret, envelope.EphemeralKey, s.EncryptionKey))
+	if err != nil {
+		return eris.Wrap(err, "failed to create envelope cipher")
+	}
+
+	envelope.Nonce = make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(envelope.Nonce); err != nil {
+		return eris.Wrap(err, "failed to generate envelope nonce")
+	}
+
+	envelope.Encrypted = true
+	envelope.Payload = gcm.Seal(nil, envelope.Nonce, payload, envelope.EphemeralKey)
+
+	return nil
+}
+
+// Seal wraps a payload, which is a message of the given type,
+// inside a new signed, and possibly encrypted, envelope
+func (s *Sealer) Seal(messageType string, payload []byte) (*Envelope, error) {
+	envelope := &Envelope{
+		Version:   envelopeVersion,
+		KeyID:     s.keyID,
+		Type:      messageType,
+		Timestamp: s.timestamp(),
+		Payload:   payload,
+	}
+
+	if s.EncryptionKey != nil {
+		if err := s.encrypt(envelope, payload); err != nil {
+			return nil, err
+		}
+	}
+
+	envelope.Signature = ed25519.Sign(s.SigningKey, envelope.signedData())
+
+	return envelope, nil
+}
+
+// Opener verifies incoming envelopes against a set of trusted keys,
+// decrypting them with DecryptionKey when encrypted.
+// Envelopes sealed more than MaxAge ago, or already opened, are rejected.
+type Opener struct {
+	VerifyingKeys map[string]ed25519.PublicKey
+	DecryptionKey DecryptionKey
+	// MaxAge is DefaultEnvelopeMaxAge if not set
+	MaxAge time.Duration
+
+	now func() time.Time
+
+	mu sync.Mutex
+	// opened contains the envelopes opened within MaxAge
+	opened map[openedEnvelope]struct{}
+}
+
+// openedEnvelope identifies an envelope, since timestamps
+// of the same signing key are unique
+type openedEnvelope struct {
+	keyID     string
+	timestamp uint64
+}
+
+func NewOpener(verifyingKeys []ed25519.PublicKey, decryptionKey DecryptionKey) (*Opener, error) {
+	o := &Opener{
+		VerifyingKeys: make(map[string]ed25519.PublicKey, len(verifyingKeys)),
+		DecryptionKey: decryptionKey,
+		MaxAge:        DefaultEnvelopeMaxAge,
+		now:           time.Now,
+		opened:        make(map[openedEnvelope]struct{}),
+	}
+	for _, key := range verifyingKeys {
+		keyID, err := KeyID(key)
+		if err != nil {
+			return nil, err
+		}
+		o.VerifyingKeys[keyID] = key
+	}
+	return o, nil
+}
+
+func (o *Opener) decrypt(envelope *Envelope) ([]byte, error) {
+	if o.DecryptionKey == nil {
+		return nil, eris.New("Encrypted envelope, but no decryption key is configured")
+	}
+
+	if len(envelope.EphemeralKey) != curve25519.PointSize {
+		return nil, eris.New("Invalid envelope ephemeral key")
+	}
+	// Low order ephemeral keys are rejected
+	secret, err := curve25519.X25519(o.DecryptionKey, envelope.EphemeralKey)
+	if err != nil {
+		return nil, eris.Wrap(err, "invalid envelope ephemeral key")
+	}
+
+	recipient, err := o.DecryptionKey.Public()
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(deriveKey(secret, envelope.EphemeralKey, recipient))
+	if err != nil {
+		return nil, eris.Wrap(err, "failed to create envelope cipher")
+	}
+	if len(envelope.Nonce) != gcm.NonceSize() {
+		return nil, eris.New("Invalid envelope nonce")
+	}
+
+	payload, err := gcm.Open(nil, envelope.Nonce, envelope.Payload, envelope.EphemeralKey)
+	if err != nil {
+		return nil, eris.Wrap(err, "failed to decrypt envelope")
+	}
+	return payload, nil
+}
+
+// checkReplay rejects envelopes sealed too long ago, or in the future,
+// and envelopes already opened
+func (o *Opener) checkReplay(envelope *Envelope) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	now := nowMs(o.now)
+	maxAge := uint64(DefaultEnvelopeMaxAge.Milliseconds())
+	if o.MaxAge > 0 {
+		maxAge = uint64(o.MaxAge.Milliseconds())
+	}
+	if o.opened == nil {
+		o.opened = make(map[openedEnvelope]struct{})
+	}
+
+	if envelope.Timestamp+maxAge < now || envelope.Timestamp > now+maxAge {
+		return eris.Wrapf(ErrStaleEnvelope, "timestamp: %d", envelope.Timestamp)
+	}
+
+	// Stale envelopes are rejected anyway
+	for opened := range o.opened {
+		if opened.timestamp+maxAge < now {
+			delete(o.opened, opened)
+		}
+	}
+
+	key := openedEnvelope{keyID: envelope.KeyID, timestamp: envelope.Timestamp}
+	if _, ok := o.opened[key]; ok {
+		return eris.Wrapf(ErrDuplicateEnvelope, "timestamp: %d", envelope.Timestamp)
+	}
+	o.opened[key] = struct{}{}
+
+	return nil
+}
+
+// Open verifies the signature, the message type and the freshness of an envelope,
+// returning its decrypted payload
+func (o *Opener) Open(messageType string, envelope *Envelope) ([]byte, error) {
+	if envelope.Version != envelopeVersion {
+		return nil, eris.Errorf("Unsupported envelope version: %d", envelope.Version)
+	}
+
+	key, ok := o.VerifyingKeys[envelope.KeyID]
+	if !ok {
+		return nil, eris.Wrapf(ErrUnknownKey, "key ID: '%s'", envelope.KeyID)
+	}
+
+	if !ed25519.Verify(key, envelope.signedData(), envelope.Signature) {
+		return nil, ErrInvalidSignature
+	}
+
+	if envelope.Type != messageType {
+		return nil, eris.Wrapf(ErrUnexpectedType, "expected '%s', got '%s'", messageType, envelope.Type)
+	}
+
+	payload := envelope.Payload
+	if envelope.Encrypted {
+		var err error
+		if payload, err = o.decrypt(envelope); err != nil {
+			return nil, err
+		}
+	}
+
+	// Envelopes which can't be opened aren't recorded
+	if err := o.checkReplay(envelope); err != nil {
+		return nil, err
+	}
+
+	return payload, nil
+}
@@ -0,0 +1,220 @@
+package security
+
+import (
+	"crypto/ed25519"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+const messageType = "EVENTS"
+
+type SecuritySuite struct {
+	suite.Suite
+}
+
+// generateKeyFiles generates a key pair of the given type,
+// returning the paths of the private and public key files
+func (s *SecuritySuite) generateKeyFiles(keyType KeyType) (string, string) {
+	assert := s.Require()
+
+	privatePEM, publicPEM, err := GenerateKey(keyType)
+	assert.NoError(err)
+
+	dir := s.T().TempDir()
+	privatePath := filepath.Join(dir, "key")
+	publicPath := filepath.Join(dir, "key.pub")
+	assert.NoError(ioutil.WriteFile(privatePath, privatePEM, 0600))
+	assert.NoError(ioutil.WriteFile(publicPath, publicPEM, 0644))
+
+	return privatePath, publicPath
+}
+
+func (s *SecuritySuite) signingKeys() (ed25519.PrivateKey, ed25519.PublicKey) {
+	assert := s.Require()
+
+	privatePath, publicPath := s.generateKeyFiles(KeySigning)
+
+	privateKey, err := LoadSigningKey(privatePath)
+	assert.NoError(err)
+	publicKey, err := LoadVerifyingKey(publicPath)
+	assert.NoError(err)
+
+	return privateKey, publicKey
+}
+
+func (s *SecuritySuite) TestKeys() {
+	assert := s.Require()
+
+	signingPrivate, signingPublic := s.generateKeyFiles(KeySigning)
+	encryptionPrivate, encryptionPublic := s.generateKeyFiles(KeyEncryption)
+
+	encryptionKey, err := LoadEncryptionKey(encryptionPublic)
+	assert.NoError(err)
+	decryptionKey, err := LoadDecryptionKey(encryptionPrivate)
+	assert.NoError(err)
+	assert.Len(encryptionKey, 32)
+	publicKey, err := decryptionKey.Public()
+	assert.NoError(err)
+	assert.Equal(encryptionKey, publicKey)
+
+	// Key IDs of PEM encoded keys match the ones of parsed keys
+	publicPEM, err := ioutil.ReadFile(signingPublic)
+	assert.NoError(err)
+	verifyingKey, err := LoadVerifyingKey(signingPublic)
+	assert.NoError(err)
+	keyID, err := KeyID(verifyingKey)
+	assert.NoError(err)
+	pemKeyID, err := PEMKeyID(publicPEM)
+	assert.NoError(err)
+	assert.Equal(keyID, pemKeyID)
+
+	// Keys of the wrong type or kind are rejected
+	_, err = LoadSigningKey(encryptionPrivate)
+	assert.Error(err)
+	_, err = LoadVerifyingKey(signingPrivate)
+	assert.Error(err)
+	_, err = LoadEncryptionKey(signingPublic)
+	assert.Error(err)
+	_, err = LoadDecryptionKey(signingPrivate)
+	assert.Error(err)
+
+	_, _, err = GenerateKey("UNKNOWN")
+	assert.Error(err)
+}
+
+func (s *SecuritySuite) TestSignature() {
+	assert := s.Require()
+
+	signingKey, verifyingKey := s.signingKeys()
+	_, otherKey := s.signingKeys()
+
+	sealer, err := NewSealer(signingKey, nil)
+	assert.NoError(err)
+
+	payload := []byte(`[{"id":1}]`)
+	envelope, err := sealer.Seal(messageType, payload)
+	assert.NoError(err)
+	assert.False(envelope.Encrypted)
+	assert.Equal(payload, envelope.Payload)
+
+	opener, err := NewOpener([]ed25519.PublicKey{otherKey, verifyingKey}, nil)
+	assert.NoError(err)
+
+	opened, err := opener.Open(messageType, envelope)
+	assert.NoError(err)
+	assert.Equal(payload, opened)
+
+	// Tampered payloads and types are rejected
+	tampered := *envelope
+	tampered.Payload = []byte(`[{"id":2}]`)
+	_, err = opener.Open(messageType, &tampered)
+	assert.ErrorIs(err, ErrInvalidSignature)
+
+	tampered = *envelope
+	tampered.Type = "SYNC"
+	_, err = opener.Open("SYNC", &tampered)
+	assert.ErrorIs(err, ErrInvalidSignature)
+
+	// Envelopes signed by unknown keys are rejected
+	opener, err = NewOpener([]ed25519.PublicKey{otherKey}, nil)
+	assert.NoError(err)
+	_, err = opener.Open(messageType, envelope)
+	assert.ErrorIs(err, ErrUnknownKey)
+}
+
+func (s *SecuritySuite) TestReplay() {
+	assert := s.Require()
+
+	signingKey, verifyingKey := s.signingKeys()
+
+	sealer, err := NewSealer(signingKey, nil)
+	assert.NoError(err)
+	opener, err := NewOpener([]ed25519.PublicKey{verifyingKey}, nil)
+	assert.NoError(err)
+
+	payload := []byte(`[{"id":1}]`)
+	first, err := sealer.Seal(messageType, payload)
+	assert.NoError(err)
+	second, err := sealer.Seal(messageType, payload)
+	assert.NoError(err)
+	assert.Greater(second.Timestamp, first.Timestamp)
+
+	// Envelopes are bound to their message type
+	_, err = opener.Open("SYNC", first)
+	assert.ErrorIs(err, ErrUnexpectedType)
+
+	_, err = opener.Open(messageType, first)
+	assert.NoError(err)
+	_, err = opener.Open(messageType, second)
+	assert.NoError(err)
+
+	// Envelopes can be opened only once
+	_, err = opener.Open(messageType, first)
+	assert.ErrorIs(err, ErrDuplicateEnvelope)
+
+	// Stale envelopes are rejected
+	sealer.now = func() time.Time {
+		return time.Now().Add(-2 * opener.MaxAge)
+	}
+	sealer.lastTimestamp = 0
+	stale, err := sealer.Seal(messageType, payload)
+	assert.NoError(err)
+	_, err = opener.Open(messageType, stale)
+	assert.ErrorIs(err, ErrStaleEnvelope)
+}
+
+func (s *SecuritySuite) TestEncryption() {
+	assert := s.Require()
+
+	signingKey, verifyingKey := s.signingKeys()
+
+	privatePath, publicPath := s.generateKeyFiles(KeyEncryption)
+	decryptionKey, err := LoadDecryptionKey(privatePath)
+	assert.NoError(err)
+	encryptionKey, err := LoadEncryptionKey(publicPath)
+	assert.NoError(err)
+
+	sealer, err := NewSealer(signingKey, encryptionKey)
+	assert.NoError(err)
+
+	payload := []byte(`[{"id":1}]`)
+	envelope, err := sealer.Seal(messageType, payload)
+	assert.NoError(err)
+	assert.True(envelope.Encrypted)
+	assert.NotContains(string(envelope.Payload), `"id"`)
+
+	opener, err := NewOpener([]ed25519.PublicKey{verifyingKey}, decryptionKey)
+	assert.NoError(err)
+
+	opened, err := opener.Open(messageType, envelope)
+	assert.NoError(err)
+	assert.Equal(payload, opened)
+
+	// Envelopes can't be decrypted without the recipient key
+	otherPrivatePath, _ := s.generateKeyFiles(KeyEncryption)
+	otherKey, err := LoadDecryptionKey(otherPrivatePath)
+	assert.NoError(err)
+
+	for _, key := range []DecryptionKey{nil, otherKey} {
+		opener.DecryptionKey = key
+		_, err = opener.Open(messageType, envelope)
+		assert.Error(err)
+	}
+
+	// Low order ephemeral keys are rejected
+	opener.DecryptionKey = decryptionKey
+	lowOrder := *envelope
+	lowOrder.EphemeralKey = make([]byte, 32)
+	lowOrder.Signature = ed25519.Sign(signingKey, lowOrder.signedData())
+	_, err = opener.Open(messageType, &lowOrder)
+	assert.Error(err)
+	assert.NotErrorIs(err, ErrInvalidSignature)
+}
+
+func TestSecurity(t *testing.T) {
+	suite.Run(t, new(SecuritySuite))
+}
@@ -0,0 +1,268 @@
+package security
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+
+	"github.com/rotisserie/eris"
+	"golang.org/x/crypto/curve25519"
+)
+
+type KeyType string
+
+const (
+	// KeySigning is an Ed25519 key pair, used to sign messages
+	KeySigning KeyType = "SIGNING"
+	// KeyEncryption is an X25519 key pair, used for key agreement
+	KeyEncryption KeyType = "ENCRYPTION"
+)
+
+const (
+	privateKeyPEMType = "PRIVATE KEY"
+	publicKeyPEMType  = "PUBLIC KEY"
+)
+
+// oidX25519 identifies X25519 keys, see RFC 8410
+var oidX25519 = asn1.ObjectIdentifier{1, 3, 101, 110}
+
+// EncryptionKey is an X25519 public key
+type EncryptionKey []byte
+
+// DecryptionKey is an X25519 private key
+type DecryptionKey []byte
+
+// Public returns the public key of a private key
+func (k DecryptionKey) Public() (EncryptionKey, error) {
+	publicKey, err := curve25519.X25519(k, curve25519.Basepoint)
+	if err != nil {
+		return nil, eris.Wrap(err, "invalid X25519 private key")
+	}
+	return publicKey, nil
+}
+
+// pkixPublicKey is the PKIX encoding of a public key, like in crypto/x509
+type pkixPublicKey struct {
+	Algorithm pkix.AlgorithmIdentifier
+	PublicKey asn1.BitString
+}
+
+// pkcs8PrivateKey is the PKCS #8 encoding of a private key, like in crypto/x509
+type pkcs8PrivateKey struct {
+	Version    int
+	Algorithm  pkix.AlgorithmIdentifier
+	PrivateKey []byte
+}
+
+// marshalX25519Keys encodes an X25519 key pair as PKCS #8 and PKIX, since
+// crypto/x509 doesn't support X25519 keys
+func marshalX25519Keys(privateKey DecryptionKey, publicKey EncryptionKey) ([]byte, []byte, error) {
+	algorithm := pkix.AlgorithmIdentifier{Algorithm: oidX25519}
+
+	// The private key is wrapped in an octet string, see RFC 8410
+	curvePrivateKey, err := asn1.Marshal(privateKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	privateDER, err := asn1.Marshal(pkcs8PrivateKey{Algorithm: algorithm, PrivateKey: curvePrivateKey})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	publicDER, err := asn1.Marshal(pkixPublicKey{
+		Algorithm: algorithm,
+		PublicKey: asn1.BitString{Bytes: publicKey, BitLength: 8 * len(publicKey)},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return privateDER, publicDER, nil
+}
+
+func parseX25519PublicKey(der []byte) (EncryptionKey, error) {
+	var key pkixPublicKey
+	rest, err := asn1.Unmarshal(der, &key)
+	if err != nil || len(rest) > 0 {
+		return nil, eris.New("Invalid PKIX public key")
+	}
+	if !key.Algorithm.Algorithm.Equal(oidX25519) || len(key.PublicKey.Bytes) != curve25519.PointSize {
+		return nil, eris.New("Not an X25519 public key")
+	}
+	return key.PublicKey.Bytes, nil
+}
+
+func parseX25519PrivateKey(der []byte) (DecryptionKey, error) {
+	var key pkcs8PrivateKey
+	rest, err := asn1.Unmarshal(der, &key)
+	if err != nil || len(rest) > 0 {
+		return nil, eris.New("Invalid PKCS #8 private key")
+	}
+	if !key.Algorithm.Algorithm.Equal(oidX25519) {
+		return nil, eris.New("Not an X25519 private key")
+	}
+	var curvePrivateKey []byte
+	rest, err = asn1.Unmarshal(key.PrivateKey, &curvePrivateKey)
+	if err != nil || len(rest) > 0 || len(curvePrivateKey) != curve25519.ScalarSize {
+		return nil, eris.New("Invalid X25519 private key")
+	}
+	return curvePrivateKey, nil
+}
+
+// KeyID identifies a public key by the first 8 bytes of the SHA-256
+// of its PKIX encoding, hex-encoded
+func KeyID(publicKey crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return "", eris.Wrap(err, "failed to encode public key")
+	}
+	return derKeyID(der), nil
+}
+
+// PEMKeyID returns the KeyID of a PEM encoded public key of any type
+func PEMKeyID(publicPEM []byte) (string, error) {
+	block, _ := pem.Decode(publicPEM)
+	if block == nil || block.Type != publicKeyPEMType {
+		return "", eris.Errorf("Not a PEM encoded %s", publicKeyPEMType)
+	}
+	return derKeyID(block.Bytes), nil
+}
+
+func derKeyID(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:8])
+}
+
+// GenerateKey generates a new key pair of the given type,
+// returning the PEM encoded private and public keys
+func GenerateKey(keyType KeyType) (privatePEM []byte, publicPEM []byte, err error) {
+	var privateDER, publicDER []byte
+
+	switch keyType {
+	case KeySigning:
+		privateDER, publicDER, err = generateSigningKey()
+	case KeyEncryption:
+		privateDER, publicDER, err = generateEncryptionKey()
+	default:
+		return nil, nil, eris.Errorf("Unknown key type: '%s'", keyType)
+	}
+	if err != nil {
+		return nil, nil, eris.Wrapf(err, "failed to generate %s key", keyType)
+	}
+
+	privatePEM = pem.EncodeToMemory(&pem.Block{Type: privateKeyPEMType, Bytes: privateDER})
+	publicPEM = pem.EncodeToMemory(&pem.Block{Type: publicKeyPEMType, Bytes: publicDER})
+
+	return privatePEM, publicPEM, nil
+}
+
+func generateSigningKey() ([]byte, []byte, error) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	privateDER, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, nil, eris.Wrap(err, "failed to encode private key")
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, nil, eris.Wrap(err, "failed to encode public key")
+	}
+	return privateDER, publicDER, nil
+}
+
+func generateEncryptionKey() ([]byte, []byte, error) {
+	privateKey := make(DecryptionKey, curve25519.ScalarSize)
+	if _, err := rand.Read(privateKey); err != nil {
+		return nil, nil, err
+	}
+	publicKey, err := privateKey.Public()
+	if err != nil {
+		return nil, nil, err
+	}
+	privateDER, publicDER, err := marshalX25519Keys(privateKey, publicKey)
+	if err != nil {
+		return nil, nil, eris.Wrap(err, "failed to encode keys")
+	}
+	return privateDER, publicDER, nil
+}
+
+func readPEM(path, pemType string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to read key file '%s'", path)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != pemType {
+		return nil, eris.Errorf("Key file '%s' doesn't contain a PEM encoded %s", path, pemType)
+	}
+	return block.Bytes, nil
+}
+
+// LoadSigningKey loads an Ed25519 private key from a PEM file
+func LoadSigningKey(path string) (ed25519.PrivateKey, error) {
+	der, err := readPEM(path, privateKeyPEMType)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to parse private key '%s'", path)
+	}
+	signingKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, eris.Errorf("Key '%s' is not an Ed25519 private key", path)
+	}
+	return signingKey, nil
+}
+
+// LoadVerifyingKey loads an Ed25519 public key from a PEM file
+func LoadVerifyingKey(path string) (ed25519.PublicKey, error) {
+	der, err := readPEM(path, publicKeyPEMType)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to parse public key '%s'", path)
+	}
+	verifyingKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, eris.Errorf("Key '%s' is not an Ed25519 public key", path)
+	}
+	return verifyingKey, nil
+}
+
+// LoadEncryptionKey loads an X25519 public key from a PEM file
+func LoadEncryptionKey(path string) (EncryptionKey, error) {
+	der, err := readPEM(path, publicKeyPEMType)
+	if err != nil {
+		return nil, err
+	}
+	encryptionKey, err := parseX25519PublicKey(der)
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to parse public key '%s'", path)
+	}
+	return encryptionKey, nil
+}
+
+// LoadDecryptionKey loads an X25519 private key from a PEM file
+func LoadDecryptionKey(path string) (DecryptionKey, error) {
+	der, err := readPEM(path, privateKeyPEMType)
+	if err != nil {
+		return nil, err
+	}
+	decryptionKey, err := parseX25519PrivateKey(der)
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to parse private key '%s'", path)
+	}
+	return decryptionKey, nil
+}
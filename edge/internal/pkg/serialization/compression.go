@@ -0,0 +1,180 @@
+package serialization
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/golang/snappy"
+	"github.com/rotisserie/eris"
+)
+
+type Compression byte
+
+const (
+	CompressionNone Compression = iota
+	CompressionGzip
+	CompressionSnappy
+)
+
+// envelopeMagic starts every compressed payload.
+// It can't start a JSON document, and it would be a top-level byte string
+// in CBOR, which is never used for messages.
+var envelopeMagic = []byte{'K', 'Z'}
+
+const (
+	envelopeVersion = 1
+
+	// DefaultMaxDecompressedSize is the maximum size of decompressed payloads,
+	// when no other limit is given
+	DefaultMaxDecompressedSize = 16 * 1024 * 1024
+)
+
+var ErrPayloadTooLarge = eris.New("Decompressed payload too large")
+
+// envelopeHeaderLen is the length of the compression envelope header:
+// the magic bytes, the envelope version and the compression algorithm
+var envelopeHeaderLen = len(envelopeMagic) + 2
+
+func (c Compression) MarshalText() ([]byte, error) {
+	switch c {
+	case CompressionNone:
+		return []byte("NONE"), nil
+	case CompressionGzip:
+		return []byte("GZIP"), nil
+	case CompressionSnappy:
+		return []byte("SNAPPY"), nil
+	default:
+		return nil, eris.New("Invalid compression")
+	}
+}
+
+func (c Compression) String() string {
+	text, err := c.MarshalText()
+	if err != nil {
+		return "Unknown"
+	}
+	return string(text)
+}
+
+func CompressionFromString(str string) (Compression, error) {
+	switch strings.ToLower(str) {
+	case "", "none":
+		return CompressionNone, nil
+	case "gzip":
+		return CompressionGzip, nil
+	case "snappy":
+		return CompressionSnappy, nil
+	default:
+		return CompressionNone, eris.Errorf("Unknown compression: '%s'", str)
+	}
+}
+
+// Compressor compresses serialized payloads with a given algorithm,
+// wrapping them inside an envelope which records the algorithm.
+// Payloads smaller than Threshold bytes are left untouched.
+//
+// The envelope is made of:
+//   - the 2 magic bytes "KZ"
+//   - the envelope version (1)
+//   - the compression algorithm (1 for gzip, 2 for snappy)
+//   - the compressed payload
+type Compressor struct {
+	Compression Compression
+	Threshold   int
+}
+
+func (c *Compressor) Compress(payload []byte) ([]byte, error) {
+	if c == nil || c.Compression == CompressionNone || len(payload) < c.Threshold {
+		return payload, nil
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, envelopeHeaderLen+len(payload)/2))
+	buf.Write(envelopeMagic)
+	buf.WriteByte(envelopeVersion)
+	buf.WriteByte(byte(c.Compression))
+
+	switch c.Compression {
+	case CompressionGzip:
+		writer := gzip.NewWriter(buf)
+		if _, err := writer.Write(payload); err != nil {
+			return nil, eris.Wrap(err, "failed to compress payload")
+		}
+		if err := writer.Close(); err != nil {
+			return nil, eris.Wrap(err, "failed to compress payload")
+		}
+	case CompressionSnappy:
+		buf.Write(snappy.Encode(nil, payload))
+	default:
+		return nil, eris.Errorf("Invalid compression: '%v'", c.Compression)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// IsCompressed determines if a payload is wrapped inside a compression envelope
+func IsCompressed(payload []byte) bool {
+	return len(payload) >= envelopeHeaderLen && bytes.HasPrefix(payload, envelopeMagic)
+}
+
+// Decompress unwraps a payload compressed by a Compressor.
+// Payloads without a compression envelope are returned untouched.
+// Payloads larger than maxSize bytes once decompressed are rejected with
+// ErrPayloadTooLarge, without being decompressed in full.
+// If maxSize is 0 or negative, DefaultMaxDecompressedSize is applied.
+func Decompress(payload []byte, maxSize int64) ([]byte, error) {
+	if !IsCompressed(payload) {
+		return payload, nil
+	}
+	if maxSize <= 0 {
+		maxSize = DefaultMaxDecompressedSize
+	}
+
+	version := payload[len(envelopeMagic)]
+	if version != envelopeVersion {
+		return nil, eris.Errorf("Unsupported compression envelope version: %d", version)
+	}
+
+	compression := Compression(payload[len(envelopeMagic)+1])
+	data := payload[envelopeHeaderLen:]
+
+	switch compression {
+	case CompressionNone:
+		if int64(len(data)) > maxSize {
+			return nil, eris.Wrapf(ErrPayloadTooLarge, "limit: %d bytes", maxSize)
+		}
+		return data, nil
+	case CompressionGzip:
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, eris.Wrap(err, "failed to decompress payload")
+		}
+		defer reader.Close()
+		// An additional byte detects payloads exceeding the limit
+		decompressed, err := ioutil.ReadAll(io.LimitReader(reader, maxSize+1))
+		if err != nil {
+			return nil, eris.Wrap(err, "failed to decompress payload")
+		}
+		if int64(len(decompressed)) > maxSize {
+			return nil, eris.Wrapf(ErrPayloadTooLarge, "limit: %d bytes", maxSize)
+		}
+		return decompressed, nil
+	case CompressionSnappy:
+		size, err := snappy.DecodedLen(data)
+		if err != nil {
+			return nil, eris.Wrap(err, "failed to decompress payload")
+		}
+		if int64(size) > maxSize {
+			return nil, eris.Wrapf(ErrPayloadTooLarge, "limit: %d bytes, payload: %d bytes", maxSize, size)
+		}
+		decompressed, err := snappy.Decode(nil, data)
+		if err != nil {
+			return nil, eris.Wrap(err, "failed to decompress payload")
+		}
+		return decompressed, nil
+	default:
+		return nil, eris.Errorf("Unknown payload compression: %d", compression)
+	}
+}
@@ -0,0 +1,118 @@
+package serialization
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type CompressionSuite struct {
+	suite.Suite
+}
+
+func (s *CompressionSuite) TestRoundTrip() {
+	assert := s.Require()
+
+	payload := bytes.Repeat([]byte(`{"id":"item","name":"Item"}`), 100)
+
+	for _, compression := range []Compression{CompressionGzip, CompressionSnappy} {
+		compressor := &Compressor{Compression: compression}
+
+		compressed, err := compressor.Compress(payload)
+		assert.NoError(err, compression)
+		assert.True(IsCompressed(compressed), compression)
+		assert.Less(len(compressed), len(payload), compression)
+
+		decompressed, err := Decompress(compressed, 0)
+		assert.NoError(err, compression)
+		assert.Equal(payload, decompressed, compression)
+
+		// The limit is inclusive
+		decompressed, err = Decompress(compressed, int64(len(payload)))
+		assert.NoError(err, compression)
+		assert.Equal(payload, decompressed, compression)
+	}
+}
+
+func (s *CompressionSuite) TestPassthrough() {
+	assert := s.Require()
+
+	payload := []byte(`{"id":"item"}`)
+
+	// Payloads below the threshold, or without compression, are left untouched
+	for _, compressor := range []*Compressor{
+		nil,
+		{Compression: CompressionNone},
+		{Compression: CompressionGzip, Threshold: len(payload) + 1},
+	} {
+		compressed, err := compressor.Compress(payload)
+		assert.NoError(err)
+		assert.Equal(payload, compressed)
+		assert.False(IsCompressed(compressed))
+	}
+
+	decompressed, err := Decompress(payload, 1)
+	assert.NoError(err)
+	assert.Equal(payload, decompressed)
+
+	_, err = (&Compressor{Compression: Compression(10)}).Compress(payload)
+	assert.Error(err)
+}
+
+func (s *CompressionSuite) TestCorruptEnvelope() {
+	assert := s.Require()
+
+	payload := bytes.Repeat([]byte("payload"), 10)
+
+	for _, corrupt := range [][]byte{
+		// Unsupported version
+		append([]byte{'K', 'Z', 2, byte(CompressionGzip)}, payload...),
+		// Unknown compression
+		append([]byte{'K', 'Z', envelopeVersion, 10}, payload...),
+		// Invalid compressed data
+		append([]byte{'K', 'Z', envelopeVersion, byte(CompressionGzip)}, payload...),
+		append([]byte{'K', 'Z', envelopeVersion, byte(CompressionSnappy)}, 0xff, 0xff, 0xff, 0xff, 0xff),
+	} {
+		_, err := Decompress(corrupt, 0)
+		assert.Error(err)
+	}
+
+	// Truncated payloads are rejected
+	compressed, err := (&Compressor{Compression: CompressionGzip}).Compress(payload)
+	assert.NoError(err)
+	_, err = Decompress(compressed[:len(compressed)-4], 0)
+	assert.Error(err)
+}
+
+func (s *CompressionSuite) TestOversize() {
+	assert := s.Require()
+
+	// Highly compressible payloads can't exceed the limit once decompressed
+	payload := make([]byte, 1024*1024)
+	const maxSize = 1024
+
+	for _, compression := range []Compression{CompressionGzip, CompressionSnappy} {
+		compressed, err := (&Compressor{Compression: compression}).Compress(payload)
+		assert.NoError(err, compression)
+		assert.Less(len(compressed), maxSize*64, compression)
+
+		_, err = Decompress(compressed, maxSize)
+		assert.ErrorIs(err, ErrPayloadTooLarge, compression)
+	}
+
+	uncompressed := append([]byte{'K', 'Z', envelopeVersion, byte(CompressionNone)}, payload[:maxSize+1]...)
+	_, err := Decompress(uncompressed, maxSize)
+	assert.ErrorIs(err, ErrPayloadTooLarge)
+
+	// The default limit applies when no limit is given
+	payload = make([]byte, DefaultMaxDecompressedSize+1)
+	compressed, err := (&Compressor{Compression: CompressionGzip}).Compress(payload)
+	assert.NoError(err)
+	_, err = Decompress(compressed, 0)
+	assert.ErrorIs(err, ErrPayloadTooLarge)
+}
+
+func TestCompression(t *testing.T) {
+	suite.Run(t, new(CompressionSuite))
+}
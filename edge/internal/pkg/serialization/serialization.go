@@ -32,6 +32,18 @@ func (s Type) String() string {
 	return string(text)
 }
 
+// ContentType returns the MIME type of payloads serialized with this type
+func (s Type) ContentType() string {
+	switch s {
+	case TypeJSON:
+		return "application/json"
+	case TypeCBOR:
+		return "application/cbor"
+	default:
+		return "application/octet-stream"
+	}
+}
+
 func TypeFromString(str string) (Type, error) {
 	switch strings.ToLower(str) {
 	case "json":
@@ -1,9 +1,12 @@
 package services
 
 import (
+	"strconv"
+
 	"devais.it/kronos/internal/pkg/constants"
 	"devais.it/kronos/internal/pkg/db"
 	"devais.it/kronos/internal/pkg/db/models"
+	"devais.it/kronos/internal/pkg/schema"
 	"devais.it/kronos/internal/pkg/types"
 	"devais.it/kronos/internal/pkg/util"
 	"github.com/google/uuid"
@@ -20,8 +23,14 @@ func BatchCreateAttributesTx(ctx *db.TxContext, attributes []models.Attribute, m
 	var err error
 
 	tx := ctx.Tx
+	registry := SchemaRegistry()
 
 	for _, attribute := range attributes {
+		err = validateAttributeTx(tx, registry, &attribute)
+		if err != nil {
+			return err
+		}
+
 		if attribute.CreatedBy == "" {
 			attribute.CreatedBy = modifiedBy
 		}
@@ -35,6 +44,11 @@ func BatchCreateAttributesTx(ctx *db.TxContext, attributes []models.Attribute, m
 			return err
 		}
 
+		err = recordAttributeHistoryTx(tx, &attribute)
+		if err != nil {
+			return err
+		}
+
 		err = PublishEvent(
 			ctx,
 			types.EventEntityCreated,
@@ -106,6 +120,15 @@ func GetAttributesByType(attributeType string, page, pageSize int) ([]models.Att
 	return attributes, nil
 }
 
+func GetAttributesByTypePage(attributeType, cursor string, pageSize int) ([]models.Attribute, *db.Page, error) {
+	var attributes []models.Attribute
+	page, err := GetByTypePage(attributeType, &attributes, cursor, pageSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	return attributes, page, nil
+}
+
 func FindAttributesByName(name string, page, pageSize int) ([]models.Attribute, error) {
 	var attributes []models.Attribute
 	err := FindByName(name, &attributes, page, pageSize)
@@ -115,6 +138,15 @@ func FindAttributesByName(name string, page, pageSize int) ([]models.Attribute,
 	return attributes, nil
 }
 
+func FindAttributesByNamePage(name, cursor string, pageSize int) ([]models.Attribute, *db.Page, error) {
+	var attributes []models.Attribute
+	page, err := FindByNamePage(name, &attributes, cursor, pageSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	return attributes, page, nil
+}
+
 func GetAllAttributes(page, pageSize int) (attributes []models.Attribute, err error) {
 	err = db.GetAll(&attributes, page, pageSize)
 	if err != nil {
@@ -123,12 +155,32 @@ func GetAllAttributes(page, pageSize int) (attributes []models.Attribute, err er
 	return
 }
 
+func GetAllAttributesPage(cursor string, pageSize int) ([]models.Attribute, *db.Page, error) {
+	var attributes []models.Attribute
+	page, err := GetAllPage(&attributes, cursor, pageSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	return attributes, page, nil
+}
+
 func UpdateAttributeTx(ctx *db.TxContext, patch map[string]interface{}, modifiedBy string) error {
 	err := UpdateTx(ctx, &models.Attribute{}, patch, modifiedBy)
 	if err != nil {
 		return err
 	}
 
+	attributeID := patch["id"].(string)
+
+	err = validateStoredAttributeTx(ctx.Tx, SchemaRegistry(), attributeID)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := patch["value"]; ok {
+		return recordUpdatedAttributeHistoryTx(ctx.Tx, attributeID)
+	}
+
 	return nil
 }
 
@@ -171,7 +223,9 @@ func UpsertAttribute(patch map[string]interface{}, modifiedBy string) error {
 }
 
 func DeleteAttribute(attr *models.Attribute, modifiedBy string) error {
-	err := Delete(attr, modifiedBy)
+	err := db.DB().Transaction(func(tx *gorm.DB) error {
+		return DeleteAttributeByIDTx(&db.TxContext{Tx: tx}, attr.ID, modifiedBy)
+	})
 
 	if err != nil {
 		return eris.Wrapf(err, "failed to delete attribute '%s'", attr.ID)
@@ -181,7 +235,9 @@ func DeleteAttribute(attr *models.Attribute, modifiedBy string) error {
 }
 
 func HardDeleteAttributeByID(attributeID, modifiedBy string) error {
-	err := HardDeleteByID(attributeID, &models.Attribute{}, modifiedBy)
+	err := db.GetHardDeleteTx(db.DB()).Transaction(func(tx *gorm.DB) error {
+		return DeleteAttributeByIDTx(&db.TxContext{Tx: tx}, attributeID, modifiedBy)
+	})
 
 	if err != nil {
 		return eris.Wrapf(err, "failed to hard delete attribute '%s'", attributeID)
@@ -191,11 +247,17 @@ func HardDeleteAttributeByID(attributeID, modifiedBy string) error {
 }
 
 func DeleteAttributeByIDTx(ctx *db.TxContext, attributeID, modifiedBy string) error {
+	err := checkRequiredAttributeTx(ctx.Tx, SchemaRegistry(), attributeID, modifiedBy)
+	if err != nil {
+		return err
+	}
 	return DeleteByIDTx(ctx, attributeID, &models.Attribute{}, modifiedBy)
 }
 
 func DeleteAttributeByID(attributeID, modifiedBy string) error {
-	err := DeleteByID(attributeID, &models.Attribute{}, modifiedBy)
+	err := db.DB().Transaction(func(tx *gorm.DB) error {
+		return DeleteAttributeByIDTx(&db.TxContext{Tx: tx}, attributeID, modifiedBy)
+	})
 
 	if err != nil {
 		return eris.Wrapf(err, "failed to delete attribute '%s'", attributeID)
@@ -212,6 +274,64 @@ func GetAttributesCount() (count int64, err error) {
 	return
 }
 
+// GetItemSubtreeAttributes returns attributes of an item and all its descendants
+func GetItemSubtreeAttributes(itemID string, page, pageSize int) ([]models.Attribute, error) {
+	tx, err := db.Paginate(db.DB(), page, pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	var attributes []models.Attribute
+
+	tx = tx.Where("item_id IN ("+itemSubtreeIDsQuery+")", itemID).Find(&attributes)
+	if tx.Error != nil {
+		return nil, eris.Wrapf(tx.Error, "failed to get attributes of subtree of item '%s'", itemID)
+	}
+
+	return attributes, nil
+}
+
+func GetItemSubtreeAttributesCount(itemID string) (count int64, err error) {
+	tx := db.DB().
+		Model(&models.Attribute{}).
+		Where("item_id IN ("+itemSubtreeIDsQuery+")", itemID).
+		Count(&count)
+	if tx.Error != nil {
+		err = eris.Wrapf(tx.Error, "failed to get attributes count of subtree of item '%s'", itemID)
+	}
+	return
+}
+
+// GetAttributesByItemType returns attributes of all items of the given type
+func GetAttributesByItemType(itemType string, page, pageSize int) ([]models.Attribute, error) {
+	tx, err := db.Paginate(db.DB(), page, pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	var attributes []models.Attribute
+
+	tx = tx.
+		Where("item_id IN (SELECT id FROM "+models.ItemsTableName+" WHERE type = ?)", itemType).
+		Find(&attributes)
+	if tx.Error != nil {
+		return nil, eris.Wrapf(tx.Error, "failed to get attributes of items with type '%s'", itemType)
+	}
+
+	return attributes, nil
+}
+
+func GetAttributesByItemTypeCount(itemType string) (count int64, err error) {
+	tx := db.DB().
+		Model(&models.Attribute{}).
+		Where("item_id IN (SELECT id FROM "+models.ItemsTableName+" WHERE type = ?)", itemType).
+		Count(&count)
+	if tx.Error != nil {
+		err = eris.Wrapf(tx.Error, "failed to get attributes count of items with type '%s'", itemType)
+	}
+	return
+}
+
 func GetAttributeVersion(attributeID string) (string, error) {
 	version, err := GetVersion(attributeID, models.AttributesTableName)
 	if err != nil {
@@ -228,6 +348,14 @@ func GetAttributesVersion(page, pageSize int) ([]models.EntityVersion, error) {
 	return versions, nil
 }
 
+func GetAttributesVersionPage(cursor string, pageSize int) ([]models.EntityVersion, *db.Page, error) {
+	versions, page, err := GetAllVersionsPage(models.AttributesTableName, cursor, pageSize)
+	if err != nil {
+		return nil, nil, eris.Wrap(err, "failed to get attributes version")
+	}
+	return versions, page, nil
+}
+
 func GetAttributesSyncPolicy(attributeID string) (null.String, error) {
 	attribute := &models.Attribute{}
 	err := db.DB().
@@ -252,3 +380,97 @@ func GetAttributeValue(attributeID string) (*models.AttributeValue, error) {
 	}
 	return value, err
 }
+
+// ValueOp is a comparison operator of attribute value queries
+type ValueOp string
+
+const (
+	ValueOpEq  ValueOp = "eq"
+	ValueOpNe  ValueOp = "ne"
+	ValueOpGt  ValueOp = "gt"
+	ValueOpGte ValueOp = "gte"
+	ValueOpLt  ValueOp = "lt"
+	ValueOpLte ValueOp = "lte"
+)
+
+var (
+	ErrInvalidValueOp = eris.New("Invalid value operator")
+
+	valueOpsSQL = map[ValueOp]string{
+		ValueOpEq:  "=",
+		ValueOpNe:  "<>",
+		ValueOpGt:  ">",
+		ValueOpGte: ">=",
+		ValueOpLt:  "<",
+		ValueOpLte: "<=",
+	}
+)
+
+// ToTypedAttributeValue converts an attribute value to the native type of its value type
+func ToTypedAttributeValue(value *models.AttributeValue) (*models.TypedAttributeValue, error) {
+	base := SchemaRegistry().BaseType(value.ValueType)
+	typedValue, err := schema.ParseValue(base, value.Value)
+	if err != nil {
+		return nil, err
+	}
+	return &models.TypedAttributeValue{
+		Value:     typedValue,
+		ValueType: value.ValueType,
+		BaseType:  string(base),
+	}, nil
+}
+
+func GetAttributeTypedValue(attributeID string) (*models.TypedAttributeValue, error) {
+	value, err := GetAttributeValue(attributeID)
+	if err != nil {
+		return nil, err
+	}
+	typedValue, err := ToTypedAttributeValue(value)
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to convert value of attribute '%s'", attributeID)
+	}
+	return typedValue, nil
+}
+
+// FindAttributesByValue returns attributes of the given type whose value
+// satisfies a comparison.
+// Numeric operands are compared with the values of attributes with numeric
+// value types, e.g. "int" and "float", other operands are compared as text.
+func FindAttributesByValue(attributeType string, op ValueOp, value string, page, pageSize int) ([]models.Attribute, error) {
+	sqlOp, ok := valueOpsSQL[op]
+	if !ok {
+		return nil, eris.Wrapf(ErrInvalidValueOp, "operator: '%s'", op)
+	}
+
+	tx, err := db.Paginate(db.DB(), page, pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	tx = tx.Where("type = ?", attributeType)
+
+	if number, err := strconv.ParseFloat(value, 64); err == nil {
+		tx = tx.Where(
+			"value_type IN ? AND value <> '' AND CAST(value AS REAL) "+sqlOp+" ?",
+			SchemaRegistry().NumericValueTypes(),
+			number,
+		)
+	} else {
+		tx = tx.Where("value "+sqlOp+" ?", value)
+	}
+
+	var attributes []models.Attribute
+
+	err = tx.Order("id").Find(&attributes).Error
+	if err != nil {
+		return nil, eris.Wrapf(
+			err,
+			"failed to find attributes with type '%s' and value %s '%s'",
+			attributeType,
+			op,
+			value,
+		)
+	}
+
+	return attributes, nil
+}
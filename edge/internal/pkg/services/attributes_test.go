@@ -3,10 +3,12 @@ package services
 import (
 	"gopkg.in/guregu/null.v4"
 	"testing"
+	"time"
 
 	"devais.it/kronos/internal/pkg/constants"
 	"devais.it/kronos/internal/pkg/db"
 	"devais.it/kronos/internal/pkg/db/models"
+	"devais.it/kronos/internal/pkg/schema"
 	"devais.it/kronos/internal/pkg/util"
 	"github.com/google/uuid"
 	"github.com/rotisserie/eris"
@@ -531,6 +533,111 @@ func (s *AttributesSuite) TestVersions() {
 	assert.NoError(DeleteItem(item, mbAttribute))
 }
 
+func (s *AttributesSuite) TestTypedValues() {
+	assert := s.Require()
+
+	item := newItem()
+	assert.NoError(CreateItem(item, mbAttribute))
+
+	cases := []struct {
+		valueType string
+		value     string
+		expected  interface{}
+	}{
+		{"int", "42", int64(42)},
+		{"float", "21.5", 21.5},
+		{"bool", "true", true},
+		{"timestamp", "1000", time.Unix(1, 0).UTC()},
+		{"json", `{"a":1}`, []byte(`{"a":1}`)},
+		{"custom", "text", "text"},
+		{"int", "", nil},
+	}
+
+	for _, c := range cases {
+		attribute := newAttribute(item.ID)
+		attribute.ValueType = c.valueType
+		attribute.Value = c.value
+		assert.NoError(CreateAttribute(attribute, mbAttribute))
+
+		typedValue, err := GetAttributeTypedValue(attribute.ID)
+		assert.NoError(err)
+		assert.EqualValues(c.expected, typedValue.Value)
+		assert.Equal(c.valueType, typedValue.ValueType)
+
+		typedValue, err = GetItemAttributeTypedValueByName(item.ID, attribute.Name)
+		assert.NoError(err)
+		assert.EqualValues(c.expected, typedValue.Value)
+	}
+
+	// Values not matching their value type can't be converted
+	attribute := newAttribute(item.ID)
+	attribute.ValueType = "int"
+	attribute.Value = "abc"
+	assert.NoError(CreateAttribute(attribute, mbAttribute))
+
+	_, err := GetAttributeTypedValue(attribute.ID)
+	assert.ErrorIs(err, schema.ErrValidation)
+}
+
+func (s *AttributesSuite) TestFindByValue() {
+	assert := s.Require()
+
+	item := newItem()
+	assert.NoError(CreateItem(item, mbAttribute))
+
+	values := map[string]string{
+		"int":   "35",
+		"float": "30.5",
+		"other": "40",
+	}
+	ids := make(map[string]string, len(values))
+
+	for valueType, value := range values {
+		attribute := newAttribute(item.ID)
+		attribute.Type = "temperature"
+		attribute.ValueType = valueType
+		attribute.Value = value
+		assert.NoError(CreateAttribute(attribute, mbAttribute))
+		ids[valueType] = attribute.ID
+	}
+
+	attributes, err := FindAttributesByValue("temperature", ValueOpGt, "30", 1, 10)
+	assert.NoError(err)
+	assert.Len(attributes, 2)
+	s.assertContainsID(attributes, ids["int"])
+	s.assertContainsID(attributes, ids["float"])
+
+	attributes, err = FindAttributesByValue("temperature", ValueOpLte, "30.5", 1, 10)
+	assert.NoError(err)
+	assert.Len(attributes, 1)
+	s.assertContainsID(attributes, ids["float"])
+
+	// Non numeric operands are compared as text
+	attributes, err = FindAttributesByValue("temperature", ValueOpEq, "40", 1, 10)
+	assert.NoError(err)
+	assert.Empty(attributes)
+
+	attributes, err = FindAttributesByValue("temperature", ValueOpNe, "text", 1, 10)
+	assert.NoError(err)
+	assert.Len(attributes, 3)
+	// Pages are stable, attributes are ordered by ID
+	assert.True(attributes[0].ID < attributes[1].ID && attributes[1].ID < attributes[2].ID)
+
+	// Registered numeric value types
+	registry, err := schema.Parse([]byte(`{"value_types": {"other": {"base": "int"}}}`))
+	assert.NoError(err)
+	SetSchemaRegistry(registry)
+	defer SetSchemaRegistry(nil)
+
+	attributes, err = FindAttributesByValue("temperature", ValueOpEq, "40", 1, 10)
+	assert.NoError(err)
+	assert.Len(attributes, 1)
+	s.assertContainsID(attributes, ids["other"])
+
+	_, err = FindAttributesByValue("temperature", "like", "40", 1, 10)
+	assert.ErrorIs(err, ErrInvalidValueOp)
+}
+
 func TestAttributesService(t *testing.T) {
 	suite.Run(t, new(AttributesSuite))
 }
@@ -0,0 +1,191 @@
+package services
+
+import (
+	"devais.it/kronos/internal/pkg/db"
+	"devais.it/kronos/internal/pkg/db/models"
+	"devais.it/kronos/internal/pkg/types"
+	"github.com/google/uuid"
+	"github.com/rotisserie/eris"
+	"gorm.io/gorm"
+)
+
+// CascadeDelete lists the entities removed along with an item.
+// Relations and attributes are deleted before the item, in this order.
+type CascadeDelete struct {
+	ItemID string `json:"item_id"`
+	// Relations contains the composite IDs of the relations where
+	// the item is either the parent or the child
+	Relations  []string `json:"relations"`
+	Attributes []string `json:"attributes"`
+}
+
+// Len returns the number of deleted entities.
+// Deletions of entities never published to the server publish no event,
+// so the deletion can publish fewer events.
+func (c *CascadeDelete) Len() int {
+	return 1 + len(c.Relations) + len(c.Attributes)
+}
+
+// getItemCascadeTx returns the entities removed along with an item
+func getItemCascadeTx(tx *gorm.DB, itemID string) (*CascadeDelete, error) {
+	if itemID == "" {
+		return nil, db.ErrMissingID
+	}
+
+	err := tx.Select("id").First(&models.Item{}, "id = ?", itemID).Error
+	if err != nil {
+		return nil, err
+	}
+
+	cascade := &CascadeDelete{
+		ItemID:     itemID,
+		Relations:  []string{},
+		Attributes: []string{},
+	}
+
+	err = tx.
+		Model(&models.Relation{}).
+		Where("parent_id = ? OR child_id = ?", itemID, itemID).
+		Order(models.RelationCompositeIDColumn).
+		Pluck(models.RelationCompositeIDColumn, &cascade.Relations).
+		Error
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to get relations of item '%s'", itemID)
+	}
+
+	err = tx.
+		Model(&models.Attribute{}).
+		Where("item_id = ?", itemID).
+		Order("id").
+		Pluck("id", &cascade.Attributes).
+		Error
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to get attributes of item '%s'", itemID)
+	}
+
+	return cascade, nil
+}
+
+// PreviewDeleteItem returns the entities that would be removed
+// by the deletion of an item, without deleting anything
+func PreviewDeleteItem(itemID string) (*CascadeDelete, error) {
+	cascade, err := getItemCascadeTx(db.DB(), itemID)
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to preview deletion of item '%s'", itemID)
+	}
+	return cascade, nil
+}
+
+// dropPendingEventsTx removes the queued create and update events of an entity,
+// since they are useless once it is deleted.
+// The remaining events of their transactions are reindexed.
+// Returns true if a create event with the same trigger as the deletion was
+// removed, meaning that the entity was never published and its deletion
+// shouldn't be published either, like PublishEvent does.
+func dropPendingEventsTx(tx *gorm.DB, entityType types.EntityType, entityID, deletedBy string) (bool, error) {
+	var events []models.Event
+
+	err := queuedEvents(tx).
+		Where(
+			"entity_type = ? AND entity_id = ? AND event_type IN ?",
+			entityType,
+			entityID,
+			[]types.EventType{types.EventEntityCreated, types.EventEntityUpdated},
+		).
+		Find(&events).
+		Error
+	if err != nil {
+		return false, eris.Wrap(err, "failed to get pending events")
+	}
+	if len(events) == 0 {
+		return false, nil
+	}
+
+	created := false
+	for _, event := range events {
+		if event.EventType == types.EventEntityCreated && event.TriggeredBy == deletedBy {
+			created = true
+		}
+	}
+
+	err = deleteQueuedEventsTx(tx, events)
+	if err != nil {
+		return false, eris.Wrap(err, "failed to drop pending events")
+	}
+
+	return created, nil
+}
+
+// publishCascadeDeleteTx publishes the deletion of an entity removed along with an item
+func publishCascadeDeleteTx(
+	ctx *db.TxContext,
+	entityType types.EntityType,
+	entityID string,
+	modifiedBy string,
+	body interface{}) error {
+	created, err := dropPendingEventsTx(ctx.Tx, entityType, entityID, modifiedBy)
+	if err != nil || created {
+		return err
+	}
+
+	return PublishEvent(ctx, types.EventEntityDeleted, entityType, entityID, modifiedBy, body)
+}
+
+// deleteItemCascadeTx deletes an item along with its relations and attributes,
+// publishing a deletion event for each of them
+func deleteItemCascadeTx(ctx *db.TxContext, cascade *CascadeDelete, modifiedBy string) error {
+	for _, relationID := range cascade.Relations {
+		relation := &models.Relation{}
+		err := relation.SetCompositeID(relationID)
+		if err != nil {
+			return err
+		}
+		err = ctx.Tx.
+			Scopes(byRelationKey(relation.ParentID, relation.ChildID, relation.Type)).
+			Delete(&models.Relation{}).
+			Error
+		if err != nil {
+			return eris.Wrapf(err, "failed to delete relation '%s'", relationID)
+		}
+		err = publishCascadeDeleteTx(ctx, types.EntityTypeRelation, relationID, modifiedBy, relation)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, attributeID := range cascade.Attributes {
+		err := db.DeleteByID(ctx.Tx, attributeID, &models.Attribute{})
+		if err != nil {
+			return eris.Wrapf(err, "failed to delete attribute '%s'", attributeID)
+		}
+		err = publishCascadeDeleteTx(ctx, types.EntityTypeAttribute, attributeID, modifiedBy, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	return DeleteByIDTx(ctx, cascade.ItemID, &models.Item{}, modifiedBy)
+}
+
+// deleteItemTx deletes an item with its relations and attributes
+// in a new transaction of events, whose length is the number of events
+// actually published
+func deleteItemTx(tx *gorm.DB, itemID, modifiedBy string) error {
+	cascade, err := getItemCascadeTx(tx, itemID)
+	if err != nil {
+		return err
+	}
+
+	ctx := &db.TxContext{Tx: tx}
+	if txLen := cascade.Len(); txLen > 1 {
+		ctx.TxUUID = uuid.NewString()
+		ctx.TxLen = txLen
+	}
+
+	err = deleteItemCascadeTx(ctx, cascade, modifiedBy)
+	if err != nil {
+		return err
+	}
+
+	return SealTxEventsTx(ctx)
+}
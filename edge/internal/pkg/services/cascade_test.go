@@ -0,0 +1,176 @@
+package services
+
+import (
+	"testing"
+
+	"devais.it/kronos/internal/pkg/constants"
+	"devais.it/kronos/internal/pkg/db"
+	"devais.it/kronos/internal/pkg/db/models"
+	"devais.it/kronos/internal/pkg/types"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/gorm"
+)
+
+const mbCascade = "CASCADE_TEST"
+
+type CascadeSuite struct {
+	db.SuiteBase
+}
+
+func (s *CascadeSuite) TestDeleteItem() {
+	assert := s.Require()
+
+	parent := &models.Item{ID: "cascade-parent", Name: "Parent", Type: "room"}
+	item := &models.Item{ID: "cascade-item", Name: "Item", Type: "lamp"}
+	child := &models.Item{ID: "cascade-child", Name: "Child", Type: "bulb"}
+	assert.NoError(BatchCreateItems([]models.Item{*parent, *item, *child}, mbCascade))
+
+	published := newAttribute(item.ID)
+	published.ID = "cascade-attribute-a"
+	pending := newAttribute(item.ID)
+	pending.ID = "cascade-attribute-b"
+	kept := newAttribute(parent.ID)
+	kept.ID = "cascade-attribute-c"
+	assert.NoError(BatchCreateAttributes([]models.Attribute{*published, *pending, *kept}, mbCascade))
+
+	parentRelation := newRelation(parent.ID, item.ID)
+	childRelation := newRelation(item.ID, child.ID)
+	assert.NoError(BatchCreateRelations([]models.Relation{*parentRelation, *childRelation}, mbCascade))
+
+	// Simulate entities already published to the server
+	for _, entity := range []struct {
+		entityType types.EntityType
+		id         string
+	}{
+		{types.EntityTypeItem, item.ID},
+		{types.EntityTypeAttribute, published.ID},
+		{types.EntityTypeRelation, parentRelation.CompositeID()},
+	} {
+		_, err := PurgeEntityEvents(entity.entityType, entity.id)
+		assert.NoError(err)
+	}
+	assert.NoError(UpdateAttribute(map[string]interface{}{"id": published.ID, "value": "1"}, mbCascade))
+
+	cascade, err := PreviewDeleteItem(item.ID)
+	assert.NoError(err)
+	assert.Equal(item.ID, cascade.ItemID)
+	assert.Equal([]string{childRelation.CompositeID(), parentRelation.CompositeID()}, cascade.Relations)
+	assert.Equal([]string{published.ID, pending.ID}, cascade.Attributes)
+	assert.Equal(5, cascade.Len())
+
+	// Preview doesn't delete anything
+	_, err = GetAttributeByID(pending.ID)
+	assert.NoError(err)
+
+	_, err = PreviewDeleteItem("cascade-missing")
+	assert.ErrorIs(err, gorm.ErrRecordNotFound)
+
+	assert.NoError(DeleteItemByID(item.ID, mbCascade))
+
+	count, err := GetAttributesCount()
+	assert.NoError(err)
+	assert.EqualValues(1, count)
+	count, err = GetRelationsCount()
+	assert.NoError(err)
+	assert.Zero(count)
+
+	// Pending events of deleted entities are dropped, and deletions
+	// are published only for entities known by the server
+	for _, entityID := range []string{pending.ID, childRelation.CompositeID()} {
+		count, err = GetEventsFilteredCount(&EventsFilter{EntityID: entityID})
+		assert.NoError(err)
+		assert.Zero(count, entityID)
+	}
+
+	events, err := GetEvents(&EventsFilter{EventType: types.EventEntityDeleted}, 1, 10)
+	assert.NoError(err)
+	assert.Len(events, 3)
+	assert.Equal(parentRelation.CompositeID(), events[0].EntityID)
+	assert.Equal(published.ID, events[1].EntityID)
+	assert.Equal(item.ID, events[2].EntityID)
+
+	// The transaction length counts only the published deletions
+	for i, event := range events {
+		assert.NotEmpty(event.TxUUID)
+		assert.Equal(events[0].TxUUID, event.TxUUID)
+		assert.Equal(len(events), event.TxLen)
+		assert.Equal(i, event.TxIndex)
+	}
+
+	// The transaction of the dropped creation is reindexed,
+	// the purged creation counts as already dequeued
+	keptEvent, err := GetEvent(db.DB(), types.EventEntityCreated, types.EntityTypeAttribute, kept.ID)
+	assert.NoError(err)
+	assert.Equal(1, keptEvent.TxIndex)
+	assert.Equal(2, keptEvent.TxLen)
+
+	count, err = GetEventsFilteredCount(&EventsFilter{EntityID: published.ID})
+	assert.NoError(err)
+	assert.EqualValues(1, count)
+}
+
+func (s *CascadeSuite) TestDeleteUnpublishedItem() {
+	assert := s.Require()
+
+	parent := &models.Item{ID: "unpublished-parent", Name: "Parent", Type: "room"}
+	item := &models.Item{ID: "unpublished-item", Name: "Item", Type: "lamp"}
+	assert.NoError(CreateItem(parent, mbCascade))
+	assert.NoError(CreateItem(item, mbCascade))
+	relation := newRelation(parent.ID, item.ID)
+	assert.NoError(CreateRelation(relation, mbCascade))
+
+	_, err := PurgeEntityEvents(types.EntityTypeRelation, relation.CompositeID())
+	assert.NoError(err)
+
+	assert.NoError(DeleteItemByID(item.ID, mbCascade))
+
+	// Only the relation was published, so its deletion is the whole transaction
+	events, err := GetEvents(&EventsFilter{EventType: types.EventEntityDeleted}, 1, 10)
+	assert.NoError(err)
+	assert.Len(events, 1)
+	assert.Equal(relation.CompositeID(), events[0].EntityID)
+	assert.Equal(1, events[0].TxLen)
+	assert.Equal(0, events[0].TxIndex)
+
+	count, err := GetEventsFilteredCount(&EventsFilter{EntityID: item.ID})
+	assert.NoError(err)
+	assert.Zero(count)
+}
+
+func (s *CascadeSuite) TestDeleteSyncedChildren() {
+	assert := s.Require()
+
+	parent := &models.Item{ID: "synced-parent", Name: "Parent", Type: "room"}
+	item := &models.Item{ID: "synced-item", Name: "Item", Type: "lamp"}
+	assert.NoError(CreateItem(parent, constants.ModifiedBySyncName))
+	assert.NoError(CreateItem(item, mbCascade))
+	relation := newRelation(parent.ID, item.ID)
+	assert.NoError(CreateRelation(relation, constants.ModifiedBySyncName))
+	attribute := newAttribute(item.ID)
+	assert.NoError(CreateAttribute(attribute, constants.ModifiedBySyncName))
+
+	assert.NoError(DeleteItemByID(item.ID, mbCascade))
+
+	// Children created by SYNC are known by the server, so their
+	// deletions are published even if their creations are still queued
+	events, err := GetEvents(&EventsFilter{EventType: types.EventEntityDeleted}, 1, 10)
+	assert.NoError(err)
+	assert.Len(events, 2)
+	assert.Equal(relation.CompositeID(), events[0].EntityID)
+	assert.Equal(attribute.ID, events[1].EntityID)
+
+	for _, entityID := range []string{relation.CompositeID(), attribute.ID} {
+		count, err := GetEventsFilteredCount(&EventsFilter{EntityID: entityID})
+		assert.NoError(err)
+		assert.EqualValues(1, count, entityID)
+	}
+
+	// The item created locally was never published
+	count, err := GetEventsFilteredCount(&EventsFilter{EntityID: item.ID})
+	assert.NoError(err)
+	assert.Zero(count)
+}
+
+func TestCascadeService(t *testing.T) {
+	suite.Run(t, new(CascadeSuite))
+}
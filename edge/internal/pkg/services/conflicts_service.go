@@ -0,0 +1,118 @@
+package services
+
+import (
+	"devais.it/kronos/internal/pkg/constants"
+	"devais.it/kronos/internal/pkg/db"
+	"devais.it/kronos/internal/pkg/db/models"
+	"devais.it/kronos/internal/pkg/types"
+	"devais.it/kronos/internal/pkg/util"
+	"github.com/rotisserie/eris"
+	"gorm.io/gorm"
+)
+
+// CreateConflictTx records a resolved synchronization conflict,
+// publishing an event to notify the server application
+func CreateConflictTx(ctx *db.TxContext, conflict *models.Conflict) error {
+	if conflict.Timestamp == 0 {
+		conflict.Timestamp = util.TimestampMs()
+	}
+
+	err := db.Create(ctx.Tx, conflict)
+	if err != nil {
+		return eris.Wrapf(
+			err,
+			"failed to create conflict for %s '%s'",
+			conflict.EntityType,
+			conflict.EntityID,
+		)
+	}
+
+	return PublishEvent(
+		ctx,
+		types.EventEntityConflict,
+		conflict.EntityType,
+		conflict.EntityID,
+		constants.ModifiedBySyncName,
+		conflict,
+	)
+}
+
+// PruneConflictsTx deletes the oldest conflicts, keeping at most maxConflicts
+// conflicts up to lastID. Since conflict IDs are increasing, only the primary
+// key is used and no count is needed. If maxConflicts is 0, nothing is deleted.
+func PruneConflictsTx(ctx *db.TxContext, lastID uint, maxConflicts int) error {
+	if maxConflicts <= 0 || lastID <= uint(maxConflicts) {
+		return nil
+	}
+
+	err := ctx.Tx.
+		Where("id <= ?", lastID-uint(maxConflicts)).
+		Delete(&models.Conflict{}).
+		Error
+	if err != nil {
+		return eris.Wrap(err, "failed to prune conflicts")
+	}
+	return nil
+}
+
+func GetConflictByID(conflictID uint) (*models.Conflict, error) {
+	conflict := &models.Conflict{}
+	err := db.DB().First(conflict, "id = ?", conflictID).Error
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to get conflict %d", conflictID)
+	}
+	return conflict, nil
+}
+
+func GetAllConflicts(page, pageSize int) (conflicts []models.Conflict, err error) {
+	err = db.GetAll(&conflicts, page, pageSize)
+	if err != nil {
+		err = eris.Wrap(err, "failed to get all conflicts")
+	}
+	return
+}
+
+func GetEntityConflicts(entityType types.EntityType, entityID string) ([]models.Conflict, error) {
+	var conflicts []models.Conflict
+	err := db.DB().
+		Where("entity_type = ? AND entity_id = ?", entityType, entityID).
+		Order("id ASC").
+		Find(&conflicts).
+		Error
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to get conflicts of %s '%s'", entityType, entityID)
+	}
+	return conflicts, nil
+}
+
+func GetConflictsCount() (count int64, err error) {
+	count, err = db.Count(&models.Conflict{})
+	if err != nil {
+		err = eris.Wrap(err, "failed to get conflicts count")
+	}
+	return
+}
+
+// DeleteConflictByID removes a conflict from the conflicts log
+func DeleteConflictByID(conflictID uint) error {
+	tx := db.DB().Delete(&models.Conflict{}, "id = ?", conflictID)
+	if tx.Error != nil {
+		return eris.Wrapf(tx.Error, "failed to delete conflict %d", conflictID)
+	}
+	if tx.RowsAffected == 0 {
+		return eris.Wrapf(gorm.ErrRecordNotFound, "failed to delete conflict %d", conflictID)
+	}
+	return nil
+}
+
+// DeleteAllConflicts clears the conflicts log
+func DeleteAllConflicts() error {
+	err := db.DB().
+		Session(&gorm.Session{AllowGlobalUpdate: true}).
+		Delete(&models.Conflict{}).
+		Error
+	if err != nil {
+		return eris.Wrap(err, "failed to delete all conflicts")
+	}
+	return nil
+}
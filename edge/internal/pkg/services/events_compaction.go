@@ -0,0 +1,298 @@
+package services
+
+import (
+	"sort"
+
+	"devais.it/kronos/internal/pkg/constants"
+	"devais.it/kronos/internal/pkg/db"
+	"devais.it/kronos/internal/pkg/db/models"
+	"devais.it/kronos/internal/pkg/types"
+	"github.com/rotisserie/eris"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+type compactionKey struct {
+	entityType types.EntityType
+	entityID   string
+}
+
+type eventsCompaction struct {
+	removed map[uint]struct{}
+	patched map[uint]*models.Event
+	heads   map[compactionKey]*models.Event
+}
+
+func isCompactable(eventType types.EventType) bool {
+	return eventType == types.EventEntityCreated ||
+		eventType == types.EventEntityUpdated ||
+		eventType == types.EventEntityDeleted
+}
+
+// fold merges an update event into the head of its entity chain.
+// The most recent trigger is kept, but SYNC never overrides a local trigger,
+// otherwise local edits would be mistaken for echoes of server changes.
+func (c *eventsCompaction) fold(head, event *models.Event) error {
+	body, err := patchEventBody(head.Body, event.Body)
+	if err != nil {
+		return err
+	}
+	head.Body = body
+	head.Timestamp = event.Timestamp
+	if event.TriggeredBy != constants.ModifiedBySyncName {
+		head.TriggeredBy = event.TriggeredBy
+	}
+	c.patched[head.ID] = head
+	c.removed[event.ID] = struct{}{}
+	return nil
+}
+
+func (c *eventsCompaction) remove(event *models.Event) {
+	c.removed[event.ID] = struct{}{}
+	delete(c.patched, event.ID)
+}
+
+// isUnpublishedCreate determines if the entity of a create event is unknown
+// to the server when it is deleted.
+// An entity created by SYNC comes from the server, so the server must be
+// notified of its deletion, unless it is deleted by SYNC too.
+func isUnpublishedCreate(create, deletion *models.Event) bool {
+	return create.TriggeredBy != constants.ModifiedBySyncName ||
+		create.TriggeredBy == deletion.TriggeredBy
+}
+
+func (c *eventsCompaction) add(event *models.Event) error {
+	key := compactionKey{event.EntityType, event.EntityID}
+	head := c.heads[key]
+
+	switch event.EventType {
+	case types.EventEntityCreated:
+		c.heads[key] = event
+	case types.EventEntityUpdated:
+		if head == nil || head.EventType == types.EventEntityDeleted {
+			c.heads[key] = event
+			return nil
+		}
+		return c.fold(head, event)
+	case types.EventEntityDeleted:
+		if head == nil || head.EventType == types.EventEntityDeleted {
+			c.heads[key] = event
+			return nil
+		}
+		// Previous changes are useless once the entity is deleted
+		c.remove(head)
+		if head.EventType == types.EventEntityCreated && isUnpublishedCreate(head, event) {
+			// The entity was never published, drop the deletion too
+			c.remove(event)
+			delete(c.heads, key)
+		} else {
+			c.heads[key] = event
+		}
+	}
+
+	return nil
+}
+
+// reindexTransactions keeps TxLen and TxIndex of the remaining events consistent
+// for every transaction which lost events.
+// Indexes start from the first index still in the queue, since previous events
+// of the same transaction could have already been dequeued.
+func (c *eventsCompaction) reindexTransactions(events []models.Event) map[uint]*models.Event {
+	removedByTx := make(map[string]int)
+	for i := range events {
+		event := &events[i]
+		if _, ok := c.removed[event.ID]; ok && event.TxUUID != "" {
+			removedByTx[event.TxUUID]++
+		}
+	}
+
+	reindexed := make(map[uint]*models.Event)
+
+	for txUUID, removedCount := range removedByTx {
+		var remaining []*models.Event
+		start := -1
+		txLen := 0
+		for i := range events {
+			event := &events[i]
+			if event.TxUUID != txUUID {
+				continue
+			}
+			if start < 0 || event.TxIndex < start {
+				start = event.TxIndex
+			}
+			txLen = event.TxLen
+			if _, ok := c.removed[event.ID]; !ok {
+				remaining = append(remaining, event)
+			}
+		}
+
+		sort.Slice(remaining, func(i, j int) bool {
+			return remaining[i].ID < remaining[j].ID
+		})
+
+		txLen -= removedCount
+		if txLen < start+len(remaining) {
+			txLen = start + len(remaining)
+		}
+
+		for i, event := range remaining {
+			if event.TxIndex != start+i || event.TxLen != txLen {
+				event.TxIndex = start + i
+				event.TxLen = txLen
+				reindexed[event.ID] = event
+			}
+		}
+	}
+
+	return reindexed
+}
+
+// CompactEvents folds the create, update and delete events of every entity
+// in the queue, regardless of the trigger and the transaction they belong to:
+//   - updates are merged into the previous create or update
+//   - a delete drops the previous create or update
+//   - a create followed by a delete drops both, unless the entity was
+//     created by SYNC and deleted locally
+//
+// Other event types and in-flight events are left untouched.
+// Returns the number of events removed from the queue.
+func CompactEvents(tx *gorm.DB) (int, error) {
+	// In-flight events could be already processed by the server application
+	var events []models.Event
+	err := queuedEvents(tx).Order("id ASC").Find(&events).Error
+	if err != nil {
+		return 0, eris.Wrap(err, "failed to get events to compact")
+	}
+
+	c := &eventsCompaction{
+		removed: make(map[uint]struct{}),
+		patched: make(map[uint]*models.Event),
+		heads:   make(map[compactionKey]*models.Event),
+	}
+
+	for i := range events {
+		event := &events[i]
+		if !isCompactable(event.EventType) {
+			continue
+		}
+		if err := c.add(event); err != nil {
+			return 0, eris.Wrapf(err, "failed to compact event %d", event.ID)
+		}
+	}
+
+	if len(c.removed) == 0 {
+		return 0, nil
+	}
+
+	for id, event := range c.reindexTransactions(events) {
+		c.patched[id] = event
+	}
+
+	for _, event := range c.patched {
+		err = tx.Model(&models.Event{}).Where("id = ?", event.ID).Updates(map[string]interface{}{
+			"triggered_by": event.TriggeredBy,
+			"tx_len":       event.TxLen,
+			"tx_index":     event.TxIndex,
+			"timestamp":    event.Timestamp,
+			"body":         event.Body,
+		}).Error
+		if err != nil {
+			return 0, eris.Wrapf(err, "failed to update compacted event %d", event.ID)
+		}
+	}
+
+	ids := make([]uint, 0, len(c.removed))
+	for id := range c.removed {
+		ids = append(ids, id)
+	}
+
+	if err := deleteEventsByID(tx, ids); err != nil {
+		return 0, eris.Wrap(err, "failed to delete compacted events")
+	}
+	db.InvalidateEventsQueueStats(tx)
+
+	log.Debugf("Events queue compacted, %d of %d events removed", len(ids), len(events))
+
+	return len(ids), nil
+}
+
+// updateTxIndexes stores TxLen and TxIndex of reindexed events
+func updateTxIndexes(tx *gorm.DB, events map[uint]*models.Event) error {
+	for _, event := range events {
+		err := tx.Model(&models.Event{}).Where("id = ?", event.ID).Updates(map[string]interface{}{
+			"tx_len":   event.TxLen,
+			"tx_index": event.TxIndex,
+		}).Error
+		if err != nil {
+			return eris.Wrapf(err, "failed to reindex event %d", event.ID)
+		}
+	}
+	return nil
+}
+
+// deleteQueuedEventsTx removes queued events, keeping TxLen and TxIndex
+// of the remaining events of their transactions consistent like CompactEvents
+func deleteQueuedEventsTx(tx *gorm.DB, removed []models.Event) error {
+	if len(removed) == 0 {
+		return nil
+	}
+
+	c := &eventsCompaction{removed: make(map[uint]struct{}, len(removed))}
+	var txUUIDs []string
+
+	for i := range removed {
+		c.removed[removed[i].ID] = struct{}{}
+		if removed[i].TxUUID != "" {
+			txUUIDs = append(txUUIDs, removed[i].TxUUID)
+		}
+	}
+
+	if len(txUUIDs) > 0 {
+		var events []models.Event
+		err := queuedEvents(tx).Where("tx_uuid IN ?", txUUIDs).Order("id ASC").Find(&events).Error
+		if err != nil {
+			return eris.Wrap(err, "failed to get events of transactions to reindex")
+		}
+		if err := updateTxIndexes(tx, c.reindexTransactions(events)); err != nil {
+			return err
+		}
+	}
+
+	return deleteEvents(tx, removed)
+}
+
+// SealTxEventsTx sets TxLen and TxIndex of the events published in the
+// transaction of ctx from the events actually queued.
+// Events are coalesced when published, so a transaction can publish fewer
+// events than its operations: it must be called once all operations are applied.
+func SealTxEventsTx(ctx *db.TxContext) error {
+	if ctx.TxUUID == "" {
+		return nil
+	}
+
+	var events []models.Event
+	err := queuedEvents(ctx.Tx).
+		Select("id", "tx_len", "tx_index").
+		Where("tx_uuid = ?", ctx.TxUUID).
+		Order("id ASC").
+		Find(&events).
+		Error
+	if err != nil {
+		return eris.Wrapf(err, "failed to get events of transaction '%s'", ctx.TxUUID)
+	}
+
+	reindexed := make(map[uint]*models.Event)
+	for i := range events {
+		event := &events[i]
+		if event.TxIndex != i || event.TxLen != len(events) {
+			event.TxIndex = i
+			event.TxLen = len(events)
+			reindexed[event.ID] = event
+		}
+	}
+
+	ctx.TxLen = len(events)
+	ctx.TxIndex = int32(len(events))
+
+	return updateTxIndexes(ctx.Tx, reindexed)
+}
@@ -0,0 +1,137 @@
+package services
+
+import (
+	"time"
+
+	"devais.it/kronos/internal/pkg/db"
+	"devais.it/kronos/internal/pkg/db/models"
+	"devais.it/kronos/internal/pkg/util"
+	"github.com/google/uuid"
+	"github.com/rotisserie/eris"
+	"gorm.io/gorm"
+)
+
+// queuedEvents restricts a query to events which are not in-flight
+func queuedEvents(tx *gorm.DB) *gorm.DB {
+	return tx.Where("batch_id IS NULL OR batch_id = ''")
+}
+
+// inFlightEvents restricts a query to events waiting for acknowledgement
+func inFlightEvents(tx *gorm.DB) *gorm.DB {
+	return tx.Where("batch_id IS NOT NULL AND batch_id <> ''")
+}
+
+// GetInFlightEventsCount returns the number of events waiting for acknowledgement
+func GetInFlightEventsCount(tx *gorm.DB) (int64, error) {
+	var count int64
+	err := inFlightEvents(tx).Model(&models.Event{}).Count(&count).Error
+	if err != nil {
+		return 0, eris.Wrap(err, "failed to count in-flight events")
+	}
+	return count, nil
+}
+
+// TakeEventsBatch marks the next batch of queued events as in-flight,
+// assigning them a new batch ID.
+// Events stay in the queue until the batch is acknowledged with AckEventsBatch,
+// or released with ReleaseEventsBatch to be delivered again.
+func TakeEventsBatch(tx *gorm.DB, limit int) (*EventsBatch, error) {
+	batch, err := getEventsBatch(tx, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	batch.ID = uuid.NewString()
+	sentAt := util.TimestampMs()
+
+	ids := make([]uint, len(batch.Events))
+	for i := range batch.Events {
+		ids[i] = batch.Events[i].ID
+		batch.Events[i].BatchID = batch.ID
+		batch.Events[i].SentAt = sentAt
+	}
+
+	for start := 0; start < len(ids); start += eventsDeleteBatchSize {
+		end := start + eventsDeleteBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		err = tx.Model(&models.Event{}).Where("id IN ?", ids[start:end]).Updates(map[string]interface{}{
+			"batch_id": batch.ID,
+			"sent_at":  sentAt,
+		}).Error
+		if err != nil {
+			return nil, eris.Wrapf(err, "failed to mark events batch '%s' as in-flight", batch.ID)
+		}
+	}
+
+	return batch, nil
+}
+
+// AckEventsBatch removes the events of an acknowledged batch from the queue.
+// Returns the number of removed events, which is zero for unknown batches,
+// like batches already acknowledged or released.
+func AckEventsBatch(batchID string) (int64, error) {
+	if batchID == "" {
+		return 0, db.ErrMissingID
+	}
+	var count int64
+	err := db.DB().Transaction(func(tx *gorm.DB) error {
+		// Transactions sent in parts
+		var txUUIDs []string
+		err := tx.Model(&models.Event{}).
+			Where("batch_id = ? AND tx_part > 0", batchID).
+			Distinct().
+			Pluck("tx_uuid", &txUUIDs).
+			Error
+		if err != nil {
+			return err
+		}
+
+		deleteTx := tx.Where("batch_id = ?", batchID).Delete(&models.Event{})
+		if deleteTx.Error != nil {
+			return deleteTx.Error
+		}
+		count = deleteTx.RowsAffected
+
+		return pruneTxParts(tx, txUUIDs)
+	})
+	if err != nil {
+		return 0, eris.Wrapf(err, "failed to acknowledge events batch '%s'", batchID)
+	}
+	if count > 0 {
+		db.InvalidateEventsQueueStats(nil)
+	}
+	return count, nil
+}
+
+func releaseEvents(tx *gorm.DB) (int64, error) {
+	tx = tx.Model(&models.Event{}).Updates(map[string]interface{}{
+		"batch_id": "",
+		"sent_at":  0,
+	})
+	return tx.RowsAffected, tx.Error
+}
+
+// ReleaseEventsBatch moves the events of a batch back to the queue,
+// so that they are delivered again.
+// Returns the number of released events.
+func ReleaseEventsBatch(tx *gorm.DB, batchID string) (int64, error) {
+	count, err := releaseEvents(tx.Where("batch_id = ?", batchID))
+	if err != nil {
+		return 0, eris.Wrapf(err, "failed to release events batch '%s'", batchID)
+	}
+	return count, nil
+}
+
+// ReleaseExpiredEvents moves in-flight events sent more than timeout ago
+// back to the queue, so that they are delivered again.
+// Returns the number of released events.
+func ReleaseExpiredEvents(tx *gorm.DB, timeout time.Duration) (int64, error) {
+	deadline := util.TimestampMs() - uint64(timeout.Milliseconds())
+	count, err := releaseEvents(inFlightEvents(tx).Where("sent_at < ?", deadline))
+	if err != nil {
+		return 0, eris.Wrap(err, "failed to release expired events")
+	}
+	return count, nil
+}
@@ -0,0 +1,277 @@
+package services
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"devais.it/kronos/internal/pkg/config"
+	"devais.it/kronos/internal/pkg/db"
+	"devais.it/kronos/internal/pkg/db/models"
+	"devais.it/kronos/internal/pkg/types"
+	"github.com/rotisserie/eris"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// eventsDeleteBatchSize limits the number of IDs in a single delete,
+// to stay below SQLite's maximum number of bound variables
+const eventsDeleteBatchSize = 500
+
+var ErrEventsQueueFull = eris.New("Events queue is full")
+
+// queueExcess returns how many events and bytes exceed the queue limits
+// once an event of the given size is added
+func queueExcess(conf *config.EventsQueueConfig, count, size, eventSize int64) (int64, int64) {
+	var excessCount, excessSize int64
+	if conf.MaxEvents > 0 {
+		excessCount = count + 1 - conf.MaxEvents
+	}
+	if conf.MaxSize > 0 {
+		excessSize = size + eventSize - int64(conf.MaxSize)
+	}
+	return excessCount, excessSize
+}
+
+// dropDuplicatesInterval is the minimum interval between compactions of a full
+// queue with the DROP_DUPLICATES policy, so that writes into a full queue without
+// duplicates don't compact the whole queue every time
+const dropDuplicatesInterval = 10 * time.Second
+
+var dropDuplicates struct {
+	sync.Mutex
+	compactedAt time.Time
+}
+
+// tryDropDuplicates reports whether a full queue can be compacted,
+// recording the compaction time if so
+func tryDropDuplicates() bool {
+	dropDuplicates.Lock()
+	defer dropDuplicates.Unlock()
+	if time.Since(dropDuplicates.compactedAt) < dropDuplicatesInterval {
+		return false
+	}
+	dropDuplicates.compactedAt = time.Now()
+	return true
+}
+
+// resetDropDuplicates allows the next compaction of a full queue right away
+func resetDropDuplicates() {
+	dropDuplicates.Lock()
+	defer dropDuplicates.Unlock()
+	dropDuplicates.compactedAt = time.Time{}
+}
+
+// reserveEventsQueue makes room in the events queue for a new event with a body
+// of the given size, applying the configured overflow policy if a limit is reached.
+// Queue stats are cached, so the queue isn't scanned on every event.
+// Returns ErrEventsQueueFull if the event can't be queued.
+// Queue stats are updated by queueEvent, once the event is actually queued.
+func reserveEventsQueue(tx *gorm.DB, bodySize int) error {
+	dbConf := db.Config()
+	if dbConf == nil {
+		return nil
+	}
+	conf := &dbConf.EventsQueue
+	if conf.MaxEvents <= 0 && conf.MaxSize == 0 {
+		return nil
+	}
+
+	eventSize := int64(bodySize) + db.EventRowOverhead
+	if conf.MaxSize > 0 && eventSize > int64(conf.MaxSize) {
+		return eris.Wrapf(ErrEventsQueueFull, "event size %d exceeds the maximum queue size %d", eventSize, conf.MaxSize)
+	}
+
+	count, size, err := db.CachedEventsQueueStats(tx)
+	if err != nil {
+		return err
+	}
+
+	excessCount, excessSize := queueExcess(conf, count, size, eventSize)
+	if excessCount > 0 || excessSize > 0 {
+		if err := makeEventsQueueRoom(tx, conf, excessCount, excessSize, eventSize); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// queueEvent appends an event to the events queue, if there is room for it
+func queueEvent(tx *gorm.DB, event *models.Event) error {
+	if err := reserveEventsQueue(tx, len(event.Body)); err != nil {
+		return err
+	}
+	if err := db.Create(tx, event); err != nil {
+		return err
+	}
+	db.AddEventsQueueStats(tx, 1, int64(len(event.Body))+db.EventRowOverhead)
+	return nil
+}
+
+// makeEventsQueueRoom applies the overflow policy to a full events queue
+func makeEventsQueueRoom(tx *gorm.DB, conf *config.EventsQueueConfig, excessCount, excessSize, eventSize int64) error {
+	switch conf.OverflowPolicy {
+	case types.QueueOverflowDropOldest:
+		events, err := getOldestEvents(tx, excessCount, excessSize)
+		if err != nil {
+			return err
+		}
+		log.Warnf("Events queue full, dropping %d oldest events", len(events))
+		return deleteEvents(tx, events)
+	case types.QueueOverflowSpill:
+		events, err := getOldestEvents(tx, excessCount, excessSize)
+		if err != nil {
+			return err
+		}
+		path, err := writeEventsArchive(conf.ArchiveDir, events)
+		if err != nil {
+			return err
+		}
+		log.Warnf("Events queue full, %d oldest events spilled to '%s'", len(events), path)
+		return deleteEvents(tx, events)
+	case types.QueueOverflowDropDuplicates:
+		if !tryDropDuplicates() {
+			break
+		}
+		saved, err := CompactEvents(tx)
+		if err != nil {
+			return err
+		}
+		log.Warnf("Events queue full, %d duplicated events dropped", saved)
+		count, size, err := db.CachedEventsQueueStats(tx)
+		if err != nil {
+			return err
+		}
+		excessCount, excessSize = queueExcess(conf, count, size, eventSize)
+		if excessCount <= 0 && excessSize <= 0 {
+			return nil
+		}
+	}
+
+	return eris.Wrapf(ErrEventsQueueFull, "limits exceeded by %d events, %d bytes", excessCount, excessSize)
+}
+
+// getOldestEvents returns the oldest queued events which need to be removed
+// to free the given number of events and bytes.
+// In-flight events are skipped, since they are removed when acknowledged.
+func getOldestEvents(tx *gorm.DB, excessCount, excessSize int64) ([]models.Event, error) {
+	rows, err := queuedEvents(tx).Model(&models.Event{}).Order("id ASC").Rows()
+	if err != nil {
+		return nil, eris.Wrap(err, "failed to get oldest events")
+	}
+	defer rows.Close()
+
+	var events []models.Event
+	var freedCount, freedSize int64
+
+	for (freedCount < excessCount || freedSize < excessSize) && rows.Next() {
+		event := models.Event{}
+		if err := tx.ScanRows(rows, &event); err != nil {
+			return nil, eris.Wrap(err, "failed to scan oldest events")
+		}
+		events = append(events, event)
+		freedCount++
+		freedSize += int64(len(event.Body)) + db.EventRowOverhead
+	}
+
+	return events, rows.Err()
+}
+
+func deleteEventsByID(tx *gorm.DB, ids []uint) error {
+	for start := 0; start < len(ids); start += eventsDeleteBatchSize {
+		end := start + eventsDeleteBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		err := tx.Delete(&models.Event{}, ids[start:end]).Error
+		if err != nil {
+			return eris.Wrap(err, "failed to delete events")
+		}
+	}
+	return nil
+}
+
+// deleteEvents removes events from the queue, updating cached queue stats
+func deleteEvents(tx *gorm.DB, events []models.Event) error {
+	ids := make([]uint, len(events))
+	var size int64
+	for i := range events {
+		ids[i] = events[i].ID
+		size += int64(len(events[i].Body)) + db.EventRowOverhead
+	}
+	if err := deleteEventsByID(tx, ids); err != nil {
+		return err
+	}
+	db.AddEventsQueueStats(tx, -int64(len(events)), -size)
+	return nil
+}
+
+// writeEventsArchive writes events to a new gzip compressed file of JSON lines
+// inside the given directory, returning its path.
+// The archive is written before events are removed from the queue, so if the
+// removing transaction fails the archived events are still queued too.
+func writeEventsArchive(dir string, events []models.Event) (string, error) {
+	if len(events) == 0 {
+		return "", nil
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", eris.Wrapf(err, "failed to create events archive directory '%s'", dir)
+	}
+
+	name := fmt.Sprintf(
+		"events-%d-%d-%d.jsonl.gz",
+		time.Now().UnixNano(),
+		events[0].ID,
+		events[len(events)-1].ID,
+	)
+	path := filepath.Join(dir, name)
+
+	file, err := os.Create(path)
+	if err != nil {
+		return "", eris.Wrapf(err, "failed to create events archive '%s'", path)
+	}
+	defer file.Close()
+
+	writer := gzip.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+
+	for i := range events {
+		if err := encoder.Encode(&events[i]); err != nil {
+			return "", eris.Wrap(err, "failed to write archived event")
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return "", eris.Wrapf(err, "failed to write events archive '%s'", path)
+	}
+
+	return path, file.Sync()
+}
+
+// ReadEventsArchive reads the events spilled to an archive file
+func ReadEventsArchive(path string) ([]models.Event, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to open events archive '%s'", path)
+	}
+	defer file.Close()
+
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to decompress events archive '%s'", path)
+	}
+	defer reader.Close()
+
+	events, err := ImportEvents(bufio.NewReader(reader))
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to read events archive '%s'", path)
+	}
+
+	return events, nil
+}
@@ -1,6 +1,8 @@
 package services
 
 import (
+	"io"
+
 	"devais.it/kronos/internal/pkg/db"
 	"devais.it/kronos/internal/pkg/db/models"
 	"devais.it/kronos/internal/pkg/types"
@@ -46,12 +48,15 @@ func GetFirstEvents(tx *gorm.DB, limit int) ([]models.Event, error) {
 	return events, nil
 }
 
+// GetEvent returns the oldest queued event of an entity with the given type.
+// In-flight events are ignored.
 func GetEvent(
+	tx *gorm.DB,
 	eventType types.EventType,
 	entityType types.EntityType,
 	entityID string) (*models.Event, error) {
 	event := &models.Event{}
-	tx := db.DB().Where(
+	tx = queuedEvents(tx).Where(
 		"event_type = ? AND entity_type = ? AND entity_id = ?",
 		eventType,
 		entityType,
@@ -72,20 +77,27 @@ func UpdateEvent(tx *gorm.DB, patch map[string]interface{}) error {
 }
 
 func DeleteEvent(tx *gorm.DB, event *models.Event) error {
-	err := tx.Delete(event).Error
-	if err != nil {
-		return eris.Wrap(err, "failed to delete event")
+	deleteTx := tx.Delete(event)
+	if deleteTx.Error != nil {
+		return eris.Wrap(deleteTx.Error, "failed to delete event")
+	}
+	if deleteTx.RowsAffected > 0 {
+		db.AddEventsQueueStats(tx, -deleteTx.RowsAffected, -(int64(len(event.Body)) + db.EventRowOverhead))
 	}
 	return nil
 }
 
+// DeleteEventsByType removes the queued events of an entity with the given types,
+// reindexing the remaining events of their transactions.
+// In-flight events are left untouched.
 func DeleteEventsByType(
 	tx *gorm.DB,
 	entityType types.EntityType,
 	entityID string,
 	triggeredBy string,
 	types []types.EventType) error {
-	tx = tx.
+	var events []models.Event
+	err := queuedEvents(tx).
 		Where(
 			"entity_type = ? AND entity_id = ? AND triggered_by = ? AND event_type IN ?",
 			entityType,
@@ -93,13 +105,17 @@ func DeleteEventsByType(
 			triggeredBy,
 			types,
 		).
-		Delete(&models.Event{})
-	if tx.Error != nil {
-		return eris.Wrap(tx.Error, "failed to delete events by type")
+		Find(&events).
+		Error
+	if err != nil {
+		return eris.Wrap(err, "failed to get events by type")
 	}
-	if tx.RowsAffected == 0 {
+	if len(events) == 0 {
 		return gorm.ErrRecordNotFound
 	}
+	if err := deleteQueuedEventsTx(tx, events); err != nil {
+		return eris.Wrap(err, "failed to delete events by type")
+	}
 	return nil
 }
 
@@ -111,6 +127,180 @@ func GetEventsCount() (count int64, err error) {
 	return
 }
 
+// EventsFilter selects events in the queue.
+// Empty fields are ignored, From and To are inclusive timestamp bounds in milliseconds.
+type EventsFilter struct {
+	EntityType types.EntityType `json:"entity_type,omitempty"`
+	EntityID   string           `json:"entity_id,omitempty"`
+	EventType  types.EventType  `json:"event_type,omitempty"`
+	TxUUID     string           `json:"tx_uuid,omitempty"`
+	From       uint64           `json:"from,omitempty"`
+	To         uint64           `json:"to,omitempty"`
+}
+
+func (f *EventsFilter) apply(tx *gorm.DB) *gorm.DB {
+	if f == nil {
+		return tx
+	}
+	if f.EntityType != "" {
+		tx = tx.Where("entity_type = ?", f.EntityType)
+	}
+	if f.EntityID != "" {
+		tx = tx.Where("entity_id = ?", f.EntityID)
+	}
+	if f.EventType != "" {
+		tx = tx.Where("event_type = ?", f.EventType)
+	}
+	if f.TxUUID != "" {
+		tx = tx.Where("tx_uuid = ?", f.TxUUID)
+	}
+	if f.From > 0 {
+		tx = tx.Where("timestamp >= ?", f.From)
+	}
+	if f.To > 0 {
+		tx = tx.Where("timestamp <= ?", f.To)
+	}
+	return tx
+}
+
+// GetEvents returns the queued events matching the filter, in queue order
+func GetEvents(filter *EventsFilter, page, pageSize int) ([]models.Event, error) {
+	tx, err := db.Paginate(db.DB(), page, pageSize)
+	if err != nil {
+		return nil, eris.Wrap(err, "failed to paginate events")
+	}
+	var events []models.Event
+	err = filter.apply(tx).Order("id ASC").Find(&events).Error
+	if err != nil {
+		return nil, eris.Wrap(err, "failed to get events")
+	}
+	return events, nil
+}
+
+func GetEventsFilteredCount(filter *EventsFilter) (count int64, err error) {
+	err = filter.apply(db.DB().Model(&models.Event{})).Count(&count).Error
+	if err != nil {
+		err = eris.Wrap(err, "failed to get filtered events count")
+	}
+	return
+}
+
+func GetEventByID(eventID uint) (*models.Event, error) {
+	event := &models.Event{}
+	err := db.GetByID(eventID, event)
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to get event %d", eventID)
+	}
+	return event, nil
+}
+
+// PurgeEntityEvents removes all queued events of an entity.
+// In-flight events are left untouched, they are removed when acknowledged.
+// Returns the number of removed events.
+func PurgeEntityEvents(entityType types.EntityType, entityID string) (int64, error) {
+	if err := db.CheckID(entityID); err != nil {
+		return 0, err
+	}
+	tx := queuedEvents(db.DB()).
+		Where("entity_type = ? AND entity_id = ?", entityType, entityID).
+		Delete(&models.Event{})
+	if tx.Error != nil {
+		return 0, eris.Wrapf(tx.Error, "failed to purge events of %s '%s'", entityType, entityID)
+	}
+	if tx.RowsAffected > 0 {
+		db.InvalidateEventsQueueStats(nil)
+	}
+	return tx.RowsAffected, nil
+}
+
+func requeueEventTx(tx *gorm.DB, event *models.Event) error {
+	event.ID = 0
+	// A requeued event is no longer in-flight
+	event.BatchID = ""
+	event.SentAt = 0
+	return queueEvent(tx, event)
+}
+
+// RequeueEventByID moves an event to the end of the queue
+func RequeueEventByID(eventID uint) (*models.Event, error) {
+	event, err := GetEventByID(eventID)
+	if err != nil {
+		return nil, err
+	}
+	err = db.DB().Transaction(func(tx *gorm.DB) error {
+		if err := DeleteEvent(tx, event); err != nil {
+			return err
+		}
+		return requeueEventTx(tx, event)
+	})
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to requeue event %d", eventID)
+	}
+	return event, nil
+}
+
+// RequeueEvents appends events to the queue, preserving their order,
+// e.g. events previously exported or spilled to an archive.
+// Events get new IDs, the given IDs are ignored.
+func RequeueEvents(events []models.Event) (int, error) {
+	err := db.DB().Transaction(func(tx *gorm.DB) error {
+		for i := range events {
+			if err := requeueEventTx(tx, &events[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, eris.Wrap(err, "failed to requeue events")
+	}
+	return len(events), nil
+}
+
+// ExportEvents writes the queued events matching the filter as NDJSON,
+// one event per line, in queue order.
+// Returns the number of exported events.
+func ExportEvents(w io.Writer, filter *EventsFilter) (int, error) {
+	tx := filter.apply(db.DB().Model(&models.Event{}))
+	rows, err := tx.Order("id ASC").Rows()
+	if err != nil {
+		return 0, eris.Wrap(err, "failed to export events")
+	}
+	defer rows.Close()
+
+	count := 0
+	encoder := json.NewEncoder(w)
+
+	for rows.Next() {
+		event := models.Event{}
+		if err := tx.ScanRows(rows, &event); err != nil {
+			return count, eris.Wrap(err, "failed to scan exported event")
+		}
+		if err := encoder.Encode(&event); err != nil {
+			return count, eris.Wrap(err, "failed to write exported event")
+		}
+		count++
+	}
+
+	return count, rows.Err()
+}
+
+// ImportEvents reads NDJSON events, as written by ExportEvents
+func ImportEvents(r io.Reader) ([]models.Event, error) {
+	var events []models.Event
+	decoder := json.NewDecoder(r)
+
+	for decoder.More() {
+		event := models.Event{}
+		if err := decoder.Decode(&event); err != nil {
+			return nil, eris.Wrapf(gorm.ErrInvalidData, "invalid event at line %d: %v", len(events)+1, err)
+		}
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
 func patchEventBody(body, patch string) (string, error) {
 	var err error
 	var bodyMap map[string]interface{}
@@ -156,7 +346,9 @@ func PublishEvent(
 	body := string(bodyBytes)
 
 	if eventType == types.EventEntityDeleted {
-		// If event is entity deleted, remove previous creation
+		// If event is entity deleted, remove previous creation.
+		// In-flight creations could be already received by the server,
+		// so the deletion must be published anyway.
 		err = DeleteEventsByType(
 			ctx.Tx,
 			entityType,
@@ -182,10 +374,12 @@ func PublishEvent(
 			return nil
 		}
 	} else if eventType == types.EventEntityUpdated {
-		// Try to update existing create event
-		lastEvent, err := GetEvent(types.EventEntityCreated, entityType, entityID)
+		// Try to update existing create event.
+		// In-flight events are never patched, since they are removed
+		// from the queue when acknowledged.
+		lastEvent, err := GetEvent(ctx.Tx, types.EventEntityCreated, entityType, entityID)
 		if err != nil || lastEvent == nil {
-			lastEvent, err = GetEvent(types.EventEntityUpdated, entityType, entityID)
+			lastEvent, err = GetEvent(ctx.Tx, types.EventEntityUpdated, entityType, entityID)
 		}
 
 		// Check if event is triggered by the same entity
@@ -205,6 +399,7 @@ func PublishEvent(
 			if err != nil {
 				return eris.Wrap(err, "failed to update event")
 			}
+			db.AddEventsQueueStats(ctx.Tx, 0, int64(len(newBody)-len(lastEvent.Body)))
 
 			return nil
 		}
@@ -222,7 +417,7 @@ func PublishEvent(
 		Body:        body,
 	}
 
-	err = db.Create(ctx.Tx, event)
+	err = queueEvent(ctx.Tx, event)
 	if err != nil {
 		return eris.Wrap(err, "failed to publish event")
 	}
@@ -272,26 +467,245 @@ func TryDequeueEvent(tx *gorm.DB, fn func(event *models.Event) error) error {
 	return nil
 }
 
-func TryDequeueEvents(tx *gorm.DB, limit int, fn func(events []models.Event) error) (int, error) {
-	events, err := GetFirstEvents(tx, limit)
+// EventsBatch is a batch of dequeued events.
+// Transactions are never split across batches, unless a single transaction
+// is larger than the batch limit: in that case the transaction is dequeued
+// in numbered parts, and Part and Parts are set (starting from 1).
+type EventsBatch struct {
+	// ID is set only for batches delivered with acknowledgement
+	ID     string
+	Events []models.Event
+	TxUUID string
+	Part   int
+	Parts  int
+}
+
+func countTxEvents(tx *gorm.DB, txUUID string, afterID uint) (count int64, err error) {
+	err = queuedEvents(tx).
+		Model(&models.Event{}).
+		Where("tx_uuid = ? AND id > ?", txUUID, afterID).
+		Count(&count).
+		Error
 	if err != nil {
-		return 0, err
+		err = eris.Wrapf(err, "failed to count events of transaction '%s'", txUUID)
+	}
+	return
+}
+
+// checkTxEvents validates the events of the same transaction using TxIndex and TxLen:
+// indexes must be increasing and lower than TxLen, and the last part of a transaction
+// should end with the last index.
+// Events coalesced when published can leave gaps, so problems are only reported.
+func checkTxEvents(events []models.Event, last bool) {
+	prevIndex := map[string]int{}
+	lastEvent := map[string]*models.Event{}
+
+	for i := range events {
+		event := &events[i]
+		if event.TxUUID == "" || event.TxLen == 0 {
+			continue
+		}
+		if prev, ok := prevIndex[event.TxUUID]; ok && event.TxIndex <= prev {
+			log.Warnf("Transaction '%s' events out of order: index %d after %d", event.TxUUID, event.TxIndex, prev)
+		}
+		if event.TxIndex >= event.TxLen {
+			log.Warnf("Transaction '%s' event index %d out of range, length: %d", event.TxUUID, event.TxIndex, event.TxLen)
+		}
+		prevIndex[event.TxUUID] = event.TxIndex
+		lastEvent[event.TxUUID] = event
+	}
+
+	if !last {
+		return
+	}
+
+	for txUUID, event := range lastEvent {
+		if event.TxIndex != event.TxLen-1 {
+			log.Debugf("Transaction '%s' may be incomplete: last index %d, length: %d", txUUID, event.TxIndex, event.TxLen)
+		}
+	}
+}
+
+// txPartPrefixLen returns the number of events at the beginning of the slice
+// which belong to the same transaction and part of the first one
+func txPartPrefixLen(events []models.Event) int {
+	n := 1
+	for n < len(events) &&
+		events[n].TxUUID == events[0].TxUUID &&
+		events[n].TxPart == events[0].TxPart {
+		n++
+	}
+	return n
+}
+
+// getTxParts returns the number of parts of a transaction already dequeued
+func getTxParts(tx *gorm.DB, txUUID string) (int, error) {
+	var txParts []models.TxParts
+	err := tx.Where("tx_uuid = ?", txUUID).Limit(1).Find(&txParts).Error
+	if err != nil {
+		return 0, eris.Wrapf(err, "failed to get parts of transaction '%s'", txUUID)
+	}
+	if len(txParts) == 0 {
+		return 0, nil
+	}
+	return txParts[0].Parts, nil
+}
+
+// pruneTxParts removes the parts count of the given transactions
+// once none of their events is left in the queue
+func pruneTxParts(tx *gorm.DB, txUUIDs []string) error {
+	if len(txUUIDs) == 0 {
+		return nil
+	}
+	err := tx.
+		Where("tx_uuid IN ?", txUUIDs).
+		Where("tx_uuid NOT IN (?)", tx.Model(&models.Event{}).Select("tx_uuid").Where("tx_uuid IN ?", txUUIDs)).
+		Delete(&models.TxParts{}).
+		Error
+	if err != nil {
+		return eris.Wrap(err, "failed to prune transaction parts")
+	}
+	return nil
+}
+
+// getEventsBatch returns the next batch of at most limit events,
+// without splitting transactions when possible
+func getEventsBatch(tx *gorm.DB, limit int) (*EventsBatch, error) {
+	events, err := GetFirstEvents(queuedEvents(tx), limit)
+	if err != nil {
+		return nil, err
+	}
+	if len(events) == 0 {
+		return nil, gorm.ErrRecordNotFound
 	}
-	eventsCount := len(events)
 
-	if eventsCount == 0 {
-		return 0, gorm.ErrRecordNotFound
+	batch := &EventsBatch{Events: events}
+	head := &events[0]
+
+	// A transaction with parts already dequeued is the continuation of a
+	// transaction sent in parts, and it's sent alone. A part taken again
+	// after being released is sent alone as well, with the same number.
+	if head.TxUUID != "" {
+		dequeued, err := getTxParts(tx, head.TxUUID)
+		if err != nil {
+			return nil, err
+		}
+		if dequeued > 0 || head.TxPart > 0 {
+			batch.Events = events[:txPartPrefixLen(events)]
+			return batch, setBatchPart(tx, batch, limit, dequeued)
+		}
+	}
+
+	last := &events[len(events)-1]
+	if last.TxUUID == "" {
+		checkTxEvents(batch.Events, true)
+		return batch, nil
+	}
+
+	following, err := countTxEvents(tx, last.TxUUID, last.ID)
+	if err != nil {
+		return nil, err
+	}
+	if following == 0 {
+		checkTxEvents(batch.Events, true)
+		return batch, nil
+	}
+
+	// The last transaction doesn't fit in the batch
+	start := len(events) - 1
+	for start > 0 && events[start-1].TxUUID == last.TxUUID {
+		start--
+	}
+
+	if start > 0 {
+		// Leave the whole transaction to the next batch
+		batch.Events = events[:start]
+		checkTxEvents(batch.Events, true)
+		return batch, nil
+	}
+
+	// The transaction alone is larger than the batch, send it in parts
+	return batch, setBatchPart(tx, batch, limit, 0)
+}
+
+// setBatchPart numbers a batch made of a part of a single transaction,
+// given the number of its parts already dequeued.
+// The number of dequeued parts is stored per transaction, and the part number
+// on its events, since transaction indexes can have gaps left by coalescing.
+func setBatchPart(tx *gorm.DB, batch *EventsBatch, limit int, dequeued int) error {
+	first := &batch.Events[0]
+
+	part := first.TxPart
+	if part == 0 {
+		dequeued++
+		part = dequeued
+
+		err := tx.Save(&models.TxParts{TxUUID: first.TxUUID, Parts: dequeued}).Error
+		if err != nil {
+			return eris.Wrapf(err, "failed to save parts of transaction '%s'", first.TxUUID)
+		}
+
+		ids := make([]uint, len(batch.Events))
+		for i := range batch.Events {
+			ids[i] = batch.Events[i].ID
+			batch.Events[i].TxPart = part
+		}
+		for start := 0; start < len(ids); start += eventsDeleteBatchSize {
+			end := start + eventsDeleteBatchSize
+			if end > len(ids) {
+				end = len(ids)
+			}
+			err = tx.Model(&models.Event{}).Where("id IN ?", ids[start:end]).Update("tx_part", part).Error
+			if err != nil {
+				return eris.Wrapf(err, "failed to set part of transaction '%s'", first.TxUUID)
+			}
+		}
 	}
 
-	err = fn(events)
+	// Events not dequeued yet make up the following parts
+	var pending int64
+	err := queuedEvents(tx).
+		Model(&models.Event{}).
+		Where("tx_uuid = ? AND tx_part = 0", first.TxUUID).
+		Count(&pending).
+		Error
+	if err != nil {
+		return eris.Wrapf(err, "failed to count events of transaction '%s'", first.TxUUID)
+	}
+
+	batch.TxUUID = first.TxUUID
+	batch.Part = part
+	batch.Parts = dequeued + util.CeilDiv(int(pending), limit)
+	checkTxEvents(batch.Events, pending == 0)
+
+	return nil
+}
+
+// TryDequeueEvents dequeues a batch of at most limit events, see EventsBatch.
+// Events are removed from the queue only if fn succeeds.
+// Returns the number of dequeued events.
+func TryDequeueEvents(tx *gorm.DB, limit int, fn func(batch *EventsBatch) error) (int, error) {
+	batch, err := getEventsBatch(tx, limit)
 	if err != nil {
 		return 0, err
 	}
 
-	err = tx.Delete(events).Error
+	err = fn(batch)
 	if err != nil {
 		return 0, err
 	}
 
-	return eventsCount, nil
+	err = deleteEvents(tx, batch.Events)
+	if err != nil {
+		return 0, err
+	}
+
+	if batch.TxUUID != "" {
+		err = pruneTxParts(tx, []string{batch.TxUUID})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(batch.Events), nil
 }
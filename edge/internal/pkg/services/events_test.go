@@ -1,6 +1,14 @@
 package services
 
 import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"devais.it/kronos/internal/pkg/config"
+	"devais.it/kronos/internal/pkg/constants"
 	"devais.it/kronos/internal/pkg/db"
 	"devais.it/kronos/internal/pkg/db/models"
 	"devais.it/kronos/internal/pkg/types"
@@ -20,6 +28,11 @@ type EventsSuite struct {
 	db.SuiteBase
 }
 
+func (s *EventsSuite) SetupTest() {
+	s.SuiteBase.SetupTest()
+	resetDropDuplicates()
+}
+
 func (s *EventsSuite) assertCount(expected int) {
 	assert := s.Require()
 	count, err := GetEventsCount()
@@ -49,7 +62,7 @@ func (s *EventsSuite) TestGet() {
 	assert.NoError(err)
 	assert.Empty(events)
 
-	event, err = GetEvent(types.EventEntityCreated, types.EntityTypeItem, uuid.NewString())
+	event, err = GetEvent(db.DB(), types.EventEntityCreated, types.EntityTypeItem, uuid.NewString())
 	assert.ErrorIs(err, gorm.ErrRecordNotFound)
 	assert.Nil(event)
 
@@ -61,7 +74,7 @@ func (s *EventsSuite) TestGet() {
 	assert.False(called)
 	assert.ErrorIs(err, gorm.ErrRecordNotFound)
 
-	count, err := TryDequeueEvents(db.DB(), eventsBatchSize, func(events []models.Event) error {
+	count, err := TryDequeueEvents(db.DB(), eventsBatchSize, func(batch *EventsBatch) error {
 		called = true
 		return nil
 	})
@@ -111,7 +124,7 @@ func (s *EventsSuite) TestGet() {
 	})
 	assert.Error(err)
 
-	count, err = TryDequeueEvents(db.DB(), eventsBatchSize, func(events []models.Event) error {
+	count, err = TryDequeueEvents(db.DB(), eventsBatchSize, func(batch *EventsBatch) error {
 		return eris.New("test err")
 	})
 	assert.Error(err)
@@ -128,8 +141,9 @@ func (s *EventsSuite) TestGet() {
 
 	s.assertCount(eventsBatchSize - 1)
 
-	count, err = TryDequeueEvents(db.DB(), eventsBatchSize, func(events []models.Event) error {
-		assert.Len(events, eventsBatchSize-1)
+	count, err = TryDequeueEvents(db.DB(), eventsBatchSize, func(batch *EventsBatch) error {
+		assert.Len(batch.Events, eventsBatchSize-1)
+		assert.Zero(batch.Parts)
 		return nil
 	})
 
@@ -286,6 +300,591 @@ func (s *EventsSuite) TestCreate() {
 	s.assertCount(3)
 }
 
+func (s *EventsSuite) TestCompaction() {
+	assert := s.Require()
+
+	saved, err := CompactEvents(db.DB())
+	assert.NoError(err)
+	assert.Zero(saved)
+
+	const mbOther = "EVENTS_TEST_OTHER"
+
+	newEvent := func(
+		eventType types.EventType,
+		entityID, triggeredBy, txUUID string,
+		txLen, txIndex int,
+		body string) *models.Event {
+		event := &models.Event{
+			EventType:   eventType,
+			EntityType:  types.EntityTypeItem,
+			EntityID:    entityID,
+			TriggeredBy: triggeredBy,
+			TxUUID:      txUUID,
+			TxLen:       txLen,
+			TxIndex:     txIndex,
+			Body:        body,
+		}
+		assert.NoError(db.Create(db.DB(), event))
+		return event
+	}
+
+	tx1 := uuid.NewString()
+	tx2 := uuid.NewString()
+	tx3 := uuid.NewString()
+
+	// Create and update of A are folded, across triggers
+	createA := newEvent(types.EventEntityCreated, "A", mbEvents, tx1, 3, 0, `{"id":"A","name":"a"}`)
+	// Create, update and delete of B are all removed, across transactions
+	newEvent(types.EventEntityCreated, "B", mbEvents, tx1, 3, 1, `{"id":"B","name":"b"}`)
+	newEvent(types.EventEntityUpdated, "A", mbOther, tx1, 3, 2, `{"name":"a2"}`)
+	newEvent(types.EventEntityUpdated, "B", constants.ModifiedBySyncName, "", 0, 0, `{"name":"b2"}`)
+	// Update of C is dropped by its deletion
+	newEvent(types.EventEntityUpdated, "C", mbEvents, tx2, 2, 0, `{"name":"c2"}`)
+	deleteC := newEvent(types.EventEntityDeleted, "C", mbEvents, tx2, 2, 1, `{"id":"C"}`)
+	newEvent(types.EventEntityDeleted, "B", mbEvents, tx3, 1, 0, `{"id":"B"}`)
+	// Other events are untouched
+	conflict := newEvent(types.EventEntityConflict, "A", constants.ModifiedBySyncName, "", 0, 0, `{}`)
+	// A SYNC update doesn't override the local trigger
+	updateD := newEvent(types.EventEntityUpdated, "D", mbEvents, "", 0, 0, `{"name":"d2"}`)
+	newEvent(types.EventEntityUpdated, "D", constants.ModifiedBySyncName, "", 0, 0, `{"type":"d"}`)
+	// The local delete of E created by SYNC must reach the server
+	newEvent(types.EventEntityCreated, "E", constants.ModifiedBySyncName, "", 0, 0, `{"id":"E"}`)
+	deleteE := newEvent(types.EventEntityDeleted, "E", mbEvents, "", 0, 0, `{"id":"E"}`)
+	// The SYNC delete of F created by SYNC is dropped along with its create
+	newEvent(types.EventEntityCreated, "F", constants.ModifiedBySyncName, "", 0, 0, `{"id":"F"}`)
+	newEvent(types.EventEntityDeleted, "F", constants.ModifiedBySyncName, "", 0, 0, `{"id":"F"}`)
+
+	s.assertCount(14)
+
+	saved, err = CompactEvents(db.DB())
+	assert.NoError(err)
+	assert.Equal(9, saved)
+
+	s.assertCount(5)
+
+	events, err := GetFirstEvents(db.DB(), eventsBatchSize)
+	assert.NoError(err)
+	assert.Len(events, 5)
+
+	assert.Equal(createA.ID, events[0].ID)
+	assert.Equal(types.EventEntityCreated, events[0].EventType)
+	assert.Equal(mbOther, events[0].TriggeredBy)
+	assert.Equal(tx1, events[0].TxUUID)
+	assert.Equal(1, events[0].TxLen)
+	assert.Equal(0, events[0].TxIndex)
+	assert.JSONEq(`{"id":"A","name":"a2"}`, events[0].Body)
+
+	assert.Equal(deleteC.ID, events[1].ID)
+	assert.Equal(tx2, events[1].TxUUID)
+	assert.Equal(1, events[1].TxLen)
+	assert.Equal(0, events[1].TxIndex)
+
+	assert.Equal(conflict.ID, events[2].ID)
+
+	assert.Equal(updateD.ID, events[3].ID)
+	assert.Equal(mbEvents, events[3].TriggeredBy)
+	assert.JSONEq(`{"name":"d2","type":"d"}`, events[3].Body)
+
+	assert.Equal(deleteE.ID, events[4].ID)
+	assert.Equal(types.EventEntityDeleted, events[4].EventType)
+
+	// Compaction is idempotent
+	saved, err = CompactEvents(db.DB())
+	assert.NoError(err)
+	assert.Zero(saved)
+	s.assertCount(5)
+}
+
+func (s *EventsSuite) TestBatches() {
+	assert := s.Require()
+
+	newTx := func(txLen int) string {
+		txUUID := ""
+		if txLen > 1 {
+			txUUID = uuid.NewString()
+		}
+		for i := 0; i < txLen; i++ {
+			assert.NoError(db.Create(db.DB(), &models.Event{
+				EventType:   types.EventEntityCreated,
+				EntityType:  types.EntityTypeItem,
+				EntityID:    uuid.NewString(),
+				TriggeredBy: mbEvents,
+				TxUUID:      txUUID,
+				TxLen:       txLen,
+				TxIndex:     i,
+				Body:        `{}`,
+			}))
+		}
+		return txUUID
+	}
+
+	dequeue := func(limit int) *EventsBatch {
+		var dequeued *EventsBatch
+		count, err := TryDequeueEvents(db.DB(), limit, func(batch *EventsBatch) error {
+			dequeued = batch
+			return nil
+		})
+		assert.NoError(err)
+		assert.Equal(len(dequeued.Events), count)
+		return dequeued
+	}
+
+	// A transaction is never split across batches
+	newTx(1)
+	tx1 := newTx(3)
+	tx2 := newTx(2)
+
+	batch := dequeue(3)
+	assert.Len(batch.Events, 1)
+	assert.Zero(batch.Parts)
+
+	batch = dequeue(3)
+	assert.Len(batch.Events, 3)
+	assert.Equal(tx1, batch.Events[0].TxUUID)
+	assert.Equal(tx1, batch.Events[2].TxUUID)
+	assert.Zero(batch.Parts)
+
+	batch = dequeue(3)
+	assert.Len(batch.Events, 2)
+	assert.Equal(tx2, batch.Events[0].TxUUID)
+	assert.Zero(batch.Parts)
+
+	s.assertCount(0)
+
+	// A transaction larger than the batch is sent alone, in numbered parts
+	tx3 := newTx(7)
+	newTx(1)
+
+	for part := 1; part <= 3; part++ {
+		batch = dequeue(3)
+		assert.Equal(tx3, batch.TxUUID)
+		assert.Equal(part, batch.Part)
+		assert.Equal(3, batch.Parts)
+		for i, event := range batch.Events {
+			assert.Equal(tx3, event.TxUUID)
+			assert.Equal((part-1)*3+i, event.TxIndex)
+		}
+	}
+	assert.Len(batch.Events, 1)
+
+	batch = dequeue(3)
+	assert.Len(batch.Events, 1)
+	assert.Empty(batch.Events[0].TxUUID)
+	assert.Zero(batch.Parts)
+
+	s.assertCount(0)
+}
+
+func (s *EventsSuite) TestBatchParts() {
+	assert := s.Require()
+
+	newTx := func(indexes ...int) string {
+		txUUID := uuid.NewString()
+		for _, index := range indexes {
+			assert.NoError(db.Create(db.DB(), &models.Event{
+				EventType:   types.EventEntityCreated,
+				EntityType:  types.EntityTypeItem,
+				EntityID:    uuid.NewString(),
+				TriggeredBy: mbEvents,
+				TxUUID:      txUUID,
+				TxLen:       indexes[len(indexes)-1] + 1,
+				TxIndex:     index,
+				Body:        `{}`,
+			}))
+		}
+		return txUUID
+	}
+
+	// A head left by coalescing is not a continuation
+	tx1 := newTx(1, 2)
+	tx2 := newTx(0)
+
+	batch, err := TakeEventsBatch(db.DB(), 3)
+	assert.NoError(err)
+	assert.Len(batch.Events, 3)
+	assert.Equal(tx1, batch.Events[0].TxUUID)
+	assert.Equal(tx2, batch.Events[2].TxUUID)
+	assert.Zero(batch.Parts)
+	_, err = AckEventsBatch(batch.ID)
+	assert.NoError(err)
+
+	// Parts are numbered regardless of gaps in indexes,
+	// and released parts keep their number
+	tx3 := newTx(0, 2, 3, 5, 6, 8, 9)
+
+	batch, err = TakeEventsBatch(db.DB(), 3)
+	assert.NoError(err)
+	assert.Equal(tx3, batch.TxUUID)
+	assert.Equal(1, batch.Part)
+	assert.Equal(3, batch.Parts)
+	_, err = ReleaseEventsBatch(db.DB(), batch.ID)
+	assert.NoError(err)
+
+	for part := 1; part <= 3; part++ {
+		batch, err = TakeEventsBatch(db.DB(), 3)
+		assert.NoError(err)
+		assert.Equal(tx3, batch.TxUUID)
+		assert.Equal(part, batch.Part)
+		assert.Equal(3, batch.Parts)
+		_, err = AckEventsBatch(batch.ID)
+		assert.NoError(err)
+	}
+	assert.Len(batch.Events, 1)
+
+	s.assertCount(0)
+	count, err := db.Count(&models.TxParts{})
+	assert.NoError(err)
+	assert.Zero(count)
+}
+
+func (s *EventsSuite) TestDelivery() {
+	assert := s.Require()
+
+	assert.NoError(CreateItem(newItem(), mbEvents))
+	assert.NoError(CreateItem(newItem(), mbEvents))
+
+	batch, err := TakeEventsBatch(db.DB(), 1)
+	assert.NoError(err)
+	assert.NotEmpty(batch.ID)
+	assert.Len(batch.Events, 1)
+
+	// In-flight events are not taken again
+	next, err := TakeEventsBatch(db.DB(), eventsBatchSize)
+	assert.NoError(err)
+	assert.Len(next.Events, 1)
+	assert.NotEqual(batch.Events[0].ID, next.Events[0].ID)
+
+	_, err = TakeEventsBatch(db.DB(), eventsBatchSize)
+	assert.ErrorIs(err, gorm.ErrRecordNotFound)
+
+	count, err := GetInFlightEventsCount(db.DB())
+	assert.NoError(err)
+	assert.EqualValues(2, count)
+
+	// Released events are queued again
+	released, err := ReleaseEventsBatch(db.DB(), next.ID)
+	assert.NoError(err)
+	assert.EqualValues(1, released)
+
+	released, err = ReleaseExpiredEvents(db.DB(), time.Hour)
+	assert.NoError(err)
+	assert.Zero(released)
+
+	count, err = GetInFlightEventsCount(db.DB())
+	assert.NoError(err)
+	assert.EqualValues(1, count)
+
+	// Acknowledged events are removed
+	acked, err := AckEventsBatch(batch.ID)
+	assert.NoError(err)
+	assert.EqualValues(1, acked)
+
+	acked, err = AckEventsBatch(batch.ID)
+	assert.NoError(err)
+	assert.Zero(acked)
+
+	s.assertCount(1)
+
+	_, err = TakeEventsBatch(db.DB(), eventsBatchSize)
+	assert.NoError(err)
+
+	time.Sleep(2 * time.Millisecond)
+
+	released, err = ReleaseExpiredEvents(db.DB(), time.Millisecond)
+	assert.NoError(err)
+	assert.EqualValues(1, released)
+
+	count, err = GetInFlightEventsCount(db.DB())
+	assert.NoError(err)
+	assert.Zero(count)
+}
+
+func (s *EventsSuite) TestInFlightCoalescing() {
+	assert := s.Require()
+
+	updated := newItem()
+	deleted := newItem()
+	assert.NoError(CreateItem(updated, mbEvents))
+	assert.NoError(CreateItem(deleted, mbEvents))
+
+	batch, err := TakeEventsBatch(db.DB(), eventsBatchSize)
+	assert.NoError(err)
+	assert.Len(batch.Events, 2)
+
+	// Changes of in-flight entities are published as new events
+	assert.NoError(UpdateItem(map[string]interface{}{"id": updated.ID, "name": "Updated"}, mbEvents))
+	assert.NoError(DeleteItemByID(deleted.ID, mbEvents))
+
+	for _, event := range batch.Events {
+		stored, err := GetEventByID(event.ID)
+		assert.NoError(err)
+		assert.NotContains(stored.Body, "Updated")
+	}
+
+	acked, err := AckEventsBatch(batch.ID)
+	assert.NoError(err)
+	assert.EqualValues(2, acked)
+
+	events, err := GetEvents(nil, 1, eventsBatchSize)
+	assert.NoError(err)
+	assert.Len(events, 2)
+	assert.Equal(types.EventEntityUpdated, events[0].EventType)
+	assert.Equal(updated.ID, events[0].EntityID)
+	assert.Contains(events[0].Body, "Updated")
+	assert.Equal(types.EventEntityDeleted, events[1].EventType)
+	assert.Equal(deleted.ID, events[1].EntityID)
+
+	// In-flight events are never purged
+	batch, err = TakeEventsBatch(db.DB(), 1)
+	assert.NoError(err)
+	purged, err := PurgeEntityEvents(types.EntityTypeItem, updated.ID)
+	assert.NoError(err)
+	assert.Zero(purged)
+	_, err = GetEvent(db.DB(), types.EventEntityUpdated, types.EntityTypeItem, updated.ID)
+	assert.ErrorIs(err, gorm.ErrRecordNotFound)
+}
+
+func (s *EventsSuite) TestInspection() {
+	assert := s.Require()
+
+	txUUID := uuid.NewString()
+	for i := 0; i < 4; i++ {
+		event := &models.Event{
+			EventType:   types.EventEntityCreated,
+			EntityType:  types.EntityTypeItem,
+			EntityID:    fmt.Sprintf("Item%d", i%2),
+			TriggeredBy: mbEvents,
+			Timestamp:   uint64(1000 * (i + 1)),
+			Body:        "{}",
+		}
+		if i >= 2 {
+			event.EventType = types.EventEntityUpdated
+			event.TxUUID = txUUID
+		}
+		assert.NoError(db.Create(db.DB(), event))
+	}
+
+	events, err := GetEvents(nil, 1, eventsBatchSize)
+	assert.NoError(err)
+	assert.Len(events, 4)
+
+	events, err = GetEvents(&EventsFilter{EntityID: "Item1"}, 1, eventsBatchSize)
+	assert.NoError(err)
+	assert.Len(events, 2)
+
+	events, err = GetEvents(&EventsFilter{EventType: types.EventEntityUpdated, TxUUID: txUUID}, 1, 1)
+	assert.NoError(err)
+	assert.Len(events, 1)
+	assert.EqualValues(3000, events[0].Timestamp)
+
+	count, err := GetEventsFilteredCount(&EventsFilter{From: 2000, To: 3000})
+	assert.NoError(err)
+	assert.EqualValues(2, count)
+
+	event, err := GetEventByID(events[0].ID)
+	assert.NoError(err)
+	assert.Equal(events[0].ID, event.ID)
+
+	_, err = GetEventByID(event.ID + 100)
+	assert.ErrorIs(err, gorm.ErrRecordNotFound)
+
+	// Requeue moves the event to the end of the queue
+	requeued, err := RequeueEventByID(event.ID)
+	assert.NoError(err)
+	last, err := GetLastEvent()
+	assert.NoError(err)
+	assert.Equal(requeued.ID, last.ID)
+	assert.Greater(requeued.ID, event.ID)
+	s.assertCount(4)
+
+	// Export and requeue
+	var buf bytes.Buffer
+	exported, err := ExportEvents(&buf, &EventsFilter{EntityID: "Item0"})
+	assert.NoError(err)
+	assert.Equal(2, exported)
+
+	imported, err := ImportEvents(&buf)
+	assert.NoError(err)
+	assert.Len(imported, 2)
+
+	purged, err := PurgeEntityEvents(types.EntityTypeItem, "Item0")
+	assert.NoError(err)
+	assert.EqualValues(2, purged)
+	s.assertCount(2)
+
+	_, err = PurgeEntityEvents(types.EntityTypeItem, "")
+	assert.ErrorIs(err, db.ErrMissingID)
+
+	count, err = GetEventsFilteredCount(&EventsFilter{EntityID: "Item0"})
+	assert.NoError(err)
+	assert.Zero(count)
+
+	requeuedCount, err := RequeueEvents(imported)
+	assert.NoError(err)
+	assert.Equal(2, requeuedCount)
+	s.assertCount(4)
+
+	_, err = ImportEvents(strings.NewReader("{invalid"))
+	assert.ErrorIs(err, gorm.ErrInvalidData)
+}
+
+func (s *EventsSuite) TestQueueLimits() {
+	assert := s.Require()
+
+	queueConf := &db.Config().EventsQueue
+	defer func(conf config.EventsQueueConfig) {
+		*queueConf = conf
+	}(*queueConf)
+
+	publish := func(eventType types.EventType, entityID string) error {
+		return PublishEvent(
+			&db.TxContext{Tx: db.DB()},
+			eventType,
+			types.EntityTypeItem,
+			entityID,
+			mbEvents,
+			map[string]interface{}{"id": entityID},
+		)
+	}
+
+	firstID := func() string {
+		event, err := GetFirstEvent()
+		assert.NoError(err)
+		return event.EntityID
+	}
+
+	// Reject
+	queueConf.MaxEvents = 3
+	queueConf.OverflowPolicy = types.QueueOverflowReject
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(publish(types.EventEntityCreated, fmt.Sprintf("Reject%d", i)))
+	}
+	err := publish(types.EventEntityCreated, "Reject3")
+	assert.ErrorIs(err, ErrEventsQueueFull)
+	s.assertCount(3)
+
+	// Updates coalesced by PublishEvent don't need room
+	assert.NoError(publish(types.EventEntityUpdated, "Reject0"))
+	s.assertCount(3)
+
+	// Drop oldest
+	queueConf.OverflowPolicy = types.QueueOverflowDropOldest
+
+	assert.NoError(publish(types.EventEntityCreated, "DropOldest0"))
+	s.assertCount(3)
+	assert.Equal("Reject1", firstID())
+
+	// Drop duplicates, updates from other triggers are compacted
+	queueConf.MaxEvents = 4
+
+	err = PublishEvent(
+		&db.TxContext{Tx: db.DB()},
+		types.EventEntityUpdated,
+		types.EntityTypeItem,
+		"Reject1",
+		constants.ModifiedBySyncName,
+		map[string]interface{}{"name": "Reject1"},
+	)
+	assert.NoError(err)
+	s.assertCount(4)
+
+	queueConf.OverflowPolicy = types.QueueOverflowDropDuplicates
+
+	assert.NoError(publish(types.EventEntityCreated, "DropDuplicates0"))
+	s.assertCount(4)
+	assert.Equal("Reject1", firstID())
+
+	err = publish(types.EventEntityCreated, "DropDuplicates1")
+	assert.ErrorIs(err, ErrEventsQueueFull)
+	s.assertCount(4)
+
+	// Spill
+	queueConf.OverflowPolicy = types.QueueOverflowSpill
+	queueConf.ArchiveDir = s.T().TempDir()
+
+	assert.NoError(publish(types.EventEntityCreated, "Spill0"))
+	assert.NoError(publish(types.EventEntityCreated, "Spill1"))
+	s.assertCount(4)
+	assert.Equal("DropOldest0", firstID())
+
+	archives, err := filepath.Glob(filepath.Join(queueConf.ArchiveDir, "*.jsonl.gz"))
+	assert.NoError(err)
+	assert.Len(archives, 2)
+
+	var spilled []string
+	for _, archive := range archives {
+		events, err := ReadEventsArchive(archive)
+		assert.NoError(err)
+		for _, event := range events {
+			spilled = append(spilled, event.EntityID)
+		}
+	}
+	assert.ElementsMatch([]string{"Reject1", "Reject2"}, spilled)
+
+	// Size limit
+	queueConf.MaxEvents = 0
+	queueConf.MaxSize = 10
+	queueConf.OverflowPolicy = types.QueueOverflowDropOldest
+
+	err = publish(types.EventEntityCreated, "TooLarge")
+	assert.ErrorIs(err, ErrEventsQueueFull)
+
+	count, size, err := db.EventsQueueStats(db.DB())
+	assert.NoError(err)
+	assert.EqualValues(4, count)
+
+	queueConf.MaxSize = types.FileSize(size)
+	assert.NoError(publish(types.EventEntityCreated, "SizeLimit0"))
+	s.assertCount(4)
+	assert.Equal("DropDuplicates0", firstID())
+}
+
+func (s *EventsSuite) TestQueueLimitsRollback() {
+	assert := s.Require()
+
+	queueConf := &db.Config().EventsQueue
+	defer func(conf config.EventsQueueConfig) {
+		*queueConf = conf
+	}(*queueConf)
+
+	queueConf.MaxEvents = 2
+	queueConf.OverflowPolicy = types.QueueOverflowReject
+
+	publish := func(tx *gorm.DB, entityID string) error {
+		return PublishEvent(
+			&db.TxContext{Tx: tx},
+			types.EventEntityCreated,
+			types.EntityTypeItem,
+			entityID,
+			mbEvents,
+			map[string]interface{}{"id": entityID},
+		)
+	}
+
+	// Events of rolled back transactions don't take room in the queue
+	errRollback := eris.New("rollback")
+	err := db.DB().Transaction(func(tx *gorm.DB) error {
+		assert.NoError(publish(tx, "RolledBack0"))
+		assert.NoError(publish(tx, "RolledBack1"))
+		return errRollback
+	})
+	assert.ErrorIs(err, errRollback)
+
+	assert.NoError(publish(db.DB(), "Committed0"))
+	assert.NoError(publish(db.DB(), "Committed1"))
+	s.assertCount(2)
+
+	// A requeued event takes the room of the removed one
+	event, err := GetFirstEvent()
+	assert.NoError(err)
+	_, err = RequeueEventByID(event.ID)
+	assert.NoError(err)
+	s.assertCount(2)
+
+	assert.ErrorIs(publish(db.DB(), "Rejected"), ErrEventsQueueFull)
+}
+
 func TestEventsService(t *testing.T) {
 	suite.Run(t, new(EventsSuite))
 }
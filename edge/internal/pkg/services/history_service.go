@@ -0,0 +1,202 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"devais.it/kronos/internal/pkg/db"
+	"devais.it/kronos/internal/pkg/db/models"
+	"devais.it/kronos/internal/pkg/schema"
+	"devais.it/kronos/internal/pkg/util"
+	"github.com/rotisserie/eris"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrHistoryNotNumeric    = eris.New("Only the history of numeric attributes can be downsampled")
+	ErrInvalidHistoryBucket = eris.New("History buckets must last at least one millisecond")
+)
+
+// recordAttributeHistoryTx records the value of an attribute in its history,
+// if enabled for the attribute.
+// Values are recorded at their source timestamp, if set.
+func recordAttributeHistoryTx(tx *gorm.DB, attribute *models.Attribute) error {
+	conf := &db.Config().History
+	if !conf.IsRecorded(attribute.ID, attribute.Type) {
+		return nil
+	}
+
+	timestamp := attribute.SourceTimestamp
+	if timestamp == 0 {
+		timestamp = attribute.ModifiedAt
+	}
+
+	err := tx.Create(&models.AttributeHistory{
+		AttributeID: attribute.ID,
+		Value:       attribute.Value,
+		Timestamp:   timestamp,
+	}).Error
+	if err != nil {
+		return eris.Wrapf(err, "failed to record history of attribute '%s'", attribute.ID)
+	}
+	return nil
+}
+
+// ApplyHistoryRetention removes the values recorded in the attributes history
+// older than MaxAge, and the oldest values of the attributes with more than
+// MaxPoints values. It returns the number of removed values.
+func ApplyHistoryRetention() (removed int64, err error) {
+	conf := &db.Config().History
+
+	if conf.MaxAge > 0 {
+		deadline := util.TimestampMs() - uint64(conf.MaxAge.Milliseconds())
+		tx := db.DB().
+			Where("timestamp < ?", deadline).
+			Delete(&models.AttributeHistory{})
+		if tx.Error != nil {
+			return removed, eris.Wrap(tx.Error, "failed to remove expired attributes history")
+		}
+		removed += tx.RowsAffected
+	}
+
+	if conf.MaxPoints > 0 {
+		var attributeIDs []string
+		err = db.DB().
+			Model(&models.AttributeHistory{}).
+			Group("attribute_id").
+			Having("COUNT(*) > ?", conf.MaxPoints).
+			Pluck("attribute_id", &attributeIDs).
+			Error
+		if err != nil {
+			return removed, eris.Wrap(err, "failed to get attributes exceeding the history limit")
+		}
+
+		for _, attributeID := range attributeIDs {
+			tx := db.DB().
+				Where(
+					"attribute_id = ? AND id NOT IN (SELECT id FROM "+models.AttributesHistoryTableName+" "+
+						"WHERE attribute_id = ? ORDER BY timestamp DESC, id DESC LIMIT ?)",
+					attributeID,
+					attributeID,
+					conf.MaxPoints,
+				).
+				Delete(&models.AttributeHistory{})
+			if tx.Error != nil {
+				return removed, eris.Wrapf(tx.Error, "failed to remove oldest history of attribute '%s'", attributeID)
+			}
+			removed += tx.RowsAffected
+		}
+	}
+
+	return removed, nil
+}
+
+// recordUpdatedAttributeHistoryTx records the value of an updated attribute
+func recordUpdatedAttributeHistoryTx(tx *gorm.DB, attributeID string) error {
+	if !db.Config().History.Enabled() {
+		return nil
+	}
+	attribute := &models.Attribute{}
+	err := tx.
+		Select("id, type, value, modified_at, source_timestamp").
+		First(attribute, "id = ?", attributeID).
+		Error
+	if err != nil {
+		return eris.Wrapf(err, "failed to get attribute '%s'", attributeID)
+	}
+	return recordAttributeHistoryTx(tx, attribute)
+}
+
+// historyRange restricts a history query to an attribute and a time range.
+// Zero bounds are ignored.
+func historyRange(tx *gorm.DB, attributeID string, from, to uint64) *gorm.DB {
+	tx = tx.Where("attribute_id = ?", attributeID)
+	if from > 0 {
+		tx = tx.Where("timestamp >= ?", from)
+	}
+	if to > 0 {
+		tx = tx.Where("timestamp <= ?", to)
+	}
+	return tx
+}
+
+// GetAttributeHistory returns the values recorded in the history of an attribute
+// between from and to, in milliseconds since epoch, ordered by time
+func GetAttributeHistory(attributeID string, from, to uint64, page, pageSize int) ([]models.AttributeHistory, error) {
+	if _, err := GetAttributeValue(attributeID); err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Paginate(db.DB(), page, pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	var history []models.AttributeHistory
+
+	err = historyRange(tx, attributeID, from, to).
+		Order("timestamp, id").
+		Find(&history).
+		Error
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to get history of attribute '%s'", attributeID)
+	}
+
+	return history, nil
+}
+
+// GetAttributeHistoryBuckets downsamples the history of a numeric attribute
+// between from and to, in milliseconds since epoch, summarizing the values
+// recorded in every interval of the given duration
+func GetAttributeHistoryBuckets(attributeID string, from, to uint64, bucket time.Duration) ([]models.HistoryBucket, error) {
+	value, err := GetAttributeValue(attributeID)
+	if err != nil {
+		return nil, err
+	}
+
+	base := SchemaRegistry().BaseType(value.ValueType)
+	if base != schema.BaseInt && base != schema.BaseFloat {
+		return nil, eris.Wrapf(ErrHistoryNotNumeric, "value type: '%s'", value.ValueType)
+	}
+
+	bucketMs := bucket.Milliseconds()
+	if bucketMs <= 0 {
+		return nil, eris.Wrapf(ErrInvalidHistoryBucket, "bucket: %v", bucket)
+	}
+
+	var buckets []models.HistoryBucket
+
+	bucketStart := fmt.Sprintf("(timestamp / %d) * %d", bucketMs, bucketMs)
+
+	err = historyRange(db.DB().Table(models.AttributesHistoryTableName), attributeID, from, to).
+		Select(
+			bucketStart + " AS timestamp, " +
+				"MIN(CAST(value AS REAL)) AS min, " +
+				"MAX(CAST(value AS REAL)) AS max, " +
+				"AVG(CAST(value AS REAL)) AS avg, " +
+				"COUNT(*) AS count",
+		).
+		Where("value <> ''").
+		Group(bucketStart).
+		Order(bucketStart).
+		Scan(&buckets).
+		Error
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to downsample history of attribute '%s'", attributeID)
+	}
+
+	return buckets, nil
+}
+
+// GetAttributeHistoryCount returns the number of values recorded in the history of an attribute
+func GetAttributeHistoryCount(attributeID string) (count int64, err error) {
+	err = db.DB().
+		Model(&models.AttributeHistory{}).
+		Where("attribute_id = ?", attributeID).
+		Count(&count).
+		Error
+	if err != nil {
+		err = eris.Wrapf(err, "failed to get history count of attribute '%s'", attributeID)
+	}
+	return
+}
@@ -0,0 +1,225 @@
+package services
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"devais.it/kronos/internal/pkg/config"
+	"devais.it/kronos/internal/pkg/db"
+	"devais.it/kronos/internal/pkg/util"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/gorm"
+)
+
+const (
+	mbHistory            = "HISTORY_TEST"
+	historyAttributeType = "history-measure"
+)
+
+type HistorySuite struct {
+	db.SuiteBase
+	defaultConf config.HistoryConfig
+}
+
+func (s *HistorySuite) SetupTest() {
+	s.SuiteBase.SetupTest()
+
+	conf := &db.Config().History
+	s.defaultConf = *conf
+	conf.AttributeTypes = []string{historyAttributeType}
+	conf.Attributes = nil
+	conf.MaxAge = 0
+	conf.MaxPoints = 0
+}
+
+func (s *HistorySuite) TearDownTest() {
+	db.Config().History = s.defaultConf
+}
+
+// createHistoryAttribute creates a recorded float attribute, returning its ID
+func (s *HistorySuite) createHistoryAttribute() string {
+	assert := s.Require()
+
+	item := newItem()
+	assert.NoError(CreateItem(item, mbHistory))
+
+	attribute := newAttribute(item.ID)
+	attribute.Type = historyAttributeType
+	attribute.ValueType = "float"
+	attribute.Value = "0"
+	attribute.SourceTimestamp = 1000
+	assert.NoError(CreateAttribute(attribute, mbHistory))
+
+	return attribute.ID
+}
+
+func (s *HistorySuite) updateValue(attributeID, value string, timestamp uint64) {
+	err := UpdateAttribute(map[string]interface{}{
+		"id":               attributeID,
+		"value":            value,
+		"source_timestamp": timestamp,
+	}, mbHistory)
+	s.Require().NoError(err)
+}
+
+func (s *HistorySuite) TestRecord() {
+	assert := s.Require()
+
+	attrID := s.createHistoryAttribute()
+	for i, value := range []string{"1", "2", "3"} {
+		s.updateValue(attrID, value, uint64(2000+i*1000))
+	}
+
+	// Updates not changing the value aren't recorded
+	err := UpdateAttribute(map[string]interface{}{
+		"id":   attrID,
+		"name": "Renamed",
+	}, mbHistory)
+	assert.NoError(err)
+
+	history, err := GetAttributeHistory(attrID, 0, 0, 0, 0)
+	assert.NoError(err)
+	assert.Len(history, 4)
+	assert.Equal("0", history[0].Value)
+	assert.Equal(uint64(1000), history[0].Timestamp)
+	assert.Equal("3", history[3].Value)
+	assert.Equal(uint64(4000), history[3].Timestamp)
+
+	history, err = GetAttributeHistory(attrID, 2000, 3000, 0, 0)
+	assert.NoError(err)
+	assert.Len(history, 2)
+	assert.Equal("1", history[0].Value)
+
+	history, err = GetAttributeHistory(attrID, 0, 0, 2, 3)
+	assert.NoError(err)
+	assert.Len(history, 1)
+	assert.Equal("3", history[0].Value)
+
+	// Attributes of other types aren't recorded
+	item := newItem()
+	assert.NoError(CreateItem(item, mbHistory))
+	attribute := newAttribute(item.ID)
+	assert.NoError(CreateAttribute(attribute, mbHistory))
+	s.updateValue(attribute.ID, "1", 1000)
+
+	count, err := GetAttributeHistoryCount(attribute.ID)
+	assert.NoError(err)
+	assert.Zero(count)
+
+	// Unless listed explicitly
+	db.Config().History.Attributes = []string{attribute.ID}
+	s.updateValue(attribute.ID, "2", 2000)
+
+	count, err = GetAttributeHistoryCount(attribute.ID)
+	assert.NoError(err)
+	assert.Equal(int64(1), count)
+
+	_, err = GetAttributeHistory("missing", 0, 0, 0, 0)
+	assert.ErrorIs(err, gorm.ErrRecordNotFound)
+
+	// History is deleted along with its attribute
+	assert.NoError(DeleteAttributeByID(attrID, mbHistory))
+	count, err = GetAttributeHistoryCount(attrID)
+	assert.NoError(err)
+	assert.Zero(count)
+}
+
+func (s *HistorySuite) TestRetention() {
+	assert := s.Require()
+
+	conf := &db.Config().History
+	conf.MaxPoints = 3
+
+	attrID := s.createHistoryAttribute()
+	for i := 1; i <= 5; i++ {
+		s.updateValue(attrID, strconv.Itoa(i), uint64(1000+i*1000))
+	}
+	otherID := s.createHistoryAttribute()
+	s.updateValue(otherID, "1", 2000)
+
+	// Limits aren't applied when recording values
+	count, err := GetAttributeHistoryCount(attrID)
+	assert.NoError(err)
+	assert.EqualValues(6, count)
+
+	removed, err := ApplyHistoryRetention()
+	assert.NoError(err)
+	assert.EqualValues(3, removed)
+
+	history, err := GetAttributeHistory(attrID, 0, 0, 0, 0)
+	assert.NoError(err)
+	assert.Len(history, 3)
+	assert.Equal("3", history[0].Value)
+	assert.Equal("5", history[2].Value)
+
+	count, err = GetAttributeHistoryCount(otherID)
+	assert.NoError(err)
+	assert.EqualValues(2, count)
+
+	// Values older than the maximum age are removed even if
+	// no value was recorded since
+	conf.MaxAge = time.Hour
+	s.updateValue(attrID, "6", util.TimestampMs())
+
+	removed, err = ApplyHistoryRetention()
+	assert.NoError(err)
+	assert.EqualValues(5, removed)
+
+	history, err = GetAttributeHistory(attrID, 0, 0, 0, 0)
+	assert.NoError(err)
+	assert.Len(history, 1)
+	assert.Equal("6", history[0].Value)
+
+	count, err = GetAttributeHistoryCount(otherID)
+	assert.NoError(err)
+	assert.Zero(count)
+
+	removed, err = ApplyHistoryRetention()
+	assert.NoError(err)
+	assert.Zero(removed)
+}
+
+func (s *HistorySuite) TestBuckets() {
+	assert := s.Require()
+
+	attrID := s.createHistoryAttribute()
+	values := []string{"2", "4", "10", "20", "30"}
+	for i, value := range values {
+		s.updateValue(attrID, value, uint64(1500+i*500))
+	}
+
+	// Timestamps: 1000, 1500, 2000, 2500, 3000, 3500
+	buckets, err := GetAttributeHistoryBuckets(attrID, 0, 0, time.Second)
+	assert.NoError(err)
+	assert.Len(buckets, 3)
+
+	assert.Equal(uint64(1000), buckets[0].Timestamp)
+	assert.Equal(int64(2), buckets[0].Count)
+	assert.Equal(0.0, buckets[0].Min)
+	assert.Equal(2.0, buckets[0].Max)
+	assert.Equal(1.0, buckets[0].Avg)
+
+	assert.Equal(uint64(3000), buckets[2].Timestamp)
+	assert.Equal(25.0, buckets[2].Avg)
+
+	buckets, err = GetAttributeHistoryBuckets(attrID, 2000, 2999, time.Second)
+	assert.NoError(err)
+	assert.Len(buckets, 1)
+	assert.Equal(7.0, buckets[0].Avg)
+
+	_, err = GetAttributeHistoryBuckets(attrID, 0, 0, time.Microsecond)
+	assert.ErrorIs(err, ErrInvalidHistoryBucket)
+
+	// Only numeric values can be downsampled
+	assert.NoError(UpdateAttribute(map[string]interface{}{
+		"id":         attrID,
+		"value_type": "string",
+	}, mbHistory))
+	_, err = GetAttributeHistoryBuckets(attrID, 0, 0, time.Second)
+	assert.ErrorIs(err, ErrHistoryNotNumeric)
+}
+
+func TestHistoryService(t *testing.T) {
+	suite.Run(t, new(HistorySuite))
+}
@@ -24,8 +24,14 @@ func BatchCreateItemsTx(ctx *db.TxContext, items []models.Item, modifiedBy strin
 	var err error
 
 	tx := ctx.Tx
+	registry := SchemaRegistry()
 
 	for _, item := range items {
+		err = validateItem(registry, &item, modifiedBy)
+		if err != nil {
+			return err
+		}
+
 		if item.CreatedBy == "" {
 			item.CreatedBy = modifiedBy
 		}
@@ -179,6 +185,33 @@ func FindItemsByType(typeStr string, page, pageSize int) ([]models.Item, error)
 	return items, nil
 }
 
+func GetItemsByTypePage(itemType, cursor string, pageSize int) ([]models.Item, *db.Page, error) {
+	var items []models.Item
+	page, err := GetByTypePage(itemType, &items, cursor, pageSize)
+	if err != nil {
+		return nil, nil, eris.Wrapf(err, "failed to get items by type '%s'", itemType)
+	}
+	return items, page, nil
+}
+
+func FindItemsByNamePage(name, cursor string, pageSize int) ([]models.Item, *db.Page, error) {
+	var items []models.Item
+	page, err := FindByNamePage(name, &items, cursor, pageSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	return items, page, nil
+}
+
+func FindItemsByTypePage(typeStr, cursor string, pageSize int) ([]models.Item, *db.Page, error) {
+	var items []models.Item
+	page, err := FindByTypePage(typeStr, &items, cursor, pageSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	return items, page, nil
+}
+
 func GetItemVersion(itemID string) (string, error) {
 	version, err := GetVersion(itemID, models.ItemsTableName)
 	if err != nil {
@@ -263,6 +296,15 @@ func GetAllItems(page, pageSize int) (items []models.Item, err error) {
 	return
 }
 
+func GetAllItemsPage(cursor string, pageSize int) ([]models.Item, *db.Page, error) {
+	var items []models.Item
+	page, err := GetAllPage(&items, cursor, pageSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	return items, page, nil
+}
+
 func UpdateItemTx(ctx *db.TxContext, patch map[string]interface{}, modifiedBy string) error {
 	itemPatch := make(map[string]interface{})
 	for key, value := range patch {
@@ -270,15 +312,19 @@ func UpdateItemTx(ctx *db.TxContext, patch map[string]interface{}, modifiedBy st
 	}
 
 	var attributes interface{}
+	sealTx := false
 
 	if attrs, ok := itemPatch["attributes"]; ok {
 		// Extract attributes from item patch
 		attributes = attrs
 		delete(itemPatch, "attributes")
 
-		// If transaction length is 0, set it to at least to 1
-		if ctx.TxLen == 0 {
-			ctx.TxLen = 1
+		// Item and attributes updates are a single transaction,
+		// unless the caller already accounted for them in its own
+		if ctx.TxUUID == "" {
+			ctx.TxUUID = uuid.NewString()
+			ctx.TxLen = db.CalcTxLength(patch)
+			sealTx = true
 		}
 	}
 
@@ -287,14 +333,20 @@ func UpdateItemTx(ctx *db.TxContext, patch map[string]interface{}, modifiedBy st
 		return err
 	}
 
+	if _, ok := itemPatch["type"]; ok {
+		// Attributes must comply with the new item type
+		err = validateStoredItemTx(ctx.Tx, SchemaRegistry(), itemPatch["id"].(string))
+		if err != nil {
+			return err
+		}
+	}
+
 	if attributes != nil {
 		itemId := itemPatch["id"]
 
-		// Update all attributes, increasing transaction length accordingly
+		// Upsert all attributes
 		switch v := attributes.(type) {
 		case []map[string]interface{}:
-			ctx.TxLen += len(v)
-
 			for _, attrPatch := range v {
 				attrPatch["item_id"] = itemId
 				err = UpsertAttributeTx(ctx, attrPatch, modifiedBy)
@@ -303,8 +355,6 @@ func UpdateItemTx(ctx *db.TxContext, patch map[string]interface{}, modifiedBy st
 				}
 			}
 		case []interface{}:
-			ctx.TxLen += len(v)
-
 			for _, attr := range v {
 				attrPatch, ok := attr.(map[string]interface{})
 				if !ok {
@@ -324,6 +374,11 @@ func UpdateItemTx(ctx *db.TxContext, patch map[string]interface{}, modifiedBy st
 		}
 	}
 
+	if sealTx {
+		// Updates may be coalesced with queued events
+		return SealTxEventsTx(ctx)
+	}
+
 	return nil
 }
 
@@ -365,8 +420,13 @@ func UpsertItem(patch map[string]interface{}, modifiedBy string) error {
 	return nil
 }
 
+// DeleteItemByIDTx deletes an item along with its relations and attributes,
+// see PreviewDeleteItem
 func DeleteItemByIDTx(ctx *db.TxContext, itemID, modifiedBy string) error {
-	err := DeleteByIDTx(ctx, itemID, &models.Item{}, modifiedBy)
+	cascade, err := getItemCascadeTx(ctx.Tx, itemID)
+	if err == nil {
+		err = deleteItemCascadeTx(ctx, cascade, modifiedBy)
+	}
 	if err != nil {
 		return eris.Wrapf(err, "failed to delete item '%s'", itemID)
 	}
@@ -374,17 +434,13 @@ func DeleteItemByIDTx(ctx *db.TxContext, itemID, modifiedBy string) error {
 }
 
 func DeleteItem(item *models.Item, modifiedBy string) error {
-	err := Delete(item, modifiedBy)
-
-	if err != nil {
-		return eris.Wrapf(err, "failed to delete item '%s'", item.ID)
-	}
-
-	return nil
+	return DeleteItemByID(item.ID, modifiedBy)
 }
 
 func HardDeleteItemByID(itemID, modifiedBy string) error {
-	err := HardDeleteByID(itemID, &models.Item{}, modifiedBy)
+	err := db.GetHardDeleteTx(db.DB()).Transaction(func(tx *gorm.DB) error {
+		return deleteItemTx(tx, itemID, modifiedBy)
+	})
 
 	if err != nil {
 		return eris.Wrapf(err, "failed to hard delete item '%s'", itemID)
@@ -394,7 +450,9 @@ func HardDeleteItemByID(itemID, modifiedBy string) error {
 }
 
 func DeleteItemByID(itemID, modifiedBy string) error {
-	err := DeleteByID(itemID, &models.Item{}, modifiedBy)
+	err := db.DB().Transaction(func(tx *gorm.DB) error {
+		return deleteItemTx(tx, itemID, modifiedBy)
+	})
 
 	if err != nil {
 		return eris.Wrapf(err, "failed to delete item '%s'", itemID)
@@ -470,6 +528,23 @@ func GetItemAttributeValueByName(itemID, attributeName string) (*models.Attribut
 	return value, nil
 }
 
+func GetItemAttributeTypedValueByName(itemID, attributeName string) (*models.TypedAttributeValue, error) {
+	value, err := GetItemAttributeValueByName(itemID, attributeName)
+	if err != nil {
+		return nil, err
+	}
+	typedValue, err := ToTypedAttributeValue(value)
+	if err != nil {
+		return nil, eris.Wrapf(
+			err,
+			"failed to convert value of attribute '%s' on item '%s'",
+			attributeName,
+			itemID,
+		)
+	}
+	return typedValue, nil
+}
+
 func GetItemAttributesByType(itemID, attributeType string) ([]models.Attribute, error) {
 	var attributes []models.Attribute
 	err := db.DB().
@@ -486,47 +561,74 @@ func GetItemAttributesByType(itemID, attributeType string) ([]models.Attribute,
 	return attributes, nil
 }
 
-func GetItemChildren(itemID string) ([]models.Item, error) {
+// getRelatedItems returns the items related to an item, which is the parent
+// of the relations if itemColumn is parent_id, or the child if it's child_id.
+// Items are ordered by the sort index of their relations and then by ID.
+// If relationType isn't nil, only relations with that type are considered.
+func getRelatedItems(itemID, itemColumn, relatedColumn string, relationType *string) ([]models.Item, error) {
 	if err := ItemExistsErr(itemID); err != nil {
 		return nil, err
 	}
 
 	var items []models.Item
 
-	tx := db.DB().Raw(
-		"SELECT * FROM "+models.ItemsTableName+" "+
-			"WHERE id IN "+
-			"(SELECT child_id FROM "+models.RelationsTableName+" "+
-			"WHERE parent_id=?)",
-		itemID,
-	).Find(&items)
+	tx := db.DB().
+		Table(models.ItemsTableName).
+		Select(models.ItemsTableName+".*").
+		Joins(
+			"INNER JOIN "+models.RelationsTableName+" ON "+
+				models.RelationsTableName+"."+relatedColumn+" = "+models.ItemsTableName+".id",
+		).
+		Where(models.RelationsTableName+"."+itemColumn+" = ?", itemID)
+	if relationType != nil {
+		tx = tx.Where(models.RelationsTableName+".type = ?", *relationType)
+	}
+	tx = tx.
+		Group(models.ItemsTableName + ".id").
+		Order("MIN(" + models.RelationsTableName + ".sort_index), " + models.ItemsTableName + ".id").
+		Find(&items)
 
 	if tx.Error != nil {
-		return nil, eris.Wrapf(tx.Error, "failed to get children of item '%s'", itemID)
+		return nil, tx.Error
 	}
 
 	return items, nil
 }
 
-func GetItemParents(itemID string) ([]models.Item, error) {
-	if err := ItemExistsErr(itemID); err != nil {
-		return nil, err
+// GetItemChildren returns the children of an item, ordered by sort index
+func GetItemChildren(itemID string) ([]models.Item, error) {
+	items, err := getRelatedItems(itemID, "parent_id", "child_id", nil)
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to get children of item '%s'", itemID)
 	}
+	return items, nil
+}
 
-	var items []models.Item
-
-	tx := db.DB().Raw(
-		"SELECT * FROM "+models.ItemsTableName+" "+
-			"WHERE items.id IN "+
-			"(SELECT parent_id FROM "+models.RelationsTableName+" "+
-			"WHERE child_id=?)",
-		itemID,
-	).Find(&items)
+// GetItemChildrenByType returns the children of an item through relations
+// with the given type, ordered by sort index
+func GetItemChildrenByType(itemID, relationType string) ([]models.Item, error) {
+	items, err := getRelatedItems(itemID, "parent_id", "child_id", &relationType)
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to get children of item '%s' with relation type '%s'", itemID, relationType)
+	}
+	return items, nil
+}
 
-	if tx.Error != nil {
-		return nil, eris.Wrapf(tx.Error, "failed to get parents of item '%s'", itemID)
+func GetItemParents(itemID string) ([]models.Item, error) {
+	items, err := getRelatedItems(itemID, "child_id", "parent_id", nil)
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to get parents of item '%s'", itemID)
 	}
+	return items, nil
+}
 
+// GetItemParentsByType returns the parents of an item through relations
+// with the given type
+func GetItemParentsByType(itemID, relationType string) ([]models.Item, error) {
+	items, err := getRelatedItems(itemID, "child_id", "parent_id", &relationType)
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to get parents of item '%s' with relation type '%s'", itemID, relationType)
+	}
 	return items, nil
 }
 
@@ -551,6 +653,81 @@ func GetItemRelations(itemID string) ([]models.Relation, error) {
 	return relations, nil
 }
 
+// GetItemRelationsByType returns the relations with the given type
+// where an item is either the parent or the child
+func GetItemRelationsByType(itemID, relationType string) ([]models.Relation, error) {
+	if err := ItemExistsErr(itemID); err != nil {
+		return nil, err
+	}
+
+	var relations []models.Relation
+
+	tx := db.DB().
+		Where("(parent_id = ? OR child_id = ?) AND type = ?", itemID, itemID, relationType).
+		Order("parent_id, sort_index, child_id").
+		Find(&relations)
+
+	if tx.Error != nil {
+		return nil, eris.Wrapf(tx.Error, "failed to get item relations with type '%s'", relationType)
+	}
+
+	return relations, nil
+}
+
+// itemSubtreeIDsQuery selects the ID of an item along with
+// the IDs of all its descendants.
+// UNION is used instead of UNION ALL to stop recursion on cycles.
+const itemSubtreeIDsQuery = "WITH RECURSIVE subtree(id) AS (" +
+	"SELECT ? UNION " +
+	"SELECT child_id FROM " + models.RelationsTableName + " " +
+	"INNER JOIN subtree ON parent_id = subtree.id" +
+	") SELECT id FROM subtree"
+
+// GetItemSubtree returns an item along with all its descendants
+func GetItemSubtree(itemID string, page, pageSize int) ([]models.Item, error) {
+	if err := ItemExistsErr(itemID); err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Paginate(db.DB(), page, pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []models.Item
+
+	tx = tx.Where("id IN ("+itemSubtreeIDsQuery+")", itemID).Find(&items)
+	if tx.Error != nil {
+		return nil, eris.Wrapf(tx.Error, "failed to get subtree of item '%s'", itemID)
+	}
+
+	return items, nil
+}
+
+// GetItemSubtreeCount returns the number of items in the subtree of an item,
+// the item itself included
+func GetItemSubtreeCount(itemID string) (count int64, err error) {
+	tx := db.DB().
+		Model(&models.Item{}).
+		Where("id IN ("+itemSubtreeIDsQuery+")", itemID).
+		Count(&count)
+	if tx.Error != nil {
+		err = eris.Wrapf(tx.Error, "failed to get subtree count of item '%s'", itemID)
+	}
+	return
+}
+
+func GetItemsByTypeCount(itemType string) (count int64, err error) {
+	tx := db.DB().
+		Model(&models.Item{}).
+		Where("type = ?", itemType).
+		Count(&count)
+	if tx.Error != nil {
+		err = eris.Wrapf(tx.Error, "failed to get count of items with type '%s'", itemType)
+	}
+	return
+}
+
 func GetItemsCount() (count int64, err error) {
 	count, err = db.Count(&models.Item{})
 	if err != nil {
@@ -567,6 +744,14 @@ func GetItemsVersion(page, pageSize int) ([]models.EntityVersion, error) {
 	return versions, nil
 }
 
+func GetItemsVersionPage(cursor string, pageSize int) ([]models.EntityVersion, *db.Page, error) {
+	versions, page, err := GetAllVersionsPage(models.ItemsTableName, cursor, pageSize)
+	if err != nil {
+		return nil, nil, eris.Wrap(err, "failed to get items version")
+	}
+	return versions, page, nil
+}
+
 func GetItemSyncPolicy(itemID string) (null.String, error) {
 	item := &models.Item{}
 	err := db.DB().
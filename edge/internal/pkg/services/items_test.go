@@ -1,6 +1,7 @@
 package services
 
 import (
+	"fmt"
 	"testing"
 
 	"devais.it/kronos/internal/pkg/constants"
@@ -552,6 +553,39 @@ func (s *ItemsSuite) TestUpsert() {
 	assert.Equal(newItemName, createdItem.Name)
 }
 
+func (s *ItemsSuite) TestUpsertAttributes() {
+	assert := s.Require()
+
+	item := newItem()
+	attribute := newAttribute(item.ID)
+
+	patch := map[string]interface{}{
+		"id":   item.ID,
+		"name": item.Name,
+		"type": item.Type,
+		"attributes": []interface{}{
+			map[string]interface{}{
+				"id":   attribute.ID,
+				"name": attribute.Name,
+				"type": attribute.Type,
+			},
+		},
+	}
+	assert.NoError(UpsertItem(patch, mbItem))
+
+	_, err := GetAttributeByID(attribute.ID)
+	assert.NoError(err)
+
+	// Item and attribute creation events share the same transaction
+	events, err := GetEvents(nil, 1, 10)
+	assert.NoError(err)
+	assert.Len(events, 2)
+	assert.NotEmpty(events[0].TxUUID)
+	assert.Equal(events[0].TxUUID, events[1].TxUUID)
+	assert.Equal(2, events[1].TxLen)
+	assert.Equal(1, events[1].TxIndex)
+}
+
 func (s *ItemsSuite) TestDelete() {
 	assert := s.Require()
 
@@ -614,6 +648,10 @@ func (s *ItemsSuite) TestVersions() {
 	assert.Error(err)
 	assert.Empty(versions)
 
+	versions, err = GetItemsVersion(2, itemsBatchSize/2)
+	assert.NoError(err)
+	assert.Len(versions, itemsBatchSize/2)
+
 	versions, err = GetItemsVersion(1, itemsBatchSize)
 	assert.NoError(err)
 	assert.Len(versions, itemsBatchSize)
@@ -659,6 +697,65 @@ func (s *ItemsSuite) TestVersions() {
 	}
 }
 
+func (s *ItemsSuite) TestPages() {
+	assert := s.Require()
+
+	items := make([]models.Item, 5)
+	for i := range items {
+		items[i] = models.Item{
+			ID:   fmt.Sprintf("item-%d", i),
+			Name: fmt.Sprintf("Item-%d", i),
+			Type: "TestItem",
+		}
+	}
+	assert.NoError(BatchCreateItems(items, mbItem))
+
+	var ids []string
+	cursor := ""
+	for {
+		page, info, err := GetAllItemsPage(cursor, 2)
+		assert.NoError(err)
+		// The total is only counted for the first page
+		if cursor == "" {
+			assert.Equal(int64(5), *info.Total)
+		} else {
+			assert.Nil(info.Total)
+		}
+		for _, item := range page {
+			ids = append(ids, item.ID)
+		}
+		if info.NextCursor == "" {
+			break
+		}
+		cursor = info.NextCursor
+
+		// Rows deleted or created before the cursor don't shift the following pages
+		if len(ids) == 2 {
+			assert.NoError(DeleteItemByID("item-0", mbItem))
+			assert.NoError(CreateItem(&models.Item{ID: "item-00", Name: "Item-00", Type: "TestItem"}, mbItem))
+		}
+	}
+	assert.Equal([]string{"item-0", "item-1", "item-2", "item-3", "item-4"}, ids)
+
+	found, info, err := FindItemsByNamePage("Item-", "", 10)
+	assert.NoError(err)
+	assert.Len(found, 5)
+	assert.Equal(int64(5), *info.Total)
+	assert.Empty(info.NextCursor)
+
+	_, _, err = GetItemsByTypePage("Unknown", "", 0)
+	assert.ErrorIs(err, gorm.ErrRecordNotFound)
+
+	_, _, err = GetAllItemsPage("not a cursor!", 0)
+	assert.ErrorIs(err, db.ErrInvalidPagination)
+
+	versions, info, err := GetItemsVersionPage(db.EncodeCursor("item-2"), 0)
+	assert.NoError(err)
+	assert.Nil(info.Total)
+	assert.Len(versions, 2)
+	assert.Equal("item-3", versions[0].ID)
+}
+
 func TestItemsService(t *testing.T) {
 	suite.Run(t, new(ItemsSuite))
 }
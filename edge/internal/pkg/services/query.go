@@ -0,0 +1,279 @@
+package services
+
+import (
+	"reflect"
+	"strings"
+
+	"devais.it/kronos/internal/pkg/db"
+	"devais.it/kronos/internal/pkg/db/models"
+	"github.com/rotisserie/eris"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// Operators of query filters, besides the comparison ones of attribute value queries
+const (
+	ValueOpIn   ValueOp = "in"
+	ValueOpLike ValueOp = "like"
+)
+
+var ErrInvalidQuery = eris.New("Invalid query")
+
+// Filter is a condition of an entity query.
+// It either compares a field with a value or, when And or Or are set,
+// combines other filters.
+type Filter struct {
+	And []Filter `json:"and,omitempty"`
+	Or  []Filter `json:"or,omitempty"`
+
+	// Field is the JSON name of the compared field
+	Field string `json:"field,omitempty"`
+	// Op is the comparison operator, eq if missing
+	Op ValueOp `json:"op,omitempty"`
+	// Value is the compared value. Null values are allowed only by eq and ne,
+	// the in operator requires an array of values.
+	Value interface{} `json:"value"`
+}
+
+// EntityQuery is a structured query of entities
+type EntityQuery struct {
+	Filter *Filter `json:"filter,omitempty"`
+	// Sort lists the JSON names of the fields sorting the results,
+	// prefixed by '-' for descending order
+	Sort []string `json:"sort,omitempty"`
+	// Fields lists the JSON names of the fields returned, all if empty
+	Fields   []string `json:"fields,omitempty"`
+	Page     int      `json:"page,omitempty"`
+	PageSize int      `json:"page_size,omitempty"`
+}
+
+// queryBuilder translates entity queries to SQL, on the table of a model
+type queryBuilder struct {
+	stmt *gorm.Statement
+	// columns maps the JSON names of the fields to their columns
+	columns map[string]string
+}
+
+func newQueryBuilder(tx *gorm.DB, model interface{}) (*queryBuilder, error) {
+	stmt := &gorm.Statement{DB: tx}
+	if err := stmt.Parse(model); err != nil {
+		return nil, eris.Wrapf(err, "failed to parse %s model", lcEntityType(model))
+	}
+
+	columns := make(map[string]string, len(stmt.Schema.Fields))
+	for _, field := range stmt.Schema.Fields {
+		name := jsonFieldName(field)
+		if field.DBName == "" || !field.Readable || name == "" {
+			continue
+		}
+		columns[name] = field.DBName
+	}
+
+	return &queryBuilder{stmt: stmt, columns: columns}, nil
+}
+
+// jsonFieldName returns the JSON name of a model field, empty if not serialized
+func jsonFieldName(field *schema.Field) string {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+func (b *queryBuilder) column(field string) (string, error) {
+	column, ok := b.columns[field]
+	if !ok {
+		return "", eris.Wrapf(ErrInvalidQuery, "unknown field '%s'", field)
+	}
+	return b.stmt.Quote(column), nil
+}
+
+// filter returns the SQL condition of a filter along with its arguments
+func (b *queryBuilder) filter(filter *Filter) (string, []interface{}, error) {
+	if len(filter.And) > 0 || len(filter.Or) > 0 {
+		if filter.Field != "" || (len(filter.And) > 0 && len(filter.Or) > 0) {
+			return "", nil, eris.Wrap(ErrInvalidQuery, "filters must either compare a field or combine other filters")
+		}
+		if len(filter.And) > 0 {
+			return b.group(filter.And, " AND ")
+		}
+		return b.group(filter.Or, " OR ")
+	}
+
+	column, err := b.column(filter.Field)
+	if err != nil {
+		return "", nil, err
+	}
+
+	op := filter.Op
+	if op == "" {
+		op = ValueOpEq
+	}
+
+	if filter.Value == nil {
+		switch op {
+		case ValueOpEq:
+			return column + " IS NULL", nil, nil
+		case ValueOpNe:
+			return column + " IS NOT NULL", nil, nil
+		default:
+			return "", nil, eris.Wrapf(ErrInvalidQuery, "operator '%s' doesn't allow null values", op)
+		}
+	}
+
+	switch op {
+	case ValueOpIn:
+		values := reflect.ValueOf(filter.Value)
+		if values.Kind() != reflect.Slice || values.Len() == 0 {
+			return "", nil, eris.Wrap(ErrInvalidQuery, "operator 'in' requires a non empty array of values")
+		}
+		return column + " IN ?", []interface{}{filter.Value}, nil
+	case ValueOpLike:
+		return column + " LIKE ?", []interface{}{filter.Value}, nil
+	}
+
+	sqlOp, ok := valueOpsSQL[op]
+	if !ok {
+		return "", nil, eris.Wrapf(ErrInvalidValueOp, "operator: '%s'", op)
+	}
+	if kind := reflect.ValueOf(filter.Value).Kind(); kind == reflect.Slice || kind == reflect.Map {
+		return "", nil, eris.Wrapf(ErrInvalidQuery, "operator '%s' requires a scalar value", op)
+	}
+
+	return column + " " + sqlOp + " ?", []interface{}{filter.Value}, nil
+}
+
+// group returns the SQL condition of filters combined by the given operator
+func (b *queryBuilder) group(filters []Filter, op string) (string, []interface{}, error) {
+	conditions := make([]string, len(filters))
+	var args []interface{}
+
+	for i := range filters {
+		condition, conditionArgs, err := b.filter(&filters[i])
+		if err != nil {
+			return "", nil, err
+		}
+		conditions[i] = condition
+		args = append(args, conditionArgs...)
+	}
+
+	return "(" + strings.Join(conditions, op) + ")", args, nil
+}
+
+// order returns the SQL sorting of the query results.
+// Primary keys are always appended, for a stable pagination.
+func (b *queryBuilder) order(sort []string) (string, error) {
+	clauses := make([]string, 0, len(sort)+len(b.stmt.Schema.PrimaryFields))
+
+	for _, field := range sort {
+		direction := " ASC"
+		if strings.HasPrefix(field, "-") {
+			field = field[1:]
+			direction = " DESC"
+		}
+		column, err := b.column(field)
+		if err != nil {
+			return "", err
+		}
+		clauses = append(clauses, column+direction)
+	}
+
+	for _, field := range b.stmt.Schema.PrimaryFields {
+		clauses = append(clauses, b.stmt.Quote(field.DBName))
+	}
+
+	return strings.Join(clauses, ", "), nil
+}
+
+// selection returns the columns of the projected fields
+func (b *queryBuilder) selection(fields []string) ([]string, error) {
+	columns := make([]string, len(fields))
+	for i, field := range fields {
+		column, ok := b.columns[field]
+		if !ok {
+			return nil, eris.Wrapf(ErrInvalidQuery, "unknown field '%s'", field)
+		}
+		columns[i] = column
+	}
+	return columns, nil
+}
+
+// queryTx applies the filter, sorting and pagination of a query to a transaction
+func queryTx(tx *gorm.DB, model interface{}, query *EntityQuery) (*gorm.DB, *queryBuilder, error) {
+	builder, err := newQueryBuilder(tx, model)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tx = tx.Model(model)
+
+	if query.Filter != nil {
+		condition, args, err := builder.filter(query.Filter)
+		if err != nil {
+			return nil, nil, err
+		}
+		tx = tx.Where(condition, args...)
+	}
+
+	order, err := builder.order(query.Sort)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tx, err = db.Paginate(tx.Order(order), query.Page, query.PageSize)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return tx, builder, nil
+}
+
+// QueryEntities runs a query on the entities of the given model.
+// The results are a slice of entities, or of maps of the projected fields if any.
+func QueryEntities(model interface{}, query *EntityQuery) (interface{}, error) {
+	tx, builder, err := queryTx(db.DB(), model, query)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(query.Fields) > 0 {
+		columns, err := builder.selection(query.Fields)
+		if err != nil {
+			return nil, err
+		}
+		results := make([]map[string]interface{}, 0)
+		err = tx.Select(columns).Find(&results).Error
+		if err != nil {
+			return nil, eris.Wrapf(err, "failed to query %ss", lcEntityType(model))
+		}
+		return results, nil
+	}
+
+	results := reflect.New(reflect.SliceOf(reflect.TypeOf(model).Elem()))
+	results.Elem().Set(reflect.MakeSlice(results.Elem().Type(), 0, 0))
+	err = tx.Find(results.Interface()).Error
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to query %ss", lcEntityType(model))
+	}
+
+	return results.Elem().Interface(), nil
+}
+
+// QueryItems runs a query on items
+func QueryItems(query *EntityQuery) (interface{}, error) {
+	return QueryEntities(&models.Item{}, query)
+}
+
+// QueryAttributes runs a query on attributes
+func QueryAttributes(query *EntityQuery) (interface{}, error) {
+	return QueryEntities(&models.Attribute{}, query)
+}
+
+// QueryRelations runs a query on relations
+func QueryRelations(query *EntityQuery) (interface{}, error) {
+	return QueryEntities(&models.Relation{}, query)
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"testing"
+
+	"devais.it/kronos/internal/pkg/db"
+	"devais.it/kronos/internal/pkg/db/models"
+	"github.com/stretchr/testify/suite"
+	"gopkg.in/guregu/null.v4"
+)
+
+const mbQuery = "QUERY_TEST"
+
+type QuerySuite struct {
+	db.SuiteBase
+}
+
+func (s *QuerySuite) createItems() []models.Item {
+	assert := s.Require()
+
+	items := []models.Item{
+		{ID: "item-a", Name: "Alpha", Type: "sensor"},
+		{ID: "item-b", Name: "Beta", Type: "sensor", CustomerID: null.StringFrom("customer")},
+		{ID: "item-c", Name: "Gamma", Type: "gateway"},
+	}
+	assert.NoError(BatchCreateItems(items, mbQuery))
+
+	return items
+}
+
+func (s *QuerySuite) parseQuery(data string) *EntityQuery {
+	query := &EntityQuery{}
+	s.Require().NoError(json.Unmarshal([]byte(data), query))
+	return query
+}
+
+func (s *QuerySuite) queryItemIDs(data string) []string {
+	assert := s.Require()
+
+	results, err := QueryItems(s.parseQuery(data))
+	assert.NoError(err)
+
+	items, ok := results.([]models.Item)
+	assert.True(ok)
+
+	ids := make([]string, len(items))
+	for i, item := range items {
+		ids[i] = item.ID
+	}
+	return ids
+}
+
+func (s *QuerySuite) TestFilter() {
+	assert := s.Require()
+
+	s.createItems()
+
+	assert.Equal([]string{"item-a", "item-b"}, s.queryItemIDs(`{"filter": {"field": "type", "value": "sensor"}}`))
+	assert.Equal([]string{"item-c"}, s.queryItemIDs(`{"filter": {"field": "type", "op": "ne", "value": "sensor"}}`))
+	assert.Equal([]string{"item-a", "item-c"}, s.queryItemIDs(`{"filter": {"field": "name", "op": "in", "value": ["Alpha", "Gamma"]}}`))
+	assert.Equal([]string{"item-c"}, s.queryItemIDs(`{"filter": {"field": "name", "op": "like", "value": "%mm%"}}`))
+	assert.Equal([]string{"item-b"}, s.queryItemIDs(`{"filter": {"field": "customer_id", "op": "ne", "value": null}}`))
+	assert.Equal([]string{"item-b", "item-c"}, s.queryItemIDs(`{"filter": {"field": "name", "op": "gt", "value": "Alpha"}}`))
+
+	assert.Equal([]string{"item-a", "item-c"}, s.queryItemIDs(`{"filter": {"or": [
+		{"field": "type", "value": "gateway"},
+		{"and": [
+			{"field": "type", "value": "sensor"},
+			{"field": "customer_id", "value": null}
+		]}
+	]}}`))
+
+	// Meta fields can be filtered too
+	assert.Len(s.queryItemIDs(`{"filter": {"and": [
+		{"field": "created_by", "value": "`+mbQuery+`"},
+		{"field": "modified_at", "op": "gte", "value": 1},
+		{"field": "sync_policy", "value": null}
+	]}}`), 3)
+
+	invalid := []string{
+		`{"filter": {"field": "unknown", "value": "sensor"}}`,
+		`{"filter": {"field": "changed_fields", "value": "name"}}`,
+		`{"filter": {"field": "type", "op": "in", "value": "sensor"}}`,
+		`{"filter": {"field": "type", "op": "gt", "value": null}}`,
+		`{"filter": {"field": "type", "op": "lt", "value": ["sensor"]}}`,
+		`{"filter": {"field": "type", "and": [{"field": "name", "value": "Alpha"}]}}`,
+		`{"sort": ["unknown"]}`,
+		`{"fields": ["attributes"]}`,
+	}
+	for _, data := range invalid {
+		_, err := QueryItems(s.parseQuery(data))
+		assert.ErrorIs(err, ErrInvalidQuery, data)
+	}
+
+	_, err := QueryItems(s.parseQuery(`{"filter": {"field": "type", "op": "between", "value": "sensor"}}`))
+	assert.ErrorIs(err, ErrInvalidValueOp)
+}
+
+func (s *QuerySuite) TestSortAndPaginate() {
+	assert := s.Require()
+
+	s.createItems()
+
+	assert.Equal([]string{"item-c", "item-b", "item-a"}, s.queryItemIDs(`{"sort": ["-name"]}`))
+	assert.Equal([]string{"item-c", "item-b", "item-a"}, s.queryItemIDs(`{"sort": ["type", "-id"]}`))
+	assert.Equal([]string{"item-b"}, s.queryItemIDs(`{"sort": ["name"], "page": 2, "page_size": 1}`))
+	assert.Empty(s.queryItemIDs(`{"filter": {"field": "type", "value": "actuator"}}`))
+}
+
+func (s *QuerySuite) TestProjection() {
+	assert := s.Require()
+
+	items := s.createItems()
+
+	item := items[0]
+	attribute := newAttribute(item.ID)
+	attribute.Value = "42"
+	assert.NoError(CreateAttribute(attribute, mbQuery))
+	assert.NoError(CreateRelation(&models.Relation{ParentID: items[2].ID, ChildID: item.ID}, mbQuery))
+
+	results, err := QueryItems(s.parseQuery(`{"fields": ["id", "name"], "sort": ["name"], "page_size": 1}`))
+	assert.NoError(err)
+	assert.Equal([]map[string]interface{}{{"id": "item-a", "name": "Alpha"}}, results)
+
+	results, err = QueryAttributes(s.parseQuery(`{"filter": {"field": "item_id", "value": "item-a"}, "fields": ["value"]}`))
+	assert.NoError(err)
+	assert.Equal([]map[string]interface{}{{"value": "42"}}, results)
+
+	results, err = QueryRelations(s.parseQuery(`{"filter": {"field": "child_id", "value": "item-a"}}`))
+	assert.NoError(err)
+	relations, ok := results.([]models.Relation)
+	assert.True(ok)
+	assert.Len(relations, 1)
+	assert.Equal(items[2].ID, relations[0].ParentID)
+}
+
+func TestQueryService(t *testing.T) {
+	suite.Run(t, new(QuerySuite))
+}
@@ -1,9 +1,11 @@
 package services
 
 import (
+	"devais.it/kronos/internal/pkg/constants"
 	"devais.it/kronos/internal/pkg/db"
 	"devais.it/kronos/internal/pkg/db/models"
 	"devais.it/kronos/internal/pkg/types"
+	"devais.it/kronos/internal/pkg/util"
 	"github.com/google/uuid"
 	"github.com/rotisserie/eris"
 	"gopkg.in/guregu/null.v4"
@@ -82,16 +84,26 @@ func GetAllRelations(page, pageSize int) ([]models.Relation, error) {
 	return relations, nil
 }
 
-func GetRelation(parentID, childID string) (*models.Relation, error) {
+func GetAllRelationsPage(cursor string, pageSize int) ([]models.Relation, *db.Page, error) {
+	var relations []models.Relation
+	page, err := GetAllPage(&relations, cursor, pageSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	return relations, page, nil
+}
+
+func GetRelation(parentID, childID, relationType string) (*models.Relation, error) {
 	relation := &models.Relation{}
 
 	tx := db.DB().
-		Where("parent_id = ? AND child_id = ?", parentID, childID).
+		Scopes(byRelationKey(parentID, childID, relationType)).
 		First(relation)
 	if tx.Error != nil {
 		return nil, eris.Wrapf(
 			tx.Error,
-			"failed to get relation between parent '%s' and child '%s'",
+			"failed to get relation '%s' between parent '%s' and child '%s'",
+			relationType,
 			parentID,
 			childID,
 		)
@@ -100,15 +112,138 @@ func GetRelation(parentID, childID string) (*models.Relation, error) {
 	return relation, nil
 }
 
-func HardDeleteRelation(parentID, childID, modifiedBy string) error {
+// GetRelationsByType returns the relations with the given type,
+// the empty one for untyped relations
+func GetRelationsByType(relationType string, page, pageSize int) ([]models.Relation, error) {
+	var relations []models.Relation
+	err := GetByType(relationType, &relations, page, pageSize)
+	if err != nil {
+		return nil, err
+	}
+	return relations, nil
+}
+
+func GetRelationsByTypePage(relationType, cursor string, pageSize int) ([]models.Relation, *db.Page, error) {
+	var relations []models.Relation
+	page, err := GetByTypePage(relationType, &relations, cursor, pageSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	return relations, page, nil
+}
+
+func GetRelationsByTypeCount(relationType string) (count int64, err error) {
+	tx := db.DB().
+		Model(&models.Relation{}).
+		Where("type = ?", relationType).
+		Count(&count)
+	if tx.Error != nil {
+		err = eris.Wrapf(tx.Error, "failed to get relations count with type '%s'", relationType)
+	}
+	return
+}
+
+// byRelationKey returns the scope selecting a relation by parent and child IDs and type
+func byRelationKey(parentID, childID, relationType string) func(tx *gorm.DB) *gorm.DB {
+	return func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("parent_id = ? AND child_id = ? AND type = ?", parentID, childID, relationType)
+	}
+}
+
+// relationUpdates returns the updated columns of a relation patch,
+// without the key fields and with the properties in their stored format
+func relationUpdates(patch map[string]interface{}) (map[string]interface{}, error) {
+	updates := make(map[string]interface{})
+	for key, value := range patch {
+		switch key {
+		case constants.ParentIDField, constants.ChildIDField, constants.RelationTypeField:
+			continue
+		case constants.PropertiesField:
+			var properties models.RelationProperties
+			if err := util.JSONToStruct(value, &properties); err != nil {
+				return nil, eris.Wrap(err, "failed to unmarshal relation properties")
+			}
+			updates[key] = properties
+		default:
+			updates[key] = value
+		}
+	}
+	return updates, nil
+}
+
+// UpdateRelationTx updates the relation identified by the parent_id, child_id
+// and type fields of the patch, which can't be changed: use MoveItemTx to change the parent.
+// The version is computed again and an update event is published.
+func UpdateRelationTx(ctx *db.TxContext, patch map[string]interface{}, modifiedBy string) error {
+	relation := &models.Relation{}
+	err := util.JSONToStruct(patch, relation)
+	if err != nil {
+		return eris.Wrap(err, "failed to unmarshal relation patch")
+	}
+	if relation.ParentID == "" || relation.ChildID == "" {
+		return db.ErrMissingID
+	}
+
+	if m := patch[constants.ModifiedByField]; m == nil || m == "" {
+		patch[constants.ModifiedByField] = modifiedBy
+	}
+
+	updates, err := relationUpdates(patch)
+	if err != nil {
+		return err
+	}
+
+	scope := byRelationKey(relation.ParentID, relation.ChildID, relation.Type)
+
+	tx := ctx.Tx.
+		Model(&models.Relation{}).
+		Scopes(scope).
+		Updates(updates)
+	if tx.Error != nil {
+		return eris.Wrap(tx.Error, "update failed")
+	}
+	if tx.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return publishUpdateTx(ctx, &models.Relation{}, relation.CompositeID(), scope, patch, modifiedBy)
+}
+
+func UpdateRelation(patch map[string]interface{}, modifiedBy string) error {
+	err := db.DB().Transaction(func(tx *gorm.DB) error {
+		return UpdateRelationTx(&db.TxContext{Tx: tx}, patch, modifiedBy)
+	})
+
+	if err != nil {
+		return eris.Wrap(err, "failed to update relation")
+	}
+
+	return nil
+}
+
+func UpsertRelationTx(ctx *db.TxContext, patch map[string]interface{}, modifiedBy string) error {
+	err := UpdateRelationTx(ctx, patch, modifiedBy)
+	if eris.Is(err, gorm.ErrRecordNotFound) {
+		relation := models.Relation{}
+		err = util.JSONToStruct(patch, &relation)
+		if err != nil {
+			return eris.Wrapf(err, "failed to unmarshal relation")
+		}
+		err = BatchCreateRelationsTx(ctx, []models.Relation{relation}, modifiedBy)
+	}
+
+	return err
+}
+
+func HardDeleteRelation(parentID, childID, relationType, modifiedBy string) error {
 	if parentID == "" || childID == "" {
 		return db.ErrMissingID
 	}
-	rel := &models.Relation{ParentID: parentID, ChildID: childID}
+	rel := &models.Relation{ParentID: parentID, ChildID: childID, Type: relationType}
 	err := db.GetHardDeleteTx(db.DB()).Transaction(func(tx *gorm.DB) error {
 		ctx := &db.TxContext{Tx: tx}
 		tx = tx.
-			Where("parent_id = ? AND child_id = ?", parentID, childID).
+			Scopes(byRelationKey(parentID, childID, relationType)).
 			Delete(&models.Relation{})
 		if tx.Error != nil {
 			return tx.Error
@@ -129,7 +264,8 @@ func HardDeleteRelation(parentID, childID, modifiedBy string) error {
 	if err != nil {
 		return eris.Wrapf(
 			err,
-			"failed to delete relation with parent '%s' and child '%s'",
+			"failed to delete relation '%s' with parent '%s' and child '%s'",
+			relationType,
 			parentID,
 			childID,
 		)
@@ -138,12 +274,12 @@ func HardDeleteRelation(parentID, childID, modifiedBy string) error {
 	return nil
 }
 
-func DeleteRelationTx(ctx *db.TxContext, parentID, childID, modifiedBy string) error {
+func DeleteRelationTx(ctx *db.TxContext, parentID, childID, relationType, modifiedBy string) error {
 	if parentID == "" || childID == "" {
 		return db.ErrMissingID
 	}
 	tx := ctx.Tx.
-		Where("parent_id = ? AND child_id = ?", parentID, childID).
+		Scopes(byRelationKey(parentID, childID, relationType)).
 		Delete(&models.Relation{})
 	if tx.Error != nil {
 		return tx.Error
@@ -151,7 +287,7 @@ func DeleteRelationTx(ctx *db.TxContext, parentID, childID, modifiedBy string) e
 	if tx.RowsAffected == 0 {
 		return gorm.ErrRecordNotFound
 	}
-	rel := &models.Relation{ParentID: parentID, ChildID: childID}
+	rel := &models.Relation{ParentID: parentID, ChildID: childID, Type: relationType}
 	return PublishEvent(
 		ctx,
 		types.EventEntityDeleted,
@@ -162,15 +298,16 @@ func DeleteRelationTx(ctx *db.TxContext, parentID, childID, modifiedBy string) e
 	)
 }
 
-func DeleteRelation(parentID, childID, modifiedBy string) error {
+func DeleteRelation(parentID, childID, relationType, modifiedBy string) error {
 	err := db.DB().Transaction(func(tx *gorm.DB) error {
-		return DeleteRelationTx(&db.TxContext{Tx: tx}, parentID, childID, modifiedBy)
+		return DeleteRelationTx(&db.TxContext{Tx: tx}, parentID, childID, relationType, modifiedBy)
 	})
 
 	if err != nil {
 		return eris.Wrapf(
 			err,
-			"failed to delete relation with parent '%s' and child '%s'",
+			"failed to delete relation '%s' with parent '%s' and child '%s'",
+			relationType,
 			parentID,
 			childID,
 		)
@@ -179,21 +316,59 @@ func DeleteRelation(parentID, childID, modifiedBy string) error {
 	return nil
 }
 
-func MoveItem(parentID, childID, newParentID, modifiedBy string) error {
-	tx := db.DB().Exec(
-		"UPDATE "+models.RelationsTableName+" "+
-			"SET parent_id = ?, modified_by = ? "+
-			"WHERE parent_id = ? AND child_id = ?",
-		newParentID,
-		modifiedBy,
-		parentID,
-		childID,
-	)
-	if tx.Error != nil {
-		return eris.Wrapf(tx.Error, "failed to move item from '%s' to '%s'", parentID, newParentID)
+// MoveItemTx moves an item from a parent to another one.
+// Since parent and child IDs identify a relation, moving an item deletes
+// the relation with the old parent and creates a new one with the new parent,
+// publishing the corresponding events. The new relation keeps the type, properties,
+// sort index and sync policy of the old one, while its version is computed again.
+func MoveItemTx(ctx *db.TxContext, parentID, childID, relationType, newParentID, modifiedBy string) error {
+	if parentID == "" || childID == "" || newParentID == "" {
+		return db.ErrMissingID
 	}
-	if tx.RowsAffected == 0 {
-		return gorm.ErrRecordNotFound
+
+	relation := &models.Relation{}
+	err := ctx.Tx.
+		Scopes(byRelationKey(parentID, childID, relationType)).
+		First(relation).
+		Error
+	if err != nil {
+		return err
+	}
+
+	err = DeleteRelationTx(ctx, parentID, childID, relationType, modifiedBy)
+	if err != nil {
+		return err
+	}
+
+	moved := models.Relation{
+		ParentID:   newParentID,
+		ChildID:    childID,
+		Type:       relationType,
+		Properties: relation.Properties,
+		SortIndex:  relation.SortIndex,
+	}
+	moved.SyncPolicy = relation.SyncPolicy
+
+	return BatchCreateRelationsTx(ctx, []models.Relation{moved}, modifiedBy)
+}
+
+func MoveItem(parentID, childID, relationType, newParentID, modifiedBy string) error {
+	err := db.DB().Transaction(func(tx *gorm.DB) error {
+		ctx := &db.TxContext{
+			Tx:     tx,
+			TxUUID: uuid.NewString(),
+			TxLen:  2,
+		}
+		err := MoveItemTx(ctx, parentID, childID, relationType, newParentID, modifiedBy)
+		if err != nil {
+			return err
+		}
+		// The deletion of a never published relation publishes no event
+		return SealTxEventsTx(ctx)
+	})
+
+	if err != nil {
+		return eris.Wrapf(err, "failed to move item '%s' from '%s' to '%s'", childID, parentID, newParentID)
 	}
 
 	return nil
@@ -207,12 +382,114 @@ func GetRelationsCount() (count int64, err error) {
 	return
 }
 
-func GetRelationSyncPolicy(parentID, childID string) (null.String, error) {
-	relation := &models.Relation{ParentID: parentID, ChildID: childID}
+// GetRelationsByItem returns relations where the given item is either
+// the parent or the child
+func GetRelationsByItem(itemID string, page, pageSize int) ([]models.Relation, error) {
+	tx, err := db.Paginate(db.DB(), page, pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	var relations []models.Relation
+
+	tx = tx.Where("parent_id = ? OR child_id = ?", itemID, itemID).Find(&relations)
+	if tx.Error != nil {
+		return nil, eris.Wrapf(tx.Error, "failed to get relations of item '%s'", itemID)
+	}
+
+	return relations, nil
+}
+
+func GetRelationsByItemCount(itemID string) (count int64, err error) {
+	tx := db.DB().
+		Model(&models.Relation{}).
+		Where("parent_id = ? OR child_id = ?", itemID, itemID).
+		Count(&count)
+	if tx.Error != nil {
+		err = eris.Wrapf(tx.Error, "failed to get relations count of item '%s'", itemID)
+	}
+	return
+}
+
+// GetItemSubtreeRelations returns relations between an item and all its descendants
+func GetItemSubtreeRelations(itemID string, page, pageSize int) ([]models.Relation, error) {
+	tx, err := db.Paginate(db.DB(), page, pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	var relations []models.Relation
+
+	tx = tx.Where("parent_id IN ("+itemSubtreeIDsQuery+")", itemID).Find(&relations)
+	if tx.Error != nil {
+		return nil, eris.Wrapf(tx.Error, "failed to get relations of subtree of item '%s'", itemID)
+	}
+
+	return relations, nil
+}
+
+func GetItemSubtreeRelationsCount(itemID string) (count int64, err error) {
+	tx := db.DB().
+		Model(&models.Relation{}).
+		Where("parent_id IN ("+itemSubtreeIDsQuery+")", itemID).
+		Count(&count)
+	if tx.Error != nil {
+		err = eris.Wrapf(tx.Error, "failed to get relations count of subtree of item '%s'", itemID)
+	}
+	return
+}
+
+func GetRelationVersion(parentID, childID, relationType string) (string, error) {
+	if parentID == "" || childID == "" {
+		return "", db.ErrMissingID
+	}
+
+	var version string
+
+	tx := db.DB().
+		Model(&models.Relation{}).
+		Select("version").
+		Scopes(byRelationKey(parentID, childID, relationType)).
+		First(&version)
+	if tx.Error != nil {
+		return "", eris.Wrapf(
+			tx.Error,
+			"failed to get version of relation '%s' between parent '%s' and child '%s'",
+			relationType,
+			parentID,
+			childID,
+		)
+	}
+
+	return version, nil
+}
+
+// GetRelationsVersion returns the versions of all relations,
+// identified by their composite ID
+func GetRelationsVersion(page, pageSize int) ([]models.EntityVersion, error) {
+	versions, err := GetAllVersions(models.RelationsTableName, page, pageSize)
+	if err != nil {
+		return nil, eris.Wrap(err, "failed to get relations version")
+	}
+	return versions, nil
+}
+
+// GetRelationsVersionPage returns a page of the versions of all relations,
+// paginated by cursor
+func GetRelationsVersionPage(cursor string, pageSize int) ([]models.EntityVersion, *db.Page, error) {
+	versions, page, err := GetAllVersionsPage(models.RelationsTableName, cursor, pageSize)
+	if err != nil {
+		return nil, nil, eris.Wrap(err, "failed to get relations version")
+	}
+	return versions, page, nil
+}
+
+func GetRelationSyncPolicy(parentID, childID, relationType string) (null.String, error) {
+	relation := &models.Relation{ParentID: parentID, ChildID: childID, Type: relationType}
 	tx := db.DB().
 		Model(relation).
 		Select("sync_policy").
-		Where("parent_id = ? AND child_id = ?", parentID, childID).
+		Scopes(byRelationKey(parentID, childID, relationType)).
 		First(relation)
 	if tx.Error != nil {
 		return null.String{}, eris.Wrapf(
@@ -1,13 +1,17 @@
 package services
 
 import (
+	"sort"
 	"testing"
 
+	"devais.it/kronos/internal/pkg/constants"
 	"devais.it/kronos/internal/pkg/db"
 	"devais.it/kronos/internal/pkg/db/models"
+	"devais.it/kronos/internal/pkg/types"
 	"devais.it/kronos/internal/pkg/util"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/suite"
+	"gopkg.in/guregu/null.v4"
 	"gorm.io/gorm"
 )
 
@@ -64,7 +68,7 @@ func (s *RelationsSuite) TestGet() {
 	child := newItem()
 	rel := newRelation(parent.ID, child.ID)
 
-	_, err := GetRelation(parent.ID, child.ID)
+	_, err := GetRelation(parent.ID, child.ID, "")
 	assert.ErrorIs(err, gorm.ErrRecordNotFound)
 
 	assert.NoError(CreateItem(parent, mbRelation))
@@ -74,7 +78,7 @@ func (s *RelationsSuite) TestGet() {
 
 	s.assertCount(1)
 
-	createdRel, err := GetRelation(parent.ID, child.ID)
+	createdRel, err := GetRelation(parent.ID, child.ID, "")
 	assert.NoError(err)
 	assert.NotNil(createdRel)
 	assert.Equal(parent.ID, createdRel.ParentID)
@@ -205,7 +209,7 @@ func (s *RelationsSuite) TestBatchCreate() {
 	for _, relation := range relations {
 		s.assertContains(relations, relation.ParentID, relation.ChildID)
 
-		createdRelation, err := GetRelation(relation.ParentID, relation.ChildID)
+		createdRelation, err := GetRelation(relation.ParentID, relation.ChildID, "")
 		assert.NoError(err)
 		assert.GreaterOrEqual(createdRelation.CreatedAt, start)
 		assert.GreaterOrEqual(createdRelation.ModifiedAt, start)
@@ -240,11 +244,11 @@ func (s *RelationsSuite) TestMove() {
 	s.assertContainsItem(parents, itemA.ID)
 
 	// Test move error
-	assert.ErrorIs(MoveItem(itemA.ID, uuid.NewString(), itemC.ID, mbRelation), gorm.ErrRecordNotFound)
-	assert.Error(MoveItem(itemA.ID, itemB.ID, uuid.NewString(), mbRelation))
+	assert.ErrorIs(MoveItem(itemA.ID, uuid.NewString(), "", itemC.ID, mbRelation), gorm.ErrRecordNotFound)
+	assert.Error(MoveItem(itemA.ID, itemB.ID, "", uuid.NewString(), mbRelation))
 
 	// Move B from A to C
-	assert.NoError(MoveItem(itemA.ID, itemB.ID, itemC.ID, mbRelation))
+	assert.NoError(MoveItem(itemA.ID, itemB.ID, "", itemC.ID, mbRelation))
 
 	children, err = GetItemChildren(itemA.ID)
 	assert.NoError(err)
@@ -260,12 +264,247 @@ func (s *RelationsSuite) TestMove() {
 	assert.Len(parents, 1)
 	s.assertContainsItem(parents, itemC.ID)
 
-	relation, err := GetRelation(itemC.ID, itemB.ID)
+	relation, err := GetRelation(itemC.ID, itemB.ID, "")
 	assert.NoError(err)
 	assert.Equal(mbRelation, relation.CreatedBy)
 	assert.Equal(mbRelation, relation.ModifiedBy)
 }
 
+func (s *RelationsSuite) TestMoveEvents() {
+	assert := s.Require()
+
+	itemA := newItem()
+	itemB := newItem()
+	itemC := newItem()
+	assert.NoError(BatchCreateItems([]models.Item{*itemA, *itemB, *itemC}, mbRelation))
+
+	old := newRelation(itemA.ID, itemB.ID)
+	old.SyncPolicy = null.StringFrom(constants.SyncPolicyDontSync)
+	assert.NoError(CreateRelation(old, mbRelation))
+
+	// Simulate an already synchronized relation
+	_, err := PurgeEntityEvents(types.EntityTypeRelation, old.CompositeID())
+	assert.NoError(err)
+
+	assert.NoError(MoveItem(itemA.ID, itemB.ID, "", itemC.ID, mbRelation))
+
+	events, err := GetEvents(&EventsFilter{EntityType: types.EntityTypeRelation}, 1, 10)
+	assert.NoError(err)
+	assert.Len(events, 2)
+
+	moved, err := GetRelation(itemC.ID, itemB.ID, "")
+	assert.NoError(err)
+	assert.NotEmpty(moved.Version)
+	assert.Equal(old.SyncPolicy, moved.SyncPolicy)
+
+	assert.Equal(types.EventEntityDeleted, events[0].EventType)
+	assert.Equal(old.CompositeID(), events[0].EntityID)
+	assert.Equal(types.EventEntityCreated, events[1].EventType)
+	assert.Equal(moved.CompositeID(), events[1].EntityID)
+
+	for i, event := range events {
+		assert.NotEmpty(event.TxUUID)
+		assert.Equal(events[0].TxUUID, event.TxUUID)
+		assert.Equal(2, event.TxLen)
+		assert.Equal(i, event.TxIndex)
+	}
+}
+
+func (s *RelationsSuite) TestMoveUnpublished() {
+	assert := s.Require()
+
+	itemA := newItem()
+	itemB := newItem()
+	itemC := newItem()
+	assert.NoError(BatchCreateItems([]models.Item{*itemA, *itemB, *itemC}, mbRelation))
+	assert.NoError(CreateRelation(newRelation(itemA.ID, itemB.ID), mbRelation))
+
+	// The queued creation is dropped along with the relation
+	assert.NoError(MoveItem(itemA.ID, itemB.ID, "", itemC.ID, mbRelation))
+
+	events, err := GetEvents(&EventsFilter{EntityType: types.EntityTypeRelation}, 1, 10)
+	assert.NoError(err)
+	assert.Len(events, 1)
+
+	moved, err := GetRelation(itemC.ID, itemB.ID, "")
+	assert.NoError(err)
+	assert.Equal(types.EventEntityCreated, events[0].EventType)
+	assert.Equal(moved.CompositeID(), events[0].EntityID)
+	assert.NotEmpty(events[0].TxUUID)
+	assert.Equal(1, events[0].TxLen)
+	assert.Equal(0, events[0].TxIndex)
+}
+
+func (s *RelationsSuite) TestUpdate() {
+	assert := s.Require()
+
+	parent := newItem()
+	child := newItem()
+	assert.NoError(BatchCreateItems([]models.Item{*parent, *child}, mbRelation))
+
+	relation := newRelation(parent.ID, child.ID)
+	assert.NoError(CreateRelation(relation, mbRelation))
+	created, err := GetRelation(parent.ID, child.ID, "")
+	assert.NoError(err)
+
+	_, err = PurgeEntityEvents(types.EntityTypeRelation, relation.CompositeID())
+	assert.NoError(err)
+
+	assert.ErrorIs(UpdateRelation(map[string]interface{}{"parent_id": parent.ID}, mbRelation), db.ErrMissingID)
+	assert.ErrorIs(UpdateRelation(map[string]interface{}{
+		"parent_id": parent.ID,
+		"child_id":  uuid.NewString(),
+	}, mbRelation), gorm.ErrRecordNotFound)
+
+	assert.NoError(UpdateRelation(map[string]interface{}{
+		"parent_id":   parent.ID,
+		"child_id":    child.ID,
+		"sync_policy": constants.SyncPolicyDontSync,
+	}, mbRelation))
+
+	updated, err := GetRelation(parent.ID, child.ID, "")
+	assert.NoError(err)
+	assert.Equal(constants.SyncPolicyDontSync, updated.SyncPolicy.ValueOrZero())
+	assert.Equal("sync_policy", updated.ChangedFields.ValueOrZero())
+	assert.NotEqual(created.Version, updated.Version)
+
+	events, err := GetEvents(&EventsFilter{EntityType: types.EntityTypeRelation}, 1, 10)
+	assert.NoError(err)
+	assert.Len(events, 1)
+	assert.Equal(types.EventEntityUpdated, events[0].EventType)
+	assert.Equal(relation.CompositeID(), events[0].EntityID)
+	assert.Contains(events[0].Body, updated.Version)
+}
+
+func (s *RelationsSuite) TestCompositeID() {
+	assert := s.Require()
+
+	untyped := newRelation("Parent-ID", "Child-ID")
+	assert.Equal("Parent-ID->Child-ID", untyped.CompositeID())
+
+	typed := &models.Relation{ParentID: "Parent-ID", ChildID: "Child-ID", Type: "powered_by"}
+	assert.Equal("Parent-ID-[powered_by]->Child-ID", typed.CompositeID())
+
+	for _, relation := range []*models.Relation{untyped, typed} {
+		parsed := &models.Relation{}
+		assert.NoError(parsed.SetCompositeID(relation.CompositeID()))
+		assert.Equal(relation.ParentID, parsed.ParentID)
+		assert.Equal(relation.ChildID, parsed.ChildID)
+		assert.Equal(relation.Type, parsed.Type)
+	}
+
+	assert.Error((&models.Relation{}).SetCompositeID("Parent-ID"))
+	assert.ErrorIs(models.ValidateRelationType("a->b"), models.ErrInvalidRelationType)
+	assert.ErrorIs(models.ValidateRelationType("a[b]"), models.ErrInvalidRelationType)
+	assert.NoError(models.ValidateRelationType("monitored-by"))
+}
+
+func (s *RelationsSuite) TestTyped() {
+	assert := s.Require()
+
+	sensor := newItem()
+	room := newItem()
+	meter := newItem()
+	plug := newItem()
+	assert.NoError(BatchCreateItems([]models.Item{*sensor, *room, *meter, *plug}, mbRelation))
+
+	relations := []models.Relation{
+		*newRelation(room.ID, sensor.ID),
+		{ParentID: room.ID, ChildID: sensor.ID, Type: "located_in", SortIndex: 2},
+		{ParentID: room.ID, ChildID: meter.ID, Type: "located_in", SortIndex: 1},
+		{
+			ParentID:   plug.ID,
+			ChildID:    sensor.ID,
+			Type:       "powered_by",
+			Properties: models.RelationProperties{"voltage": 5.0},
+		},
+	}
+	assert.NoError(BatchCreateRelations(relations, mbRelation))
+	s.assertCount(4)
+
+	invalid := &models.Relation{ParentID: room.ID, ChildID: plug.ID, Type: "in[valid]"}
+	assert.ErrorIs(CreateRelation(invalid, mbRelation), models.ErrInvalidRelationType)
+
+	untyped, err := GetRelation(room.ID, sensor.ID, "")
+	assert.NoError(err)
+	typed, err := GetRelation(room.ID, sensor.ID, "located_in")
+	assert.NoError(err)
+	assert.Equal(2, typed.SortIndex)
+	assert.NotEqual(untyped.Version, typed.Version)
+
+	powered, err := GetRelation(plug.ID, sensor.ID, "powered_by")
+	assert.NoError(err)
+	assert.Equal(5.0, powered.Properties["voltage"])
+
+	located, err := GetRelationsByType("located_in", 1, 10)
+	assert.NoError(err)
+	assert.Len(located, 2)
+	count, err := GetRelationsByTypeCount("located_in")
+	assert.NoError(err)
+	assert.Equal(int64(2), count)
+	_, err = GetRelationsByType("monitored_by", 1, 10)
+	assert.ErrorIs(err, gorm.ErrRecordNotFound)
+
+	// Children are ordered by sort index
+	children, err := GetItemChildrenByType(room.ID, "located_in")
+	assert.NoError(err)
+	assert.Len(children, 2)
+	assert.Equal(meter.ID, children[0].ID)
+	assert.Equal(sensor.ID, children[1].ID)
+
+	children, err = GetItemChildren(room.ID)
+	assert.NoError(err)
+	assert.Len(children, 2)
+
+	parents, err := GetItemParentsByType(sensor.ID, "powered_by")
+	assert.NoError(err)
+	assert.Len(parents, 1)
+	assert.Equal(plug.ID, parents[0].ID)
+
+	itemRelations, err := GetItemRelationsByType(sensor.ID, "powered_by")
+	assert.NoError(err)
+	assert.Len(itemRelations, 1)
+
+	// Updates change properties and sort index, not the type
+	assert.NoError(UpdateRelation(map[string]interface{}{
+		"parent_id":  plug.ID,
+		"child_id":   sensor.ID,
+		"type":       "powered_by",
+		"properties": map[string]interface{}{"voltage": 12},
+		"sort_index": 3,
+	}, mbRelation))
+	updated, err := GetRelation(plug.ID, sensor.ID, "powered_by")
+	assert.NoError(err)
+	assert.Equal(12.0, updated.Properties["voltage"])
+	assert.Equal(3, updated.SortIndex)
+	assert.Equal("properties,sort_index", updated.ChangedFields.ValueOrZero())
+
+	events, err := GetEvents(&EventsFilter{EntityType: types.EntityTypeRelation}, 1, 10)
+	assert.NoError(err)
+	assert.Equal(powered.CompositeID(), events[len(events)-1].EntityID)
+
+	// Moving a typed relation keeps its type and properties
+	assert.NoError(MoveItem(plug.ID, sensor.ID, "powered_by", meter.ID, mbRelation))
+	moved, err := GetRelation(meter.ID, sensor.ID, "powered_by")
+	assert.NoError(err)
+	assert.Equal(12.0, moved.Properties["voltage"])
+
+	// Deleting a typed relation keeps the other relations between the same items
+	assert.NoError(DeleteRelation(room.ID, sensor.ID, "located_in", mbRelation))
+	_, err = GetRelation(room.ID, sensor.ID, "")
+	assert.NoError(err)
+	s.assertCount(3)
+
+	versions, err := GetRelationsVersion(1, 10)
+	assert.NoError(err)
+	ids := make([]string, len(versions))
+	for i, version := range versions {
+		ids[i] = version.ID
+	}
+	assert.Contains(ids, moved.CompositeID())
+	assert.Contains(ids, untyped.CompositeID())
+}
+
 func (s *RelationsSuite) TestDelete() {
 	assert := s.Require()
 
@@ -274,11 +513,11 @@ func (s *RelationsSuite) TestDelete() {
 	parent := newItem()
 	child := newItem()
 
-	assert.ErrorIs(DeleteRelation("", "", mbRelation), db.ErrMissingID)
-	assert.ErrorIs(HardDeleteRelation("", "", mbRelation), db.ErrMissingID)
+	assert.ErrorIs(DeleteRelation("", "", "", mbRelation), db.ErrMissingID)
+	assert.ErrorIs(HardDeleteRelation("", "", "", mbRelation), db.ErrMissingID)
 
-	assert.ErrorIs(DeleteRelation(parent.ID, child.ID, mbRelation), gorm.ErrRecordNotFound)
-	assert.ErrorIs(HardDeleteRelation(parent.ID, child.ID, mbRelation), gorm.ErrRecordNotFound)
+	assert.ErrorIs(DeleteRelation(parent.ID, child.ID, "", mbRelation), gorm.ErrRecordNotFound)
+	assert.ErrorIs(HardDeleteRelation(parent.ID, child.ID, "", mbRelation), gorm.ErrRecordNotFound)
 
 	assert.NoError(CreateItem(parent, mbRelation))
 	assert.NoError(CreateItem(child, mbRelation))
@@ -289,14 +528,14 @@ func (s *RelationsSuite) TestDelete() {
 	assert.NoError(CreateRelation(relation, mbRelation))
 	s.assertCount(1)
 
-	assert.NoError(DeleteRelation(parent.ID, child.ID, mbRelation))
+	assert.NoError(DeleteRelation(parent.ID, child.ID, "", mbRelation))
 	s.assertCount(0)
 
 	// Test hard delete
 	assert.NoError(CreateRelation(relation, mbRelation))
 	s.assertCount(1)
 
-	assert.NoError(HardDeleteRelation(parent.ID, child.ID, mbRelation))
+	assert.NoError(HardDeleteRelation(parent.ID, child.ID, "", mbRelation))
 	s.assertCount(0)
 
 	// Test delete parent cascade
@@ -314,6 +553,179 @@ func (s *RelationsSuite) TestDelete() {
 	s.assertCount(0)
 }
 
+func (s *RelationsSuite) TestSubtree() {
+	assert := s.Require()
+
+	// root -> child -> grandchild, plus an unrelated item
+	root := newItem()
+	child := newItem()
+	grandchild := newItem()
+	other := newItem()
+
+	for _, item := range []*models.Item{root, child, grandchild, other} {
+		assert.NoError(CreateItem(item, mbRelation))
+		assert.NoError(CreateAttribute(newAttribute(item.ID), mbRelation))
+	}
+
+	assert.NoError(CreateRelation(newRelation(root.ID, child.ID), mbRelation))
+	assert.NoError(CreateRelation(newRelation(child.ID, grandchild.ID), mbRelation))
+	assert.NoError(CreateRelation(newRelation(other.ID, root.ID), mbRelation))
+
+	_, err := GetItemSubtree("ThisItemDoesNotExist", 0, 0)
+	assert.ErrorIs(err, gorm.ErrRecordNotFound)
+
+	count, err := GetItemSubtreeCount(root.ID)
+	assert.NoError(err)
+	assert.Equal(int64(3), count)
+
+	items, err := GetItemSubtree(root.ID, 0, 0)
+	assert.NoError(err)
+	assert.Len(items, 3)
+	s.assertContainsItem(items, root.ID)
+	s.assertContainsItem(items, child.ID)
+	s.assertContainsItem(items, grandchild.ID)
+
+	items, err = GetItemSubtree(root.ID, 2, 2)
+	assert.NoError(err)
+	assert.Len(items, 1)
+
+	count, err = GetItemSubtreeAttributesCount(root.ID)
+	assert.NoError(err)
+	assert.Equal(int64(3), count)
+
+	attributes, err := GetItemSubtreeAttributes(child.ID, 0, 0)
+	assert.NoError(err)
+	assert.Len(attributes, 2)
+
+	count, err = GetItemSubtreeRelationsCount(root.ID)
+	assert.NoError(err)
+	assert.Equal(int64(2), count)
+
+	relations, err := GetItemSubtreeRelations(root.ID, 0, 0)
+	assert.NoError(err)
+	s.assertContains(relations, root.ID, child.ID)
+	s.assertContains(relations, child.ID, grandchild.ID)
+
+	// Cycles must not cause infinite recursion
+	assert.NoError(CreateRelation(newRelation(grandchild.ID, root.ID), mbRelation))
+
+	count, err = GetItemSubtreeCount(child.ID)
+	assert.NoError(err)
+	assert.Equal(int64(3), count)
+
+	count, err = GetRelationsByItemCount(root.ID)
+	assert.NoError(err)
+	assert.Equal(int64(3), count)
+
+	relations, err = GetRelationsByItem(root.ID, 1, 2)
+	assert.NoError(err)
+	assert.Len(relations, 2)
+}
+
+func (s *RelationsSuite) TestVersion() {
+	assert := s.Require()
+
+	parent := newItem()
+	child := newItem()
+
+	assert.NoError(CreateItem(parent, mbRelation))
+	assert.NoError(CreateItem(child, mbRelation))
+
+	_, err := GetRelationVersion(parent.ID, child.ID, "")
+	assert.ErrorIs(err, gorm.ErrRecordNotFound)
+
+	_, err = GetRelationVersion("", child.ID, "")
+	assert.ErrorIs(err, db.ErrMissingID)
+
+	assert.NoError(CreateRelation(newRelation(parent.ID, child.ID), mbRelation))
+
+	relation, err := GetRelation(parent.ID, child.ID, "")
+	assert.NoError(err)
+
+	version, err := GetRelationVersion(parent.ID, child.ID, "")
+	assert.NoError(err)
+	assert.NotEmpty(version)
+	assert.Equal(relation.Version, version)
+
+	// All versions
+	otherChild := newItem()
+	assert.NoError(CreateItem(otherChild, mbRelation))
+	assert.NoError(CreateRelation(newRelation(parent.ID, otherChild.ID), mbRelation))
+
+	versions, err := GetRelationsVersion(1, 1)
+	assert.NoError(err)
+	assert.Len(versions, 1)
+
+	versions, err = GetRelationsVersion(1, 10)
+	assert.NoError(err)
+	assert.Len(versions, 2)
+
+	versionsMap := make(map[string]models.EntityVersion, len(versions))
+	for _, v := range versions {
+		versionsMap[v.ID] = v
+	}
+
+	assert.Contains(versionsMap, relation.CompositeID())
+	assert.Equal(relation.Version, versionsMap[relation.CompositeID()].Version)
+	assert.Equal(mbRelation, versionsMap[relation.CompositeID()].ModifiedBy)
+
+	// Streamed versions
+	var streamed []string
+	err = ForEachVersion(models.RelationsTableName, func(v *models.EntityVersion) error {
+		assert.Equal(versionsMap[v.ID].Version, v.Version)
+		streamed = append(streamed, v.ID)
+		return nil
+	})
+	assert.NoError(err)
+	assert.Len(streamed, 2)
+	assert.True(sort.StringsAreSorted(streamed))
+}
+
+func (s *RelationsSuite) TestPages() {
+	assert := s.Require()
+
+	items := []models.Item{*newItem(), *newItem(), *newItem()}
+	assert.NoError(BatchCreateItems(items, mbRelation))
+	relations := []models.Relation{
+		*newRelation(items[0].ID, items[1].ID),
+		*newRelation(items[0].ID, items[2].ID),
+		*newRelation(items[1].ID, items[2].ID),
+	}
+	assert.NoError(BatchCreateRelations(relations, mbRelation))
+
+	page, info, err := GetAllRelationsPage("", 2)
+	assert.NoError(err)
+	assert.Len(page, 2)
+	assert.Equal(int64(3), *info.Total)
+	assert.NotEmpty(info.NextCursor)
+
+	rest, info, err := GetAllRelationsPage(info.NextCursor, 2)
+	assert.NoError(err)
+	assert.Len(rest, 1)
+	assert.Empty(info.NextCursor)
+
+	ids := map[string]struct{}{}
+	for _, relation := range append(page, rest...) {
+		ids[relation.CompositeID()] = struct{}{}
+	}
+	assert.Len(ids, 3)
+
+	versions, info, err := GetRelationsVersionPage("", 2)
+	assert.NoError(err)
+	assert.Len(versions, 2)
+	assert.Equal(page[0].CompositeID(), versions[0].ID)
+	assert.Equal(page[1].CompositeID(), versions[1].ID)
+	assert.NotEmpty(info.NextCursor)
+
+	versions, _, err = GetRelationsVersionPage(info.NextCursor, 2)
+	assert.NoError(err)
+	assert.Len(versions, 1)
+	assert.Equal(rest[0].CompositeID(), versions[0].ID)
+
+	_, _, err = GetAllRelationsPage(db.EncodeCursor("not a composite ID"), 2)
+	assert.ErrorIs(err, db.ErrInvalidPagination)
+}
+
 func TestRelationsService(t *testing.T) {
 	suite.Run(t, new(RelationsSuite))
 }
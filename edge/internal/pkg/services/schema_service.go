@@ -0,0 +1,203 @@
+package services
+
+import (
+	"sync"
+
+	"devais.it/kronos/internal/pkg/constants"
+	"devais.it/kronos/internal/pkg/db"
+	"devais.it/kronos/internal/pkg/db/models"
+	"devais.it/kronos/internal/pkg/schema"
+	"devais.it/kronos/internal/pkg/util"
+	"github.com/rotisserie/eris"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+const schemaRegistryRowID = 1
+
+var (
+	schemaRegistryMu sync.RWMutex
+	schemaRegistry   *schema.Registry
+)
+
+// SchemaRegistry returns the active schema registry, nil if validation is disabled
+func SchemaRegistry() *schema.Registry {
+	schemaRegistryMu.RLock()
+	defer schemaRegistryMu.RUnlock()
+	return schemaRegistry
+}
+
+// SetSchemaRegistry activates a compiled schema registry.
+// A nil registry disables validation.
+func SetSchemaRegistry(registry *schema.Registry) {
+	schemaRegistryMu.Lock()
+	defer schemaRegistryMu.Unlock()
+	schemaRegistry = registry
+}
+
+// LoadSchemaRegistry activates the registry received from the server application,
+// if any, otherwise the one found at the configured file
+func LoadSchemaRegistry() error {
+	conf := &db.Config().Schema
+
+	stored := &models.SchemaRegistry{}
+	err := db.DB().First(stored, "id = ?", schemaRegistryRowID).Error
+	if err != nil && !eris.Is(err, gorm.ErrRecordNotFound) {
+		return eris.Wrap(err, "failed to get stored schema registry")
+	}
+
+	if err == nil && conf.SyncEnabled {
+		registry, err := schema.Parse([]byte(stored.Content))
+		if err != nil {
+			return eris.Wrap(err, "invalid stored schema registry")
+		}
+		SetSchemaRegistry(registry)
+		log.Infof("Schema registry loaded from database. Version: '%s'", registry.Version)
+		return nil
+	}
+
+	if conf.File == "" {
+		SetSchemaRegistry(nil)
+		return nil
+	}
+
+	registry, err := schema.Load(conf.File)
+	if err != nil {
+		return err
+	}
+	SetSchemaRegistry(registry)
+	log.Infof("Schema registry loaded from '%s'. Version: '%s'", conf.File, registry.Version)
+
+	return nil
+}
+
+// SaveSchemaRegistry stores and activates a registry received from the server application.
+// An empty content removes the stored registry, restoring the configured one.
+func SaveSchemaRegistry(content []byte) error {
+	if !db.Config().Schema.SyncEnabled {
+		return eris.New("Schema registry synchronization is disabled")
+	}
+
+	if len(content) == 0 {
+		err := db.DB().Delete(&models.SchemaRegistry{}, schemaRegistryRowID).Error
+		if err != nil {
+			return eris.Wrap(err, "failed to delete stored schema registry")
+		}
+		return LoadSchemaRegistry()
+	}
+
+	registry, err := schema.Parse(content)
+	if err != nil {
+		return err
+	}
+
+	err = db.DB().Save(&models.SchemaRegistry{
+		ID:        schemaRegistryRowID,
+		Version:   registry.Version,
+		Content:   string(content),
+		UpdatedAt: util.TimestampMs(),
+	}).Error
+	if err != nil {
+		return eris.Wrap(err, "failed to store schema registry")
+	}
+
+	SetSchemaRegistry(registry)
+
+	return nil
+}
+
+// getItemTypeTx returns the type of an item
+func getItemTypeTx(tx *gorm.DB, itemID string) (string, error) {
+	var itemType string
+	err := tx.
+		Model(&models.Item{}).
+		Select("type").
+		First(&itemType, "id = ?", itemID).
+		Error
+	if err != nil {
+		return "", eris.Wrapf(err, "failed to get type of item '%s'", itemID)
+	}
+	return itemType, nil
+}
+
+// validateItem validates an item to be created along with its attributes.
+// Required attributes aren't checked for items created by the server application,
+// since their attributes are synchronized as separate entities.
+func validateItem(registry *schema.Registry, item *models.Item, modifiedBy string) error {
+	checkRequired := modifiedBy != constants.ModifiedBySyncName
+	return registry.ValidateItem(item, item.Attributes, checkRequired)
+}
+
+// validateAttributeTx validates an attribute against the type of its item.
+// Attributes of missing items are left to foreign key checks.
+func validateAttributeTx(tx *gorm.DB, registry *schema.Registry, attribute *models.Attribute) error {
+	if registry == nil {
+		return nil
+	}
+	itemType, err := getItemTypeTx(tx, attribute.ItemID)
+	if eris.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return registry.ValidateAttribute(itemType, attribute)
+}
+
+// validateStoredItemTx validates an updated item along with all its attributes
+func validateStoredItemTx(tx *gorm.DB, registry *schema.Registry, itemID string) error {
+	if registry == nil {
+		return nil
+	}
+	item := &models.Item{}
+	if err := tx.First(item, "id = ?", itemID).Error; err != nil {
+		return eris.Wrapf(err, "failed to get item '%s'", itemID)
+	}
+	var attributes []models.Attribute
+	if err := tx.Find(&attributes, "item_id = ?", itemID).Error; err != nil {
+		return eris.Wrapf(err, "failed to get attributes of item '%s'", itemID)
+	}
+	return registry.ValidateItem(item, attributes, false)
+}
+
+// validateStoredAttributeTx validates an updated attribute
+func validateStoredAttributeTx(tx *gorm.DB, registry *schema.Registry, attributeID string) error {
+	if registry == nil {
+		return nil
+	}
+	attribute := &models.Attribute{}
+	if err := tx.First(attribute, "id = ?", attributeID).Error; err != nil {
+		return eris.Wrapf(err, "failed to get attribute '%s'", attributeID)
+	}
+	return validateAttributeTx(tx, registry, attribute)
+}
+
+// checkRequiredAttributeTx prevents the deletion of required attributes through local APIs.
+// The server application is allowed to delete them, along with their items.
+func checkRequiredAttributeTx(tx *gorm.DB, registry *schema.Registry, attributeID, modifiedBy string) error {
+	if registry == nil || modifiedBy == constants.ModifiedBySyncName {
+		return nil
+	}
+	attribute := &models.Attribute{}
+	err := tx.Select("name, item_id").First(attribute, "id = ?", attributeID).Error
+	if eris.Is(err, gorm.ErrRecordNotFound) {
+		// Reported by the delete itself
+		return nil
+	}
+	if err != nil {
+		return eris.Wrapf(err, "failed to get attribute '%s'", attributeID)
+	}
+	itemType, err := getItemTypeTx(tx, attribute.ItemID)
+	if err != nil {
+		return err
+	}
+	if registry.IsRequiredAttribute(itemType, attribute.Name) {
+		return eris.Wrapf(
+			schema.ErrValidation,
+			"Attribute '%s' is required on items of type '%s'",
+			attribute.Name,
+			itemType,
+		)
+	}
+	return nil
+}
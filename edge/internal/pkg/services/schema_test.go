@@ -0,0 +1,179 @@
+package services
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"devais.it/kronos/internal/pkg/constants"
+	"devais.it/kronos/internal/pkg/db"
+	"devais.it/kronos/internal/pkg/db/models"
+	"devais.it/kronos/internal/pkg/schema"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/suite"
+)
+
+const (
+	mbSchema = "SCHEMA_TEST"
+
+	testSchemaRegistry = `{
+		"version": "1",
+		"value_types": {
+			"temperature": {"base": "float", "min": -40, "max": 125}
+		},
+		"item_types": {
+			"sensor": {
+				"attributes": {
+					"temperature": {"value_type": "temperature", "required": true}
+				},
+				"additional_attributes": true
+			}
+		}
+	}`
+)
+
+type SchemaSuite struct {
+	db.SuiteBase
+}
+
+func (s *SchemaSuite) SetupTest() {
+	s.SuiteBase.SetupTest()
+
+	registry, err := schema.Parse([]byte(testSchemaRegistry))
+	s.Require().NoError(err)
+	SetSchemaRegistry(registry)
+}
+
+func (s *SchemaSuite) TearDownTest() {
+	SetSchemaRegistry(nil)
+}
+
+func newSensor(temperature string) *models.Item {
+	item := newItem()
+	item.Type = "sensor"
+	item.Attributes = []models.Attribute{{
+		ID:    uuid.NewString(),
+		Name:  "temperature",
+		Type:  "measure",
+		Value: temperature,
+	}}
+	return item
+}
+
+func (s *SchemaSuite) TestCreate() {
+	assert := s.Require()
+
+	assert.ErrorIs(CreateItem(newSensor("200"), mbSchema), schema.ErrValidation)
+
+	sensor := newSensor("20")
+	sensor.Attributes = nil
+	assert.ErrorIs(CreateItem(sensor, mbSchema), schema.ErrValidation)
+
+	// Attributes of items created by the server are synchronized separately
+	assert.NoError(CreateItem(sensor, constants.ModifiedBySyncName))
+
+	sensor = newSensor("20")
+	assert.NoError(CreateItem(sensor, mbSchema))
+
+	attribute := newAttribute(sensor.ID)
+	attribute.ValueType = "int"
+	attribute.Value = "abc"
+	assert.ErrorIs(CreateAttribute(attribute, mbSchema), schema.ErrValidation)
+
+	attribute.Value = "42"
+	assert.NoError(CreateAttribute(attribute, mbSchema))
+
+	count, err := GetAttributesCount()
+	assert.NoError(err)
+	assert.Equal(int64(2), count)
+}
+
+func (s *SchemaSuite) TestUpdate() {
+	assert := s.Require()
+
+	sensor := newSensor("20")
+	assert.NoError(CreateItem(sensor, mbSchema))
+	attrID := sensor.Attributes[0].ID
+
+	err := UpdateAttribute(map[string]interface{}{
+		"id":    attrID,
+		"value": "hot",
+	}, constants.ModifiedBySyncName)
+	assert.ErrorIs(err, schema.ErrValidation)
+
+	err = UpdateAttribute(map[string]interface{}{
+		"id":    attrID,
+		"value": "21.5",
+	}, mbSchema)
+	assert.NoError(err)
+
+	value, err := GetAttributeValue(attrID)
+	assert.NoError(err)
+	assert.Equal("21.5", value.Value)
+
+	// Changing the item type validates existing attributes
+	SchemaRegistry().Strict = true
+	err = UpdateItem(map[string]interface{}{
+		"id":   sensor.ID,
+		"type": "actuator",
+	}, mbSchema)
+	assert.ErrorIs(err, schema.ErrValidation)
+
+	item, err := GetItemByID(sensor.ID)
+	assert.NoError(err)
+	assert.Equal("sensor", item.Type)
+}
+
+func (s *SchemaSuite) TestDeleteRequired() {
+	assert := s.Require()
+
+	sensor := newSensor("20")
+	assert.NoError(CreateItem(sensor, mbSchema))
+	attrID := sensor.Attributes[0].ID
+
+	assert.ErrorIs(DeleteAttributeByID(attrID, mbSchema), schema.ErrValidation)
+	assert.NoError(DeleteAttributeByID(attrID, constants.ModifiedBySyncName))
+}
+
+func (s *SchemaSuite) TestSaveAndLoad() {
+	assert := s.Require()
+
+	conf := &db.Config().Schema
+	defaultConf := *conf
+	defer func() {
+		*conf = defaultConf
+	}()
+
+	path := filepath.Join(s.T().TempDir(), "schema.json")
+	assert.NoError(ioutil.WriteFile(path, []byte(`{"version": "file"}`), 0644))
+	conf.File = path
+
+	assert.NoError(LoadSchemaRegistry())
+	assert.Equal("file", SchemaRegistry().Version)
+
+	// Registries received from the server take precedence
+	assert.Error(SaveSchemaRegistry([]byte(`{"value_types": {"a": {"base": "complex"}}}`)))
+	assert.Equal("file", SchemaRegistry().Version)
+
+	assert.NoError(SaveSchemaRegistry([]byte(testSchemaRegistry)))
+	assert.Equal("1", SchemaRegistry().Version)
+
+	SetSchemaRegistry(nil)
+	assert.NoError(LoadSchemaRegistry())
+	assert.Equal("1", SchemaRegistry().Version)
+
+	// Removing the received registry restores the configured one
+	assert.NoError(SaveSchemaRegistry(nil))
+	assert.Equal("file", SchemaRegistry().Version)
+
+	conf.File = ""
+	assert.NoError(LoadSchemaRegistry())
+	assert.Nil(SchemaRegistry())
+
+	conf.SyncEnabled = false
+	assert.Error(SaveSchemaRegistry([]byte(testSchemaRegistry)))
+}
+
+func TestSchemaService(t *testing.T) {
+	suite.Run(t, new(SchemaSuite))
+}
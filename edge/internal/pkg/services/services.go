@@ -8,7 +8,10 @@ import (
 	"github.com/google/uuid"
 	jsoniter "github.com/json-iterator/go"
 	"github.com/rotisserie/eris"
+	"gopkg.in/guregu/null.v4"
 	"gorm.io/gorm"
+	"reflect"
+	"sort"
 	"strings"
 )
 
@@ -120,6 +123,77 @@ func FindByType(typeStr string, model interface{}, page, pageSize int) error {
 	)
 }
 
+// relationCursorKey pages relations by the columns of their primary key,
+// in the order of its index
+var relationCursorKey = db.CursorKey{
+	Columns: []string{"parent_id", "child_id", "type"},
+	Split: func(key string) ([]interface{}, error) {
+		relation := &models.Relation{}
+		if err := relation.SetCompositeID(key); err != nil {
+			return nil, err
+		}
+		return []interface{}{relation.ParentID, relation.ChildID, relation.Type}, nil
+	},
+}
+
+// cursorKey returns the key ordering the pages of entities paginated by cursor
+func cursorKey(dest interface{}) db.CursorKey {
+	if _, ok := dest.(*[]models.Relation); ok {
+		return relationCursorKey
+	}
+	return db.IDCursorKey
+}
+
+// GetAllPage gets a page of entities paginated by cursor
+func GetAllPage(dest interface{}, cursor string, pageSize int) (*db.Page, error) {
+	page, err := db.FindPage(db.DB(), dest, cursorKey(dest), cursor, pageSize)
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to get all %ss", lcEntityType(dest))
+	}
+	return page, nil
+}
+
+// GetByTypePage gets a page of entities of the given type paginated by cursor
+func GetByTypePage(typeStr string, dest interface{}, cursor string, pageSize int) (*db.Page, error) {
+	tx := db.DB().Where("type = ?", typeStr)
+	page, err := db.FindPage(tx, dest, cursorKey(dest), cursor, pageSize)
+	if err != nil {
+		return nil, eris.Wrapf(
+			err,
+			"failed to get %ss by type %s",
+			lcEntityType(dest),
+			typeStr,
+		)
+	}
+	if page.Total != nil && *page.Total == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return page, nil
+}
+
+func findByQueryPage(value, field string, dest interface{}, cursor string, pageSize int) (*db.Page, error) {
+	tx := db.DB().Where(field+" LIKE ?", "%"+value+"%")
+	page, err := db.FindPage(tx, dest, cursorKey(dest), cursor, pageSize)
+	if err != nil {
+		return nil, eris.Wrapf(
+			err,
+			"failed to find %ss with %s %s",
+			lcEntityType(dest),
+			field,
+			value,
+		)
+	}
+	return page, nil
+}
+
+func FindByNamePage(name string, dest interface{}, cursor string, pageSize int) (*db.Page, error) {
+	return findByQueryPage(name, "name", dest, cursor, pageSize)
+}
+
+func FindByTypePage(typeStr string, dest interface{}, cursor string, pageSize int) (*db.Page, error) {
+	return findByQueryPage(typeStr, "type", dest, cursor, pageSize)
+}
+
 func GetByIDs(ids []string, models interface{}) error {
 	if len(ids) == 0 {
 		return db.ErrMissingID
@@ -149,13 +223,48 @@ func UpdateTx(ctx *db.TxContext, model interface{}, patch map[string]interface{}
 
 	id := patch["id"].(string)
 
+	return publishUpdateTx(ctx, model, id, byID(id), patch, modifiedBy)
+}
+
+// byID returns the scope selecting an entity by ID
+func byID(id string) func(tx *gorm.DB) *gorm.DB {
+	return func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("id = ?", id)
+	}
+}
+
+// publishUpdateTx tracks the changes of an updated entity, selected by scope,
+// and publishes the update event with the patch and the new version
+func publishUpdateTx(
+	ctx *db.TxContext,
+	model interface{},
+	id string,
+	scope func(tx *gorm.DB) *gorm.DB,
+	patch map[string]interface{},
+	modifiedBy string) error {
+	var err error
+
+	if modifiedBy == constants.ModifiedBySyncName {
+		err = ctx.Tx.
+			Model(model).
+			Scopes(scope).
+			UpdateColumn(constants.ChangedFieldsField, nil).
+			Error
+	} else {
+		err = trackLocalChangesTx(ctx.Tx, model, id, scope, patch)
+	}
+	if err != nil {
+		return err
+	}
+
 	// Fetch version
 	var version string
 
 	err = ctx.Tx.
 		Model(model).
 		Select("version").
-		First(&version, "id = ?", id).
+		Scopes(scope).
+		First(&version).
 		Error
 	if err != nil {
 		return err
@@ -174,6 +283,138 @@ func UpdateTx(ctx *db.TxContext, model interface{}, patch map[string]interface{}
 	)
 }
 
+// trackLocalChangesTx refreshes the version of an entity edited locally
+// and records the fields changed since the last synchronization.
+// A version different from the sync version marks the entity as locally modified.
+func trackLocalChangesTx(
+	tx *gorm.DB,
+	model interface{},
+	id string,
+	scope func(tx *gorm.DB) *gorm.DB,
+	patch map[string]interface{}) error {
+	entity := reflect.New(reflect.TypeOf(model).Elem()).Interface()
+
+	err := tx.Scopes(scope).First(entity).Error
+	if err != nil {
+		return eris.Wrapf(err, "failed to get %s '%s'", lcEntityType(model), id)
+	}
+
+	syncModel, err := models.GetEntitySyncModel(entity)
+	if err != nil {
+		return err
+	}
+
+	// The type of relations is part of their key
+	_, isRelation := model.(*models.Relation)
+
+	changedFields := SplitChangedFields(syncModel.ChangedFields)
+	for field := range patch {
+		if isUntrackedField(field) || (isRelation && field == constants.RelationTypeField) {
+			continue
+		}
+		changedFields[field] = struct{}{}
+	}
+
+	err = syncModel.RefreshVersion(entity)
+	if err != nil {
+		return eris.Wrap(err, "failed to refresh version")
+	}
+
+	return tx.
+		Model(model).
+		Scopes(scope).
+		UpdateColumns(map[string]interface{}{
+			"version":                    syncModel.Version,
+			constants.ChangedFieldsField: joinChangedFields(changedFields),
+		}).
+		Error
+}
+
+// RefreshMergedVersionTx refreshes the version of an entity whose local edits
+// were merged with server changes, so that it matches the merged content.
+// The locally changed fields are tracked again, since they still differ from
+// the server ones, and an update event with the new version is published.
+func RefreshMergedVersionTx(ctx *db.TxContext, model interface{}, id string, changedFields null.String) error {
+	scope := byID(id)
+	if _, ok := model.(*models.Relation); ok {
+		relation := &models.Relation{}
+		if err := relation.SetCompositeID(id); err != nil {
+			return err
+		}
+		scope = byRelationKey(relation.ParentID, relation.ChildID, relation.Type)
+	}
+
+	patch := make(map[string]interface{})
+	for field := range SplitChangedFields(changedFields) {
+		patch[field] = nil
+	}
+
+	err := trackLocalChangesTx(ctx.Tx, model, id, scope, patch)
+	if err != nil {
+		return err
+	}
+
+	var version string
+	err = ctx.Tx.
+		Model(model).
+		Select("version").
+		Scopes(scope).
+		First(&version).
+		Error
+	if err != nil {
+		return err
+	}
+
+	return PublishEvent(
+		ctx,
+		types.EventEntityUpdated,
+		models.GetEntityType(model),
+		id,
+		constants.ModifiedBySyncName,
+		map[string]interface{}{"version": version},
+	)
+}
+
+// isUntrackedField returns true if changes to a field
+// shouldn't be tracked as local edits
+func isUntrackedField(field string) bool {
+	if field == constants.IDField ||
+		field == constants.ParentIDField ||
+		field == constants.ChildIDField ||
+		field == constants.ModifiedByField ||
+		field == constants.AttributesField {
+		return true
+	}
+	for _, metaField := range constants.MetaFields {
+		if field == metaField {
+			return true
+		}
+	}
+	return false
+}
+
+// SplitChangedFields returns the set of fields edited locally
+// since the last synchronization
+func SplitChangedFields(changedFields null.String) map[string]struct{} {
+	fields := make(map[string]struct{})
+	if changedFields.ValueOrZero() == "" {
+		return fields
+	}
+	for _, field := range strings.Split(changedFields.String, ",") {
+		fields[field] = struct{}{}
+	}
+	return fields
+}
+
+func joinChangedFields(fields map[string]struct{}) string {
+	sorted := make([]string, 0, len(fields))
+	for field := range fields {
+		sorted = append(sorted, field)
+	}
+	sort.Strings(sorted)
+	return strings.Join(sorted, ",")
+}
+
 func Update(model interface{}, patch map[string]interface{}, modifiedBy string) error {
 	return db.DB().Transaction(func(tx *gorm.DB) error {
 		return UpdateTx(&db.TxContext{Tx: tx}, model, patch, modifiedBy)
@@ -247,15 +488,63 @@ func GetAllVersions(modelTableName string, page, pageSize int) ([]models.EntityV
 	if err != nil {
 		return nil, eris.Wrap(err, "failed to paginate versions")
 	}
-	err = tx.
-		Raw("SELECT id, version, sync_version, modified_at, modified_by FROM " + modelTableName).
-		Find(&versions).Error
+	err = versionsQuery(tx, modelTableName).Find(&versions).Error
 	if err != nil {
 		return nil, err
 	}
 	return versions, nil
 }
 
+// GetAllVersionsPage gets a page of the versions of the entities
+// in the given table, paginated by cursor
+func GetAllVersionsPage(modelTableName, cursor string, pageSize int) ([]models.EntityVersion, *db.Page, error) {
+	var versions []models.EntityVersion
+	key := db.IDCursorKey
+	if modelTableName == models.RelationsTableName {
+		key = relationCursorKey
+	}
+	page, err := db.FindPage(versionsQuery(db.DB(), modelTableName), &versions, key, cursor, pageSize)
+	if err != nil {
+		return nil, nil, eris.Wrap(err, "failed to paginate versions")
+	}
+	return versions, page, nil
+}
+
+// ForEachVersion calls fn for the version of every entity in the given table,
+// ordered by ID.
+// Versions are streamed from the database, so the whole table is never
+// loaded in memory.
+func ForEachVersion(modelTableName string, fn func(version *models.EntityVersion) error) error {
+	tx := versionsQuery(db.DB(), modelTableName)
+	rows, err := tx.Order("id").Rows()
+	if err != nil {
+		return eris.Wrapf(err, "failed to query versions of '%s'", modelTableName)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		version := &models.EntityVersion{}
+		if err := tx.ScanRows(rows, version); err != nil {
+			return eris.Wrapf(err, "failed to scan versions of '%s'", modelTableName)
+		}
+		if err := fn(version); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func versionsQuery(tx *gorm.DB, modelTableName string) *gorm.DB {
+	// Relations don't have an ID column, use their composite ID instead
+	idColumn := "id"
+	if modelTableName == models.RelationsTableName {
+		idColumn = models.RelationCompositeIDColumn
+	}
+	return tx.
+		Table(modelTableName).
+		Select(idColumn + " AS id, version, sync_version, modified_at, modified_by")
+}
+
 //=============================================================================
 // Batch functions
 //=============================================================================
@@ -266,17 +555,24 @@ func BatchCreateAllTx(
 	attributes []models.Attribute,
 	relations []models.Relation,
 	modifiedBy string) error {
-	err := BatchCreateItemsTx(ctx, items, modifiedBy)
-	if err != nil {
-		return err
+	// Empty slices are skipped, to allow creation of a single kind of entity
+	if len(items) > 0 {
+		err := BatchCreateItemsTx(ctx, items, modifiedBy)
+		if err != nil {
+			return err
+		}
 	}
-	err = BatchCreateAttributesTx(ctx, attributes, modifiedBy)
-	if err != nil {
-		return err
+	if len(attributes) > 0 {
+		err := BatchCreateAttributesTx(ctx, attributes, modifiedBy)
+		if err != nil {
+			return err
+		}
 	}
-	err = BatchCreateRelationsTx(ctx, relations, modifiedBy)
-	if err != nil {
-		return err
+	if len(relations) > 0 {
+		err := BatchCreateRelationsTx(ctx, relations, modifiedBy)
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -303,10 +599,12 @@ func BatchUpdateAllTx(
 		}
 	}
 
-	/*
-		for _, relationPatch := range relations {
+	for _, relationPatch := range relations {
+		err := UpdateRelationTx(ctx, relationPatch, modifiedBy)
+		if err != nil {
+			return err
 		}
-	*/
+	}
 
 	return nil
 }
@@ -324,7 +622,7 @@ func BatchDeleteAllTx(
 		if err != nil {
 			return err
 		}
-		err = DeleteRelationTx(ctx, relation.ParentID, relation.ChildID, modifiedBy)
+		err = DeleteRelationTx(ctx, relation.ParentID, relation.ChildID, relation.Type, modifiedBy)
 		if err != nil {
 			return err
 		}
@@ -379,6 +677,12 @@ func BatchDeleteAll(
 			TxUUID: uuid.NewString(),
 			TxLen:  len(items) + len(attributes) + len(relations),
 		}
-		return BatchDeleteAllTx(ctx, items, attributes, relations, modifiedBy)
+		err := BatchDeleteAllTx(ctx, items, attributes, relations, modifiedBy)
+		if err != nil {
+			return err
+		}
+		// Deleted items take their relations and attributes with them,
+		// while deletions of entities never published publish nothing
+		return SealTxEventsTx(ctx)
 	})
 }
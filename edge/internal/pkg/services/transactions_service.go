@@ -0,0 +1,287 @@
+package services
+
+import (
+	"fmt"
+
+	"devais.it/kronos/internal/pkg/constants"
+	"devais.it/kronos/internal/pkg/db"
+	"devais.it/kronos/internal/pkg/db/models"
+	"devais.it/kronos/internal/pkg/types"
+	"devais.it/kronos/internal/pkg/util"
+	"github.com/google/uuid"
+	"github.com/rotisserie/eris"
+	"gorm.io/gorm"
+)
+
+// TxAction is the action of a transaction operation
+type TxAction string
+
+const (
+	TxActionCreate TxAction = "CREATE"
+	TxActionUpdate TxAction = "UPDATE"
+	TxActionUpsert TxAction = "UPSERT"
+	TxActionDelete TxAction = "DELETE"
+)
+
+// TxOperationStatus is the outcome of a transaction operation
+type TxOperationStatus string
+
+const (
+	// TxOperationApplied means that the operation was applied and committed
+	TxOperationApplied TxOperationStatus = "APPLIED"
+	// TxOperationFailed means that the operation failed
+	TxOperationFailed TxOperationStatus = "FAILED"
+	// TxOperationRolledBack means that the operation succeeded, but it was
+	// rolled back because other operations of the same transaction failed
+	TxOperationRolledBack TxOperationStatus = "ROLLED_BACK"
+)
+
+var (
+	ErrInvalidTransaction    = eris.New("Invalid transaction")
+	ErrTransactionRolledBack = eris.New("Transaction rolled back")
+)
+
+// TxOperation is an operation of a transaction requested through local APIs
+type TxOperation struct {
+	Action     TxAction         `json:"action"`
+	EntityType types.EntityType `json:"entity_type"`
+	// Entity is the created entity, or the patch of the updated or upserted one.
+	// Deleted entities are identified by their ID, or by parent_id, child_id
+	// and type for relations, whose composite ID can be used as ID too.
+	Entity map[string]interface{} `json:"entity"`
+}
+
+// TxOperationResult is the result of a single operation of a transaction
+type TxOperationResult struct {
+	EntityType types.EntityType  `json:"entity_type"`
+	EntityID   string            `json:"entity_id"`
+	Action     TxAction          `json:"action"`
+	Status     TxOperationStatus `json:"status"`
+	Error      string            `json:"error,omitempty"`
+}
+
+// TransactionResult is the outcome of a transaction, with the results
+// of its operations in the same order they were requested
+type TransactionResult struct {
+	TxUUID    string              `json:"tx_uuid"`
+	Committed bool                `json:"committed"`
+	Results   []TxOperationResult `json:"results"`
+}
+
+// ApplySavepointsTx applies operations in order, each one inside its own savepoint
+// of the transaction of ctx, returning the error of every operation.
+// Failed operations are rolled back alone, so the following ones are still applied
+// and reported. Events of successful operations share the transaction of ctx.
+func ApplySavepointsTx(ctx *db.TxContext, operations []func(ctx *db.TxContext) error) []error {
+	errs := make([]error, len(operations))
+
+	for i, apply := range operations {
+		errs[i] = ctx.Tx.Transaction(func(savepointTx *gorm.DB) error {
+			opCtx := &db.TxContext{
+				Tx:      savepointTx,
+				TxUUID:  ctx.TxUUID,
+				TxLen:   ctx.TxLen,
+				TxIndex: ctx.TxIndex,
+			}
+			if err := apply(opCtx); err != nil {
+				return err
+			}
+			// Keep the transaction index only if the operation succeeded
+			ctx.TxIndex = opCtx.TxIndex
+			return nil
+		})
+	}
+
+	return errs
+}
+
+// txOperationEntityID returns the ID of the entity of an operation,
+// the composite ID for relations
+func txOperationEntityID(op *TxOperation) string {
+	if id, ok := op.Entity[constants.IDField]; ok && id != nil {
+		return fmt.Sprint(id)
+	}
+	if op.EntityType == types.EntityTypeRelation {
+		relation := &models.Relation{}
+		_ = util.JSONToStruct(op.Entity, relation)
+		return relation.CompositeID()
+	}
+	return ""
+}
+
+// txOperationRelation returns the relation of an operation,
+// identified either by its composite ID or by its keys
+func txOperationRelation(op *TxOperation) (*models.Relation, error) {
+	relation := &models.Relation{}
+	if id, ok := op.Entity[constants.IDField]; ok && id != nil {
+		if err := relation.SetCompositeID(fmt.Sprint(id)); err != nil {
+			return nil, eris.Wrap(ErrInvalidTransaction, err.Error())
+		}
+		return relation, nil
+	}
+	if err := util.JSONToStruct(op.Entity, relation); err != nil {
+		return nil, eris.Wrap(ErrInvalidTransaction, err.Error())
+	}
+	return relation, nil
+}
+
+// txOperationApply returns the function applying an operation
+func txOperationApply(op *TxOperation, modifiedBy string) (func(ctx *db.TxContext) error, error) {
+	if op.Entity == nil {
+		return nil, eris.Wrap(ErrInvalidTransaction, "missing operation entity")
+	}
+
+	entityID := txOperationEntityID(op)
+	patch := op.Entity
+
+	switch op.EntityType {
+	case types.EntityTypeItem:
+		switch op.Action {
+		case TxActionCreate:
+			item := models.Item{}
+			if err := util.JSONToStruct(patch, &item); err != nil {
+				return nil, eris.Wrap(ErrInvalidTransaction, err.Error())
+			}
+			return func(ctx *db.TxContext) error {
+				return BatchCreateItemsTx(ctx, []models.Item{item}, modifiedBy)
+			}, nil
+		case TxActionUpdate:
+			return func(ctx *db.TxContext) error {
+				return UpdateItemTx(ctx, patch, modifiedBy)
+			}, nil
+		case TxActionUpsert:
+			return func(ctx *db.TxContext) error {
+				return UpsertItemTx(ctx, patch, modifiedBy)
+			}, nil
+		case TxActionDelete:
+			return func(ctx *db.TxContext) error {
+				return DeleteItemByIDTx(ctx, entityID, modifiedBy)
+			}, nil
+		}
+	case types.EntityTypeAttribute:
+		switch op.Action {
+		case TxActionCreate:
+			attribute := models.Attribute{}
+			if err := util.JSONToStruct(patch, &attribute); err != nil {
+				return nil, eris.Wrap(ErrInvalidTransaction, err.Error())
+			}
+			return func(ctx *db.TxContext) error {
+				return BatchCreateAttributesTx(ctx, []models.Attribute{attribute}, modifiedBy)
+			}, nil
+		case TxActionUpdate:
+			return func(ctx *db.TxContext) error {
+				return UpdateAttributeTx(ctx, patch, modifiedBy)
+			}, nil
+		case TxActionUpsert:
+			return func(ctx *db.TxContext) error {
+				return UpsertAttributeTx(ctx, patch, modifiedBy)
+			}, nil
+		case TxActionDelete:
+			return func(ctx *db.TxContext) error {
+				return DeleteAttributeByIDTx(ctx, entityID, modifiedBy)
+			}, nil
+		}
+	case types.EntityTypeRelation:
+		relation, err := txOperationRelation(op)
+		if err != nil {
+			return nil, err
+		}
+		switch op.Action {
+		case TxActionCreate:
+			return func(ctx *db.TxContext) error {
+				return BatchCreateRelationsTx(ctx, []models.Relation{*relation}, modifiedBy)
+			}, nil
+		case TxActionUpdate:
+			return func(ctx *db.TxContext) error {
+				return UpdateRelationTx(ctx, patch, modifiedBy)
+			}, nil
+		case TxActionUpsert:
+			return func(ctx *db.TxContext) error {
+				return UpsertRelationTx(ctx, patch, modifiedBy)
+			}, nil
+		case TxActionDelete:
+			return func(ctx *db.TxContext) error {
+				return DeleteRelationTx(ctx, relation.ParentID, relation.ChildID, relation.Type, modifiedBy)
+			}, nil
+		}
+	default:
+		return nil, eris.Wrapf(ErrInvalidTransaction, "unknown entity type '%s'", op.EntityType)
+	}
+
+	return nil, eris.Wrapf(ErrInvalidTransaction, "unknown action '%s'", op.Action)
+}
+
+// ApplyTransaction applies ordered operations on items, attributes and relations
+// in a single database transaction, whose events share the same TxUUID.
+// The transaction is committed only if all operations succeed: otherwise the
+// returned result reports which operations failed, along with ErrTransactionRolledBack.
+// Invalid operations, e.g. with an unknown action, are reported as failed too.
+func ApplyTransaction(operations []TxOperation, modifiedBy string) (*TransactionResult, error) {
+	if len(operations) == 0 {
+		return nil, eris.Wrap(ErrInvalidTransaction, "empty transaction")
+	}
+
+	result := &TransactionResult{
+		TxUUID:  uuid.NewString(),
+		Results: make([]TxOperationResult, len(operations)),
+	}
+
+	applies := make([]func(ctx *db.TxContext) error, len(operations))
+
+	for i := range operations {
+		op := &operations[i]
+		result.Results[i] = TxOperationResult{
+			EntityType: op.EntityType,
+			EntityID:   txOperationEntityID(op),
+			Action:     op.Action,
+		}
+
+		apply, err := txOperationApply(op, modifiedBy)
+		if err != nil {
+			opErr := eris.Wrapf(err, "invalid operation %d", i)
+			apply = func(*db.TxContext) error {
+				return opErr
+			}
+		}
+		applies[i] = apply
+	}
+
+	err := db.GetHardDeleteTx(db.DB()).Transaction(func(tx *gorm.DB) error {
+		// The length of the transaction is known only once all operations
+		// are applied, since events are coalesced when published
+		ctx := &db.TxContext{
+			Tx:     tx,
+			TxUUID: result.TxUUID,
+			TxLen:  len(operations),
+		}
+
+		failed := 0
+		for i, opErr := range ApplySavepointsTx(ctx, applies) {
+			if opErr != nil {
+				result.Results[i].Status = TxOperationFailed
+				result.Results[i].Error = eris.ToString(opErr, false)
+				failed++
+			} else {
+				result.Results[i].Status = TxOperationApplied
+			}
+		}
+
+		if failed > 0 {
+			return eris.Wrapf(ErrTransactionRolledBack, "%d of %d operations failed", failed, len(operations))
+		}
+
+		return SealTxEventsTx(ctx)
+	})
+
+	result.Committed = err == nil
+
+	if !result.Committed {
+		for i := range result.Results {
+			if result.Results[i].Status == TxOperationApplied {
+				result.Results[i].Status = TxOperationRolledBack
+			}
+		}
+	}
+
+	return result, err
+}
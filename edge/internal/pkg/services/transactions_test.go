@@ -0,0 +1,152 @@
+package services
+
+import (
+	"testing"
+
+	"devais.it/kronos/internal/pkg/db"
+	"devais.it/kronos/internal/pkg/types"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/gorm"
+)
+
+const mbTransactions = "TRANSACTIONS_TEST"
+
+type TransactionsSuite struct {
+	db.SuiteBase
+}
+
+func (s *TransactionsSuite) TestCommit() {
+	assert := s.Require()
+
+	old := newItem()
+	assert.NoError(CreateItem(old, mbTransactions))
+	_, err := PurgeEntityEvents(types.EntityTypeItem, old.ID)
+	assert.NoError(err)
+
+	operations := []TxOperation{
+		{
+			Action:     TxActionCreate,
+			EntityType: types.EntityTypeItem,
+			Entity: map[string]interface{}{
+				"id":   "tx-parent",
+				"name": "Parent",
+				"type": "room",
+				"attributes": []interface{}{
+					map[string]interface{}{"id": "tx-attribute", "name": "Temperature", "type": "measure", "value": "20"},
+				},
+			},
+		},
+		{
+			Action:     TxActionUpsert,
+			EntityType: types.EntityTypeItem,
+			Entity:     map[string]interface{}{"id": "tx-child", "name": "Child", "type": "lamp"},
+		},
+		{
+			Action:     TxActionCreate,
+			EntityType: types.EntityTypeRelation,
+			Entity:     map[string]interface{}{"parent_id": "tx-parent", "child_id": "tx-child"},
+		},
+		{
+			Action:     TxActionUpdate,
+			EntityType: types.EntityTypeAttribute,
+			Entity:     map[string]interface{}{"id": "tx-attribute", "value": "21"},
+		},
+		{
+			Action:     TxActionDelete,
+			EntityType: types.EntityTypeItem,
+			Entity:     map[string]interface{}{"id": old.ID},
+		},
+	}
+
+	result, err := ApplyTransaction(operations, mbTransactions)
+	assert.NoError(err)
+	assert.True(result.Committed)
+	assert.NotEmpty(result.TxUUID)
+	assert.Equal("tx-parent->tx-child", result.Results[2].EntityID)
+	for _, r := range result.Results {
+		assert.Equal(TxOperationApplied, r.Status, r.Error)
+	}
+
+	attribute, err := GetAttributeByID("tx-attribute")
+	assert.NoError(err)
+	assert.Equal("21", attribute.Value)
+	_, err = GetRelation("tx-parent", "tx-child", "")
+	assert.NoError(err)
+	_, err = GetItemByID(old.ID)
+	assert.ErrorIs(err, gorm.ErrRecordNotFound)
+
+	// All events share the same transaction, with consecutive indexes.
+	// The attribute update is folded into its creation.
+	events, err := GetFirstEvents(db.DB(), 10)
+	assert.NoError(err)
+	assert.Len(events, 5)
+	assert.Contains(events[1].Body, `"value":"21"`)
+	for i, event := range events {
+		assert.Equal(result.TxUUID, event.TxUUID)
+		assert.Equal(len(events), event.TxLen)
+		assert.Equal(i, event.TxIndex)
+	}
+}
+
+func (s *TransactionsSuite) TestRollback() {
+	assert := s.Require()
+
+	operations := []TxOperation{
+		{
+			Action:     TxActionCreate,
+			EntityType: types.EntityTypeItem,
+			Entity:     map[string]interface{}{"id": "tx-item", "name": "Item", "type": "lamp"},
+		},
+		{
+			Action:     TxActionUpdate,
+			EntityType: types.EntityTypeItem,
+			Entity:     map[string]interface{}{"id": "tx-missing", "name": "Missing"},
+		},
+	}
+
+	result, err := ApplyTransaction(operations, mbTransactions)
+	assert.ErrorIs(err, ErrTransactionRolledBack)
+	assert.False(result.Committed)
+	assert.Equal(TxOperationRolledBack, result.Results[0].Status)
+	assert.Equal(TxOperationFailed, result.Results[1].Status)
+	assert.NotEmpty(result.Results[1].Error)
+
+	_, err = GetItemByID("tx-item")
+	assert.ErrorIs(err, gorm.ErrRecordNotFound)
+
+	count, err := GetEventsCount()
+	assert.NoError(err)
+	assert.Zero(count)
+}
+
+func (s *TransactionsSuite) TestInvalid() {
+	assert := s.Require()
+
+	_, err := ApplyTransaction(nil, mbTransactions)
+	assert.ErrorIs(err, ErrInvalidTransaction)
+
+	// Invalid operations are reported as failed, rolling back the transaction
+	operations := []TxOperation{
+		{Action: TxActionCreate, EntityType: types.EntityTypeItem, Entity: map[string]interface{}{"id": "tx-item", "name": "Item", "type": "TxItem"}},
+		{Action: "MOVE", EntityType: types.EntityTypeItem, Entity: map[string]interface{}{"id": "tx-item"}},
+		{Action: TxActionCreate, EntityType: "UNKNOWN", Entity: map[string]interface{}{"id": "tx-item"}},
+		{Action: TxActionDelete, EntityType: types.EntityTypeItem},
+		{Action: TxActionDelete, EntityType: types.EntityTypeRelation, Entity: map[string]interface{}{"id": "not-a-relation"}},
+	}
+	result, err := ApplyTransaction(operations, mbTransactions)
+	assert.ErrorIs(err, ErrTransactionRolledBack)
+	assert.False(result.Committed)
+	assert.Equal(TxOperationRolledBack, result.Results[0].Status)
+	for _, r := range result.Results[1:] {
+		assert.Equal(TxOperationFailed, r.Status)
+		assert.Contains(r.Error, ErrInvalidTransaction.Error())
+	}
+	assert.Equal("not-a-relation", result.Results[4].EntityID)
+
+	_, err = GetItemByID("tx-item")
+	assert.ErrorIs(err, gorm.ErrRecordNotFound)
+}
+
+func TestTransactionsService(t *testing.T) {
+	suite.Run(t, new(TransactionsSuite))
+}
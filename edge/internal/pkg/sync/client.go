@@ -1,11 +1,25 @@
 package sync
 
-import "devais.it/kronos/internal/pkg/sync/messages"
+import (
+	"fmt"
+	"time"
+
+	"devais.it/kronos/internal/pkg/config"
+	"devais.it/kronos/internal/pkg/db"
+	"devais.it/kronos/internal/pkg/db/models"
+	"devais.it/kronos/internal/pkg/services"
+	"devais.it/kronos/internal/pkg/sync/messages"
+	"devais.it/kronos/internal/pkg/telemetry"
+	"devais.it/kronos/internal/pkg/types"
+	"devais.it/kronos/internal/pkg/util"
+	"github.com/rotisserie/eris"
+)
 
 type ConnectionCallback func()
 type DisconnectionCallback func(err error)
 type SyncCallback func(message messages.Sync)
 type CommandCallback func(message *messages.ServerCommand)
+type EventsAckCallback func(message *messages.EventsAck)
 
 // Client defines methods that a synchronization client
 // must implement through its own protocol.
@@ -16,8 +30,126 @@ type Client interface {
 	SetDisconnectionCallback(cb DisconnectionCallback)
 	SetSyncCallback(cb SyncCallback)
 	SetCommandCallback(cb CommandCallback)
+	SetEventsAckCallback(cb EventsAckCallback)
 	Subscribe() error
 	PublishVersions() error
+	PublishVersionsDigest(digest *messages.VersionsDigest) error
 	PublishEvents(events []messages.Event) error
 	PublishCommandResponse(message *messages.CommandResponse) error
+	PublishSyncAck(message *messages.SyncAck) error
+}
+
+//=============================================================================
+// Utilities shared between client implementations
+//=============================================================================
+
+// setDedupKeys sets the key used by the server application to deduplicate
+// events delivered more than once, made of the device and the event IDs
+func setDedupKeys(events []messages.Event, deviceID string) {
+	for i := range events {
+		events[i].DedupKey = fmt.Sprintf("%s:%d", deviceID, events[i].ID)
+	}
+}
+
+// newConnectedMessage builds the message published after a successful connection.
+// Telemetry data is attached if enabled by configuration.
+func newConnectedMessage(syncConf *config.SyncConfig, deviceID string) (*messages.Connected, error) {
+	ts := util.TimestampMs()
+	msg := &messages.Connected{
+		DeviceID:  deviceID,
+		Timestamp: &ts,
+	}
+
+	if syncConf.TelemetryEnabled {
+		telData, err := telemetry.Get()
+		if err != nil {
+			return nil, eris.Wrap(err, "failed to get telemetry data to publish")
+		}
+		msg.Telemetry = telData
+	}
+
+	return msg, nil
+}
+
+// versionsPager gets a page of the versions of the entities of a type
+type versionsPager func(cursor string, pageSize int) ([]models.EntityVersion, *db.Page, error)
+
+// buildVersionsMessages builds the list of messages containing versions of
+// all entities.
+// Each message contains at most pageSize entities of each type.
+// Versions are paginated by cursor, so entities changed while the messages
+// are built are never skipped.
+func buildVersionsMessages(pageSize int) ([]*messages.Versions, error) {
+	entityTypes := []types.EntityType{
+		types.EntityTypeItem,
+		types.EntityTypeAttribute,
+		types.EntityTypeRelation,
+	}
+	pagers := map[types.EntityType]versionsPager{
+		types.EntityTypeItem:      services.GetItemsVersionPage,
+		types.EntityTypeAttribute: services.GetAttributesVersionPage,
+		types.EntityTypeRelation:  services.GetRelationsVersionPage,
+	}
+	cursors := make(map[types.EntityType]string, len(entityTypes))
+
+	var result []*messages.Versions
+
+	for len(entityTypes) > 0 {
+		versions := map[types.EntityType]messages.EntityVersions{}
+		var pending []types.EntityType
+
+		for _, entityType := range entityTypes {
+			entityVersions, page, err := pagers[entityType](cursors[entityType], pageSize)
+			if err != nil {
+				return nil, eris.Wrapf(err, "failed to get %s versions", entityType)
+			}
+			if len(entityVersions) > 0 {
+				versions[entityType] = entityVersions
+			}
+			if page.NextCursor != "" {
+				cursors[entityType] = page.NextCursor
+				pending = append(pending, entityType)
+			}
+		}
+
+		if len(versions) > 0 {
+			result = append(result, &messages.Versions{
+				Timestamp: util.TimestampMs(),
+				Versions:  versions,
+			})
+		}
+
+		entityTypes = pending
+	}
+
+	return result, nil
+}
+
+// retry calls fn until it succeeds.
+// The call will be retried until maxRetries or the max backoff time
+// configured in syncConf is reached.
+// If maxRetries is 0, only the backoff time is considered.
+func retry(syncConf *config.SyncConfig, maxRetries int, fn func() error) error {
+	backOff := syncConf.Backoff.NewBackoff()
+	interval := backOff.InitialInterval
+	retry := 0
+	for {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+
+		if maxRetries > 0 && retry >= maxRetries {
+			return err
+		}
+
+		if interval == backOff.Stop {
+			return err
+		}
+
+		time.Sleep(interval)
+
+		interval = backOff.NextBackOff()
+		retry++
+	}
 }
@@ -0,0 +1,198 @@
+package sync
+
+import (
+	"reflect"
+
+	"devais.it/kronos/internal/pkg/config"
+	"devais.it/kronos/internal/pkg/db"
+	"devais.it/kronos/internal/pkg/db/models"
+	"devais.it/kronos/internal/pkg/services"
+	"devais.it/kronos/internal/pkg/sync/messages"
+	"devais.it/kronos/internal/pkg/types"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/rotisserie/eris"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// conflictsPolicy determines how conflicts with local edits are resolved and recorded
+type conflictsPolicy struct {
+	strategy types.ConflictStrategy
+	// record enables conflicts detection with the default server wins strategy
+	record       bool
+	maxConflicts int
+}
+
+func newConflictsPolicy(conf *config.SyncConfig) conflictsPolicy {
+	return conflictsPolicy{
+		strategy:     conf.ConflictStrategy,
+		record:       conf.RecordConflicts,
+		maxConflicts: conf.MaxConflicts,
+	}
+}
+
+// enabled returns true if conflicts should be detected.
+// Server changes are always applied with the server wins strategy,
+// thus detection is only needed to record conflicts.
+func (p conflictsPolicy) enabled() bool {
+	return p.record || (p.strategy != "" && p.strategy != types.ConflictStrategyServerWins)
+}
+
+// detectConflict checks whether a sync entry conflicts with local edits.
+// An entity was edited locally when its version differs from the version
+// of the last synchronization.
+// Detected conflicts are resolved using the strategy of the policy and recorded
+// in the conflicts log. Returns nil if there is no conflict or detection is disabled.
+func detectConflict(
+	ctx *db.TxContext,
+	entry *messages.SyncEntry,
+	model interface{},
+	policy conflictsPolicy) (*models.Conflict, error) {
+	if !policy.enabled() {
+		return nil, nil
+	}
+	if entry.Action != messages.SyncActionUpdate && entry.Action != messages.SyncActionDelete {
+		return nil, nil
+	}
+
+	strategy := policy.strategy
+
+	local := reflect.New(reflect.TypeOf(model).Elem()).Interface()
+
+	query := ctx.Tx
+	if relation, ok := model.(*models.Relation); ok {
+		query = query.Where(
+			"parent_id = ? AND child_id = ? AND type = ?",
+			relation.ParentID,
+			relation.ChildID,
+			relation.Type,
+		)
+	} else {
+		query = query.Where("id = ?", entry.EntityID)
+	}
+
+	err := query.First(local).Error
+	if eris.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to get local %s '%s'", entry.EntityType, entry.EntityID)
+	}
+
+	syncModel, err := models.GetEntitySyncModel(local)
+	if err != nil {
+		return nil, err
+	}
+
+	if !syncModel.SyncVersion.Valid || syncModel.Version == syncModel.SyncVersion.String {
+		// Entity not edited since the last synchronization
+		return nil, nil
+	}
+
+	json := jsoniter.ConfigCompatibleWithStandardLibrary
+
+	localBody, err := json.Marshal(local)
+	if err != nil {
+		return nil, eris.Wrap(err, "failed to marshal local entity")
+	}
+
+	conflict := &models.Conflict{
+		EntityType:    entry.EntityType,
+		EntityID:      entry.EntityID,
+		Action:        string(entry.Action),
+		Strategy:      strategy,
+		LocalVersion:  syncModel.Version,
+		SyncVersion:   syncModel.SyncVersion.String,
+		RemoteVersion: entry.Version,
+		ChangedFields: syncModel.ChangedFields.ValueOrZero(),
+		LocalBody:     string(localBody),
+	}
+
+	if entry.Payload != nil {
+		remoteBody, err := json.Marshal(entry.Payload)
+		if err != nil {
+			return nil, eris.Wrap(err, "failed to marshal remote entity")
+		}
+		conflict.RemoteBody = string(remoteBody)
+	}
+
+	conflict.Resolution = resolveConflict(entry, syncModel, strategy)
+
+	if conflict.Resolution == types.ConflictResolutionMerged {
+		// Locally edited fields win over server ones
+		for field := range services.SplitChangedFields(syncModel.ChangedFields) {
+			delete(entry.Payload, field)
+		}
+	}
+
+	log.Infof(
+		"Conflict detected on %s '%s' [local: %s, remote: %s], resolved with %s by strategy %s",
+		entry.EntityType,
+		entry.EntityID,
+		conflict.LocalVersion,
+		conflict.RemoteVersion,
+		conflict.Resolution,
+		strategy,
+	)
+
+	err = services.CreateConflictTx(ctx, conflict)
+	if err != nil {
+		return nil, err
+	}
+
+	err = services.PruneConflictsTx(ctx, conflict.ID, policy.maxConflicts)
+	if err != nil {
+		return nil, err
+	}
+
+	return conflict, nil
+}
+
+// resolveConflict decides which side of a conflict should be kept.
+// Unknown strategies fall back to server wins.
+func resolveConflict(
+	entry *messages.SyncEntry,
+	local *models.SyncModel,
+	strategy types.ConflictStrategy) types.ConflictResolution {
+	switch strategy {
+	case types.ConflictStrategyEdgeWins:
+		return types.ConflictResolutionEdge
+	case types.ConflictStrategyLastModifiedWins:
+		return resolveByTimestamp(entry, "modified_at", local.ModifiedAt)
+	case types.ConflictStrategyLastSourceWins:
+		return resolveByTimestamp(entry, "source_timestamp", local.SourceTimestamp)
+	case types.ConflictStrategyMergeFields:
+		if entry.Action == messages.SyncActionDelete {
+			// Deletes can't be merged
+			return types.ConflictResolutionServer
+		}
+		return types.ConflictResolutionMerged
+	default:
+		return types.ConflictResolutionServer
+	}
+}
+
+// resolveByTimestamp keeps local edits only if they are more recent than
+// the server ones. Entries without the timestamp field are always applied.
+func resolveByTimestamp(entry *messages.SyncEntry, field string, localTimestamp uint64) types.ConflictResolution {
+	remoteTimestamp, ok := payloadTimestamp(entry.Payload, field)
+	if ok && localTimestamp > remoteTimestamp {
+		return types.ConflictResolutionEdge
+	}
+	return types.ConflictResolutionServer
+}
+
+func payloadTimestamp(payload map[string]interface{}, field string) (uint64, bool) {
+	switch v := payload[field].(type) {
+	case float64:
+		return uint64(v), true
+	case int:
+		return uint64(v), true
+	case int64:
+		return uint64(v), true
+	case uint64:
+		return v, true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,109 @@
+package sync
+
+import (
+	"devais.it/kronos/internal/pkg/db"
+	"devais.it/kronos/internal/pkg/logging"
+	"devais.it/kronos/internal/pkg/services"
+	"devais.it/kronos/internal/pkg/sync/messages"
+	"devais.it/kronos/internal/pkg/telemetry"
+	"github.com/rotisserie/eris"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// errAwaitingAck is returned while the worker waits for the acknowledgement
+// of the in-flight events batch
+var errAwaitingAck = eris.New("waiting for events acknowledgement")
+
+// deliverEvents publishes the next batch of events, keeping them in the queue
+// as in-flight until the server application acknowledges the batch.
+// Only one batch is in-flight at a time, so that redelivered events
+// are never preceded by newer ones.
+func (w *Worker) deliverEvents() error {
+	var batch *services.EventsBatch
+
+	err := db.DB().Transaction(func(tx *gorm.DB) error {
+		released, err := services.ReleaseExpiredEvents(tx, w.conf.Delivery.AckTimeout)
+		if err != nil {
+			return err
+		}
+		if released > 0 {
+			log.Warnf("%d events not acknowledged in time, delivering again", released)
+			w.redeliveredCounter.Add(float64(released))
+		}
+
+		inFlight, err := services.GetInFlightEventsCount(tx)
+		if err != nil {
+			return err
+		}
+		if inFlight > 0 {
+			return errAwaitingAck
+		}
+
+		batch, err = services.TakeEventsBatch(tx, w.conf.MaxEvents)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	// Events are published after marking them as in-flight, otherwise
+	// a fast acknowledgement could arrive before the batch is committed
+	err = w.publishEvents(batch)
+	if err != nil {
+		if _, releaseErr := services.ReleaseEventsBatch(db.DB(), batch.ID); releaseErr != nil {
+			logging.Error(releaseErr, "Failed to release undelivered events batch")
+		}
+		return err
+	}
+
+	log.Infof("%d events delivered, batch: '%s'", len(batch.Events), batch.ID)
+
+	// Metrics
+	w.pubEventsCounter.Add(float64(len(batch.Events)))
+
+	return nil
+}
+
+func (w *Worker) onEventsAck(message *messages.EventsAck) {
+	defer func() {
+		if err := recover(); err != nil {
+			w.handlePanicRecovered(err)
+		}
+	}()
+
+	telemetry.SetLastMessageReceivedTs()
+
+	// Metrics
+	w.msgsReceivedCounter.Inc()
+
+	if message.BatchID == "" {
+		log.Error("Received events ack message without batch ID")
+		return
+	}
+
+	if !message.Success {
+		log.Warnf("Events batch '%s' rejected by the server application: %s", message.BatchID, message.Error)
+
+		released, err := services.ReleaseEventsBatch(db.DB(), message.BatchID)
+		if err != nil {
+			logging.Error(err, "Failed to release rejected events batch")
+			return
+		}
+		w.redeliveredCounter.Add(float64(released))
+	} else {
+		count, err := services.AckEventsBatch(message.BatchID)
+		if err != nil {
+			logging.Error(err, "Failed to acknowledge events batch")
+			return
+		}
+		if count == 0 {
+			log.Debugf("Events batch '%s' already acknowledged or released", message.BatchID)
+		} else {
+			log.Debugf("Events batch '%s' acknowledged, %d events dequeued", message.BatchID, count)
+		}
+	}
+
+	// Immediately deliver the next batch
+	w.signalEvent()
+}
@@ -10,6 +10,7 @@ import (
 	"devais.it/kronos/internal/pkg/types"
 	"devais.it/kronos/internal/pkg/util"
 	"github.com/google/uuid"
+	jsoniter "github.com/json-iterator/go"
 	"github.com/rotisserie/eris"
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/guregu/null.v4"
@@ -38,6 +39,18 @@ func (w *Worker) handleVersionCommand(entityType types.EntityType, entityID stri
 		return map[string]interface{}{
 			"version": version,
 		}, nil
+	} else if entityType == types.EntityTypeRelation {
+		key := &models.Relation{}
+		if err := key.SetCompositeID(entityID); err != nil {
+			return nil, err
+		}
+		version, err := services.GetRelationVersion(key.ParentID, key.ChildID, key.Type)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{
+			"version": version,
+		}, nil
 	} else {
 		return nil, eris.Errorf("Unknown entity type: '%s'", string(entityType))
 	}
@@ -56,6 +69,16 @@ func (w *Worker) handleGetEntityCommand(entityType types.EntityType, entityID st
 			return nil, err
 		}
 		return util.StructToJSONMap(attribute)
+	} else if entityType == types.EntityTypeRelation {
+		key := &models.Relation{}
+		if err := key.SetCompositeID(entityID); err != nil {
+			return nil, err
+		}
+		relation, err := services.GetRelation(key.ParentID, key.ChildID, key.Type)
+		if err != nil {
+			return nil, err
+		}
+		return util.StructToJSONMap(relation)
 	} else {
 		return nil, eris.Errorf("Unknown entity type: '%s'", string(entityType))
 	}
@@ -69,6 +92,230 @@ func (w *Worker) handleGetTelemetryCommand() (map[string]interface{}, error) {
 	return util.StructToJSONMap(telData)
 }
 
+// snapshotSource fetches pages of a single kind of entity included in a snapshot
+type snapshotSource struct {
+	count func() (int64, error)
+	fill  func(snapshot *messages.Snapshot, page, pageSize int) error
+}
+
+func itemsSnapshotSource(
+	count func() (int64, error),
+	get func(page, pageSize int) ([]models.Item, error)) snapshotSource {
+	return snapshotSource{
+		count: count,
+		fill: func(snapshot *messages.Snapshot, page, pageSize int) (err error) {
+			snapshot.Items, err = get(page, pageSize)
+			return
+		},
+	}
+}
+
+func attributesSnapshotSource(
+	count func() (int64, error),
+	get func(page, pageSize int) ([]models.Attribute, error)) snapshotSource {
+	return snapshotSource{
+		count: count,
+		fill: func(snapshot *messages.Snapshot, page, pageSize int) (err error) {
+			snapshot.Attributes, err = get(page, pageSize)
+			return
+		},
+	}
+}
+
+func relationsSnapshotSource(
+	count func() (int64, error),
+	get func(page, pageSize int) ([]models.Relation, error)) snapshotSource {
+	return snapshotSource{
+		count: count,
+		fill: func(snapshot *messages.Snapshot, page, pageSize int) (err error) {
+			snapshot.Relations, err = get(page, pageSize)
+			return
+		},
+	}
+}
+
+// snapshotSources returns the sources of entities dumped by a snapshot command
+func snapshotSources(message *messages.ServerCommand, params *messages.SnapshotParams) ([]snapshotSource, error) {
+	itemID := message.EntityID
+
+	switch message.CommandType {
+	case messages.CommandGetSubtree:
+		if err := services.ItemExistsErr(itemID); err != nil {
+			return nil, err
+		}
+		return []snapshotSource{
+			itemsSnapshotSource(
+				func() (int64, error) { return services.GetItemSubtreeCount(itemID) },
+				func(page, pageSize int) ([]models.Item, error) {
+					return services.GetItemSubtree(itemID, page, pageSize)
+				},
+			),
+			attributesSnapshotSource(
+				func() (int64, error) { return services.GetItemSubtreeAttributesCount(itemID) },
+				func(page, pageSize int) ([]models.Attribute, error) {
+					return services.GetItemSubtreeAttributes(itemID, page, pageSize)
+				},
+			),
+			relationsSnapshotSource(
+				func() (int64, error) { return services.GetItemSubtreeRelationsCount(itemID) },
+				func(page, pageSize int) ([]models.Relation, error) {
+					return services.GetItemSubtreeRelations(itemID, page, pageSize)
+				},
+			),
+		}, nil
+	case messages.CommandGetItemsByType:
+		itemType := params.Type
+		if itemType == "" {
+			return nil, eris.New("Missing item type")
+		}
+		return []snapshotSource{
+			itemsSnapshotSource(
+				func() (int64, error) { return services.GetItemsByTypeCount(itemType) },
+				func(page, pageSize int) ([]models.Item, error) {
+					return services.GetItemsByType(itemType, page, pageSize)
+				},
+			),
+			attributesSnapshotSource(
+				func() (int64, error) { return services.GetAttributesByItemTypeCount(itemType) },
+				func(page, pageSize int) ([]models.Attribute, error) {
+					return services.GetAttributesByItemType(itemType, page, pageSize)
+				},
+			),
+		}, nil
+	case messages.CommandGetRelations:
+		// Relations of a single item if an entity ID is given,
+		// otherwise all relations
+		if itemID != "" {
+			if err := services.ItemExistsErr(itemID); err != nil {
+				return nil, err
+			}
+			return []snapshotSource{
+				relationsSnapshotSource(
+					func() (int64, error) { return services.GetRelationsByItemCount(itemID) },
+					func(page, pageSize int) ([]models.Relation, error) {
+						return services.GetRelationsByItem(itemID, page, pageSize)
+					},
+				),
+			}, nil
+		}
+		return []snapshotSource{
+			relationsSnapshotSource(services.GetRelationsCount, services.GetAllRelations),
+		}, nil
+	default:
+		return nil, eris.Errorf("Unknown snapshot command: '%s'", message.CommandType)
+	}
+}
+
+// handleSnapshotCommand handles commands returning a dump of entities.
+// Entities are split into pages with at most MaxEntitiesPerMessage entities of each type,
+// a single page can be requested through the command body.
+func (w *Worker) handleSnapshotCommand(message *messages.ServerCommand) (map[string]interface{}, error) {
+	params := &messages.SnapshotParams{}
+	if err := util.JSONToStruct(message.Body, params); err != nil {
+		return nil, eris.Wrap(err, "invalid snapshot command parameters")
+	}
+	if params.Page < 0 {
+		return nil, db.ErrInvalidPagination
+	}
+
+	sources, err := snapshotSources(message, params)
+	if err != nil {
+		return nil, err
+	}
+
+	pageSize := w.conf.MaxEntitiesPerMessage()
+
+	// Compute the number of pages of each source
+	sourcePages := make([]int, len(sources))
+	pages := 1
+	for i, source := range sources {
+		count, err := source.count()
+		if err != nil {
+			return nil, err
+		}
+		sourcePages[i] = util.CeilDiv(int(count), pageSize)
+		pages = util.MaxInt(pages, sourcePages[i])
+	}
+
+	buildPage := func(page int) (map[string]interface{}, error) {
+		snapshot := &messages.Snapshot{
+			Page:  page,
+			Pages: pages,
+		}
+		for i, source := range sources {
+			if page <= sourcePages[i] {
+				if err := source.fill(snapshot, page, pageSize); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return util.StructToJSONMap(snapshot)
+	}
+
+	return w.respondPages(message, "snapshot", params.Page, pages, buildPage)
+}
+
+// respondPages builds the response of a command split into pages.
+// If a single page is requested, only that page is returned.
+// Otherwise all pages but the last are published as separate command responses,
+// while the last one is returned to be published as the final response.
+func (w *Worker) respondPages(message *messages.ServerCommand, name string, page, pages int,
+	buildPage func(page int) (map[string]interface{}, error)) (map[string]interface{}, error) {
+
+	if page > 0 {
+		if page > pages {
+			return nil, eris.Errorf("Page %d of %s out of range, pages: %d", page, name, pages)
+		}
+		return buildPage(page)
+	}
+
+	for page := 1; page < pages; page++ {
+		body, err := buildPage(page)
+		if err != nil {
+			return nil, err
+		}
+
+		err = w.client.PublishCommandResponse(&messages.CommandResponse{
+			UUID:    message.UUID,
+			Success: true,
+			Body:    body,
+		})
+		if err != nil {
+			return nil, eris.Wrapf(err, "failed to publish %s page %d", name, page)
+		}
+	}
+
+	return buildPage(pages)
+}
+
+// handleSetSchemaCommand replaces the schema registry with the one
+// contained in the command body.
+// An empty body removes the registry received from the server,
+// restoring the configured one.
+func (w *Worker) handleSetSchemaCommand(message *messages.ServerCommand) (map[string]interface{}, error) {
+	var content []byte
+	if len(message.Body) > 0 {
+		var err error
+		content, err = jsoniter.ConfigCompatibleWithStandardLibrary.Marshal(message.Body)
+		if err != nil {
+			return nil, eris.Wrap(err, "invalid schema registry")
+		}
+	}
+
+	if err := services.SaveSchemaRegistry(content); err != nil {
+		return nil, err
+	}
+
+	registry := services.SchemaRegistry()
+	if registry == nil {
+		return nil, nil
+	}
+	log.Infof("Schema registry updated. Version: '%s'", registry.Version)
+	return map[string]interface{}{
+		"version": registry.Version,
+	}, nil
+}
+
 /*
 func (w *Worker) checkForeignKeys() error {
 	fksEnabled, err := db.CheckForeignKeysEnabled(db.DB())
@@ -88,32 +335,174 @@ func (w *Worker) checkForeignKeys() error {
 }
 */
 
-func (w *Worker) handleSyncMessage(message messages.Sync) error {
-	// TODO: Understand why this LOC is necessary.
-	// Apparently without doing this, cascade deletes won't work. And calling
-	// w.checkForeignKeys won't make it work either. If we query SQLite to check
-	// whether foreign keys are enabled or not, it will say: "of course they are",
-	// but cascade deletes will still work randomly. The only thing that fixes the problem
-	// is calling this very exact code.
-	// This might be an ORM bug, or some sort of UB inside the C binding.
+// enableForeignKeys must be called before modifying entities on behalf of the server.
+//
+// TODO: Understand why this LOC is necessary.
+// Apparently without doing this, cascade deletes won't work. And calling
+// w.checkForeignKeys won't make it work either. If we query SQLite to check
+// whether foreign keys are enabled or not, it will say: "of course they are",
+// but cascade deletes will still work randomly. The only thing that fixes the problem
+// is calling this very exact code.
+// This might be an ORM bug, or some sort of UB inside the C binding.
+func enableForeignKeys() error {
 	tx := db.DB().Exec("PRAGMA foreign_keys = ON")
 	if tx.Error != nil {
 		return eris.Wrap(tx.Error, "failed to enable foreign keys")
 	}
-	// End of workaround
+	return nil
+}
+
+// transactionOperations returns the list of operations of a transaction,
+// in the same order used by batch services: creates, updates and deletes.
+func transactionOperations(body *messages.ApplyTransaction) ([]services.TxOperation, error) {
+	var operations []services.TxOperation
+
+	add := func(action services.TxAction, entityType types.EntityType, entity map[string]interface{}) {
+		operations = append(operations, services.TxOperation{
+			Action:     action,
+			EntityType: entityType,
+			Entity:     entity,
+		})
+	}
+
+	addCreated := func(entityType types.EntityType, model interface{}) error {
+		entity, err := util.StructToJSONMap(model)
+		if err != nil {
+			return eris.Wrapf(err, "invalid %s", entityType)
+		}
+		add(services.TxActionCreate, entityType, entity)
+		return nil
+	}
+
+	for i := range body.Create.Items {
+		if err := addCreated(types.EntityTypeItem, &body.Create.Items[i]); err != nil {
+			return nil, err
+		}
+	}
+	for i := range body.Create.Attributes {
+		if err := addCreated(types.EntityTypeAttribute, &body.Create.Attributes[i]); err != nil {
+			return nil, err
+		}
+	}
+	for i := range body.Create.Relations {
+		if err := addCreated(types.EntityTypeRelation, &body.Create.Relations[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, patch := range body.Update.Items {
+		add(services.TxActionUpdate, types.EntityTypeItem, patch)
+	}
+	for _, patch := range body.Update.Attributes {
+		add(services.TxActionUpdate, types.EntityTypeAttribute, patch)
+	}
+	for _, patch := range body.Update.Relations {
+		add(services.TxActionUpdate, types.EntityTypeRelation, patch)
+	}
+
+	// Relations are deleted by their composite ID
+	for _, relationID := range body.Delete.Relations {
+		add(services.TxActionDelete, types.EntityTypeRelation, map[string]interface{}{constants.IDField: relationID})
+	}
+	for _, attributeID := range body.Delete.Attributes {
+		add(services.TxActionDelete, types.EntityTypeAttribute, map[string]interface{}{constants.IDField: attributeID})
+	}
+	for _, itemID := range body.Delete.Items {
+		add(services.TxActionDelete, types.EntityTypeItem, map[string]interface{}{constants.IDField: itemID})
+	}
+
+	return operations, nil
+}
+
+// handleApplyTransactionCommand applies creates, updates and deletes requested
+// by the server in a single database transaction, as local transactions do.
+// Every operation runs inside its own savepoint, so that the result of each one
+// can be reported even when the transaction is rolled back.
+// The transaction is committed only if all operations succeed.
+func (w *Worker) handleApplyTransactionCommand(message *messages.ServerCommand) (map[string]interface{}, error) {
+	body := &messages.ApplyTransaction{}
+	if err := util.JSONToStruct(message.Body, body); err != nil {
+		return nil, eris.Wrap(err, "invalid transaction")
+	}
+
+	operations, err := transactionOperations(body)
+	if err != nil {
+		return nil, eris.Wrap(err, "invalid transaction")
+	}
+	if len(operations) == 0 {
+		return nil, eris.New("Empty transaction")
+	}
+
+	if err := enableForeignKeys(); err != nil {
+		return nil, err
+	}
+
+	txResult, err := services.ApplyTransaction(operations, constants.ModifiedBySyncName)
+	if txResult == nil {
+		return nil, err
+	}
+
+	result := &messages.TransactionResult{
+		TxUUID:    txResult.TxUUID,
+		Committed: txResult.Committed,
+		Results:   make([]messages.EntityResult, len(txResult.Results)),
+	}
+	for i, r := range txResult.Results {
+		result.Results[i] = messages.EntityResult{
+			EntityType: r.EntityType,
+			EntityID:   r.EntityID,
+			Action:     messages.SyncAction(r.Action),
+			Status:     messages.EntityResultStatus(r.Status),
+			Error:      r.Error,
+		}
+	}
+
+	resultMap, mapErr := util.StructToJSONMap(result)
+	if mapErr != nil {
+		return nil, eris.Wrap(mapErr, "failed to build transaction result")
+	}
+
+	if err != nil {
+		return resultMap, err
+	}
+
+	return resultMap, nil
+}
+
+// handleSyncMessage applies a sync message in a single transaction, returning
+// an acknowledgement with the outcome of every entry.
+// Every entry runs inside its own savepoint, so that all entries are evaluated
+// and reported even when one of them fails. The transaction is committed
+// only if no entry failed.
+func (w *Worker) handleSyncMessage(message messages.Sync) (*messages.SyncAck, error) {
+	if err := enableForeignKeys(); err != nil {
+		return nil, err
+	}
+
+	ack := &messages.SyncAck{
+		Entries: make([]messages.SyncAckEntry, len(message)),
+	}
+	ackEntries := make(map[*messages.SyncEntry]*messages.SyncAckEntry, len(message))
+
+	for i := 0; i < len(message); i++ {
+		entry := &message[i]
+		ack.Entries[i] = messages.SyncAckEntry{
+			EntityType: entry.EntityType,
+			EntityID:   entry.EntityID,
+			Version:    entry.Version,
+			Action:     entry.Action,
+		}
+		ackEntries[entry] = &ack.Entries[i]
+	}
 
 	// Optimization for single entries
 	if len(message) == 1 {
 		err := db.GetHardDeleteTx(db.DB()).Transaction(func(tx *gorm.DB) error {
 			ctx := &db.TxContext{Tx: tx}
-			return syncEntry(ctx, &message[0])
+			return w.syncAckEntry(ctx, &message[0], &ack.Entries[0])
 		})
 
-		if err != nil {
-			return eris.Wrap(err, "Failed to handle sync message")
-		}
-
-		return nil
+		return finalizeSyncAck(ack, err)
 	}
 
 	entriesMap := make(map[types.EntityType]map[messages.SyncAction][]*messages.SyncEntry)
@@ -161,30 +550,105 @@ func (w *Worker) handleSyncMessage(message messages.Sync) error {
 			Tx:     tx,
 		}
 
+		failed := 0
+
 		for _, entityType := range typesOrder {
 			for _, action := range actionsOrder {
 				entries := entriesMap[entityType][action]
 
 				for _, entry := range entries {
-					err := syncEntry(ctx, entry)
+					err := tx.Transaction(func(savepointTx *gorm.DB) error {
+						entryCtx := &db.TxContext{
+							TxUUID:  ctx.TxUUID,
+							TxLen:   ctx.TxLen,
+							TxIndex: ctx.TxIndex,
+							Tx:      savepointTx,
+						}
+						if err := w.syncAckEntry(entryCtx, entry, ackEntries[entry]); err != nil {
+							return err
+						}
+						// Keep the transaction index only if the entry was applied
+						ctx.TxIndex = entryCtx.TxIndex
+						return nil
+					})
 
 					if err != nil {
-						return err
+						failed++
 					}
 				}
 			}
 		}
-		return nil
+
+		// Entries with unknown entity type or action are never applied
+		// and fail the message, as single entries do
+		for i := range ack.Entries {
+			if ack.Entries[i].Status == "" {
+				ack.Entries[i].Status = messages.SyncEntryFailed
+				ack.Entries[i].Error = eris.ToString(invalidSyncEntryErr(&message[i]), false)
+				failed++
+			}
+		}
+
+		if failed > 0 {
+			return eris.Errorf("%d of %d entries failed", failed, len(message))
+		}
+
+		// Updates may be coalesced with queued events
+		return services.SealTxEventsTx(ctx)
 	})
 
+	return finalizeSyncAck(ack, err)
+}
+
+// syncAckEntry synchronizes an entry, recording the outcome on its acknowledgement entry
+func (w *Worker) syncAckEntry(ctx *db.TxContext, entry *messages.SyncEntry, ackEntry *messages.SyncAckEntry) error {
+	status, conflict, err := syncEntry(ctx, entry, newConflictsPolicy(w.conf))
 	if err != nil {
-		return eris.Wrap(err, "Failed to handle sync message")
+		ackEntry.Status = messages.SyncEntryFailed
+		ackEntry.Error = eris.ToString(err, false)
+		return err
+	}
+	ackEntry.Status = status
+	if conflict != nil {
+		ackEntry.Conflict = conflict.Resolution
 	}
-
 	return nil
 }
 
-func syncEntry(ctx *db.TxContext, entry *messages.SyncEntry) error {
+// invalidSyncEntryErr returns the error of an entry which can't be
+// synchronized because of its entity type or action
+func invalidSyncEntryErr(entry *messages.SyncEntry) error {
+	switch entry.EntityType {
+	case types.EntityTypeItem, types.EntityTypeAttribute, types.EntityTypeRelation:
+		return ErrInvalidAction
+	default:
+		return ErrInvalidEntityType
+	}
+}
+
+// finalizeSyncAck marks applied entries of a rolled back transaction
+func finalizeSyncAck(ack *messages.SyncAck, txErr error) (*messages.SyncAck, error) {
+	ack.Timestamp = util.TimestampMs()
+	ack.Committed = txErr == nil
+
+	if txErr != nil {
+		for i := range ack.Entries {
+			if ack.Entries[i].Status == messages.SyncEntryApplied {
+				ack.Entries[i].Status = messages.SyncEntryRolledBack
+			}
+		}
+		return ack, eris.Wrap(txErr, "Failed to handle sync message")
+	}
+
+	return ack, nil
+}
+
+// syncEntry synchronizes a single entry, returning whether it was applied or skipped.
+// Conflicts with local edits are resolved using the given policy, and returned.
+func syncEntry(
+	ctx *db.TxContext,
+	entry *messages.SyncEntry,
+	conflicts conflictsPolicy) (messages.SyncEntryStatus, *models.Conflict, error) {
 	mb := constants.ModifiedBySyncName
 
 	// Declare models
@@ -209,17 +673,17 @@ func syncEntry(ctx *db.TxContext, entry *messages.SyncEntry) error {
 	case types.EntityTypeRelation:
 		err = relation.SetCompositeID(entry.EntityID)
 		if err != nil {
-			return err
+			return "", nil, err
 		}
 		model = relation
-		syncPolicy, err = services.GetRelationSyncPolicy(relation.ParentID, relation.ChildID)
+		syncPolicy, err = services.GetRelationSyncPolicy(relation.ParentID, relation.ChildID, relation.Type)
 	default:
-		return ErrInvalidEntityType
+		return "", nil, ErrInvalidEntityType
 	}
 
 	// Check the result of GetSyncPolicy methods
 	if err != nil && !eris.Is(err, gorm.ErrRecordNotFound) {
-		return err
+		return "", nil, err
 	}
 
 	log.Debugf("Entity '%s' sync policy is '%s'", entry.EntityID, syncPolicy.ValueOrZero())
@@ -231,7 +695,7 @@ func syncEntry(ctx *db.TxContext, entry *messages.SyncEntry) error {
 			entry.EntityID,
 			constants.SyncPolicyDontSync,
 		)
-		return nil
+		return messages.SyncEntrySkippedDontSync, nil, nil
 	}
 
 	if entry.Action != messages.SyncActionDelete && entry.Version != "" {
@@ -239,21 +703,35 @@ func syncEntry(ctx *db.TxContext, entry *messages.SyncEntry) error {
 		// Fetch version and compare it with the incoming one
 		versionQuery := ctx.Tx.Model(model).Select("version")
 		if entry.EntityType == types.EntityTypeRelation {
-			versionQuery = versionQuery.Where("parent_id = ? AND child_id = ?", relation.ParentID, relation.ChildID)
+			versionQuery = versionQuery.Where(
+				"parent_id = ? AND child_id = ? AND type = ?",
+				relation.ParentID,
+				relation.ChildID,
+				relation.Type,
+			)
 		} else {
 			versionQuery = versionQuery.Where("id = ?", entry.EntityID)
 		}
 		err = versionQuery.First(&version).Error
 		if err != nil && !eris.Is(err, gorm.ErrRecordNotFound) {
-			return err
+			return "", nil, err
 		}
 
 		if version == entry.Version {
 			// Local entity already synchronized to requested version. Skip synchronization
-			return nil
+			return messages.SyncEntrySkippedSameVersion, nil, nil
 		}
 	}
 
+	conflict, err := detectConflict(ctx, entry, model, conflicts)
+	if err != nil {
+		return "", nil, err
+	}
+	if conflict != nil && conflict.Resolution == types.ConflictResolutionEdge {
+		// Keep local edits
+		return messages.SyncEntrySkippedConflict, conflict, nil
+	}
+
 	if entry.Action == messages.SyncActionCreate ||
 		entry.Action == messages.SyncActionUpdate {
 		// NOTE: Maybe it would be better to move this assignment
@@ -268,11 +746,14 @@ func syncEntry(ctx *db.TxContext, entry *messages.SyncEntry) error {
 	if entry.Action == messages.SyncActionCreate {
 		err = createEntry(ctx, entry)
 		if err != nil {
-			return err
+			return "", nil, err
 		}
 	} else if entry.Action == messages.SyncActionUpdate {
 		if entry.EntityType == types.EntityTypeRelation {
-			_, err = services.GetRelation(relation.ParentID, relation.ChildID)
+			entry.Payload[constants.ParentIDField] = relation.ParentID
+			entry.Payload[constants.ChildIDField] = relation.ChildID
+			entry.Payload[constants.RelationTypeField] = relation.Type
+			err = services.UpdateRelationTx(ctx, entry.Payload, mb)
 		} else {
 			entry.Payload["id"] = entry.EntityID
 
@@ -288,29 +769,34 @@ func syncEntry(ctx *db.TxContext, entry *messages.SyncEntry) error {
 		if eris.Is(err, gorm.ErrRecordNotFound) {
 			// Create the entity
 			err = createEntry(ctx, entry)
+		} else if err == nil && conflict != nil && conflict.Resolution == types.ConflictResolutionMerged {
+			// The merged content differs from the server one
+			err = services.RefreshMergedVersionTx(ctx, model, entry.EntityID, null.StringFrom(conflict.ChangedFields))
 		}
 
 		if err != nil {
-			return err
+			return "", nil, err
 		}
 	} else if entry.Action == messages.SyncActionDelete {
 		if entry.EntityType == types.EntityTypeRelation {
-			err = services.DeleteRelationTx(ctx, relation.ParentID, relation.ChildID, mb)
+			err = services.DeleteRelationTx(ctx, relation.ParentID, relation.ChildID, relation.Type, mb)
+		} else if entry.EntityType == types.EntityTypeItem {
+			err = services.DeleteItemByIDTx(ctx, entry.EntityID, mb)
 		} else {
 			err = services.DeleteByIDTx(ctx, entry.EntityID, model, mb)
 		}
 		if err != nil {
 			if eris.Is(err, gorm.ErrRecordNotFound) {
 				log.Debugf("%s '%s' does not exist.", entry.EntityType, entry.EntityID)
-				return nil
+				return messages.SyncEntryApplied, conflict, nil
 			}
-			return err
+			return "", nil, err
 		}
 	} else {
-		return ErrInvalidAction
+		return "", nil, ErrInvalidAction
 	}
 
-	return nil
+	return messages.SyncEntryApplied, conflict, nil
 }
 
 func createEntry(ctx *db.TxContext, entry *messages.SyncEntry) error {
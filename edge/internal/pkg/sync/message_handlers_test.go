@@ -10,6 +10,7 @@ import (
 	"devais.it/kronos/internal/pkg/util"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/suite"
+	"gopkg.in/guregu/null.v4"
 	"gorm.io/gorm"
 	"testing"
 )
@@ -18,6 +19,13 @@ const (
 	mbTest = "TEST"
 )
 
+// serverWins is the default conflicts policy, without conflicts detection
+var serverWins = conflictsPolicy{strategy: types.ConflictStrategyServerWins}
+
+func withStrategy(strategy types.ConflictStrategy) conflictsPolicy {
+	return conflictsPolicy{strategy: strategy}
+}
+
 type MessageHandlersSuite struct {
 	db.SuiteBase
 }
@@ -69,18 +77,18 @@ func (s *MessageHandlersSuite) TestSyncEntry() {
 
 	// Test error
 	entry.Action = "FAKE"
-	err := syncEntry(ctx, entry)
+	_, _, err := syncEntry(ctx, entry, serverWins)
 	assert.ErrorIs(err, ErrInvalidAction)
 
 	entry.Action = messages.SyncActionCreate
 	entry.EntityType = "FAKE"
 
-	err = syncEntry(ctx, entry)
+	_, _, err = syncEntry(ctx, entry, serverWins)
 	assert.ErrorIs(err, ErrInvalidEntityType)
 
 	entry.EntityType = types.EntityTypeItem
 
-	err = syncEntry(ctx, entry)
+	_, _, err = syncEntry(ctx, entry, serverWins)
 	assert.NoError(err)
 
 	count, err := services.GetItemsCount()
@@ -103,7 +111,7 @@ func (s *MessageHandlersSuite) TestSyncEntry() {
 	entry.EntityType = types.EntityTypeAttribute
 	entry.Payload = s.Marshal(attribute)
 
-	err = syncEntry(ctx, entry)
+	_, _, err = syncEntry(ctx, entry, serverWins)
 	assert.NoError(err)
 
 	count, err = services.GetAttributesCount()
@@ -123,12 +131,12 @@ func (s *MessageHandlersSuite) TestSyncEntry() {
 		Action:     messages.SyncActionCreate,
 	}
 
-	err = syncEntry(ctx, entry)
+	_, _, err = syncEntry(ctx, entry, serverWins)
 	assert.Error(err)
 
 	entry.EntityID = (&models.Relation{ParentID: parent.ID, ChildID: child.ID}).CompositeID()
 
-	err = syncEntry(ctx, entry)
+	_, _, err = syncEntry(ctx, entry, serverWins)
 	assert.NoError(err)
 
 	count, err = services.GetRelationsCount()
@@ -145,7 +153,7 @@ func (s *MessageHandlersSuite) TestSyncEntry() {
 		Payload:    map[string]interface{}{"name": newName},
 	}
 
-	err = syncEntry(ctx, entry)
+	_, _, err = syncEntry(ctx, entry, serverWins)
 	assert.NoError(err)
 
 	updatedItem, err := services.GetItemByID(item.ID)
@@ -162,7 +170,7 @@ func (s *MessageHandlersSuite) TestSyncEntry() {
 		Payload:    map[string]interface{}{"name": newName},
 	}
 
-	err = syncEntry(ctx, entry)
+	_, _, err = syncEntry(ctx, entry, serverWins)
 	assert.NoError(err)
 
 	updatedAttribute, err := services.GetAttributeByID(attribute.ID)
@@ -170,6 +178,63 @@ func (s *MessageHandlersSuite) TestSyncEntry() {
 	assert.Equal(newName, updatedAttribute.Name)
 	assert.Equal(constants.ModifiedBySyncName, updatedAttribute.ModifiedBy)
 
+	relationID := (&models.Relation{ParentID: parent.ID, ChildID: child.ID}).CompositeID()
+
+	entry = &messages.SyncEntry{
+		EntityID:   relationID,
+		EntityType: types.EntityTypeRelation,
+		Action:     messages.SyncActionUpdate,
+		Version:    "RelationVersion",
+		Payload:    map[string]interface{}{"sync_policy": "TEST_POLICY"},
+	}
+
+	_, _, err = syncEntry(ctx, entry, serverWins)
+	assert.NoError(err)
+
+	updatedRelation, err := services.GetRelation(parent.ID, child.ID, "")
+	assert.NoError(err)
+	assert.Equal("TEST_POLICY", updatedRelation.SyncPolicy.ValueOrZero())
+	assert.Equal("RelationVersion", updatedRelation.Version)
+	assert.Equal("RelationVersion", updatedRelation.SyncVersion.ValueOrZero())
+	assert.Equal(constants.ModifiedBySyncName, updatedRelation.ModifiedBy)
+
+	// Typed relations are identified by the type in their composite ID
+	typedID := (&models.Relation{ParentID: parent.ID, ChildID: child.ID, Type: "monitored_by"}).CompositeID()
+
+	entry = &messages.SyncEntry{
+		EntityID:   typedID,
+		EntityType: types.EntityTypeRelation,
+		Action:     messages.SyncActionUpdate,
+		Version:    "TypedVersion",
+		Payload: map[string]interface{}{
+			"properties": map[string]interface{}{"interval": 30},
+			"sort_index": 2,
+		},
+	}
+
+	_, _, err = syncEntry(ctx, entry, serverWins)
+	assert.NoError(err)
+
+	typedRelation, err := services.GetRelation(parent.ID, child.ID, "monitored_by")
+	assert.NoError(err)
+	assert.Equal(30.0, typedRelation.Properties["interval"])
+	assert.Equal(2, typedRelation.SortIndex)
+	assert.Equal("TypedVersion", typedRelation.Version)
+
+	entry = &messages.SyncEntry{
+		EntityID:   typedID,
+		EntityType: types.EntityTypeRelation,
+		Action:     messages.SyncActionDelete,
+	}
+
+	_, _, err = syncEntry(ctx, entry, serverWins)
+	assert.NoError(err)
+
+	_, err = services.GetRelation(parent.ID, child.ID, "monitored_by")
+	assert.ErrorIs(err, gorm.ErrRecordNotFound)
+	_, err = services.GetRelation(parent.ID, child.ID, "")
+	assert.NoError(err)
+
 	// Test upsert
 	item2 := s.newItem()
 
@@ -180,7 +245,7 @@ func (s *MessageHandlersSuite) TestSyncEntry() {
 		Payload:    s.Marshal(item2),
 	}
 
-	err = syncEntry(ctx, entry)
+	_, _, err = syncEntry(ctx, entry, serverWins)
 	assert.NoError(err)
 
 	createdItem, err = services.GetItemByID(item2.ID)
@@ -198,7 +263,7 @@ func (s *MessageHandlersSuite) TestSyncEntry() {
 		Action:     messages.SyncActionDelete,
 	}
 
-	err = syncEntry(ctx, entry)
+	_, _, err = syncEntry(ctx, entry, serverWins)
 	assert.NoError(err)
 
 	_, err = services.GetAttributeByID(attribute.ID)
@@ -210,14 +275,14 @@ func (s *MessageHandlersSuite) TestSyncEntry() {
 		Action:     messages.SyncActionDelete,
 	}
 
-	err = syncEntry(ctx, entry)
+	_, _, err = syncEntry(ctx, entry, serverWins)
 	// Should not give any error when the entity to delete
 	// doesn't exist
 	assert.NoError(err)
 
 	entry.EntityID = item.ID
 
-	err = syncEntry(ctx, entry)
+	_, _, err = syncEntry(ctx, entry, serverWins)
 	assert.NoError(err)
 
 	entry = &messages.SyncEntry{
@@ -225,7 +290,7 @@ func (s *MessageHandlersSuite) TestSyncEntry() {
 		EntityType: types.EntityTypeRelation,
 		Action:     messages.SyncActionDelete,
 	}
-	err = syncEntry(ctx, entry)
+	_, _, err = syncEntry(ctx, entry, serverWins)
 	assert.NoError(err)
 	assert.NoError(services.DeleteItem(parent, mbTest))
 	assert.NoError(services.DeleteItem(child, mbTest))
@@ -243,6 +308,349 @@ func (s *MessageHandlersSuite) TestSyncEntry() {
 	assert.Equal(int64(0), count)
 }
 
+func (s *MessageHandlersSuite) TestSyncAck() {
+	assert := s.Require()
+
+	worker := &Worker{conf: testSyncConfig()}
+
+	existingItem := s.newItem()
+	assert.NoError(services.CreateItem(existingItem, mbTest))
+
+	existingVersion, err := services.GetItemVersion(existingItem.ID)
+	assert.NoError(err)
+
+	dontSyncItem := s.newItem()
+	dontSyncItem.SyncPolicy = null.StringFrom(constants.SyncPolicyDontSync)
+	assert.NoError(services.CreateItem(dontSyncItem, mbTest))
+
+	newItem := s.newItem()
+
+	// Committed message
+	ack, err := worker.handleSyncMessage(messages.Sync{
+		{
+			EntityType: types.EntityTypeItem,
+			EntityID:   existingItem.ID,
+			Version:    existingVersion,
+			Action:     messages.SyncActionUpdate,
+			Payload:    map[string]interface{}{"name": "NewName"},
+		},
+		{
+			EntityType: types.EntityTypeItem,
+			EntityID:   dontSyncItem.ID,
+			Action:     messages.SyncActionUpdate,
+			Payload:    map[string]interface{}{"name": "NewName"},
+		},
+		{
+			EntityType: types.EntityTypeItem,
+			EntityID:   newItem.ID,
+			Version:    "NewVersion",
+			Action:     messages.SyncActionCreate,
+			Payload:    s.Marshal(newItem),
+		},
+	})
+	assert.NoError(err)
+	assert.True(ack.Committed)
+	assert.NotZero(ack.Timestamp)
+	assert.Len(ack.Entries, 3)
+
+	assert.Equal(messages.SyncEntrySkippedSameVersion, ack.Entries[0].Status)
+	assert.Equal(existingItem.ID, ack.Entries[0].EntityID)
+	assert.Equal(existingVersion, ack.Entries[0].Version)
+	assert.Equal(messages.SyncEntrySkippedDontSync, ack.Entries[1].Status)
+	assert.Equal(messages.SyncEntryApplied, ack.Entries[2].Status)
+	assert.Equal(messages.SyncActionCreate, ack.Entries[2].Action)
+	assert.Empty(ack.Entries[2].Error)
+
+	_, err = services.GetItemByID(newItem.ID)
+	assert.NoError(err)
+
+	// Rolled back message
+	anotherItem := s.newItem()
+	duplicateItem := s.newItem()
+	duplicateItem.ID = newItem.ID
+
+	ack, err = worker.handleSyncMessage(messages.Sync{
+		{
+			EntityType: types.EntityTypeItem,
+			EntityID:   anotherItem.ID,
+			Action:     messages.SyncActionCreate,
+			Payload:    s.Marshal(anotherItem),
+		},
+		{
+			EntityType: types.EntityTypeItem,
+			EntityID:   duplicateItem.ID,
+			Action:     messages.SyncActionCreate,
+			Payload:    s.Marshal(duplicateItem),
+		},
+		{
+			EntityType: "FAKE",
+			EntityID:   "FakeID",
+			Action:     messages.SyncActionCreate,
+		},
+	})
+	assert.Error(err)
+	assert.False(ack.Committed)
+	assert.Len(ack.Entries, 3)
+
+	assert.Equal(messages.SyncEntryRolledBack, ack.Entries[0].Status)
+	assert.Equal(messages.SyncEntryFailed, ack.Entries[1].Status)
+	assert.Contains(ack.Entries[1].Error, "UNIQUE")
+	assert.Equal(messages.SyncEntryFailed, ack.Entries[2].Status)
+	assert.NotEmpty(ack.Entries[2].Error)
+
+	_, err = services.GetItemByID(anotherItem.ID)
+	assert.ErrorIs(err, gorm.ErrRecordNotFound)
+
+	// Unknown entries fail the message, as single entries do
+	ack, err = worker.handleSyncMessage(messages.Sync{
+		{
+			EntityType: types.EntityTypeItem,
+			EntityID:   anotherItem.ID,
+			Action:     messages.SyncActionCreate,
+			Payload:    s.Marshal(anotherItem),
+		},
+		{
+			EntityType: types.EntityTypeItem,
+			EntityID:   anotherItem.ID,
+			Action:     "FAKE",
+		},
+	})
+	assert.Error(err)
+	assert.False(ack.Committed)
+	assert.Equal(messages.SyncEntryRolledBack, ack.Entries[0].Status)
+	assert.Equal(messages.SyncEntryFailed, ack.Entries[1].Status)
+	assert.Contains(ack.Entries[1].Error, ErrInvalidAction.Error())
+
+	_, err = services.GetItemByID(anotherItem.ID)
+	assert.ErrorIs(err, gorm.ErrRecordNotFound)
+
+	// Single entry
+	ack, err = worker.handleSyncMessage(messages.Sync{
+		{
+			EntityType: types.EntityTypeItem,
+			EntityID:   duplicateItem.ID,
+			Action:     messages.SyncActionCreate,
+			Payload:    s.Marshal(duplicateItem),
+		},
+	})
+	assert.Error(err)
+	assert.False(ack.Committed)
+	assert.Equal(messages.SyncEntryFailed, ack.Entries[0].Status)
+
+	ack, err = worker.handleSyncMessage(messages.Sync{
+		{
+			EntityType: "FAKE",
+			EntityID:   "FakeID",
+			Action:     messages.SyncActionCreate,
+		},
+	})
+	assert.ErrorIs(err, ErrInvalidEntityType)
+	assert.False(ack.Committed)
+	assert.Equal(messages.SyncEntryFailed, ack.Entries[0].Status)
+}
+
+// newSyncedItem creates an item synchronized by the server
+// application and edits it locally
+func (s *MessageHandlersSuite) newSyncedItem(ctx *db.TxContext, edit bool) *models.Item {
+	assert := s.Require()
+
+	item := s.newItem()
+
+	status, conflict, err := syncEntry(ctx, &messages.SyncEntry{
+		EntityType: types.EntityTypeItem,
+		EntityID:   item.ID,
+		Version:    "Version-" + item.ID,
+		Action:     messages.SyncActionCreate,
+		Payload:    s.Marshal(item),
+	}, serverWins)
+	assert.NoError(err)
+	assert.Nil(conflict)
+	assert.Equal(messages.SyncEntryApplied, status)
+
+	if edit {
+		err = services.UpdateItem(map[string]interface{}{
+			"id":   item.ID,
+			"name": "LocalName-" + item.ID,
+		}, mbTest)
+		assert.NoError(err)
+	}
+
+	item, err = services.GetItemByID(item.ID)
+	assert.NoError(err)
+
+	return item
+}
+
+func (s *MessageHandlersSuite) TestConflicts() {
+	assert := s.Require()
+
+	ctx := &db.TxContext{Tx: db.DB()}
+
+	remoteEntry := func(item *models.Item, payload map[string]interface{}) *messages.SyncEntry {
+		return &messages.SyncEntry{
+			EntityType: types.EntityTypeItem,
+			EntityID:   item.ID,
+			Version:    "RemoteVersion-" + item.ID,
+			Action:     messages.SyncActionUpdate,
+			Payload:    payload,
+		}
+	}
+
+	// Local edits change version and are tracked
+	item := s.newSyncedItem(ctx, true)
+	assert.NotEqual(item.SyncVersion.String, item.Version)
+	assert.Equal("name", item.ChangedFields.String)
+
+	// No conflict without local edits
+	item = s.newSyncedItem(ctx, false)
+	status, conflict, err := syncEntry(ctx, remoteEntry(item, map[string]interface{}{
+		"name": "RemoteName-" + item.ID,
+	}), withStrategy(types.ConflictStrategyEdgeWins))
+	assert.NoError(err)
+	assert.Nil(conflict)
+	assert.Equal(messages.SyncEntryApplied, status)
+
+	// No conflict for entities never synchronized
+	item = s.newItem()
+	assert.NoError(services.CreateItem(item, mbTest))
+	assert.NoError(services.UpdateItem(map[string]interface{}{"id": item.ID, "type": "LocalType"}, mbTest))
+	_, conflict, err = syncEntry(ctx, remoteEntry(item, map[string]interface{}{
+		"type": "RemoteType",
+	}), withStrategy(types.ConflictStrategyEdgeWins))
+	assert.NoError(err)
+	assert.Nil(conflict)
+
+	// Conflicts aren't detected by the default policy
+	item = s.newSyncedItem(ctx, true)
+	status, conflict, err = syncEntry(ctx, remoteEntry(item, map[string]interface{}{
+		"name": "RemoteName-" + item.ID,
+	}), serverWins)
+	assert.NoError(err)
+	assert.Nil(conflict)
+	assert.Equal(messages.SyncEntryApplied, status)
+
+	conflicts, err := services.GetEntityConflicts(types.EntityTypeItem, item.ID)
+	assert.NoError(err)
+	assert.Empty(conflicts)
+
+	// Server wins
+	item = s.newSyncedItem(ctx, true)
+	entry := remoteEntry(item, map[string]interface{}{
+		"name": "RemoteName-" + item.ID,
+	})
+	status, conflict, err = syncEntry(ctx, entry, conflictsPolicy{strategy: types.ConflictStrategyServerWins, record: true})
+	assert.NoError(err)
+	assert.NotNil(conflict)
+	assert.Equal(messages.SyncEntryApplied, status)
+	assert.Equal(types.ConflictResolutionServer, conflict.Resolution)
+	assert.Equal(item.Version, conflict.LocalVersion)
+	assert.Equal(item.SyncVersion.String, conflict.SyncVersion)
+	assert.Equal(entry.Version, conflict.RemoteVersion)
+
+	updatedItem, err := services.GetItemByID(item.ID)
+	assert.NoError(err)
+	assert.Equal("RemoteName-"+item.ID, updatedItem.Name)
+	assert.Equal(entry.Version, updatedItem.Version)
+	assert.Equal(entry.Version, updatedItem.SyncVersion.String)
+	assert.False(updatedItem.ChangedFields.Valid)
+
+	conflicts, err = services.GetEntityConflicts(types.EntityTypeItem, item.ID)
+	assert.NoError(err)
+	assert.Len(conflicts, 1)
+
+	_, err = services.GetEvent(db.DB(), types.EventEntityConflict, types.EntityTypeItem, item.ID)
+	assert.NoError(err)
+
+	// Edge wins
+	item = s.newSyncedItem(ctx, true)
+	status, conflict, err = syncEntry(ctx, remoteEntry(item, map[string]interface{}{
+		"name": "RemoteName-" + item.ID,
+	}), withStrategy(types.ConflictStrategyEdgeWins))
+	assert.NoError(err)
+	assert.Equal(messages.SyncEntrySkippedConflict, status)
+	assert.Equal(types.ConflictResolutionEdge, conflict.Resolution)
+
+	updatedItem, err = services.GetItemByID(item.ID)
+	assert.NoError(err)
+	assert.Equal(item.Name, updatedItem.Name)
+	assert.Equal(item.Version, updatedItem.Version)
+
+	// Last writer wins
+	item = s.newSyncedItem(ctx, true)
+	status, conflict, err = syncEntry(ctx, remoteEntry(item, map[string]interface{}{
+		"name":        "RemoteName-" + item.ID,
+		"modified_at": item.ModifiedAt - 1,
+	}), withStrategy(types.ConflictStrategyLastModifiedWins))
+	assert.NoError(err)
+	assert.Equal(messages.SyncEntrySkippedConflict, status)
+	assert.Equal(types.ConflictResolutionEdge, conflict.Resolution)
+
+	status, conflict, err = syncEntry(ctx, remoteEntry(item, map[string]interface{}{
+		"name":        "RemoteName-" + item.ID,
+		"modified_at": float64(item.ModifiedAt + 1),
+	}), withStrategy(types.ConflictStrategyLastModifiedWins))
+	assert.NoError(err)
+	assert.Equal(messages.SyncEntryApplied, status)
+	assert.Equal(types.ConflictResolutionServer, conflict.Resolution)
+
+	// Field level merge
+	item = s.newSyncedItem(ctx, true)
+	status, conflict, err = syncEntry(ctx, remoteEntry(item, map[string]interface{}{
+		"name": "RemoteName-" + item.ID,
+		"type": "RemoteType",
+	}), withStrategy(types.ConflictStrategyMergeFields))
+	assert.NoError(err)
+	assert.Equal(messages.SyncEntryApplied, status)
+	assert.Equal(types.ConflictResolutionMerged, conflict.Resolution)
+
+	assert.Equal("name", conflict.ChangedFields)
+
+	updatedItem, err = services.GetItemByID(item.ID)
+	assert.NoError(err)
+	assert.Equal(item.Name, updatedItem.Name)
+	assert.Equal("RemoteType", updatedItem.Type)
+	// The version matches the merged content, which still has local edits
+	assert.Equal("RemoteVersion-"+item.ID, updatedItem.SyncVersion.String)
+	assert.NotEqual(updatedItem.SyncVersion.String, updatedItem.Version)
+	assert.Equal("name", updatedItem.ChangedFields.String)
+
+	expectedItem := *updatedItem
+	assert.NoError(expectedItem.RefreshVersion(&expectedItem))
+	assert.Equal(expectedItem.Version, updatedItem.Version)
+}
+
+func (s *MessageHandlersSuite) TestConflictsRetention() {
+	assert := s.Require()
+
+	ctx := &db.TxContext{Tx: db.DB()}
+	policy := conflictsPolicy{strategy: types.ConflictStrategyEdgeWins, maxConflicts: 2}
+
+	var items []*models.Item
+	for i := 0; i < 3; i++ {
+		item := s.newSyncedItem(ctx, true)
+		items = append(items, item)
+
+		_, conflict, err := syncEntry(ctx, &messages.SyncEntry{
+			EntityType: types.EntityTypeItem,
+			EntityID:   item.ID,
+			Version:    "RemoteVersion-" + item.ID,
+			Action:     messages.SyncActionUpdate,
+			Payload:    map[string]interface{}{"name": "RemoteName-" + item.ID},
+		}, policy)
+		assert.NoError(err)
+		assert.NotNil(conflict)
+	}
+
+	count, err := services.GetConflictsCount()
+	assert.NoError(err)
+	assert.Equal(int64(2), count)
+
+	// The oldest conflict is deleted
+	conflicts, err := services.GetEntityConflicts(types.EntityTypeItem, items[0].ID)
+	assert.NoError(err)
+	assert.Empty(conflicts)
+}
+
 func TestMessageHandlers(t *testing.T) {
 	suite.Run(t, new(MessageHandlersSuite))
 }
@@ -9,6 +9,15 @@ const (
 	CommandGetAllVersions CommandType = "GET_ALL_VERSIONS"
 	CommandGetEntity      CommandType = "GET_ENTITY"
 	CommandGetTelemetry   CommandType = "GET_TELEMETRY"
+	CommandGetSubtree     CommandType = "GET_SUBTREE"
+	CommandGetItemsByType CommandType = "GET_ITEMS_BY_TYPE"
+	CommandGetRelations   CommandType = "GET_RELATIONS"
+	CommandApplyTx        CommandType = "APPLY_TRANSACTION"
+	CommandSetSchema      CommandType = "SET_SCHEMA"
+
+	CommandGetBucketHashes   CommandType = "GET_BUCKET_HASHES"
+	CommandGetBucketVersions CommandType = "GET_BUCKET_VERSIONS"
+	CommandReconciled        CommandType = "RECONCILIATION_DONE"
 )
 
 type ServerCommand struct {
@@ -11,6 +11,10 @@ type Event struct {
 	TxType      types.EventType        `json:"tx_type"`
 	TxLen       int                    `json:"tx_len,omitempty"`
 	TxIndex     int                    `json:"tx_index"`
+	TxPart      int                    `json:"tx_part,omitempty"`
+	TxParts     int                    `json:"tx_parts,omitempty"`
 	Timestamp   uint64                 `json:"timestamp"`
 	Body        map[string]interface{} `json:"body,omitempty"`
+	BatchID     string                 `json:"batch_id,omitempty"`
+	DedupKey    string                 `json:"dedup_key,omitempty"`
 }
@@ -0,0 +1,10 @@
+package messages
+
+// EventsAck is the acknowledgement of a batch of events sent by the
+// server application, when delivery acknowledgements are enabled.
+// A failed acknowledgement makes the batch events to be delivered again.
+type EventsAck struct {
+	BatchID string `json:"batch_id"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
@@ -0,0 +1,24 @@
+package messages
+
+type FrameType string
+
+const (
+	FrameConnected       FrameType = "CONNECTED"
+	FrameDisconnected    FrameType = "DISCONNECTED"
+	FrameVersions        FrameType = "VERSIONS"
+	FrameVersionsDigest  FrameType = "VERSIONS_DIGEST"
+	FrameEvents          FrameType = "EVENTS"
+	FrameEventsAck       FrameType = "EVENTS_ACK"
+	FrameSync            FrameType = "SYNC"
+	FrameSyncAck         FrameType = "SYNC_ACK"
+	FrameCommand         FrameType = "COMMAND"
+	FrameCommandResponse FrameType = "COMMAND_RESPONSE"
+)
+
+// Frame is the envelope used by transports without topics, like WebSocket.
+// Every message exchanged on the same channel is wrapped in a Frame, and
+// its Type determines how the Payload should be decoded.
+type Frame struct {
+	Type    FrameType   `json:"type"`
+	Payload interface{} `json:"payload"`
+}
@@ -0,0 +1,46 @@
+package messages
+
+import "devais.it/kronos/internal/pkg/types"
+
+// EntityDigest is the root of the versions hash tree of a single entity type
+type EntityDigest struct {
+	Count int64  `json:"count"`
+	Root  string `json:"root"`
+}
+
+// VersionsDigest is published in place of the full versions dump when
+// reconciliation is enabled.
+// The server application compares the roots with its own and requests
+// the bucket hashes of the divergent entity types only.
+type VersionsDigest struct {
+	Timestamp uint64                            `json:"timestamp"`
+	Buckets   int                               `json:"buckets"`
+	Digests   map[types.EntityType]EntityDigest `json:"digests"`
+}
+
+// BucketsParams are the parameters of the GET_BUCKET_VERSIONS command
+type BucketsParams struct {
+	Buckets []int `json:"buckets"`
+	// Page is the single page to return, starting from 1.
+	// If 0, all pages are returned, each in its own response.
+	Page int `json:"page,omitempty"`
+}
+
+// BucketHashes is the response to the GET_BUCKET_HASHES command.
+// Hashes are indexed by bucket.
+type BucketHashes struct {
+	EntityType types.EntityType `json:"entity_type"`
+	Hashes     []string         `json:"hashes"`
+}
+
+// BucketVersions is the response to the GET_BUCKET_VERSIONS command,
+// containing a page of the versions of the entities in the requested buckets.
+// When the versions don't fit in a single message, multiple responses
+// with the same UUID are sent.
+type BucketVersions struct {
+	EntityType types.EntityType `json:"entity_type"`
+	Buckets    []int            `json:"buckets"`
+	Page       int              `json:"page"`
+	Pages      int              `json:"pages"`
+	Versions   EntityVersions   `json:"versions"`
+}
@@ -0,0 +1,24 @@
+package messages
+
+import "devais.it/kronos/internal/pkg/db/models"
+
+// SnapshotParams are the parameters of snapshot commands, read from
+// the ServerCommand body.
+type SnapshotParams struct {
+	// Type is the item type requested by GET_ITEMS_BY_TYPE commands
+	Type string `json:"type,omitempty"`
+	// Page is the single page to return, starting from 1.
+	// If 0, all pages are returned, each in its own response.
+	Page int `json:"page,omitempty"`
+}
+
+// Snapshot is a page of entities dumped by a snapshot command.
+// It is sent as body of a CommandResponse: when a snapshot doesn't fit
+// in a single message, multiple responses with the same UUID are sent.
+type Snapshot struct {
+	Page       int                `json:"page"`
+	Pages      int                `json:"pages"`
+	Items      []models.Item      `json:"items,omitempty"`
+	Attributes []models.Attribute `json:"attributes,omitempty"`
+	Relations  []models.Relation  `json:"relations,omitempty"`
+}
@@ -0,0 +1,42 @@
+package messages
+
+import "devais.it/kronos/internal/pkg/types"
+
+type SyncEntryStatus string
+
+const (
+	// SyncEntryApplied means that the entry was applied and committed
+	SyncEntryApplied SyncEntryStatus = "APPLIED"
+	// SyncEntrySkippedSameVersion means that the local entity is already at the entry version
+	SyncEntrySkippedSameVersion SyncEntryStatus = "SKIPPED_SAME_VERSION"
+	// SyncEntrySkippedDontSync means that the local entity has the DONT_SYNC policy
+	SyncEntrySkippedDontSync SyncEntryStatus = "SKIPPED_DONT_SYNC"
+	// SyncEntrySkippedConflict means that the entry conflicts with local edits,
+	// which were kept by the conflict strategy
+	SyncEntrySkippedConflict SyncEntryStatus = "SKIPPED_CONFLICT"
+	// SyncEntryFailed means that the entry couldn't be applied
+	SyncEntryFailed SyncEntryStatus = "FAILED"
+	// SyncEntryRolledBack means that the entry was applied, but it was rolled back
+	// because other entries of the same sync message failed
+	SyncEntryRolledBack SyncEntryStatus = "ROLLED_BACK"
+)
+
+// SyncAckEntry is the outcome of a single SyncEntry
+type SyncAckEntry struct {
+	EntityType types.EntityType `json:"entity_type"`
+	EntityID   string           `json:"entity_id"`
+	Version    string           `json:"version"`
+	Action     SyncAction       `json:"action"`
+	Status     SyncEntryStatus  `json:"status"`
+	Error      string           `json:"error,omitempty"`
+	// Conflict is the resolution of a conflict with local edits, if any
+	Conflict types.ConflictResolution `json:"conflict,omitempty"`
+}
+
+// SyncAck acknowledges a Sync message, reporting the outcome of each entry
+// in the same order they were received.
+type SyncAck struct {
+	Timestamp uint64         `json:"timestamp"`
+	Committed bool           `json:"committed"`
+	Entries   []SyncAckEntry `json:"entries"`
+}
@@ -0,0 +1,66 @@
+package messages
+
+import (
+	"devais.it/kronos/internal/pkg/db/models"
+	"devais.it/kronos/internal/pkg/types"
+)
+
+// TransactionCreate contains entities to create in a transaction
+type TransactionCreate struct {
+	Items      []models.Item      `json:"items,omitempty"`
+	Attributes []models.Attribute `json:"attributes,omitempty"`
+	Relations  []models.Relation  `json:"relations,omitempty"`
+}
+
+// TransactionUpdate contains patches of entities to update in a transaction.
+// Each patch must contain the ID of the entity to update.
+type TransactionUpdate struct {
+	Items      []map[string]interface{} `json:"items,omitempty"`
+	Attributes []map[string]interface{} `json:"attributes,omitempty"`
+	Relations  []map[string]interface{} `json:"relations,omitempty"`
+}
+
+// TransactionDelete contains IDs of entities to delete in a transaction.
+// Relations are identified by their composite ID.
+type TransactionDelete struct {
+	Items      []string `json:"items,omitempty"`
+	Attributes []string `json:"attributes,omitempty"`
+	Relations  []string `json:"relations,omitempty"`
+}
+
+// ApplyTransaction is the body of an APPLY_TRANSACTION server command.
+// Creates, updates and deletes are applied in this order inside a single
+// database transaction.
+type ApplyTransaction struct {
+	Create TransactionCreate `json:"create"`
+	Update TransactionUpdate `json:"update"`
+	Delete TransactionDelete `json:"delete"`
+}
+
+type EntityResultStatus string
+
+const (
+	// EntityResultApplied means that the operation was applied and committed
+	EntityResultApplied EntityResultStatus = "APPLIED"
+	// EntityResultFailed means that the operation failed
+	EntityResultFailed EntityResultStatus = "FAILED"
+	// EntityResultRolledBack means that the operation succeeded, but it was
+	// rolled back because other operations of the same transaction failed
+	EntityResultRolledBack EntityResultStatus = "ROLLED_BACK"
+)
+
+// EntityResult is the result of a single operation of a transaction
+type EntityResult struct {
+	EntityType types.EntityType   `json:"entity_type"`
+	EntityID   string             `json:"entity_id"`
+	Action     SyncAction         `json:"action"`
+	Status     EntityResultStatus `json:"status"`
+	Error      string             `json:"error,omitempty"`
+}
+
+// TransactionResult is the body of the response to an APPLY_TRANSACTION command
+type TransactionResult struct {
+	TxUUID    string         `json:"tx_uuid"`
+	Committed bool           `json:"committed"`
+	Results   []EntityResult `json:"results"`
+}
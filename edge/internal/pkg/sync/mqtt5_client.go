@@ -0,0 +1,559 @@
+package sync
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"devais.it/kronos/internal/pkg/config"
+	"devais.it/kronos/internal/pkg/logging"
+	"devais.it/kronos/internal/pkg/serialization"
+	"devais.it/kronos/internal/pkg/sync/messages"
+	"devais.it/kronos/internal/pkg/util"
+	"github.com/eclipse/paho.golang/packets"
+	"github.com/eclipse/paho.golang/paho"
+	"github.com/google/uuid"
+	"github.com/rotisserie/eris"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// mqtt5ReasonFailure is the lowest MQTT v5 reason code reporting a failure
+	mqtt5ReasonFailure = 0x80
+
+	// mqtt5CompressedContentType is the content type of compressed payloads
+	mqtt5CompressedContentType = "application/octet-stream"
+
+	mqtt5UserPropertyDeviceID    = "device_id"
+	mqtt5UserPropertyMessageType = "message_type"
+)
+
+// commandResponseTarget is where the response to a server command
+// must be published, as requested by the command itself
+type commandResponseTarget struct {
+	topic           string
+	correlationData []byte
+}
+
+// MQTT5Client is a synchronization client communicating with the server
+// application through an MQTT v5 broker.
+// Unlike MQTTClient, command responses are published on the response topic
+// of each command along with its correlation data, and published messages
+// carry their content type, message type and device ID as properties.
+type MQTT5Client struct {
+	*mqttBase
+	clientID  string
+	tlsConfig *tls.Config
+	router    *paho.StandardRouter
+
+	// Mutex for client and responseTargets
+	mu     sync.Mutex
+	client *paho.Client
+	// responseTargets maps the UUIDs of received commands to their response target
+	responseTargets map[string]commandResponseTarget
+}
+
+func NewMQTT5Client(syncConf *config.SyncConfig) (*MQTT5Client, error) {
+	base, err := newMQTTBase(syncConf)
+	if err != nil {
+		return nil, err
+	}
+	conf := base.conf
+
+	switch conf.Scheme {
+	case "tcp", "ssl", "tls":
+	default:
+		return nil, eris.Errorf("Unsupported MQTT v5 scheme: '%s'", conf.Scheme)
+	}
+
+	clientID := conf.ClientID
+	if conf.RandomizeClientID {
+		// Append a new UUID to ClientID
+		clientID = clientID + "-" + uuid.NewString()
+	}
+
+	tlsConfig, err := conf.TLS.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &MQTT5Client{
+		mqttBase:        base,
+		clientID:        clientID,
+		tlsConfig:       tlsConfig,
+		router:          paho.NewStandardRouter(),
+		responseTargets: map[string]commandResponseTarget{},
+	}
+
+	if log.IsLevelEnabled(log.DebugLevel) {
+		fields := log.Fields{
+			"url":                  conf.URL(),
+			"clientID":             clientID,
+			"cleanSession":         conf.CleanSession,
+			"sessionExpiry":        conf.SessionExpiry,
+			"messageExpiry":        conf.MessageExpiry,
+			"keepAlive":            conf.KeepAlive,
+			"orderMatters":         conf.OrderMatters,
+			"communicationTimeout": conf.CommunicationTimeout,
+		}
+		log.WithFields(fields).Debug("MQTT v5 client options")
+	}
+
+	return c, nil
+}
+
+//=============================================================================
+// Client interface implementation
+//=============================================================================
+
+func (c *MQTT5Client) Connect() error {
+	conn, err := c.dial()
+	if err != nil {
+		return eris.Wrap(err, "failed to dial the MQTT broker")
+	}
+
+	var client *paho.Client
+	client = paho.NewClient(paho.ClientConfig{
+		Conn:          packets.NewThreadSafeConn(conn),
+		Router:        c.router,
+		PacketTimeout: c.conf.CommunicationTimeout,
+		OnClientError: func(err error) {
+			c.connectionLost(client, err)
+		},
+		OnServerDisconnect: func(disconnect *paho.Disconnect) {
+			c.connectionLost(client, eris.Errorf("disconnected by the broker with reason code %d", disconnect.ReasonCode))
+		},
+	})
+	if c.conf.EnablePahoLogging {
+		client.SetDebugLogger(logging.NewPahoLogger(log.DebugLevel))
+		client.SetErrorLogger(logging.NewPahoLogger(log.ErrorLevel))
+	}
+
+	connect, err := c.connectPacket()
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+
+	ctx, cancel := c.context()
+	defer cancel()
+
+	connack, err := client.Connect(ctx, connect)
+	if err != nil {
+		if connack != nil {
+			return eris.Wrapf(err, "connection refused with reason code %d", connack.ReasonCode)
+		}
+		return eris.Wrap(err, "failed to connect to the MQTT broker")
+	}
+
+	c.mu.Lock()
+	c.client = client
+	c.mu.Unlock()
+
+	connectedMsg, err := newConnectedMessage(c.syncConf, c.deviceID())
+	if err != nil {
+		return err
+	}
+
+	err = c.publishConnected(connectedMsg)
+	if err != nil {
+		return err
+	}
+
+	if c.connectionCb != nil {
+		c.connectionCb()
+	}
+	return nil
+}
+
+func (c *MQTT5Client) Disconnect() error {
+	if c.syncConf.NotifyGracefulDisconnect {
+		// Try to send disconnect message
+		ts := util.TimestampMs()
+		msg := &messages.Disconnected{
+			DeviceID:  c.deviceID(),
+			Timestamp: &ts,
+		}
+		err := c.publishDisconnect(msg)
+		if err != nil {
+			logging.Error(err, "Failed to publish disconnection message")
+		} else {
+			log.Info("Disconnection message published")
+		}
+	}
+
+	// Forget the client first, so its closing is not reported as a connection loss
+	c.mu.Lock()
+	client := c.client
+	c.client = nil
+	c.mu.Unlock()
+
+	if client != nil {
+		// A normal disconnection makes the broker discard the will message
+		err := client.Disconnect(&paho.Disconnect{ReasonCode: 0})
+		if err != nil {
+			logging.Error(err, "Failed to send MQTT disconnect packet")
+		}
+	}
+
+	return nil
+}
+
+func (c *MQTT5Client) Subscribe() error {
+	syncTopicGlobal, err := c.baseEnv.EscapeStringVariables(c.conf.SyncTopicGlobal)
+	if err != nil {
+		return err
+	}
+
+	syncTopicSpecific, err := c.baseEnv.EscapeStringVariables(c.conf.SyncTopicSpecific)
+	if err != nil {
+		return err
+	}
+
+	commandsTopic, err := c.baseEnv.EscapeStringVariables(c.conf.CommandsTopic)
+	if err != nil {
+		return err
+	}
+
+	handlers := map[string]paho.MessageHandler{
+		syncTopicGlobal: func(message *paho.Publish) {
+			c.handleSync(message.Payload)
+		},
+		syncTopicSpecific: func(message *paho.Publish) {
+			c.handleSync(message.Payload)
+		},
+		commandsTopic: c.handleCommand,
+	}
+
+	if c.syncConf.Delivery.AckEnabled {
+		eventsAckTopic, err := c.baseEnv.EscapeStringVariables(c.conf.EventsAckTopic)
+		if err != nil {
+			return err
+		}
+
+		handlers[eventsAckTopic] = func(message *paho.Publish) {
+			c.handleEventsAck(message.Payload)
+		}
+	}
+
+	subscribe := &paho.Subscribe{
+		Subscriptions: make(map[string]paho.SubscribeOptions, len(handlers)),
+	}
+	for topic, handler := range handlers {
+		// Handlers registered by a previous connection are replaced
+		c.router.UnregisterHandler(topic)
+		c.router.RegisterHandler(topic, c.dispatch(handler))
+		subscribe.Subscriptions[topic] = paho.SubscribeOptions{QoS: c.conf.SubQoS}
+	}
+
+	client, err := c.currentClient()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := c.context()
+	defer cancel()
+
+	suback, err := client.Subscribe(ctx, subscribe)
+	if err != nil {
+		return err
+	}
+	for _, reason := range suback.Reasons {
+		if reason >= mqtt5ReasonFailure {
+			return eris.Errorf("subscription refused with reason code %d", reason)
+		}
+	}
+
+	return nil
+}
+
+func (c *MQTT5Client) PublishVersions() error {
+	connectedTopic, err := c.baseEnv.EscapeStringVariables(c.conf.ConnectedTopic)
+	if err != nil {
+		return err
+	}
+
+	versionsMessages, err := buildVersionsMessages(c.conf.MaxEntitiesPerMessage)
+	if err != nil {
+		return err
+	}
+
+	for _, msg := range versionsMessages {
+		err = c.publish(connectedTopic, messages.FrameVersions, msg, nil)
+		if err != nil {
+			return eris.Wrap(err, "failed to publish versions message")
+		}
+	}
+
+	return nil
+}
+
+func (c *MQTT5Client) PublishEvents(events []messages.Event) error {
+	topic, err := c.buildEventTopic()
+	if err != nil {
+		return eris.Wrap(err, "failed to build events topic")
+	}
+
+	if c.syncConf.Delivery.AckEnabled {
+		setDedupKeys(events, c.deviceID())
+	}
+
+	return c.publish(topic, messages.FrameEvents, events, nil)
+}
+
+// PublishCommandResponse publishes the response to a server command on the
+// response topic of the command, if it had one, or on CommandsResponseTopic.
+func (c *MQTT5Client) PublishCommandResponse(message *messages.CommandResponse) error {
+	c.mu.Lock()
+	target, ok := c.responseTargets[message.UUID]
+	delete(c.responseTargets, message.UUID)
+	c.mu.Unlock()
+
+	if !ok {
+		topic, err := c.buildCommandResponseTopic(message)
+		if err != nil {
+			return eris.Wrap(err, "failed to build command response topic")
+		}
+		target.topic = topic
+	}
+
+	return retry(c.syncConf, c.conf.MaxRetries, func() error {
+		return c.publish(target.topic, messages.FrameCommandResponse, message, target.correlationData)
+	})
+}
+
+func (c *MQTT5Client) PublishVersionsDigest(digest *messages.VersionsDigest) error {
+	topic, err := c.baseEnv.EscapeStringVariables(c.conf.VersionsDigestTopic)
+	if err != nil {
+		return eris.Wrap(err, "failed to build versions digest topic")
+	}
+
+	err = c.publish(topic, messages.FrameVersionsDigest, digest, nil)
+	if err != nil {
+		return eris.Wrap(err, "failed to publish versions digest message")
+	}
+	return nil
+}
+
+func (c *MQTT5Client) PublishSyncAck(message *messages.SyncAck) error {
+	topic, err := c.baseEnv.EscapeStringVariables(c.conf.SyncAckTopic)
+	if err != nil {
+		return eris.Wrap(err, "failed to build sync ack topic")
+	}
+
+	return retry(c.syncConf, c.conf.MaxRetries, func() error {
+		return c.publish(topic, messages.FrameSyncAck, message, nil)
+	})
+}
+
+func (c *MQTT5Client) publishConnected(message *messages.Connected) error {
+	topic, err := c.baseEnv.EscapeStringVariables(c.conf.ConnectedTopic)
+	if err != nil {
+		return eris.Wrap(err, "failed to build connected topic")
+	}
+	err = c.publish(topic, messages.FrameConnected, message, nil)
+	if err != nil {
+		return eris.Wrap(err, "failed to publish connected message")
+	}
+	return nil
+}
+
+func (c *MQTT5Client) publishDisconnect(message *messages.Disconnected) error {
+	topic, err := c.baseEnv.EscapeStringVariables(c.conf.DisconnectedTopic)
+	if err != nil {
+		return eris.Wrap(err, "failed to build disconnect topic")
+	}
+	err = c.publish(topic, messages.FrameDisconnected, message, nil)
+	if err != nil {
+		return eris.Wrap(err, "failed to publish disconnect message")
+	}
+	return nil
+}
+
+//=============================================================================
+// Utilities
+//=============================================================================
+
+// context creates the context of a single operation with the broker,
+// expiring after the configured communication timeout
+func (c *MQTT5Client) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), c.conf.CommunicationTimeout)
+}
+
+// dial opens the network connection to the broker
+func (c *MQTT5Client) dial() (net.Conn, error) {
+	address := net.JoinHostPort(c.conf.Host, strconv.Itoa(c.conf.Port))
+	dialer := &net.Dialer{Timeout: c.conf.CommunicationTimeout}
+
+	if c.conf.Scheme == "tcp" {
+		return dialer.Dial("tcp", address)
+	}
+
+	tlsConfig := c.tlsConfig
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+	return tls.DialWithDialer(dialer, "tcp", address, tlsConfig)
+}
+
+// connectPacket builds the MQTT connect packet, including the last will if enabled
+func (c *MQTT5Client) connectPacket() (*paho.Connect, error) {
+	conf := c.conf
+
+	connect := &paho.Connect{
+		ClientID:   c.clientID,
+		KeepAlive:  uint16(conf.KeepAlive.Seconds()),
+		CleanStart: conf.CleanSession,
+		Properties: &paho.ConnectProperties{
+			SessionExpiryInterval: durationSeconds(conf.SessionExpiry),
+		},
+	}
+
+	if conf.Username != "" {
+		connect.Username = conf.Username
+		connect.UsernameFlag = true
+	}
+	if conf.Password != "" {
+		connect.Password = []byte(conf.Password)
+		connect.PasswordFlag = true
+	}
+
+	if conf.LastWillEnabled {
+		willTopic, willPayload, err := c.willMessage()
+		if err != nil {
+			return nil, err
+		}
+		connect.WillMessage = &paho.WillMessage{
+			Retain:  conf.PubRetained,
+			QoS:     conf.PubQoS,
+			Topic:   willTopic,
+			Payload: willPayload,
+		}
+		connect.WillProperties = &paho.WillProperties{
+			ContentType: conf.Serialization.Type.ContentType(),
+			User:        c.userProperties(messages.FrameDisconnected),
+		}
+	}
+
+	return connect, nil
+}
+
+// connectionLost notifies the loss of the connection of client,
+// unless it was already replaced or disconnected
+func (c *MQTT5Client) connectionLost(client *paho.Client, err error) {
+	c.mu.Lock()
+	current := c.client == client
+	if current {
+		c.client = nil
+	}
+	c.mu.Unlock()
+
+	if current && c.disconnectionCb != nil {
+		c.disconnectionCb(err)
+	}
+}
+
+// currentClient returns the connected Paho client, or ErrNotConnected
+func (c *MQTT5Client) currentClient() (*paho.Client, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.client == nil {
+		return nil, ErrNotConnected
+	}
+	return c.client, nil
+}
+
+// dispatch wraps a message handler so that, when message order does not
+// matter, messages are handled in their own goroutine as by MQTTClient
+func (c *MQTT5Client) dispatch(handler paho.MessageHandler) paho.MessageHandler {
+	if c.conf.OrderMatters {
+		return handler
+	}
+	return func(message *paho.Publish) {
+		go handler(message)
+	}
+}
+
+// handleCommand passes a server command to the command callback,
+// storing its response topic and correlation data, if any
+func (c *MQTT5Client) handleCommand(message *paho.Publish) {
+	command := c.decodeCommand(message.Payload)
+	if command == nil {
+		return
+	}
+
+	if message.Properties != nil && message.Properties.ResponseTopic != "" {
+		c.mu.Lock()
+		c.responseTargets[command.UUID] = commandResponseTarget{
+			topic:           message.Properties.ResponseTopic,
+			correlationData: message.Properties.CorrelationData,
+		}
+		c.mu.Unlock()
+	}
+
+	c.commandCb(command)
+}
+
+// userProperties builds the user properties attached to published messages
+func (c *MQTT5Client) userProperties(messageType messages.FrameType) paho.UserProperties {
+	return paho.UserProperties{
+		{Key: mqtt5UserPropertyDeviceID, Value: c.deviceID()},
+		{Key: mqtt5UserPropertyMessageType, Value: string(messageType)},
+	}
+}
+
+// publish serializes and publishes a message, waiting for its
+// acknowledgement when the publish QoS requires one
+func (c *MQTT5Client) publish(topic string, messageType messages.FrameType, message interface{}, correlationData []byte) error {
+	payload, err := c.serialize(messageType, message)
+	if err != nil {
+		return err
+	}
+
+	client, err := c.currentClient()
+	if err != nil {
+		return err
+	}
+
+	contentType := c.conf.Serialization.Type.ContentType()
+	if serialization.IsCompressed(payload) {
+		contentType = mqtt5CompressedContentType
+	}
+
+	ctx, cancel := c.context()
+	defer cancel()
+
+	response, err := client.Publish(ctx, &paho.Publish{
+		QoS:    c.conf.PubQoS,
+		Retain: c.conf.PubRetained,
+		Topic:  topic,
+		Properties: &paho.PublishProperties{
+			CorrelationData: correlationData,
+			ContentType:     contentType,
+			MessageExpiry:   durationSeconds(c.conf.MessageExpiry),
+			User:            c.userProperties(messageType),
+		},
+		Payload: payload,
+	})
+	if err != nil {
+		return err
+	}
+	if response != nil && response.ReasonCode >= mqtt5ReasonFailure {
+		return eris.Errorf("publish refused with reason code %d", response.ReasonCode)
+	}
+	return nil
+}
+
+// durationSeconds converts a duration to an MQTT v5 interval property,
+// which is omitted if the duration is 0
+func durationSeconds(d time.Duration) *uint32 {
+	if d <= 0 {
+		return nil
+	}
+	seconds := uint32(d.Seconds())
+	return &seconds
+}
@@ -0,0 +1,343 @@
+package sync
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"devais.it/kronos/internal/pkg/config"
+	"devais.it/kronos/internal/pkg/db"
+	"devais.it/kronos/internal/pkg/serialization"
+	"devais.it/kronos/internal/pkg/sync/messages"
+	"devais.it/kronos/internal/pkg/types"
+	"github.com/eclipse/paho.golang/packets"
+	"github.com/stretchr/testify/suite"
+)
+
+// fakeMQTT5Broker is an in-process MQTT v5 broker accepting a single
+// client, which records the messages published by the client
+type fakeMQTT5Broker struct {
+	sync.Mutex
+	listener   net.Listener
+	serializer *serialization.JSONSerializer
+	conn       net.Conn
+	connect    *packets.Connect
+	subscribed []string
+	published  []*packets.Publish
+}
+
+func newFakeMQTT5Broker() (*fakeMQTT5Broker, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	b := &fakeMQTT5Broker{
+		listener:   listener,
+		serializer: serialization.DefaultJSONSerializer(),
+	}
+	go b.accept()
+	return b, nil
+}
+
+func (b *fakeMQTT5Broker) port() int {
+	return b.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (b *fakeMQTT5Broker) accept() {
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		b.Lock()
+		b.conn = packets.NewThreadSafeConn(conn)
+		b.Unlock()
+
+		go b.handle(conn)
+	}
+}
+
+func (b *fakeMQTT5Broker) handle(conn net.Conn) {
+	for {
+		packet, err := packets.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+
+		var response *packets.ControlPacket
+
+		switch content := packet.Content.(type) {
+		case *packets.Connect:
+			b.Lock()
+			b.connect = content
+			b.Unlock()
+			response = packets.NewControlPacket(packets.CONNACK)
+		case *packets.Subscribe:
+			response = packets.NewControlPacket(packets.SUBACK)
+			suback := response.Content.(*packets.Suback)
+			suback.PacketID = content.PacketID
+			b.Lock()
+			for topic, options := range content.Subscriptions {
+				b.subscribed = append(b.subscribed, topic)
+				suback.Reasons = append(suback.Reasons, options.QoS)
+			}
+			b.Unlock()
+		case *packets.Publish:
+			b.Lock()
+			b.published = append(b.published, content)
+			b.Unlock()
+			if content.QoS == 1 {
+				response = packets.NewControlPacket(packets.PUBACK)
+				response.Content.(*packets.Puback).PacketID = content.PacketID
+			}
+		case *packets.Pingreq:
+			response = packets.NewControlPacket(packets.PINGRESP)
+		case *packets.Disconnect:
+			_ = conn.Close()
+			return
+		}
+
+		if response != nil {
+			b.Lock()
+			_, err = response.WriteTo(b.conn)
+			b.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// send publishes a message to the client
+func (b *fakeMQTT5Broker) send(topic string, message interface{}, properties *packets.Properties) error {
+	data, err := b.serializer.Serialize(message)
+	if err != nil {
+		return err
+	}
+
+	packet := packets.NewControlPacket(packets.PUBLISH)
+	publish := packet.Content.(*packets.Publish)
+	publish.Topic = topic
+	publish.Payload = data
+	if properties != nil {
+		publish.Properties = properties
+	}
+
+	b.Lock()
+	defer b.Unlock()
+	_, err = packet.WriteTo(b.conn)
+	return err
+}
+
+func (b *fakeMQTT5Broker) closeConn() error {
+	b.Lock()
+	defer b.Unlock()
+	return b.conn.Close()
+}
+
+func (b *fakeMQTT5Broker) publishedOn(topic string) []*packets.Publish {
+	b.Lock()
+	defer b.Unlock()
+
+	var result []*packets.Publish
+	for _, publish := range b.published {
+		if publish.Topic == topic {
+			result = append(result, publish)
+		}
+	}
+	return result
+}
+
+type MQTT5ClientTestSuite struct {
+	db.SuiteBase
+	broker *fakeMQTT5Broker
+	client *MQTT5Client
+}
+
+func (s *MQTT5ClientTestSuite) SetupTest() {
+	s.SuiteBase.SetupTest()
+
+	broker, err := newFakeMQTT5Broker()
+	s.Require().NoError(err)
+	s.broker = broker
+
+	conf := testSyncConfig()
+	conf.TelemetryEnabled = false
+	conf.MQTT.ProtocolVersion = config.MQTTProtocolV5
+	conf.MQTT.Host = "127.0.0.1"
+	conf.MQTT.Port = broker.port()
+	conf.MQTT.Username = "user"
+	conf.MQTT.Password = "password"
+	conf.MQTT.SessionExpiry = timeout
+	conf.MQTT.MessageExpiry = timeout
+	conf.MQTT.OrderMatters = true
+
+	client, err := NewMQTT5Client(conf)
+	s.Require().NoError(err)
+	s.client = client
+}
+
+func (s *MQTT5ClientTestSuite) TearDownTest() {
+	s.Require().NoError(s.client.Disconnect())
+	s.Require().NoError(s.broker.listener.Close())
+}
+
+// topic expands the variables of a topic as the client does
+func (s *MQTT5ClientTestSuite) topic(template string) string {
+	topic, err := s.client.baseEnv.EscapeStringVariables(template)
+	s.Require().NoError(err)
+	return topic
+}
+
+func (s *MQTT5ClientTestSuite) TestConnection() {
+	assert := s.Require()
+
+	connected := false
+	s.client.SetConnectionCallback(func() {
+		connected = true
+	})
+
+	disconnected := make(chan error, 1)
+	s.client.SetDisconnectionCallback(func(err error) {
+		disconnected <- err
+	})
+
+	assert.ErrorIs(s.client.Subscribe(), ErrNotConnected)
+
+	assert.NoError(s.client.Connect())
+	assert.True(connected)
+	assert.NoError(s.client.Subscribe())
+
+	s.broker.Lock()
+	connect := s.broker.connect
+	subscribed := s.broker.subscribed
+	s.broker.Unlock()
+
+	assert.Equal("user", connect.Username)
+	assert.Equal(uint32(timeout.Seconds()), *connect.Properties.SessionExpiryInterval)
+	assert.NotNil(connect.WillMessage)
+	assert.Equal(s.topic("/kronos/device/{deviceId}/disconnected"), connect.WillTopic)
+	assert.ElementsMatch([]string{
+		"/kronos/sync",
+		s.topic("/kronos/device/{deviceId}/sync"),
+		s.topic("/kronos/device/{deviceId}/commands"),
+	}, subscribed)
+
+	// The connected message is published before the connection callback is called
+	published := s.broker.publishedOn(s.topic("/kronos/device/{deviceId}/connected"))
+	assert.Len(published, 1)
+	assert.Equal("application/json", published[0].Properties.ContentType)
+	assert.Equal(string(messages.FrameConnected), userProperty(published[0], mqtt5UserPropertyMessageType))
+
+	// Connection lost
+	assert.NoError(s.broker.closeConn())
+
+	select {
+	case err := <-disconnected:
+		assert.Error(err)
+	case <-time.After(timeout):
+		assert.Fail("disconnection not notified")
+	}
+
+	assert.ErrorIs(s.client.PublishEvents([]messages.Event{}), ErrNotConnected)
+}
+
+func (s *MQTT5ClientTestSuite) TestPublishEvents() {
+	assert := s.Require()
+
+	assert.NoError(s.client.Connect())
+
+	err := s.client.PublishEvents([]messages.Event{
+		{
+			ID:         1,
+			EntityType: types.EntityTypeItem,
+			EntityID:   "Item00",
+			TxType:     types.EventEntityCreated,
+		},
+	})
+	assert.NoError(err)
+
+	published := s.broker.publishedOn(s.topic("/kronos/device/{deviceId}/events"))
+	assert.Len(published, 1)
+	assert.Contains(string(published[0].Payload), "Item00")
+	assert.Equal(uint32(timeout.Seconds()), *published[0].Properties.MessageExpiry)
+	assert.Equal(string(messages.FrameEvents), userProperty(published[0], mqtt5UserPropertyMessageType))
+	assert.Equal(s.client.deviceID(), userProperty(published[0], mqtt5UserPropertyDeviceID))
+}
+
+func (s *MQTT5ClientTestSuite) TestCommandResponse() {
+	assert := s.Require()
+
+	commands := make(chan *messages.ServerCommand, 2)
+	s.client.SetCommandCallback(func(message *messages.ServerCommand) {
+		commands <- message
+	})
+
+	assert.NoError(s.client.Connect())
+	assert.NoError(s.client.Subscribe())
+
+	// Commands with a response topic are answered there, with their correlation data
+	err := s.broker.send(s.topic("/kronos/device/{deviceId}/commands"), &messages.ServerCommand{
+		UUID:        "Command00",
+		CommandType: messages.CommandGetVersion,
+	}, &packets.Properties{
+		ResponseTopic:   "/responses",
+		CorrelationData: []byte("correlation"),
+	})
+	assert.NoError(err)
+
+	// Other commands are answered on the configured response topic
+	err = s.broker.send(s.topic("/kronos/device/{deviceId}/commands"), &messages.ServerCommand{
+		UUID:        "Command01",
+		CommandType: messages.CommandGetVersion,
+	}, nil)
+	assert.NoError(err)
+
+	for i := 0; i < 2; i++ {
+		select {
+		case command := <-commands:
+			err = s.client.PublishCommandResponse(&messages.CommandResponse{
+				UUID:    command.UUID,
+				Success: true,
+			})
+			assert.NoError(err)
+		case <-time.After(timeout):
+			assert.Fail("command not received")
+		}
+	}
+
+	published := s.broker.publishedOn("/responses")
+	assert.Len(published, 1)
+	assert.Equal([]byte("correlation"), published[0].Properties.CorrelationData)
+	assert.Contains(string(published[0].Payload), "Command00")
+
+	published = s.broker.publishedOn(s.topic("/kronos/device/{deviceId}/commands/Command01/response"))
+	assert.Len(published, 1)
+	assert.Empty(published[0].Properties.CorrelationData)
+	assert.Contains(string(published[0].Payload), "Command01")
+}
+
+func (s *MQTT5ClientTestSuite) TestUnsupportedScheme() {
+	conf := testSyncConfig()
+	conf.MQTT.ProtocolVersion = config.MQTTProtocolV5
+	conf.MQTT.Scheme = "ws"
+
+	_, err := NewMQTT5Client(conf)
+	s.Require().Error(err)
+}
+
+func userProperty(publish *packets.Publish, key string) string {
+	for _, property := range publish.Properties.User {
+		if property.Key == key {
+			return property.Value
+		}
+	}
+	return ""
+}
+
+func TestMQTT5Client(t *testing.T) {
+	suite.Run(t, new(MQTT5ClientTestSuite))
+}
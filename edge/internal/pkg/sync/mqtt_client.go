@@ -1,15 +1,10 @@
 package sync
 
 import (
-	"devais.it/kronos/internal/pkg/telemetry"
-	"time"
-
 	"devais.it/kronos/internal/pkg/config"
 	"devais.it/kronos/internal/pkg/logging"
 	"devais.it/kronos/internal/pkg/serialization"
-	"devais.it/kronos/internal/pkg/services"
 	"devais.it/kronos/internal/pkg/sync/messages"
-	"devais.it/kronos/internal/pkg/types"
 	"devais.it/kronos/internal/pkg/util"
 	MQTT "github.com/eclipse/paho.mqtt.golang"
 	"github.com/google/uuid"
@@ -17,25 +12,24 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-type MQTTClient struct {
+// mqttBase holds the state and the logic shared by
+// the MQTT v3 and v5 client implementations
+type mqttBase struct {
 	conf            *config.MQTTConfig
 	syncConf        *config.SyncConfig
-	client          MQTT.Client
 	connectionCb    ConnectionCallback
 	disconnectionCb DisconnectionCallback
 	syncCb          SyncCallback
 	commandCb       CommandCallback
+	eventsAckCb     EventsAckCallback
 	serializer      serialization.Serializer
 	deserializer    serialization.Deserializer
+	security        *messageSecurity
 
 	baseEnv *util.Environment
 }
 
-func (c *MQTTClient) deviceID() string {
-	return c.baseEnv.Get("deviceID")
-}
-
-func NewMQTTClient(syncConf *config.SyncConfig) (*MQTTClient, error) {
+func newMQTTBase(syncConf *config.SyncConfig) (*mqttBase, error) {
 	conf := &syncConf.MQTT
 
 	globalEnv, err := config.GetGlobalEnvironment()
@@ -46,16 +40,42 @@ func NewMQTTClient(syncConf *config.SyncConfig) (*MQTTClient, error) {
 	baseEnv := util.NewEnvironment(globalEnv)
 	baseEnv.Set("username", conf.Username)
 
-	c := &MQTTClient{
+	b := &mqttBase{
 		conf:     conf,
 		syncConf: syncConf,
 		baseEnv:  baseEnv,
 	}
 
-	c.serializer, c.deserializer, err = conf.Serialization.NewSerializer()
+	b.serializer, b.deserializer, err = conf.Serialization.NewSerializer()
+	if err != nil {
+		return nil, err
+	}
+
+	b.security, err = newMessageSecurity(&syncConf.Security)
+	if err != nil {
+		return nil, eris.Wrap(err, "failed to load messages security keys")
+	}
+
+	return b, nil
+}
+
+func (c *mqttBase) deviceID() string {
+	return c.baseEnv.Get("deviceID")
+}
+
+type MQTTClient struct {
+	*mqttBase
+	client MQTT.Client
+}
+
+func NewMQTTClient(syncConf *config.SyncConfig) (*MQTTClient, error) {
+	base, err := newMQTTBase(syncConf)
 	if err != nil {
 		return nil, err
 	}
+	conf := base.conf
+
+	c := &MQTTClient{mqttBase: base}
 
 	err = c.createPahoMQTTClient()
 	if err != nil {
@@ -146,21 +166,13 @@ func (c *MQTTClient) createPahoMQTTClient() error {
 		SetStore(store)
 
 	if conf.LastWillEnabled {
-		willTopic, err := c.baseEnv.EscapeStringVariables(conf.DisconnectedTopic)
+		willTopic, willPayload, err := c.willMessage()
 		if err != nil {
-			return eris.Wrap(err, "failed to build MQTT will topic")
-		}
-		willMsg := &messages.Disconnected{
-			DeviceID:  c.deviceID(),
-			Timestamp: nil,
-		}
-		msgJson, err := c.serializer.Serialize(willMsg)
-		if err != nil {
-			return eris.Wrap(err, "failed to marshal MQTT will message to JSON")
+			return err
 		}
 		options = options.SetWill(
 			willTopic,
-			string(msgJson),
+			string(willPayload),
 			conf.PubQoS,
 			conf.PubRetained,
 		)
@@ -184,18 +196,9 @@ func (c *MQTTClient) Connect() error {
 	}
 
 	// TODO: Move this up to sync worker
-	ts := util.TimestampMs()
-	connectedMsg := &messages.Connected{
-		DeviceID:  c.deviceID(),
-		Timestamp: &ts,
-	}
-
-	if c.syncConf.TelemetryEnabled {
-		telData, err := telemetry.Get()
-		if err != nil {
-			return eris.Wrap(err, "failed to get telemetry data to publish")
-		}
-		connectedMsg.Telemetry = telData
+	connectedMsg, err := newConnectedMessage(c.syncConf, c.deviceID())
+	if err != nil {
+		return err
 	}
 
 	return c.publishConnected(connectedMsg)
@@ -223,19 +226,23 @@ func (c *MQTTClient) Disconnect() error {
 	return nil
 }
 
-func (c *MQTTClient) SetSyncCallback(cb SyncCallback) {
+func (c *mqttBase) SetSyncCallback(cb SyncCallback) {
 	c.syncCb = cb
 }
 
-func (c *MQTTClient) SetCommandCallback(cb CommandCallback) {
+func (c *mqttBase) SetCommandCallback(cb CommandCallback) {
 	c.commandCb = cb
 }
 
-func (c *MQTTClient) SetConnectionCallback(cb ConnectionCallback) {
+func (c *mqttBase) SetEventsAckCallback(cb EventsAckCallback) {
+	c.eventsAckCb = cb
+}
+
+func (c *mqttBase) SetConnectionCallback(cb ConnectionCallback) {
 	c.connectionCb = cb
 }
 
-func (c *MQTTClient) SetDisconnectionCallback(cb DisconnectionCallback) {
+func (c *mqttBase) SetDisconnectionCallback(cb DisconnectionCallback) {
 	c.disconnectionCb = cb
 }
 
@@ -257,20 +264,10 @@ func (c *MQTTClient) Subscribe() error {
 
 	// Create a list to subscribe in parallel and wait for
 	// all tokens at once
-	tokens := make([]MQTT.Token, 0, 3)
+	tokens := make([]MQTT.Token, 0, 4)
 
 	syncMessagesFn := func(_ MQTT.Client, message MQTT.Message) {
-		if c.syncCb != nil {
-			var syncMessage messages.Sync
-			err := c.deserializer.Deserialize(message.Payload(), &syncMessage)
-			if err != nil {
-				logging.Error(err, "Failed to deserialize sync message")
-			} else if syncMessage == nil {
-				log.Error("Received empty sync message")
-			} else {
-				c.syncCb(syncMessage)
-			}
-		}
+		c.handleSync(message.Payload())
 	}
 
 	token := c.client.Subscribe(syncTopicGlobal, c.conf.SubQoS, syncMessagesFn)
@@ -280,19 +277,26 @@ func (c *MQTTClient) Subscribe() error {
 	tokens = append(tokens, token)
 
 	token = c.client.Subscribe(commandsTopic, c.conf.SubQoS, func(_ MQTT.Client, message MQTT.Message) {
-		if c.commandCb != nil {
-			var commandMessage messages.ServerCommand
-			err := c.deserializer.Deserialize(message.Payload(), &commandMessage)
-			if err != nil {
-				logging.Error(err, "Failed to deserialize server command message")
-			} else {
-				c.commandCb(&commandMessage)
-			}
+		if command := c.decodeCommand(message.Payload()); command != nil {
+			c.commandCb(command)
 		}
 	})
 
 	tokens = append(tokens, token)
 
+	if c.syncConf.Delivery.AckEnabled {
+		eventsAckTopic, err := c.baseEnv.EscapeStringVariables(c.conf.EventsAckTopic)
+		if err != nil {
+			return err
+		}
+
+		token = c.client.Subscribe(eventsAckTopic, c.conf.SubQoS, func(_ MQTT.Client, message MQTT.Message) {
+			c.handleEventsAck(message.Payload())
+		})
+
+		tokens = append(tokens, token)
+	}
+
 	for _, t := range tokens {
 		if err := c.waitToken(t); err != nil {
 			return mapErr(err)
@@ -308,51 +312,17 @@ func (c *MQTTClient) PublishVersions() error {
 		return err
 	}
 
-	itemsCount, err := services.GetItemsCount()
-	if err != nil {
-		return eris.Wrap(err, "failed to get items count")
-	}
-	attributesCount, err := services.GetAttributesCount()
+	versionsMessages, err := buildVersionsMessages(c.conf.MaxEntitiesPerMessage)
 	if err != nil {
-		return eris.Wrap(err, "failed to get attributes count")
+		return err
 	}
 
-	pageSize := c.conf.MaxEntitiesPerMessage
+	tokens := make([]MQTT.Token, 0, len(versionsMessages))
 
-	itemsPages := util.CeilDiv(int(itemsCount), pageSize)
-	attributesPages := util.CeilDiv(int(attributesCount), pageSize)
-
-	maxPages := util.MaxInt(itemsPages, attributesPages)
-
-	tokens := make([]MQTT.Token, 0, maxPages)
-
-	for page := 1; page <= maxPages; page++ {
-		versions := map[types.EntityType]messages.EntityVersions{}
-
-		if page <= itemsPages {
-			itemsVersion, err := services.GetItemsVersion(page, pageSize)
-			if err != nil {
-				return eris.Wrap(err, "failed to get items version")
-			}
-			versions[types.EntityTypeItem] = itemsVersion
-		}
-
-		if page <= attributesPages {
-			attributesVersion, err := services.GetAttributesVersion(page, pageSize)
-			if err != nil {
-				return eris.Wrap(err, "failed to get attributes version")
-			}
-			versions[types.EntityTypeAttribute] = attributesVersion
-		}
-
-		msg := &messages.Versions{
-			Timestamp: util.TimestampMs(),
-			Versions:  versions,
-		}
-
-		msgBytes, err := c.serializer.Serialize(msg)
+	for _, msg := range versionsMessages {
+		msgBytes, err := c.serialize(messages.FrameVersions, msg)
 		if err != nil {
-			return eris.Wrap(err, "failed to serialize connection message")
+			return eris.Wrap(err, "failed to serialize versions message")
 		}
 
 		token := c.client.Publish(connectedTopic, c.conf.PubQoS, c.conf.PubRetained, msgBytes)
@@ -376,7 +346,11 @@ func (c *MQTTClient) PublishEvents(events []messages.Event) error {
 		return eris.Wrap(err, "failed to build events topic")
 	}
 
-	err = c.publish(topic, events)
+	if c.syncConf.Delivery.AckEnabled {
+		setDedupKeys(events, c.deviceID())
+	}
+
+	err = c.publish(topic, messages.FrameEvents, events)
 	return mapErr(err)
 }
 
@@ -386,7 +360,29 @@ func (c *MQTTClient) PublishCommandResponse(message *messages.CommandResponse) e
 		return eris.Wrap(err, "failed to build command response topic")
 	}
 
-	return c.retryPublish(topic, message)
+	return c.retryPublish(topic, messages.FrameCommandResponse, message)
+}
+
+func (c *MQTTClient) PublishVersionsDigest(digest *messages.VersionsDigest) error {
+	topic, err := c.baseEnv.EscapeStringVariables(c.conf.VersionsDigestTopic)
+	if err != nil {
+		return eris.Wrap(err, "failed to build versions digest topic")
+	}
+
+	err = c.publish(topic, messages.FrameVersionsDigest, digest)
+	if err != nil {
+		return eris.Wrap(err, "failed to publish versions digest message")
+	}
+	return nil
+}
+
+func (c *MQTTClient) PublishSyncAck(message *messages.SyncAck) error {
+	topic, err := c.baseEnv.EscapeStringVariables(c.conf.SyncAckTopic)
+	if err != nil {
+		return eris.Wrap(err, "failed to build sync ack topic")
+	}
+
+	return c.retryPublish(topic, messages.FrameSyncAck, message)
 }
 
 func (c *MQTTClient) publishConnected(message *messages.Connected) error {
@@ -394,7 +390,7 @@ func (c *MQTTClient) publishConnected(message *messages.Connected) error {
 	if err != nil {
 		return eris.Wrap(err, "failed to build connected topic")
 	}
-	err = c.publish(topic, message)
+	err = c.publish(topic, messages.FrameConnected, message)
 	if err != nil {
 		return eris.Wrap(err, "failed to publish connected message")
 	}
@@ -406,7 +402,7 @@ func (c *MQTTClient) publishDisconnect(message *messages.Disconnected) error {
 	if err != nil {
 		return eris.Wrap(err, "failed to build disconnect topic")
 	}
-	err = c.publish(topic, message)
+	err = c.publish(topic, messages.FrameDisconnected, message)
 	if err != nil {
 		return eris.Wrap(err, "failed to publish disconnect message")
 	}
@@ -425,10 +421,118 @@ func (c *MQTTClient) waitToken(token MQTT.Token) error {
 	return token.Error()
 }
 
-func (c *MQTTClient) publish(topic string, message interface{}) error {
+// willMessage builds the topic and the payload of the last will message
+func (c *mqttBase) willMessage() (string, []byte, error) {
+	willTopic, err := c.baseEnv.EscapeStringVariables(c.conf.DisconnectedTopic)
+	if err != nil {
+		return "", nil, eris.Wrap(err, "failed to build MQTT will topic")
+	}
+	willMsg := &messages.Disconnected{
+		DeviceID:  c.deviceID(),
+		Timestamp: nil,
+	}
+	msgJson, err := c.serializer.Serialize(willMsg)
+	if err != nil {
+		return "", nil, eris.Wrap(err, "failed to marshal MQTT will message to JSON")
+	}
+	return willTopic, msgJson, nil
+}
+
+// handleSync deserializes a sync message and passes it to the sync callback
+func (c *mqttBase) handleSync(payload []byte) {
+	if c.syncCb == nil {
+		return
+	}
+
+	var syncMessage messages.Sync
+	err := c.deserialize(messages.FrameSync, payload, &syncMessage)
+	if err != nil {
+		logging.Error(err, "Failed to deserialize sync message")
+	} else if syncMessage == nil {
+		log.Error("Received empty sync message")
+	} else {
+		c.syncCb(syncMessage)
+	}
+}
+
+// decodeCommand deserializes a server command message.
+// It returns nil if the message is invalid or no command callback is set.
+func (c *mqttBase) decodeCommand(payload []byte) *messages.ServerCommand {
+	if c.commandCb == nil {
+		return nil
+	}
+
+	var commandMessage messages.ServerCommand
+	err := c.deserialize(messages.FrameCommand, payload, &commandMessage)
+	if err != nil {
+		logging.Error(err, "Failed to deserialize server command message")
+		return nil
+	}
+	return &commandMessage
+}
+
+// handleEventsAck deserializes an events ack message and passes it to the events ack callback
+func (c *mqttBase) handleEventsAck(payload []byte) {
+	if c.eventsAckCb == nil {
+		return
+	}
+
+	var ackMessage messages.EventsAck
+	err := c.deserialize(messages.FrameEventsAck, payload, &ackMessage)
+	if err != nil {
+		logging.Error(err, "Failed to deserialize events ack message")
+	} else {
+		c.eventsAckCb(&ackMessage)
+	}
+}
+
+// serialize serializes a message of the given type,
+// compressing it as configured for that type.
+// Events are then wrapped in a signed envelope, if enabled:
+// payloads are always compressed before being sealed, as by WebSocketClient.
+func (c *mqttBase) serialize(messageType messages.FrameType, message interface{}) ([]byte, error) {
 	payload, err := c.serializer.Serialize(message)
 	if err != nil {
-		return eris.Wrap(err, "failed to serialize message")
+		return nil, eris.Wrap(err, "failed to serialize message")
+	}
+
+	payload, err = c.conf.Serialization.NewCompressor(string(messageType)).Compress(payload)
+	if err != nil || messageType != messages.FrameEvents {
+		return payload, err
+	}
+
+	envelope, err := c.security.seal(messageType, payload)
+	if err != nil || envelope == nil {
+		return payload, err
+	}
+
+	payload, err = c.serializer.Serialize(envelope)
+	if err != nil {
+		return nil, eris.Wrap(err, "failed to serialize message envelope")
+	}
+	return payload, nil
+}
+
+// deserialize deserializes an incoming message of the given type,
+// verifying its envelope and decompressing it if needed
+func (c *mqttBase) deserialize(messageType messages.FrameType, payload []byte, message interface{}) error {
+	payload, err := c.security.open(c.deserializer, messageType, payload)
+	if err != nil {
+		return err
+	}
+	// The size of MQTT messages is limited by the broker,
+	// decompressed payloads get the default limit
+	payload, err = serialization.Decompress(payload, 0)
+	if err != nil {
+		return err
+	}
+	return c.deserializer.Deserialize(payload, message)
+}
+
+func (c *MQTTClient) publish(topic string, messageType messages.FrameType, message interface{}) error {
+	payload, err := c.serialize(messageType, message)
+	if err != nil {
+		return err
 	}
 	token := c.client.Publish(topic, c.conf.PubQoS, c.conf.PubRetained, payload)
 	return c.waitToken(token)
@@ -437,38 +541,19 @@ func (c *MQTTClient) publish(topic string, message interface{}) error {
 // retryPublish publishes a message, retrying on failure.
 // Message publishing will be retried until configured max retries or
 // max backoff time is reached.
-func (c *MQTTClient) retryPublish(topic string, message interface{}) error {
-	backOff := c.syncConf.Backoff.NewBackoff()
-	interval := backOff.InitialInterval
-	retry := 0
-	for {
-		err := c.publish(topic, message)
-		if err == nil {
-			return nil
-		}
-
-		if c.conf.MaxRetries > 0 && retry >= c.conf.MaxRetries {
-			return err
-		}
-
-		if interval == backOff.Stop {
-			return err
-		}
-
-		time.Sleep(interval)
-
-		interval = backOff.NextBackOff()
-		retry++
-	}
+func (c *MQTTClient) retryPublish(topic string, messageType messages.FrameType, message interface{}) error {
+	return retry(c.syncConf, c.conf.MaxRetries, func() error {
+		return c.publish(topic, messageType, message)
+	})
 }
 
-func (c *MQTTClient) buildEventTopic() (string, error) {
+func (c *mqttBase) buildEventTopic() (string, error) {
 	env := util.NewEnvironment(c.baseEnv)
 
 	return env.EscapeStringVariables(c.conf.EventsTopic)
 }
 
-func (c *MQTTClient) buildCommandResponseTopic(message *messages.CommandResponse) (string, error) {
+func (c *mqttBase) buildCommandResponseTopic(message *messages.CommandResponse) (string, error) {
 	env := util.NewEnvironment(c.baseEnv)
 	env.Set("uuid", message.UUID)
 
@@ -0,0 +1,265 @@
+package sync
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"time"
+
+	"devais.it/kronos/internal/pkg/db"
+	"devais.it/kronos/internal/pkg/db/models"
+	"devais.it/kronos/internal/pkg/services"
+	"devais.it/kronos/internal/pkg/sync/messages"
+	"devais.it/kronos/internal/pkg/types"
+	"devais.it/kronos/internal/pkg/util"
+	"github.com/rotisserie/eris"
+	log "github.com/sirupsen/logrus"
+)
+
+// errReconciling is returned while the worker waits for the server
+// application to complete the reconciliation
+var errReconciling = eris.New("waiting for reconciliation")
+
+// reconciledEntityTypes are the entity types included in versions digests
+var reconciledEntityTypes = []types.EntityType{
+	types.EntityTypeItem,
+	types.EntityTypeAttribute,
+	types.EntityTypeRelation,
+}
+
+// versionsTree is a two-level hash tree over the versions of a single entity type.
+// Every entity is assigned to a bucket by the first 4 bytes (big endian) of the
+// SHA-1 of its ID, modulo the number of buckets.
+// The hash of a bucket is the SHA-1 of the "<id>:<version>\n" lines of its
+// entities, ordered by ID, while the root is the SHA-1 of the concatenated
+// hex-encoded bucket hashes.
+type versionsTree struct {
+	count   int64
+	buckets []hash.Hash
+}
+
+func bucketOf(id string, buckets int) int {
+	sum := sha1.Sum([]byte(id))
+	return int(binary.BigEndian.Uint32(sum[:4]) % uint32(buckets))
+}
+
+func validateBuckets(buckets int) error {
+	if buckets <= 0 {
+		return eris.Errorf("Invalid number of reconciliation buckets: %d", buckets)
+	}
+	return nil
+}
+
+func buildVersionsTree(entityType types.EntityType, buckets int) (*versionsTree, error) {
+	if err := validateBuckets(buckets); err != nil {
+		return nil, err
+	}
+	tableName, err := models.GetTableName(entityType)
+	if err != nil {
+		return nil, err
+	}
+
+	tree := &versionsTree{
+		buckets: make([]hash.Hash, buckets),
+	}
+	for i := range tree.buckets {
+		tree.buckets[i] = sha1.New()
+	}
+
+	// Versions are ordered by ID, so entities of every bucket are ordered too
+	err = services.ForEachVersion(tableName, func(version *models.EntityVersion) error {
+		bucket := tree.buckets[bucketOf(version.ID, buckets)]
+		_, err := bucket.Write([]byte(version.ID + ":" + version.Version + "\n"))
+		tree.count++
+		return err
+	})
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to build %s versions tree", entityType)
+	}
+
+	return tree, nil
+}
+
+func (t *versionsTree) hashes() []string {
+	hashes := make([]string, len(t.buckets))
+	for i, bucket := range t.buckets {
+		hashes[i] = hex.EncodeToString(bucket.Sum(nil))
+	}
+	return hashes
+}
+
+func (t *versionsTree) root() string {
+	root := sha1.New()
+	for _, bucketHash := range t.hashes() {
+		// Writing to a hash never fails
+		_, _ = root.Write([]byte(bucketHash))
+	}
+	return hex.EncodeToString(root.Sum(nil))
+}
+
+// buildVersionsDigest builds the roots of the versions trees of all entity types
+func buildVersionsDigest(buckets int) (*messages.VersionsDigest, error) {
+	digest := &messages.VersionsDigest{
+		Buckets: buckets,
+		Digests: map[types.EntityType]messages.EntityDigest{},
+	}
+
+	for _, entityType := range reconciledEntityTypes {
+		tree, err := buildVersionsTree(entityType, buckets)
+		if err != nil {
+			return nil, err
+		}
+		digest.Digests[entityType] = messages.EntityDigest{
+			Count: tree.count,
+			Root:  tree.root(),
+		}
+	}
+
+	digest.Timestamp = util.TimestampMs()
+
+	return digest, nil
+}
+
+// getBucketVersions returns the versions of all entities of the given type
+// which belong to one of the given buckets
+func getBucketVersions(entityType types.EntityType, buckets int, wanted []int) (messages.EntityVersions, error) {
+	if err := validateBuckets(buckets); err != nil {
+		return nil, err
+	}
+	tableName, err := models.GetTableName(entityType)
+	if err != nil {
+		return nil, err
+	}
+
+	wantedSet := make(map[int]struct{}, len(wanted))
+	for _, bucket := range wanted {
+		if bucket < 0 || bucket >= buckets {
+			return nil, eris.Errorf("Bucket %d out of range, buckets: %d", bucket, buckets)
+		}
+		wantedSet[bucket] = struct{}{}
+	}
+
+	versions := messages.EntityVersions{}
+	err = services.ForEachVersion(tableName, func(version *models.EntityVersion) error {
+		if _, ok := wantedSet[bucketOf(version.ID, buckets)]; ok {
+			versions = append(versions, *version)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to get %s bucket versions", entityType)
+	}
+
+	return versions, nil
+}
+
+//=============================================================================
+// Worker methods
+//=============================================================================
+
+func (w *Worker) publishVersionsDigest() error {
+	log.Debug("Sync worker publishing versions digest...")
+
+	digest, err := buildVersionsDigest(w.conf.Reconciliation.Buckets)
+	if err != nil {
+		return err
+	}
+
+	err = w.client.PublishVersionsDigest(digest)
+	if err != nil {
+		return err
+	}
+
+	w.timeMutex.Lock()
+	w.reconciliationStart = time.Now()
+	w.timeMutex.Unlock()
+
+	return nil
+}
+
+// checkReconciliation ends the reconciliation if the server application
+// didn't complete it in time, otherwise returns errReconciling
+func (w *Worker) checkReconciliation() error {
+	w.timeMutex.Lock()
+	elapsed := time.Since(w.reconciliationStart)
+	w.timeMutex.Unlock()
+
+	if elapsed < w.conf.Reconciliation.Timeout {
+		return errReconciling
+	}
+
+	log.Warnf("Reconciliation not completed after %v, dequeueing events", elapsed)
+	w.onReconciled()
+
+	return nil
+}
+
+func (w *Worker) onReconciled() {
+	w.cbMutex.Lock()
+	defer w.cbMutex.Unlock()
+
+	state := w.fsm.Current()
+
+	if state == stateReconciling {
+		log.Info("Sync worker reconciled")
+		w.fsmEvent(eventReconciled)
+	} else {
+		log.Debugf("Reconciled while in state %s", state)
+	}
+}
+
+func (w *Worker) handleBucketHashesCommand(message *messages.ServerCommand) (map[string]interface{}, error) {
+	tree, err := buildVersionsTree(message.EntityType, w.conf.Reconciliation.Buckets)
+	if err != nil {
+		return nil, err
+	}
+
+	return util.StructToJSONMap(&messages.BucketHashes{
+		EntityType: message.EntityType,
+		Hashes:     tree.hashes(),
+	})
+}
+
+// handleBucketVersionsCommand handles the GET_BUCKET_VERSIONS command.
+// Versions are split into pages with at most MaxEntitiesPerMessage entities,
+// a single page can be requested through the command body.
+func (w *Worker) handleBucketVersionsCommand(message *messages.ServerCommand) (map[string]interface{}, error) {
+	params := &messages.BucketsParams{}
+	if err := util.JSONToStruct(message.Body, params); err != nil {
+		return nil, eris.Wrap(err, "invalid bucket versions command parameters")
+	}
+
+	if params.Page < 0 {
+		return nil, db.ErrInvalidPagination
+	}
+
+	versions, err := getBucketVersions(message.EntityType, w.conf.Reconciliation.Buckets, params.Buckets)
+	if err != nil {
+		return nil, err
+	}
+
+	pageSize := w.conf.MaxEntitiesPerMessage()
+	pages := util.MaxInt(1, util.CeilDiv(len(versions), pageSize))
+
+	buildPage := func(page int) (map[string]interface{}, error) {
+		start := (page - 1) * pageSize
+		end := util.MinInt(start+pageSize, len(versions))
+		return util.StructToJSONMap(&messages.BucketVersions{
+			EntityType: message.EntityType,
+			Buckets:    params.Buckets,
+			Page:       page,
+			Pages:      pages,
+			Versions:   versions[start:end],
+		})
+	}
+
+	return w.respondPages(message, "bucket versions", params.Page, pages, buildPage)
+}
+
+func (w *Worker) handleReconciledCommand() (map[string]interface{}, error) {
+	w.onReconciled()
+	// Immediately dequeue events accumulated during reconciliation
+	w.signalEvent()
+	return nil, nil
+}
@@ -0,0 +1,100 @@
+package sync
+
+import (
+	"crypto/ed25519"
+
+	"devais.it/kronos/internal/pkg/config"
+	"devais.it/kronos/internal/pkg/security"
+	"devais.it/kronos/internal/pkg/serialization"
+	"devais.it/kronos/internal/pkg/sync/messages"
+	"github.com/rotisserie/eris"
+)
+
+// messageSecurity signs and encrypts outgoing events, and verifies
+// incoming messages, as configured by config.SecurityConfig
+type messageSecurity struct {
+	sealer *security.Sealer
+	opener *security.Opener
+}
+
+func newMessageSecurity(conf *config.SecurityConfig) (*messageSecurity, error) {
+	s := &messageSecurity{}
+
+	if conf.SigningKeyFile != "" {
+		signingKey, err := security.LoadSigningKey(conf.SigningKeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		var encryptionKey security.EncryptionKey
+		if conf.EncryptionKeyFile != "" {
+			encryptionKey, err = security.LoadEncryptionKey(conf.EncryptionKeyFile)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		s.sealer, err = security.NewSealer(signingKey, encryptionKey)
+		if err != nil {
+			return nil, err
+		}
+	} else if conf.EncryptionKeyFile != "" {
+		return nil, eris.New("Events encryption requires a signing key")
+	}
+
+	if len(conf.ServerKeyFiles) > 0 {
+		serverKeys := make([]ed25519.PublicKey, 0, len(conf.ServerKeyFiles))
+		for _, keyFile := range conf.ServerKeyFiles {
+			key, err := security.LoadVerifyingKey(keyFile)
+			if err != nil {
+				return nil, err
+			}
+			serverKeys = append(serverKeys, key)
+		}
+
+		var err error
+		s.opener, err = security.NewOpener(serverKeys, nil)
+		if err != nil {
+			return nil, err
+		}
+		s.opener.MaxAge = conf.MaxMessageAge
+	}
+
+	return s, nil
+}
+
+// seal wraps a serialized, and possibly compressed, message inside a signed envelope.
+// Returns nil if events signing is disabled.
+func (s *messageSecurity) seal(messageType messages.FrameType, payload []byte) (*security.Envelope, error) {
+	if s.sealer == nil {
+		return nil, nil
+	}
+	envelope, err := s.sealer.Seal(string(messageType), payload)
+	if err != nil {
+		return nil, eris.Wrap(err, "failed to seal events message")
+	}
+	return envelope, nil
+}
+
+// open verifies a serialized envelope wrapping a message of the given type,
+// returning the serialized message it wraps, still compressed if it was.
+// If no server keys are configured, data is returned untouched.
+func (s *messageSecurity) open(
+	deserializer serialization.Deserializer,
+	messageType messages.FrameType,
+	data []byte) ([]byte, error) {
+	if s.opener == nil {
+		return data, nil
+	}
+
+	var envelope security.Envelope
+	if err := deserializer.Deserialize(data, &envelope); err != nil {
+		return nil, eris.Wrap(err, "failed to deserialize message envelope")
+	}
+
+	payload, err := s.opener.Open(string(messageType), &envelope)
+	if err != nil {
+		return nil, eris.Wrap(err, "message rejected")
+	}
+	return payload, nil
+}
@@ -0,0 +1,561 @@
+package sync
+
+import (
+	"encoding/base64"
+	"net/http"
+	"sync"
+	"time"
+
+	"devais.it/kronos/internal/pkg/config"
+	"devais.it/kronos/internal/pkg/logging"
+	"devais.it/kronos/internal/pkg/serialization"
+	"devais.it/kronos/internal/pkg/sync/messages"
+	"devais.it/kronos/internal/pkg/util"
+	"github.com/gorilla/websocket"
+	"github.com/rotisserie/eris"
+	log "github.com/sirupsen/logrus"
+)
+
+// framesQueueSize is the number of received frames which can wait to be
+// dispatched before the connection stops being read
+const framesQueueSize = 64
+
+// WebSocketClient is a synchronization client communicating with the server
+// application through a single WebSocket connection.
+// Since WebSocket has no concept of topics, every message is wrapped inside a
+// messages.Frame, which carries the message type along with its payload.
+type WebSocketClient struct {
+	conf            *config.WebSocketConfig
+	syncConf        *config.SyncConfig
+	dialer          *websocket.Dialer
+	connectionCb    ConnectionCallback
+	disconnectionCb DisconnectionCallback
+	syncCb          SyncCallback
+	commandCb       CommandCallback
+	eventsAckCb     EventsAckCallback
+	serializer      serialization.Serializer
+	deserializer    serialization.Deserializer
+	security        *messageSecurity
+
+	// Current connection. It is nil while disconnected
+	conn *websocket.Conn
+	// done is closed when the current connection is dropped
+	done   chan struct{}
+	connMu sync.Mutex
+	// Mutex for concurrent writes, which are not supported by the websocket library
+	writeMu sync.Mutex
+
+	baseEnv *util.Environment
+}
+
+func (c *WebSocketClient) deviceID() string {
+	return c.baseEnv.Get("deviceID")
+}
+
+func NewWebSocketClient(syncConf *config.SyncConfig) (*WebSocketClient, error) {
+	conf := &syncConf.WebSocket
+
+	globalEnv, err := config.GetGlobalEnvironment()
+	if err != nil {
+		return nil, err
+	}
+
+	baseEnv := util.NewEnvironment(globalEnv)
+	baseEnv.Set("username", conf.Username)
+
+	c := &WebSocketClient{
+		conf:     conf,
+		syncConf: syncConf,
+		baseEnv:  baseEnv,
+	}
+
+	c.serializer, c.deserializer, err = conf.Serialization.NewSerializer()
+	if err != nil {
+		return nil, err
+	}
+
+	c.security, err = newMessageSecurity(&syncConf.Security)
+	if err != nil {
+		return nil, eris.Wrap(err, "failed to load messages security keys")
+	}
+
+	// Load TLS certificates
+	tlsConfig, err := conf.TLS.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	c.dialer = &websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: conf.HandshakeTimeout,
+		TLSClientConfig:  tlsConfig,
+	}
+
+	if log.IsLevelEnabled(log.DebugLevel) {
+		fields := log.Fields{
+			"url":                  conf.URL,
+			"handshakeTimeout":     conf.HandshakeTimeout,
+			"communicationTimeout": conf.CommunicationTimeout,
+			"keepAlive":            conf.KeepAlive,
+			"maxMessageSize":       conf.MaxMessageSize,
+		}
+		log.WithFields(fields).Debug("WebSocket client options")
+	}
+
+	return c, nil
+}
+
+//=============================================================================
+// Client interface implementation
+//=============================================================================
+
+func (c *WebSocketClient) Connect() error {
+	url, err := c.baseEnv.EscapeStringVariables(c.conf.URL)
+	if err != nil {
+		return eris.Wrap(err, "failed to build WebSocket URL")
+	}
+
+	header, err := c.buildHeader()
+	if err != nil {
+		return eris.Wrap(err, "failed to build WebSocket handshake headers")
+	}
+
+	conn, resp, err := c.dialer.Dial(url, header)
+	if err != nil {
+		if resp != nil {
+			return eris.Wrapf(err, "WebSocket handshake failed with status %d", resp.StatusCode)
+		}
+		return eris.Wrap(err, "failed to connect to WebSocket server")
+	}
+
+	if c.conf.MaxMessageSize > 0 {
+		conn.SetReadLimit(c.conf.MaxMessageSize)
+	}
+
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(c.readDeadline())
+	})
+
+	done := make(chan struct{})
+
+	c.connMu.Lock()
+	if oldConn := c.conn; oldConn != nil {
+		c.dropConnection()
+		_ = oldConn.Close()
+	}
+	c.conn = conn
+	c.done = done
+	c.connMu.Unlock()
+
+	frames := make(chan []byte, framesQueueSize)
+
+	go c.readRoutine(conn, frames)
+	go c.dispatchRoutine(frames)
+	go c.pingRoutine(conn, done)
+
+	// The server must know about the connection before anything else is sent
+	connectedMsg, err := newConnectedMessage(c.syncConf, c.deviceID())
+	if err != nil {
+		c.closeConnection(conn)
+		return err
+	}
+
+	err = c.write(messages.FrameConnected, connectedMsg)
+	if err != nil {
+		c.closeConnection(conn)
+		return eris.Wrap(err, "failed to publish connected message")
+	}
+
+	if c.connectionCb != nil {
+		c.connectionCb()
+	}
+
+	return nil
+}
+
+func (c *WebSocketClient) Disconnect() error {
+	if c.syncConf.NotifyGracefulDisconnect && c.isConnected() {
+		// Try to send disconnect message
+		ts := util.TimestampMs()
+		msg := &messages.Disconnected{
+			DeviceID:  c.deviceID(),
+			Timestamp: &ts,
+		}
+		err := c.write(messages.FrameDisconnected, msg)
+		if err != nil {
+			logging.Error(err, "Failed to publish disconnection message")
+		} else {
+			log.Info("Disconnection message published")
+		}
+	}
+
+	c.connMu.Lock()
+	conn := c.conn
+	c.dropConnection()
+	c.connMu.Unlock()
+
+	if conn == nil {
+		return nil
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	closeMsg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	err := conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(c.conf.CommunicationTimeout))
+	if err != nil {
+		log.Debug("Failed to send WebSocket close message: ", err)
+	}
+
+	return conn.Close()
+}
+
+func (c *WebSocketClient) SetSyncCallback(cb SyncCallback) {
+	c.syncCb = cb
+}
+
+func (c *WebSocketClient) SetCommandCallback(cb CommandCallback) {
+	c.commandCb = cb
+}
+
+func (c *WebSocketClient) SetEventsAckCallback(cb EventsAckCallback) {
+	c.eventsAckCb = cb
+}
+
+func (c *WebSocketClient) SetConnectionCallback(cb ConnectionCallback) {
+	c.connectionCb = cb
+}
+
+func (c *WebSocketClient) SetDisconnectionCallback(cb DisconnectionCallback) {
+	c.disconnectionCb = cb
+}
+
+// Subscribe doesn't need to do anything over WebSocket:
+// sync and command messages are pushed by the server on the same connection.
+func (c *WebSocketClient) Subscribe() error {
+	if !c.isConnected() {
+		return ErrNotConnected
+	}
+	return nil
+}
+
+func (c *WebSocketClient) PublishVersions() error {
+	versionsMessages, err := buildVersionsMessages(c.conf.MaxEntitiesPerMessage)
+	if err != nil {
+		return err
+	}
+
+	for _, msg := range versionsMessages {
+		err = c.write(messages.FrameVersions, msg)
+		if err != nil {
+			return eris.Wrap(err, "failed to publish versions message")
+		}
+	}
+
+	return nil
+}
+
+func (c *WebSocketClient) PublishVersionsDigest(digest *messages.VersionsDigest) error {
+	return c.write(messages.FrameVersionsDigest, digest)
+}
+
+func (c *WebSocketClient) PublishEvents(events []messages.Event) error {
+	if c.syncConf.Delivery.AckEnabled {
+		setDedupKeys(events, c.deviceID())
+	}
+
+	if c.security.sealer == nil {
+		return c.write(messages.FrameEvents, events)
+	}
+
+	// Signed events are sent as an envelope wrapping the serialized events,
+	// compressed before being sealed as MQTTClient does.
+	// The frame itself isn't compressed again.
+	data, err := c.serializer.Serialize(events)
+	if err != nil {
+		return eris.Wrap(err, "failed to serialize events message")
+	}
+	data, err = c.conf.Serialization.NewCompressor(string(messages.FrameEvents)).Compress(data)
+	if err != nil {
+		return err
+	}
+	envelope, err := c.security.seal(messages.FrameEvents, data)
+	if err != nil {
+		return err
+	}
+	return c.writeFrame(messages.FrameEvents, envelope, false)
+}
+
+func (c *WebSocketClient) PublishCommandResponse(message *messages.CommandResponse) error {
+	return retry(c.syncConf, c.conf.MaxRetries, func() error {
+		return c.write(messages.FrameCommandResponse, message)
+	})
+}
+
+func (c *WebSocketClient) PublishSyncAck(message *messages.SyncAck) error {
+	return retry(c.syncConf, c.conf.MaxRetries, func() error {
+		return c.write(messages.FrameSyncAck, message)
+	})
+}
+
+//=============================================================================
+// Utilities
+//=============================================================================
+
+func (c *WebSocketClient) isConnected() bool {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	return c.conn != nil
+}
+
+// dropConnection forgets the current connection and stops its routines.
+// It must be called with connMu locked.
+func (c *WebSocketClient) dropConnection() {
+	if c.conn == nil {
+		return
+	}
+	close(c.done)
+	c.conn = nil
+	c.done = nil
+}
+
+// closeConnection drops the given connection, if it's still the current one,
+// and closes it without notifying the disconnection.
+// Closing the connection stops its read and dispatch routines.
+func (c *WebSocketClient) closeConnection(conn *websocket.Conn) {
+	c.connMu.Lock()
+	if c.conn == conn {
+		c.dropConnection()
+	}
+	c.connMu.Unlock()
+
+	_ = conn.Close()
+}
+
+// readDeadline returns the time after which the connection should be
+// considered lost if nothing is received from the server.
+// No deadline is set if keep alive is disabled.
+func (c *WebSocketClient) readDeadline() time.Time {
+	if c.conf.KeepAlive <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(c.conf.KeepAlive + c.conf.CommunicationTimeout)
+}
+
+func (c *WebSocketClient) buildHeader() (http.Header, error) {
+	header := http.Header{}
+
+	if c.conf.Username != "" {
+		auth := c.conf.Username + ":" + c.conf.Password
+		header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(auth)))
+	}
+
+	for key, value := range c.conf.Headers {
+		value, err := c.baseEnv.EscapeStringVariables(value)
+		if err != nil {
+			return nil, err
+		}
+		header.Set(key, value)
+	}
+
+	return header, nil
+}
+
+// write serializes a message inside a frame of the given type
+// and sends it to the server
+func (c *WebSocketClient) write(frameType messages.FrameType, payload interface{}) error {
+	return c.writeFrame(frameType, payload, true)
+}
+
+// writeFrame serializes a message inside a frame of the given type,
+// compressing the frame if requested, and sends it to the server
+func (c *WebSocketClient) writeFrame(frameType messages.FrameType, payload interface{}, compress bool) error {
+	frame := &messages.Frame{
+		Type:    frameType,
+		Payload: payload,
+	}
+
+	data, err := c.serializer.Serialize(frame)
+	if err != nil {
+		return eris.Wrap(err, "failed to serialize message")
+	}
+
+	// The whole frame is compressed, since its type is needed to decode the payload
+	if compress {
+		data, err = c.conf.Serialization.NewCompressor(string(frameType)).Compress(data)
+		if err != nil {
+			return err
+		}
+	}
+
+	messageType := websocket.BinaryMessage
+	if c.conf.Serialization.Type == serialization.TypeJSON && !serialization.IsCompressed(data) {
+		messageType = websocket.TextMessage
+	}
+
+	c.connMu.Lock()
+	conn := c.conn
+	c.connMu.Unlock()
+
+	if conn == nil {
+		return ErrNotConnected
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	err = conn.SetWriteDeadline(time.Now().Add(c.conf.CommunicationTimeout))
+	if err != nil {
+		return err
+	}
+
+	return mapWebSocketErr(conn.WriteMessage(messageType, data))
+}
+
+// readRoutine reads frames from a connection until it is closed,
+// queueing them to be dispatched by dispatchRoutine.
+// Frames aren't handled here, since control frames like pongs are only
+// processed while reading, and callbacks may take a long time.
+func (c *WebSocketClient) readRoutine(conn *websocket.Conn, frames chan<- []byte) {
+	defer close(frames)
+
+	for {
+		err := conn.SetReadDeadline(c.readDeadline())
+		if err == nil {
+			var data []byte
+			_, data, err = conn.ReadMessage()
+			if err == nil {
+				frames <- data
+				continue
+			}
+		}
+
+		c.connMu.Lock()
+		// Notify disconnection only if the connection wasn't closed on purpose
+		lost := c.conn == conn
+		if lost {
+			c.dropConnection()
+		}
+		c.connMu.Unlock()
+
+		if lost {
+			_ = conn.Close()
+			if c.disconnectionCb != nil {
+				c.disconnectionCb(err)
+			}
+		}
+
+		return
+	}
+}
+
+// dispatchRoutine passes the frames read from a connection to the registered
+// callbacks, in the order they were received, until the queue is closed
+func (c *WebSocketClient) dispatchRoutine(frames <-chan []byte) {
+	for data := range frames {
+		c.handleFrame(data)
+	}
+}
+
+// pingRoutine periodically sends ping messages to the server,
+// until the done channel is closed
+func (c *WebSocketClient) pingRoutine(conn *websocket.Conn, done chan struct{}) {
+	if c.conf.KeepAlive <= 0 {
+		return
+	}
+
+	t := time.NewTicker(c.conf.KeepAlive)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+			c.writeMu.Lock()
+			err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(c.conf.CommunicationTimeout))
+			c.writeMu.Unlock()
+			if err != nil {
+				log.Debug("Failed to send WebSocket ping: ", err)
+			}
+		}
+	}
+}
+
+func (c *WebSocketClient) handleFrame(data []byte) {
+	data, err := serialization.Decompress(data, c.conf.MaxMessageSize)
+	if err != nil {
+		logging.Error(err, "Failed to decompress WebSocket frame")
+		return
+	}
+
+	var frame messages.Frame
+	err = c.deserializer.Deserialize(data, &frame)
+	if err != nil {
+		logging.Error(err, "Failed to deserialize WebSocket frame")
+		return
+	}
+
+	switch frame.Type {
+	case messages.FrameSync:
+		if c.syncCb != nil {
+			var syncMessage messages.Sync
+			err := c.decodePayload(frame.Type, frame.Payload, &syncMessage)
+			if err != nil {
+				logging.Error(err, "Failed to deserialize sync message")
+			} else if syncMessage == nil {
+				log.Error("Received empty sync message")
+			} else {
+				c.syncCb(syncMessage)
+			}
+		}
+	case messages.FrameCommand:
+		if c.commandCb != nil {
+			var commandMessage messages.ServerCommand
+			err := c.decodePayload(frame.Type, frame.Payload, &commandMessage)
+			if err != nil {
+				logging.Error(err, "Failed to deserialize server command message")
+			} else {
+				c.commandCb(&commandMessage)
+			}
+		}
+	case messages.FrameEventsAck:
+		if c.eventsAckCb != nil {
+			var ackMessage messages.EventsAck
+			err := c.decodePayload(frame.Type, frame.Payload, &ackMessage)
+			if err != nil {
+				logging.Error(err, "Failed to deserialize events ack message")
+			} else {
+				c.eventsAckCb(&ackMessage)
+			}
+		}
+	default:
+		log.Warnf("Received unexpected WebSocket frame: '%s'", frame.Type)
+	}
+}
+
+// decodePayload converts a generic frame payload of the given type to a message
+// structure, verifying its envelope and decompressing the wrapped message if needed
+func (c *WebSocketClient) decodePayload(frameType messages.FrameType, payload interface{}, message interface{}) error {
+	data, err := c.serializer.Serialize(payload)
+	if err != nil {
+		return err
+	}
+	data, err = c.security.open(c.deserializer, frameType, data)
+	if err != nil {
+		return err
+	}
+	data, err = serialization.Decompress(data, c.conf.MaxMessageSize)
+	if err != nil {
+		return err
+	}
+	return c.deserializer.Deserialize(data, message)
+}
+
+// mapWebSocketErr converts from WebSocket errors to worker errors
+func mapWebSocketErr(err error) error {
+	if eris.Is(err, websocket.ErrCloseSent) {
+		return ErrNotConnected
+	}
+	return err
+}
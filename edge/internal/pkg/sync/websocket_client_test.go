@@ -0,0 +1,627 @@
+package sync
+
+import (
+	"crypto/ed25519"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"devais.it/kronos/internal/pkg/config"
+	"devais.it/kronos/internal/pkg/db"
+	"devais.it/kronos/internal/pkg/db/models"
+	"devais.it/kronos/internal/pkg/security"
+	"devais.it/kronos/internal/pkg/serialization"
+	"devais.it/kronos/internal/pkg/services"
+	"devais.it/kronos/internal/pkg/sync/messages"
+	"devais.it/kronos/internal/pkg/types"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/suite"
+)
+
+// fakeWebSocketServer is an in-process server application speaking
+// the WebSocket synchronization protocol
+type fakeWebSocketServer struct {
+	sync.Mutex
+	server     *httptest.Server
+	upgrader   websocket.Upgrader
+	serializer *serialization.JSONSerializer
+	conn       *websocket.Conn
+	header     http.Header
+	frames     []messages.Frame
+	// compressed counts the received compressed frames of every type
+	compressed map[messages.FrameType]int
+	// compressor compresses sent frames
+	compressor *serialization.Compressor
+	// pongs counts the received pongs
+	pongs int
+	// closed counts the connections closed by the client
+	closed int
+}
+
+func newFakeWebSocketServer() *fakeWebSocketServer {
+	s := &fakeWebSocketServer{
+		serializer: serialization.DefaultJSONSerializer(),
+		compressed: map[messages.FrameType]int{},
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+func (s *fakeWebSocketServer) URL() string {
+	return "ws" + strings.TrimPrefix(s.server.URL, "http") + "/kronos/device/{deviceId}/ws"
+}
+
+func (s *fakeWebSocketServer) handle(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	conn.SetPongHandler(func(string) error {
+		s.Lock()
+		s.pongs++
+		s.Unlock()
+		return nil
+	})
+
+	s.Lock()
+	s.conn = conn
+	s.header = r.Header
+	s.Unlock()
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			s.Lock()
+			s.closed++
+			s.Unlock()
+			return
+		}
+
+		compressed := serialization.IsCompressed(data)
+		data, err = serialization.Decompress(data, 0)
+		if err != nil {
+			return
+		}
+
+		var frame messages.Frame
+		if err := s.serializer.Deserialize(data, &frame); err != nil {
+			return
+		}
+
+		s.Lock()
+		s.frames = append(s.frames, frame)
+		if compressed {
+			s.compressed[frame.Type]++
+		}
+		s.Unlock()
+	}
+}
+
+func (s *fakeWebSocketServer) send(frameType messages.FrameType, payload interface{}) error {
+	data, err := s.serializer.Serialize(&messages.Frame{
+		Type:    frameType,
+		Payload: payload,
+	})
+	if err != nil {
+		return err
+	}
+
+	data, err = s.compressor.Compress(data)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	return s.conn.WriteMessage(websocket.BinaryMessage, data)
+}
+
+func (s *fakeWebSocketServer) ping() error {
+	s.Lock()
+	defer s.Unlock()
+	return s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(timeout))
+}
+
+func (s *fakeWebSocketServer) pongsCount() int {
+	s.Lock()
+	defer s.Unlock()
+	return s.pongs
+}
+
+func (s *fakeWebSocketServer) closedCount() int {
+	s.Lock()
+	defer s.Unlock()
+	return s.closed
+}
+
+func (s *fakeWebSocketServer) closeConn() error {
+	s.Lock()
+	defer s.Unlock()
+	return s.conn.Close()
+}
+
+func (s *fakeWebSocketServer) framesOfType(frameType messages.FrameType) []messages.Frame {
+	s.Lock()
+	defer s.Unlock()
+
+	var result []messages.Frame
+	for _, frame := range s.frames {
+		if frame.Type == frameType {
+			result = append(result, frame)
+		}
+	}
+	return result
+}
+
+type WebSocketClientTestSuite struct {
+	db.SuiteBase
+	server *fakeWebSocketServer
+	client *WebSocketClient
+}
+
+func (s *WebSocketClientTestSuite) SetupTest() {
+	s.SuiteBase.SetupTest()
+
+	s.server = newFakeWebSocketServer()
+
+	conf := testSyncConfig()
+	conf.ClientType = config.SyncClientWebSocket
+	conf.TelemetryEnabled = false
+	conf.WebSocket.URL = s.server.URL()
+	conf.WebSocket.Username = "user"
+	conf.WebSocket.Password = "password"
+	conf.WebSocket.CommunicationTimeout = timeout
+	conf.WebSocket.MaxRetries = 1
+	conf.WebSocket.MaxEntitiesPerMessage = 2
+
+	client, err := NewWebSocketClient(conf)
+	s.Require().NoError(err)
+	s.client = client
+}
+
+func (s *WebSocketClientTestSuite) TearDownTest() {
+	s.Require().NoError(s.client.Disconnect())
+	s.server.server.Close()
+}
+
+func (s *WebSocketClientTestSuite) TestConnection() {
+	assert := s.Require()
+
+	connected := false
+	s.client.SetConnectionCallback(func() {
+		// The connected message is sent before the callback is called
+		assert.Eventually(func() bool {
+			return len(s.server.framesOfType(messages.FrameConnected)) == 1
+		}, timeout, tick)
+		connected = true
+	})
+
+	var disconnectionErr error
+	disconnected := make(chan struct{})
+	s.client.SetDisconnectionCallback(func(err error) {
+		disconnectionErr = err
+		close(disconnected)
+	})
+
+	assert.ErrorIs(s.client.Subscribe(), ErrNotConnected)
+
+	assert.NoError(s.client.Connect())
+	assert.True(connected)
+	assert.NoError(s.client.Subscribe())
+
+	assert.Eventually(func() bool {
+		return len(s.server.framesOfType(messages.FrameConnected)) == 1
+	}, timeout, tick)
+
+	s.server.Lock()
+	_, _, hasAuth := (&http.Request{Header: s.server.header}).BasicAuth()
+	s.server.Unlock()
+	assert.True(hasAuth)
+
+	// Connection lost
+	assert.NoError(s.server.closeConn())
+
+	assert.Eventually(func() bool {
+		select {
+		case <-disconnected:
+			return true
+		default:
+			return false
+		}
+	}, timeout, tick)
+	assert.Error(disconnectionErr)
+
+	assert.ErrorIs(s.client.PublishEvents([]messages.Event{}), ErrNotConnected)
+}
+
+func (s *WebSocketClientTestSuite) TestConnectionFailure() {
+	assert := s.Require()
+
+	connected := false
+	s.client.SetConnectionCallback(func() {
+		connected = true
+	})
+	disconnected := false
+	s.client.SetDisconnectionCallback(func(err error) {
+		disconnected = true
+	})
+
+	// The connected message can't be compressed
+	serializationConf := &s.client.conf.Serialization
+	serializationConf.CompressionThreshold = 0
+	serializationConf.TopicCompression = map[string]serialization.Compression{
+		string(messages.FrameConnected): serialization.Compression(10),
+	}
+
+	assert.Error(s.client.Connect())
+	assert.False(connected)
+	assert.False(s.client.isConnected())
+
+	// The connection is closed and its routines stopped
+	assert.Eventually(func() bool {
+		return s.server.closedCount() == 1
+	}, timeout, tick)
+	assert.ErrorIs(s.client.PublishEvents([]messages.Event{}), ErrNotConnected)
+	assert.False(disconnected)
+
+	serializationConf.TopicCompression = nil
+
+	assert.NoError(s.client.Connect())
+	assert.True(connected)
+	assert.Eventually(func() bool {
+		return len(s.server.framesOfType(messages.FrameConnected)) == 1
+	}, timeout, tick)
+}
+
+func (s *WebSocketClientTestSuite) TestPublish() {
+	assert := s.Require()
+
+	for _, id := range []string{"Item00", "Item01", "Item02"} {
+		err := services.CreateItem(&models.Item{
+			ID:   id,
+			Name: id,
+			Type: "FakeItem",
+		}, modifiedByTest)
+		assert.NoError(err)
+	}
+
+	err := services.CreateRelation(&models.Relation{
+		ParentID: "Item00",
+		ChildID:  "Item01",
+	}, modifiedByTest)
+	assert.NoError(err)
+
+	assert.NoError(s.client.Connect())
+
+	// Events
+	err = s.client.PublishEvents([]messages.Event{
+		{
+			ID:         1,
+			EntityType: types.EntityTypeItem,
+			EntityID:   "Item00",
+			TxType:     types.EventEntityCreated,
+		},
+	})
+	assert.NoError(err)
+
+	// Command response
+	err = s.client.PublishCommandResponse(&messages.CommandResponse{
+		UUID:    uuid.NewString(),
+		Success: true,
+	})
+	assert.NoError(err)
+
+	// Versions
+	assert.NoError(s.client.PublishVersions())
+
+	assert.Eventually(func() bool {
+		return len(s.server.framesOfType(messages.FrameEvents)) == 1 &&
+			len(s.server.framesOfType(messages.FrameCommandResponse)) == 1 &&
+			len(s.server.framesOfType(messages.FrameVersions)) == 2
+	}, timeout, tick)
+
+	events := s.server.framesOfType(messages.FrameEvents)[0].Payload.([]interface{})
+	assert.Len(events, 1)
+	assert.Equal("Item00", events[0].(map[string]interface{})["entity_id"])
+
+	versions := s.server.framesOfType(messages.FrameVersions)[0].Payload.(map[string]interface{})["versions"]
+	relationVersions := versions.(map[string]interface{})[string(types.EntityTypeRelation)].([]interface{})
+	assert.Len(relationVersions, 1)
+	assert.Equal("Item00->Item01", relationVersions[0].(map[string]interface{})["id"])
+
+	// Graceful disconnection
+	assert.NoError(s.client.Disconnect())
+
+	assert.Eventually(func() bool {
+		return len(s.server.framesOfType(messages.FrameDisconnected)) == 1
+	}, timeout, tick)
+}
+
+func (s *WebSocketClientTestSuite) TestReceive() {
+	assert := s.Require()
+
+	syncMessages := make(chan messages.Sync, 1)
+	s.client.SetSyncCallback(func(message messages.Sync) {
+		syncMessages <- message
+	})
+
+	commands := make(chan *messages.ServerCommand, 1)
+	s.client.SetCommandCallback(func(message *messages.ServerCommand) {
+		commands <- message
+	})
+
+	assert.NoError(s.client.Connect())
+
+	// Wait for the server to accept the connection
+	assert.Eventually(func() bool {
+		return len(s.server.framesOfType(messages.FrameConnected)) == 1
+	}, timeout, tick)
+
+	err := s.server.send(messages.FrameSync, messages.Sync{
+		{
+			EntityType: types.EntityTypeItem,
+			EntityID:   "Item00",
+			Action:     messages.SyncActionCreate,
+			Payload: map[string]interface{}{
+				"id": "Item00",
+			},
+		},
+	})
+	assert.NoError(err)
+
+	syncMessage := <-syncMessages
+	assert.Len(syncMessage, 1)
+	assert.Equal(types.EntityTypeItem, syncMessage[0].EntityType)
+	assert.Equal(messages.SyncActionCreate, syncMessage[0].Action)
+	assert.Equal("Item00", syncMessage[0].Payload["id"])
+
+	commandUUID := uuid.NewString()
+	err = s.server.send(messages.FrameCommand, &messages.ServerCommand{
+		UUID:        commandUUID,
+		CommandType: messages.CommandGetEntity,
+		EntityType:  types.EntityTypeItem,
+		EntityID:    "Item00",
+	})
+	assert.NoError(err)
+
+	command := <-commands
+	assert.Equal(commandUUID, command.UUID)
+	assert.Equal(messages.CommandGetEntity, command.CommandType)
+	assert.Equal("Item00", command.EntityID)
+}
+
+func (s *WebSocketClientTestSuite) TestSlowCallback() {
+	assert := s.Require()
+
+	received := make(chan messages.Sync, 2)
+	unblock := make(chan struct{})
+	s.client.SetSyncCallback(func(message messages.Sync) {
+		received <- message
+		<-unblock
+	})
+
+	assert.NoError(s.client.Connect())
+
+	newSyncMessage := func(id string) messages.Sync {
+		return messages.Sync{
+			{
+				EntityType: types.EntityTypeItem,
+				EntityID:   id,
+				Action:     messages.SyncActionCreate,
+				Payload:    map[string]interface{}{"id": id},
+			},
+		}
+	}
+
+	assert.NoError(s.server.send(messages.FrameSync, newSyncMessage("Item00")))
+	assert.NoError(s.server.send(messages.FrameSync, newSyncMessage("Item01")))
+	assert.Equal("Item00", (<-received)[0].EntityID)
+
+	// Control frames are handled while a callback is running
+	assert.NoError(s.server.ping())
+	assert.Eventually(func() bool {
+		return s.server.pongsCount() == 1
+	}, timeout, tick)
+	assert.Empty(received)
+
+	// Messages are dispatched in order
+	close(unblock)
+	assert.Equal("Item01", (<-received)[0].EntityID)
+}
+
+func (s *WebSocketClientTestSuite) TestCompression() {
+	assert := s.Require()
+
+	serializationConf := &s.client.conf.Serialization
+	serializationConf.Compression = serialization.CompressionGzip
+	serializationConf.CompressionThreshold = 64
+	serializationConf.TopicCompression = map[string]serialization.Compression{
+		"events":           serialization.CompressionSnappy,
+		"COMMAND_RESPONSE": serialization.CompressionNone,
+	}
+
+	s.server.compressor = &serialization.Compressor{Compression: serialization.CompressionGzip}
+
+	syncMessages := make(chan messages.Sync, 1)
+	s.client.SetSyncCallback(func(message messages.Sync) {
+		syncMessages <- message
+	})
+
+	assert.NoError(s.client.Connect())
+
+	err := s.client.PublishEvents([]messages.Event{
+		{
+			ID:         1,
+			EntityType: types.EntityTypeItem,
+			EntityID:   "Item00",
+			TxType:     types.EventEntityCreated,
+		},
+	})
+	assert.NoError(err)
+
+	err = s.client.PublishCommandResponse(&messages.CommandResponse{
+		UUID:    uuid.NewString(),
+		Success: true,
+		Body:    map[string]interface{}{"padding": strings.Repeat("x", 128)},
+	})
+	assert.NoError(err)
+
+	assert.Eventually(func() bool {
+		return len(s.server.framesOfType(messages.FrameEvents)) == 1 &&
+			len(s.server.framesOfType(messages.FrameCommandResponse)) == 1
+	}, timeout, tick)
+
+	events := s.server.framesOfType(messages.FrameEvents)[0].Payload.([]interface{})
+	assert.Equal("Item00", events[0].(map[string]interface{})["entity_id"])
+
+	s.server.Lock()
+	assert.Equal(1, s.server.compressed[messages.FrameEvents])
+	assert.Zero(s.server.compressed[messages.FrameCommandResponse])
+	s.server.Unlock()
+
+	// Incoming compressed messages are decompressed
+	err = s.server.send(messages.FrameSync, messages.Sync{
+		{
+			EntityType: types.EntityTypeItem,
+			EntityID:   "Item00",
+			Action:     messages.SyncActionCreate,
+			Payload: map[string]interface{}{
+				"id": "Item00",
+			},
+		},
+	})
+	assert.NoError(err)
+
+	syncMessage := <-syncMessages
+	assert.Len(syncMessage, 1)
+	assert.Equal("Item00", syncMessage[0].Payload["id"])
+}
+
+func (s *WebSocketClientTestSuite) TestSecurity() {
+	assert := s.Require()
+
+	dir := s.T().TempDir()
+	saveKey := func(name string, keyType security.KeyType) (string, string) {
+		privatePEM, publicPEM, err := security.GenerateKey(keyType)
+		assert.NoError(err)
+		privatePath := filepath.Join(dir, name)
+		assert.NoError(ioutil.WriteFile(privatePath, privatePEM, 0600))
+		assert.NoError(ioutil.WriteFile(privatePath+".pub", publicPEM, 0644))
+		return privatePath, privatePath + ".pub"
+	}
+
+	deviceKey, deviceKeyPub := saveKey("device", security.KeySigning)
+	serverKey, serverKeyPub := saveKey("server", security.KeySigning)
+
+	conf := *s.client.syncConf
+	conf.Security.SigningKeyFile = deviceKey
+	conf.Security.ServerKeyFiles = []string{serverKeyPub}
+
+	client, err := NewWebSocketClient(&conf)
+	assert.NoError(err)
+	// Replace the default client, to disconnect it on tear down
+	s.client = client
+
+	syncMessages := make(chan messages.Sync, 1)
+	client.SetSyncCallback(func(message messages.Sync) {
+		syncMessages <- message
+	})
+
+	assert.NoError(client.Connect())
+
+	// Outgoing events are signed
+	err = client.PublishEvents([]messages.Event{
+		{
+			ID:         1,
+			EntityType: types.EntityTypeItem,
+			EntityID:   "Item00",
+			TxType:     types.EventEntityCreated,
+		},
+	})
+	assert.NoError(err)
+
+	assert.Eventually(func() bool {
+		return len(s.server.framesOfType(messages.FrameEvents)) == 1
+	}, timeout, tick)
+
+	var envelope security.Envelope
+	frame := s.server.framesOfType(messages.FrameEvents)[0]
+	data, err := s.server.serializer.Serialize(frame.Payload)
+	assert.NoError(err)
+	assert.NoError(s.server.serializer.Deserialize(data, &envelope))
+
+	deviceVerifyingKey, err := security.LoadVerifyingKey(deviceKeyPub)
+	assert.NoError(err)
+	opener, err := security.NewOpener([]ed25519.PublicKey{deviceVerifyingKey}, nil)
+	assert.NoError(err)
+	payload, err := opener.Open(string(messages.FrameEvents), &envelope)
+	assert.NoError(err)
+	payload, err = serialization.Decompress(payload, 0)
+	assert.NoError(err)
+	assert.Contains(string(payload), "Item00")
+
+	newSyncMessage := func(id string) messages.Sync {
+		return messages.Sync{
+			{
+				EntityType: types.EntityTypeItem,
+				EntityID:   id,
+				Action:     messages.SyncActionCreate,
+				Payload: map[string]interface{}{
+					"id": id,
+				},
+			},
+		}
+	}
+
+	// Unsigned incoming messages are rejected
+	assert.NoError(s.server.send(messages.FrameSync, newSyncMessage("Forged")))
+
+	// Signed incoming messages are accepted
+	serverSigningKey, err := security.LoadSigningKey(serverKey)
+	assert.NoError(err)
+	sealer, err := security.NewSealer(serverSigningKey, nil)
+	assert.NoError(err)
+	data, err = s.server.serializer.Serialize(newSyncMessage("Item00"))
+	assert.NoError(err)
+	signed, err := sealer.Seal(string(messages.FrameSync), data)
+	assert.NoError(err)
+	assert.NoError(s.server.send(messages.FrameSync, signed))
+
+	received := <-syncMessages
+	assert.Len(received, 1)
+	assert.Equal("Item00", received[0].Payload["id"])
+
+	// Replayed messages, and messages signed as another type, are rejected
+	assert.NoError(s.server.send(messages.FrameSync, signed))
+
+	data, err = s.server.serializer.Serialize(newSyncMessage("Moved"))
+	assert.NoError(err)
+	moved, err := sealer.Seal(string(messages.FrameCommand), data)
+	assert.NoError(err)
+	assert.NoError(s.server.send(messages.FrameSync, moved))
+
+	// Compressed signed messages are accepted
+	data, err = s.server.serializer.Serialize(newSyncMessage("Item01"))
+	assert.NoError(err)
+	data, err = (&serialization.Compressor{Compression: serialization.CompressionGzip}).Compress(data)
+	assert.NoError(err)
+	signed, err = sealer.Seal(string(messages.FrameSync), data)
+	assert.NoError(err)
+	assert.NoError(s.server.send(messages.FrameSync, signed))
+
+	received = <-syncMessages
+	assert.Len(received, 1)
+	assert.Equal("Item01", received[0].Payload["id"])
+	assert.Empty(syncMessages)
+}
+
+func TestWebSocketClient(t *testing.T) {
+	suite.Run(t, new(WebSocketClientTestSuite))
+}
@@ -9,7 +9,6 @@ import (
 
 	"devais.it/kronos/internal/pkg/config"
 	"devais.it/kronos/internal/pkg/db"
-	"devais.it/kronos/internal/pkg/db/models"
 	"devais.it/kronos/internal/pkg/logging"
 	"devais.it/kronos/internal/pkg/services"
 	"devais.it/kronos/internal/pkg/util"
@@ -27,6 +26,7 @@ const (
 	stateConnecting  = "connecting"
 	stateSubscribing = "subscribing"
 	statePubVersions = "pubVersions"
+	stateReconciling = "reconciling"
 	stateDequeueing  = "dequeueing"
 	stateStopped     = "stopped"
 
@@ -34,6 +34,8 @@ const (
 	eventConnected         = "connected"
 	eventSubscribed        = "subscribed"
 	eventVersionsPublished = "versionsPublished"
+	eventDigestPublished   = "digestPublished"
+	eventReconciled        = "reconciled"
 	eventDisconnected      = "disconnected"
 	eventStop              = "stop"
 )
@@ -43,6 +45,7 @@ var (
 		stateConnecting,
 		stateSubscribing,
 		statePubVersions,
+		stateReconciling,
 		stateDequeueing,
 		stateStopped,
 	}
@@ -51,6 +54,8 @@ var (
 		{Name: eventConnected, Src: []string{stateConnecting}, Dst: stateSubscribing},
 		{Name: eventSubscribed, Src: []string{stateSubscribing}, Dst: statePubVersions},
 		{Name: eventVersionsPublished, Src: []string{statePubVersions}, Dst: stateDequeueing},
+		{Name: eventDigestPublished, Src: []string{statePubVersions}, Dst: stateReconciling},
+		{Name: eventReconciled, Src: []string{stateReconciling}, Dst: stateDequeueing},
 		{Name: eventDisconnected, Src: allStates, Dst: stateConnecting},
 		{Name: eventStop, Src: allStates, Dst: stateStopped},
 	}
@@ -63,6 +68,10 @@ var (
 	pingResponseMsg = "pong"
 )
 
+// ConflictCallback is called for every committed sync entry
+// which conflicted with local edits
+type ConflictCallback func(entry messages.SyncAckEntry)
+
 type Worker struct {
 	conf *config.SyncConfig
 	fsm  *fsm.FSM
@@ -76,8 +85,16 @@ type Worker struct {
 	timeMutex    sync.Mutex
 	lastSyncTime time.Time
 
-	syncCallbacks []SyncCallback
-	syncCbMutex   sync.RWMutex
+	reconciliationStart time.Time
+
+	lastCompactionTime  time.Time
+	lastCompactionCount int64
+
+	lastHistoryRetentionTime time.Time
+
+	syncCallbacks     []SyncCallback
+	conflictCallbacks []ConflictCallback
+	syncCbMutex       sync.RWMutex
 
 	// Prometheus collectors
 	cyclesCounter       prometheus.Counter
@@ -85,18 +102,38 @@ type Worker struct {
 	msgsReceivedCounter prometheus.Counter
 	panicsCounter       prometheus.Counter
 	pubEventsCounter    prometheus.Counter
+	compactedCounter    prometheus.Counter
+	redeliveredCounter  prometheus.Counter
 }
 
 func NewWorker(conf *config.SyncConfig) (*Worker, error) {
 	var client Client
 
-	if conf.ClientType == config.SyncClientMQTT {
-		mqttClient, err := NewMQTTClient(conf)
+	switch conf.ClientType {
+	case config.SyncClientMQTT:
+		switch conf.MQTT.ProtocolVersion {
+		case config.MQTTProtocolV311:
+			mqttClient, err := NewMQTTClient(conf)
+			if err != nil {
+				return nil, eris.Wrap(err, "Failed to create sync worker client")
+			}
+			client = mqttClient
+		case config.MQTTProtocolV5:
+			mqttClient, err := NewMQTT5Client(conf)
+			if err != nil {
+				return nil, eris.Wrap(err, "Failed to create sync worker client")
+			}
+			client = mqttClient
+		default:
+			return nil, eris.Errorf("Unknown MQTT protocol version: %v", conf.MQTT.ProtocolVersion)
+		}
+	case config.SyncClientWebSocket:
+		wsClient, err := NewWebSocketClient(conf)
 		if err != nil {
 			return nil, eris.Wrap(err, "Failed to create sync worker client")
 		}
-		client = mqttClient
-	} else {
+		client = wsClient
+	default:
 		return nil, eris.Errorf("Unknown sync client type: %v", conf.ClientType)
 	}
 
@@ -125,6 +162,14 @@ func NewWorker(conf *config.SyncConfig) (*Worker, error) {
 			Name: "kronos_published_events_total",
 			Help: "The number of published events",
 		}),
+		compactedCounter: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "kronos_compacted_events_total",
+			Help: "The number of events removed by queue compaction",
+		}),
+		redeliveredCounter: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "kronos_redelivered_events_total",
+			Help: "The number of events delivered again after a missing or failed acknowledgement",
+		}),
 	}
 
 	return worker, nil
@@ -137,6 +182,8 @@ func (w *Worker) Collectors() []prometheus.Collector {
 		w.msgsReceivedCounter,
 		w.panicsCounter,
 		w.pubEventsCounter,
+		w.compactedCounter,
+		w.redeliveredCounter,
 	}
 }
 
@@ -160,6 +207,28 @@ func (w *Worker) SignalSyncEvent(message messages.Sync) {
 	}
 }
 
+func (w *Worker) AddConflictCallback(cb ConflictCallback) {
+	w.syncCbMutex.Lock()
+	defer w.syncCbMutex.Unlock()
+
+	w.conflictCallbacks = append(w.conflictCallbacks, cb)
+}
+
+// SignalConflicts signals conflicts of a committed sync message to registered callbacks
+func (w *Worker) SignalConflicts(ack *messages.SyncAck) {
+	w.syncCbMutex.RLock()
+	defer w.syncCbMutex.RUnlock()
+
+	for _, entry := range ack.Entries {
+		if entry.Conflict == "" {
+			continue
+		}
+		for _, cb := range w.conflictCallbacks {
+			cb(entry)
+		}
+	}
+}
+
 func (w *Worker) Start() error {
 	c := w.client
 
@@ -167,6 +236,7 @@ func (w *Worker) Start() error {
 	c.SetDisconnectionCallback(w.onDisconnected)
 	c.SetSyncCallback(w.onSyncMessage)
 	c.SetCommandCallback(w.onServerCommandMessage)
+	c.SetEventsAckCallback(w.onEventsAck)
 
 	go w.workerRoutine()
 	log.Info("Sync worker started")
@@ -243,7 +313,9 @@ func (w *Worker) onDisconnected(err error) {
 	defer w.cbMutex.Unlock()
 
 	state := w.fsm.Current()
-	if state != stateConnecting {
+	// A stopped worker must not be restarted by the disconnection
+	// triggered by Stop itself
+	if state != stateConnecting && state != stateStopped {
 		if err != nil {
 			log.Error("Sync worker disconnected, error: ", err)
 		}
@@ -254,6 +326,10 @@ func (w *Worker) onDisconnected(err error) {
 }
 
 func (w *Worker) dequeueEvents() error {
+	if w.conf.Delivery.AckEnabled {
+		return w.deliverEvents()
+	}
+
 	// Dequeue events in a transaction
 	return db.DB().Transaction(func(tx *gorm.DB) error {
 		count, err := services.TryDequeueEvents(tx, w.conf.MaxEvents, w.publishEvents)
@@ -269,6 +345,77 @@ func (w *Worker) dequeueEvents() error {
 	})
 }
 
+// compactEvents compacts the events queue when the configured interval elapsed,
+// or when enough events were queued since the last compaction.
+// Compaction errors are logged, without preventing events dequeueing.
+func (w *Worker) compactEvents() {
+	conf := &w.conf.Compaction
+	if !conf.Enabled {
+		return
+	}
+
+	count, err := services.GetEventsCount()
+	if err != nil {
+		logging.Error(err, "Failed to get events count for compaction")
+		return
+	}
+
+	due := conf.Interval > 0 && time.Since(w.lastCompactionTime) >= conf.Interval
+	due = due || (conf.Threshold > 0 && count-w.lastCompactionCount >= conf.Threshold)
+	if !due {
+		return
+	}
+
+	var saved int
+	err = db.DB().Transaction(func(tx *gorm.DB) error {
+		saved, err = services.CompactEvents(tx)
+		return err
+	})
+
+	w.lastCompactionTime = time.Now()
+
+	if err != nil {
+		w.lastCompactionCount = count
+		logging.Error(err, "Failed to compact events queue")
+		return
+	}
+
+	w.lastCompactionCount = count - int64(saved)
+
+	if saved > 0 {
+		log.Infof("Events queue compacted, %d events saved", saved)
+	}
+
+	// Metrics
+	w.compactedCounter.Add(float64(saved))
+}
+
+// applyHistoryRetention applies the retention limits to the attributes history
+// when the configured interval elapsed, regardless of the connection state.
+// Errors are logged, without affecting the synchronization.
+func (w *Worker) applyHistoryRetention() {
+	conf := &db.Config().History
+	if conf.RetentionInterval <= 0 || (conf.MaxAge <= 0 && conf.MaxPoints <= 0) {
+		return
+	}
+	if time.Since(w.lastHistoryRetentionTime) < conf.RetentionInterval {
+		return
+	}
+
+	removed, err := services.ApplyHistoryRetention()
+
+	w.lastHistoryRetentionTime = time.Now()
+
+	if err != nil {
+		logging.Error(err, "Failed to apply attributes history retention")
+		return
+	}
+
+	if removed > 0 {
+		log.Infof("Attributes history retention applied, %d values removed", removed)
+	}
+}
+
 func (w *Worker) onSyncMessage(message messages.Sync) {
 	defer func() {
 		if err := recover(); err != nil {
@@ -278,7 +425,15 @@ func (w *Worker) onSyncMessage(message messages.Sync) {
 
 	telemetry.SetLastMessageReceivedTs()
 
-	if err := w.handleSyncMessage(message); err != nil {
+	ack, err := w.handleSyncMessage(message)
+
+	if ack != nil && w.conf.SyncAckEnabled {
+		if err := w.client.PublishSyncAck(ack); err != nil {
+			logging.Error(err, "Failed to publish sync acknowledgement")
+		}
+	}
+
+	if err != nil {
 		logging.Error(err, "Failed to handle sync message")
 	} else {
 		log.Debug("Sync message handled")
@@ -302,6 +457,7 @@ func (w *Worker) onSyncMessage(message messages.Sync) {
 
 		// Signal event to registered callbacks
 		w.SignalSyncEvent(message)
+		w.SignalConflicts(ack)
 	}
 
 	// Metrics
@@ -337,6 +493,18 @@ func (w *Worker) onServerCommandMessage(message *messages.ServerCommand) {
 		response.Body, err = w.handleGetEntityCommand(message.EntityType, message.EntityID)
 	case messages.CommandGetTelemetry:
 		response.Body, err = w.handleGetTelemetryCommand()
+	case messages.CommandGetSubtree, messages.CommandGetItemsByType, messages.CommandGetRelations:
+		response.Body, err = w.handleSnapshotCommand(message)
+	case messages.CommandApplyTx:
+		response.Body, err = w.handleApplyTransactionCommand(message)
+	case messages.CommandSetSchema:
+		response.Body, err = w.handleSetSchemaCommand(message)
+	case messages.CommandGetBucketHashes:
+		response.Body, err = w.handleBucketHashesCommand(message)
+	case messages.CommandGetBucketVersions:
+		response.Body, err = w.handleBucketVersionsCommand(message)
+	case messages.CommandReconciled:
+		response.Body, err = w.handleReconciledCommand()
 	default:
 		err = eris.Errorf("Unknown command: '%s'", message.CommandType)
 	}
@@ -369,10 +537,10 @@ func (w *Worker) publishVersions() error {
 	return w.client.PublishVersions()
 }
 
-func (w *Worker) publishEvents(events []models.Event) error {
-	eventMessages := make([]messages.Event, 0, len(events))
+func (w *Worker) publishEvents(batch *services.EventsBatch) error {
+	eventMessages := make([]messages.Event, 0, len(batch.Events))
 
-	for _, event := range events {
+	for _, event := range batch.Events {
 		var eventBody map[string]interface{}
 
 		err := event.UnmarshalBody(&eventBody)
@@ -389,8 +557,11 @@ func (w *Worker) publishEvents(events []models.Event) error {
 			TxType:      event.EventType,
 			TxLen:       event.TxLen,
 			TxIndex:     event.TxIndex,
+			TxPart:      batch.Part,
+			TxParts:     batch.Parts,
 			Timestamp:   event.Timestamp,
 			Body:        eventBody,
+			BatchID:     batch.ID,
 		}
 
 		eventMessages = append(eventMessages, eventMsg)
@@ -409,6 +580,8 @@ func (w *Worker) doWork(ticker *time.Ticker, backOff *backoff.ExponentialBackOff
 		return false
 	}
 
+	w.applyHistoryRetention()
+
 	var err error
 
 	switch state {
@@ -424,7 +597,13 @@ func (w *Worker) doWork(ticker *time.Ticker, backOff *backoff.ExponentialBackOff
 			w.fsmEvent(eventSubscribed)
 		}
 	case statePubVersions:
-		if w.conf.PublishVersions {
+		if w.conf.PublishVersions && w.conf.Reconciliation.Enabled {
+			err = w.publishVersionsDigest()
+			if err == nil {
+				log.Info("Sync worker published versions digest")
+				w.fsmEvent(eventDigestPublished)
+			}
+		} else if w.conf.PublishVersions {
 			err = w.publishVersions()
 			if err == nil {
 				log.Info("Sync worker published versions")
@@ -435,7 +614,11 @@ func (w *Worker) doWork(ticker *time.Ticker, backOff *backoff.ExponentialBackOff
 			err = nil
 			w.fsmEvent(eventVersionsPublished)
 		}
+	case stateReconciling:
+		// Events are not dequeued until the server application completes the reconciliation
+		err = w.checkReconciliation()
 	case stateDequeueing:
+		w.compactEvents()
 		err = w.dequeueEvents()
 		if err == nil {
 			log.Debug("Sync worker dequeued events")
@@ -445,7 +628,7 @@ func (w *Worker) doWork(ticker *time.Ticker, backOff *backoff.ExponentialBackOff
 	}
 
 	if err != nil {
-		if eris.Is(err, gorm.ErrRecordNotFound) {
+		if eris.Is(err, gorm.ErrRecordNotFound) || eris.Is(err, errReconciling) || eris.Is(err, errAwaitingAck) {
 			ticker.Reset(backOff.InitialInterval)
 		} else {
 			if eris.Is(err, ErrNotConnected) {
@@ -2,6 +2,8 @@ package sync
 
 import (
 	"devais.it/kronos/internal/pkg/sync/messages"
+	"devais.it/kronos/internal/pkg/util"
+	"fmt"
 	"sync"
 	"testing"
 	"time"
@@ -14,6 +16,7 @@ import (
 	"devais.it/kronos/internal/pkg/types"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/suite"
+	"gorm.io/gorm"
 )
 
 const (
@@ -40,20 +43,28 @@ func testSyncConfig() *config.SyncConfig {
 
 type testClient struct {
 	sync.Mutex
+	// connectErr is returned by Connect, if set
+	connectErr        error
 	connected         bool
 	subscribed        bool
 	versionsPublished bool
+	digests           []messages.VersionsDigest
 	connectionCb      ConnectionCallback
 	disconnectionCb   DisconnectionCallback
 	syncCb            SyncCallback
 	commandCb         CommandCallback
+	eventsAckCb       EventsAckCallback
 	events            []messages.Event
 	commandResponses  []messages.CommandResponse
+	syncAcks          []messages.SyncAck
 }
 
 func (c *testClient) Connect() error {
 	c.Lock()
 	defer c.Unlock()
+	if c.connectErr != nil {
+		return c.connectErr
+	}
 	c.connected = true
 	if c.connectionCb != nil {
 		c.connectionCb()
@@ -97,6 +108,12 @@ func (c *testClient) SetCommandCallback(cb CommandCallback) {
 	c.commandCb = cb
 }
 
+func (c *testClient) SetEventsAckCallback(cb EventsAckCallback) {
+	c.Lock()
+	defer c.Unlock()
+	c.eventsAckCb = cb
+}
+
 func (c *testClient) Subscribe() error {
 	c.Lock()
 	defer c.Unlock()
@@ -111,6 +128,13 @@ func (c *testClient) PublishVersions() error {
 	return nil
 }
 
+func (c *testClient) PublishVersionsDigest(digest *messages.VersionsDigest) error {
+	c.Lock()
+	defer c.Unlock()
+	c.digests = append(c.digests, *digest)
+	return nil
+}
+
 func (c *testClient) PublishEvents(events []messages.Event) error {
 	c.Lock()
 	defer c.Unlock()
@@ -126,6 +150,13 @@ func (c *testClient) PublishCommandResponse(message *messages.CommandResponse) e
 	return nil
 }
 
+func (c *testClient) PublishSyncAck(message *messages.SyncAck) error {
+	c.Lock()
+	defer c.Unlock()
+	c.syncAcks = append(c.syncAcks, *message)
+	return nil
+}
+
 type WorkerTestSuite struct {
 	db.SuiteBase
 }
@@ -138,6 +169,7 @@ func (s *WorkerTestSuite) TestEvents() {
 	//=========================================================================
 
 	conf := testSyncConfig()
+	conf.SyncAckEnabled = true
 
 	worker, err := NewWorker(conf)
 	assert.NoError(err)
@@ -210,6 +242,15 @@ func (s *WorkerTestSuite) TestEvents() {
 		assert.Equal(itemType, firstEvent.Body["type"])
 	}()
 
+	func() {
+		client.Lock()
+		defer client.Unlock()
+
+		assert.Len(client.syncAcks, 1)
+		assert.True(client.syncAcks[0].Committed)
+		assert.Equal(messages.SyncEntryApplied, client.syncAcks[0].Entries[0].Status)
+	}()
+
 	createdItem, err := services.GetItemByID(itemID)
 	assert.NoError(err)
 	assert.Equal(itemID, createdItem.ID)
@@ -338,6 +379,806 @@ func (s *WorkerTestSuite) TestCommands() {
 	assert.NoError(err)
 }
 
+func (s *WorkerTestSuite) TestSnapshotCommands() {
+	assert := s.Require()
+
+	//=========================================================================
+	// Setup
+	//=========================================================================
+
+	conf := testSyncConfig()
+	conf.PublishVersions = false
+	conf.MQTT.MaxEntitiesPerMessage = 2
+
+	worker, err := NewWorker(conf)
+	assert.NoError(err)
+
+	client := &testClient{}
+	worker.client = client
+
+	err = worker.Start()
+	assert.NoError(err)
+
+	assert.Eventually(func() bool {
+		return worker.fsm.Current() == stateDequeueing
+	}, timeout, tick)
+
+	// Create a tree of items: root -> child(0..2)
+	root := &models.Item{ID: "Root-ID", Name: "Root", Type: "RootItem"}
+	assert.NoError(services.CreateItem(root, modifiedByTest))
+
+	for i := 0; i < 3; i++ {
+		child := &models.Item{
+			ID:   fmt.Sprintf("Child%02d-ID", i),
+			Name: fmt.Sprintf("Child%02d", i),
+			Type: "ChildItem",
+		}
+		assert.NoError(services.CreateItem(child, modifiedByTest))
+		relation := &models.Relation{ParentID: root.ID, ChildID: child.ID}
+		assert.NoError(services.CreateRelation(relation, modifiedByTest))
+	}
+
+	sendCommand := func(command *messages.ServerCommand, expectedResponses int) []messages.CommandResponse {
+		client.Lock()
+		client.commandResponses = nil
+		client.Unlock()
+
+		client.commandCb(command)
+
+		assert.Eventually(func() bool {
+			client.Lock()
+			defer client.Unlock()
+			return len(client.commandResponses) == expectedResponses
+		}, timeout, tick)
+
+		client.Lock()
+		defer client.Unlock()
+
+		for _, response := range client.commandResponses {
+			assert.True(response.Success, response.Error)
+			assert.Equal(command.UUID, response.UUID)
+		}
+
+		return client.commandResponses
+	}
+
+	decodeSnapshot := func(response messages.CommandResponse) *messages.Snapshot {
+		snapshot := &messages.Snapshot{}
+		assert.NoError(util.JSONToStruct(response.Body, snapshot))
+		return snapshot
+	}
+
+	//=========================================================================
+	// Subtree
+	//=========================================================================
+
+	// 4 items and 3 relations, split in pages of 2 entities of each type
+	responses := sendCommand(&messages.ServerCommand{
+		UUID:        uuid.NewString(),
+		CommandType: messages.CommandGetSubtree,
+		EntityType:  types.EntityTypeItem,
+		EntityID:    root.ID,
+	}, 2)
+
+	itemsCount := 0
+	relationsCount := 0
+	for i, response := range responses {
+		snapshot := decodeSnapshot(response)
+		assert.Equal(i+1, snapshot.Page)
+		assert.Equal(2, snapshot.Pages)
+		itemsCount += len(snapshot.Items)
+		relationsCount += len(snapshot.Relations)
+	}
+	assert.Equal(4, itemsCount)
+	assert.Equal(3, relationsCount)
+
+	//=========================================================================
+	// Items by type, single page
+	//=========================================================================
+
+	responses = sendCommand(&messages.ServerCommand{
+		UUID:        uuid.NewString(),
+		CommandType: messages.CommandGetItemsByType,
+		Body: map[string]interface{}{
+			"type": "ChildItem",
+			"page": 2,
+		},
+	}, 1)
+
+	snapshot := decodeSnapshot(responses[0])
+	assert.Equal(2, snapshot.Page)
+	assert.Equal(2, snapshot.Pages)
+	assert.Len(snapshot.Items, 1)
+
+	//=========================================================================
+	// Relations
+	//=========================================================================
+
+	responses = sendCommand(&messages.ServerCommand{
+		UUID:        uuid.NewString(),
+		CommandType: messages.CommandGetRelations,
+		EntityType:  types.EntityTypeItem,
+		EntityID:    "Child00-ID",
+	}, 1)
+
+	snapshot = decodeSnapshot(responses[0])
+	assert.Len(snapshot.Relations, 1)
+	assert.Equal(root.ID, snapshot.Relations[0].ParentID)
+
+	//=========================================================================
+	// Relation version and entity
+	//=========================================================================
+
+	relationID := root.ID + "->Child00-ID"
+	relation, err := services.GetRelation(root.ID, "Child00-ID", "")
+	assert.NoError(err)
+
+	responses = sendCommand(&messages.ServerCommand{
+		UUID:        uuid.NewString(),
+		CommandType: messages.CommandGetVersion,
+		EntityType:  types.EntityTypeRelation,
+		EntityID:    relationID,
+	}, 1)
+	assert.Equal(relation.Version, responses[0].Body["version"])
+
+	responses = sendCommand(&messages.ServerCommand{
+		UUID:        uuid.NewString(),
+		CommandType: messages.CommandGetEntity,
+		EntityType:  types.EntityTypeRelation,
+		EntityID:    relationID,
+	}, 1)
+	assert.Equal(root.ID, responses[0].Body["parent_id"])
+	assert.Equal("Child00-ID", responses[0].Body["child_id"])
+
+	//=========================================================================
+	// Stop
+	//=========================================================================
+
+	err = worker.Stop()
+	assert.NoError(err)
+}
+
+func (s *WorkerTestSuite) TestApplyTransactionCommand() {
+	assert := s.Require()
+
+	//=========================================================================
+	// Setup
+	//=========================================================================
+
+	conf := testSyncConfig()
+	conf.PublishVersions = false
+
+	worker, err := NewWorker(conf)
+	assert.NoError(err)
+
+	client := &testClient{}
+	worker.client = client
+
+	err = worker.Start()
+	assert.NoError(err)
+
+	assert.Eventually(func() bool {
+		return worker.fsm.Current() == stateDequeueing
+	}, timeout, tick)
+
+	existingItem := &models.Item{ID: "Existing-ID", Name: "Existing", Type: "FakeItem"}
+	assert.NoError(services.CreateItem(existingItem, modifiedByTest))
+
+	obsoleteItem := &models.Item{ID: "Obsolete-ID", Name: "Obsolete", Type: "FakeItem"}
+	assert.NoError(services.CreateItem(obsoleteItem, modifiedByTest))
+
+	sendTransaction := func(tx *messages.ApplyTransaction) (messages.CommandResponse, *messages.TransactionResult) {
+		body, err := util.StructToJSONMap(tx)
+		assert.NoError(err)
+
+		client.Lock()
+		client.commandResponses = nil
+		client.Unlock()
+
+		command := &messages.ServerCommand{
+			UUID:        uuid.NewString(),
+			CommandType: messages.CommandApplyTx,
+			Body:        body,
+		}
+		client.commandCb(command)
+
+		client.Lock()
+		defer client.Unlock()
+
+		assert.Len(client.commandResponses, 1)
+		response := client.commandResponses[0]
+		assert.Equal(command.UUID, response.UUID)
+
+		result := &messages.TransactionResult{}
+		assert.NoError(util.JSONToStruct(response.Body, result))
+		return response, result
+	}
+
+	//=========================================================================
+	// Committed transaction
+	//=========================================================================
+
+	response, result := sendTransaction(&messages.ApplyTransaction{
+		Create: messages.TransactionCreate{
+			Items: []models.Item{
+				{
+					ID:   "New-ID",
+					Name: "New",
+					Type: "FakeItem",
+					Attributes: []models.Attribute{
+						{ID: "NewAttribute-ID", Name: "NewAttribute", Type: "FakeAttribute"},
+					},
+				},
+			},
+			Relations: []models.Relation{
+				{ParentID: existingItem.ID, ChildID: "New-ID"},
+			},
+		},
+		Update: messages.TransactionUpdate{
+			Items: []map[string]interface{}{
+				{"id": existingItem.ID, "name": "Updated"},
+			},
+			Relations: []map[string]interface{}{
+				{"parent_id": existingItem.ID, "child_id": "New-ID", "sync_policy": "TEST_POLICY"},
+			},
+		},
+		Delete: messages.TransactionDelete{
+			Items: []string{obsoleteItem.ID},
+		},
+	})
+
+	assert.True(response.Success, response.Error)
+	assert.True(result.Committed)
+	assert.NotEmpty(result.TxUUID)
+	assert.Len(result.Results, 5)
+	for _, entityResult := range result.Results {
+		assert.Equal(messages.EntityResultApplied, entityResult.Status)
+		assert.Empty(entityResult.Error)
+	}
+	assert.Equal(messages.SyncActionDelete, result.Results[4].Action)
+	assert.Equal(obsoleteItem.ID, result.Results[4].EntityID)
+
+	updatedItem, err := services.GetItemByID(existingItem.ID)
+	assert.NoError(err)
+	assert.Equal("Updated", updatedItem.Name)
+
+	_, err = services.GetAttributeByID("NewAttribute-ID")
+	assert.NoError(err)
+
+	relation, err := services.GetRelation(existingItem.ID, "New-ID", "")
+	assert.NoError(err)
+	assert.Equal("TEST_POLICY", relation.SyncPolicy.ValueOrZero())
+
+	_, err = services.GetItemByID(obsoleteItem.ID)
+	assert.ErrorIs(err, gorm.ErrRecordNotFound)
+
+	//=========================================================================
+	// Rolled back transaction
+	//=========================================================================
+
+	response, result = sendTransaction(&messages.ApplyTransaction{
+		Create: messages.TransactionCreate{
+			Items: []models.Item{
+				{ID: "Another-ID", Name: "Another", Type: "FakeItem"},
+				// Duplicate
+				{ID: existingItem.ID, Name: "Existing", Type: "FakeItem"},
+			},
+		},
+		Update: messages.TransactionUpdate{
+			Relations: []map[string]interface{}{
+				// Malformed
+				{"parent_id": []int{1}, "child_id": "New-ID"},
+			},
+		},
+		Delete: messages.TransactionDelete{
+			Attributes: []string{"NonExistingAttribute-ID"},
+		},
+	})
+
+	assert.False(response.Success)
+	assert.NotEmpty(response.Error)
+	assert.False(result.Committed)
+	assert.Len(result.Results, 4)
+	assert.Equal(messages.EntityResultRolledBack, result.Results[0].Status)
+	assert.Equal(messages.EntityResultFailed, result.Results[1].Status)
+	assert.NotEmpty(result.Results[1].Error)
+	assert.Equal(messages.EntityResultFailed, result.Results[2].Status)
+	assert.Equal(messages.SyncActionUpdate, result.Results[2].Action)
+	assert.NotEmpty(result.Results[2].Error)
+	assert.Equal(messages.EntityResultFailed, result.Results[3].Status)
+
+	_, err = services.GetItemByID("Another-ID")
+	assert.ErrorIs(err, gorm.ErrRecordNotFound)
+
+	//=========================================================================
+	// Stop
+	//=========================================================================
+
+	err = worker.Stop()
+	assert.NoError(err)
+}
+
+func (s *WorkerTestSuite) TestReconciliation() {
+	assert := s.Require()
+
+	//=========================================================================
+	// Setup
+	//=========================================================================
+
+	const buckets = 4
+	const pageSize = 4
+
+	for i := 0; i < 10; i++ {
+		err := services.CreateItem(&models.Item{
+			ID:   fmt.Sprintf("Item%02d-ID", i),
+			Name: fmt.Sprintf("Item%02d", i),
+			Type: "FakeItem",
+		}, modifiedByTest)
+		assert.NoError(err)
+	}
+	err := services.CreateRelation(&models.Relation{ParentID: "Item00-ID", ChildID: "Item01-ID"}, modifiedByTest)
+	assert.NoError(err)
+
+	conf := testSyncConfig()
+	conf.Reconciliation.Enabled = true
+	conf.Reconciliation.Buckets = buckets
+	conf.MQTT.MaxEntitiesPerMessage = pageSize
+
+	worker, err := NewWorker(conf)
+	assert.NoError(err)
+
+	client := &testClient{}
+	worker.client = client
+
+	//=========================================================================
+	// Start, a digest is published in place of full versions
+	//=========================================================================
+
+	err = worker.Start()
+	assert.NoError(err)
+
+	assert.Eventually(func() bool {
+		return worker.fsm.Current() == stateReconciling
+	}, timeout, tick)
+
+	assert.False(client.versionsPublished)
+	assert.Len(client.digests, 1)
+
+	digest := client.digests[0]
+	assert.Equal(buckets, digest.Buckets)
+	assert.Len(digest.Digests, 3)
+	assert.EqualValues(10, digest.Digests[types.EntityTypeItem].Count)
+	assert.EqualValues(0, digest.Digests[types.EntityTypeAttribute].Count)
+	assert.EqualValues(1, digest.Digests[types.EntityTypeRelation].Count)
+
+	itemsTree, err := buildVersionsTree(types.EntityTypeItem, buckets)
+	assert.NoError(err)
+	assert.Equal(itemsTree.root(), digest.Digests[types.EntityTypeItem].Root)
+
+	sendPagedCommand := func(commandType messages.CommandType, entityType types.EntityType, body map[string]interface{},
+		expectedResponses int) []messages.CommandResponse {
+
+		client.Lock()
+		count := len(client.commandResponses)
+		client.Unlock()
+
+		client.commandCb(&messages.ServerCommand{
+			UUID:        uuid.New().String(),
+			CommandType: commandType,
+			EntityType:  entityType,
+			Body:        body,
+		})
+		assert.Eventually(func() bool {
+			client.Lock()
+			defer client.Unlock()
+			return len(client.commandResponses) == count+expectedResponses
+		}, timeout, tick)
+
+		client.Lock()
+		defer client.Unlock()
+		return client.commandResponses[count:]
+	}
+
+	sendCommand := func(commandType messages.CommandType, entityType types.EntityType, body map[string]interface{}) messages.CommandResponse {
+		return sendPagedCommand(commandType, entityType, body, 1)[0]
+	}
+
+	//=========================================================================
+	// Events are not dequeued while reconciling
+	//=========================================================================
+
+	err = services.UpdateItem(map[string]interface{}{
+		"id":   "Item05-ID",
+		"name": "Item05-Edited",
+	}, modifiedByTest)
+	assert.NoError(err)
+
+	//=========================================================================
+	// Bucket hashes
+	//=========================================================================
+
+	response := sendCommand(messages.CommandGetBucketHashes, types.EntityTypeItem, nil)
+	assert.True(response.Success, response.Error)
+
+	bucketHashes := &messages.BucketHashes{}
+	assert.NoError(util.JSONToStruct(response.Body, bucketHashes))
+	assert.Equal(types.EntityTypeItem, bucketHashes.EntityType)
+	assert.Len(bucketHashes.Hashes, buckets)
+
+	// Only the bucket of the edited item diverges
+	editedBucket := bucketOf("Item05-ID", buckets)
+	for i, hash := range bucketHashes.Hashes {
+		if i == editedBucket {
+			assert.NotEqual(itemsTree.hashes()[i], hash)
+		} else {
+			assert.Equal(itemsTree.hashes()[i], hash)
+		}
+	}
+
+	response = sendCommand(messages.CommandGetBucketHashes, "INVALID", nil)
+	assert.False(response.Success)
+
+	//=========================================================================
+	// Bucket versions
+	//=========================================================================
+
+	editedBucketCount := 0
+	for i := 0; i < 10; i++ {
+		if bucketOf(fmt.Sprintf("Item%02d-ID", i), buckets) == editedBucket {
+			editedBucketCount++
+		}
+	}
+	editedBucketPages := util.CeilDiv(editedBucketCount, pageSize)
+
+	responses := sendPagedCommand(messages.CommandGetBucketVersions, types.EntityTypeItem, map[string]interface{}{
+		"buckets": []int{editedBucket},
+	}, editedBucketPages)
+
+	editedVersion, err := services.GetItemVersion("Item05-ID")
+	assert.NoError(err)
+
+	found := false
+	for i, response := range responses {
+		assert.True(response.Success, response.Error)
+
+		bucketVersions := &messages.BucketVersions{}
+		assert.NoError(util.JSONToStruct(response.Body, bucketVersions))
+		assert.Equal([]int{editedBucket}, bucketVersions.Buckets)
+		assert.Equal(i+1, bucketVersions.Page)
+		assert.Equal(editedBucketPages, bucketVersions.Pages)
+		assert.NotEmpty(bucketVersions.Versions)
+
+		for _, version := range bucketVersions.Versions {
+			assert.Equal(editedBucket, bucketOf(version.ID, buckets))
+			if version.ID == "Item05-ID" {
+				found = true
+				assert.Equal(editedVersion, version.Version)
+			}
+		}
+	}
+	assert.True(found)
+
+	// 10 items, split in pages of 4 versions
+	allBuckets := []int{0, 1, 2, 3}
+	responses = sendPagedCommand(messages.CommandGetBucketVersions, types.EntityTypeItem, map[string]interface{}{
+		"buckets": allBuckets,
+	}, 3)
+
+	ids := []string{}
+	for i, response := range responses {
+		assert.True(response.Success, response.Error)
+
+		bucketVersions := &messages.BucketVersions{}
+		assert.NoError(util.JSONToStruct(response.Body, bucketVersions))
+		assert.Equal(i+1, bucketVersions.Page)
+		assert.Equal(3, bucketVersions.Pages)
+		assert.LessOrEqual(len(bucketVersions.Versions), pageSize)
+		for _, version := range bucketVersions.Versions {
+			ids = append(ids, version.ID)
+		}
+	}
+	assert.Len(ids, 10)
+	assert.IsIncreasing(ids)
+
+	// Single page
+	response = sendCommand(messages.CommandGetBucketVersions, types.EntityTypeItem, map[string]interface{}{
+		"buckets": allBuckets,
+		"page":    3,
+	})
+	assert.True(response.Success, response.Error)
+
+	bucketVersions := &messages.BucketVersions{}
+	assert.NoError(util.JSONToStruct(response.Body, bucketVersions))
+	assert.Equal(3, bucketVersions.Page)
+	assert.Equal(3, bucketVersions.Pages)
+	assert.Len(bucketVersions.Versions, 2)
+	for i, version := range bucketVersions.Versions {
+		assert.Equal(ids[8+i], version.ID)
+	}
+
+	response = sendCommand(messages.CommandGetBucketVersions, types.EntityTypeItem, map[string]interface{}{
+		"buckets": allBuckets,
+		"page":    4,
+	})
+	assert.False(response.Success)
+
+	response = sendCommand(messages.CommandGetBucketVersions, types.EntityTypeItem, map[string]interface{}{
+		"buckets": []int{buckets},
+	})
+	assert.False(response.Success)
+
+	assert.Equal(stateReconciling, worker.fsm.Current())
+	assert.Empty(client.events)
+
+	//=========================================================================
+	// Reconciliation completed by the server
+	//=========================================================================
+
+	response = sendCommand(messages.CommandReconciled, "", nil)
+	assert.True(response.Success, response.Error)
+
+	assert.Eventually(func() bool {
+		return worker.fsm.Current() == stateDequeueing
+	}, timeout, tick)
+
+	assert.Eventually(func() bool {
+		client.Lock()
+		defer client.Unlock()
+		return len(client.events) > 0
+	}, timeout, tick)
+
+	//=========================================================================
+	// Stop
+	//=========================================================================
+
+	err = worker.Stop()
+	assert.NoError(err)
+}
+
+func (s *WorkerTestSuite) TestReconciliationTimeout() {
+	assert := s.Require()
+
+	conf := testSyncConfig()
+	conf.Reconciliation.Enabled = true
+	conf.Reconciliation.Timeout = tick
+
+	worker, err := NewWorker(conf)
+	assert.NoError(err)
+
+	client := &testClient{}
+	worker.client = client
+
+	err = worker.Start()
+	assert.NoError(err)
+
+	// The worker dequeues events even if the server never completes the reconciliation
+	assert.Eventually(func() bool {
+		return worker.fsm.Current() == stateDequeueing
+	}, timeout, tick)
+
+	assert.Len(client.digests, 1)
+
+	err = worker.Stop()
+	assert.NoError(err)
+}
+
+func (s *WorkerTestSuite) TestCompaction() {
+	assert := s.Require()
+
+	conf := testSyncConfig()
+	conf.PublishVersions = false
+	conf.Compaction.Enabled = true
+	conf.Compaction.Interval = 0
+	conf.Compaction.Threshold = 3
+
+	item := &models.Item{ID: "Compacted-ID", Name: "Compacted", Type: "FakeItem"}
+	assert.NoError(services.CreateItem(item, modifiedByTest))
+
+	// Updates with different triggers are not coalesced when published
+	for i, modifiedBy := range []string{"TEST_A", "TEST_B", "TEST_C"} {
+		err := services.UpdateItem(map[string]interface{}{
+			"id":   item.ID,
+			"name": fmt.Sprintf("Compacted%d", i),
+		}, modifiedBy)
+		assert.NoError(err)
+	}
+
+	count, err := services.GetEventsCount()
+	assert.NoError(err)
+	assert.EqualValues(4, count)
+
+	worker, err := NewWorker(conf)
+	assert.NoError(err)
+
+	client := &testClient{}
+	worker.client = client
+
+	err = worker.Start()
+	assert.NoError(err)
+
+	assert.Eventually(func() bool {
+		client.Lock()
+		defer client.Unlock()
+		return len(client.events) > 0
+	}, timeout, tick)
+
+	err = worker.Stop()
+	assert.NoError(err)
+
+	assert.Len(client.events, 1)
+	assert.Equal(types.EventEntityCreated, client.events[0].TxType)
+	assert.Equal("TEST_C", client.events[0].TriggeredBy)
+	assert.Equal("Compacted2", client.events[0].Body["name"])
+}
+
+func (s *WorkerTestSuite) TestHistoryRetention() {
+	assert := s.Require()
+
+	historyConf := &db.Config().History
+	defaultHistoryConf := *historyConf
+	defer func() {
+		db.Config().History = defaultHistoryConf
+	}()
+	historyConf.AttributeTypes = []string{"HistoryAttribute"}
+	historyConf.MaxAge = 0
+	historyConf.MaxPoints = 2
+	historyConf.RetentionInterval = time.Hour
+
+	item := &models.Item{ID: "History-ID", Name: "History", Type: "FakeItem"}
+	assert.NoError(services.CreateItem(item, modifiedByTest))
+	attribute := &models.Attribute{ID: "HistoryAttribute-ID", ItemID: item.ID, Name: "History", Type: "HistoryAttribute"}
+	assert.NoError(services.CreateAttribute(attribute, modifiedByTest))
+
+	updateValue := func(value string) {
+		err := services.UpdateAttribute(map[string]interface{}{
+			"id":    attribute.ID,
+			"value": value,
+		}, modifiedByTest)
+		assert.NoError(err)
+	}
+
+	historyCount := func() int64 {
+		count, err := services.GetAttributeHistoryCount(attribute.ID)
+		assert.NoError(err)
+		return count
+	}
+
+	for _, value := range []string{"1", "2", "3"} {
+		updateValue(value)
+	}
+	assert.EqualValues(4, historyCount())
+
+	conf := testSyncConfig()
+	conf.PublishVersions = false
+
+	worker, err := NewWorker(conf)
+	assert.NoError(err)
+
+	// Retention doesn't depend on the connection
+	client := &testClient{connectErr: ErrNotConnected}
+	worker.client = client
+
+	err = worker.Start()
+	assert.NoError(err)
+
+	// The limits are applied on start, then once per interval
+	assert.Eventually(func() bool {
+		return historyCount() == 2
+	}, timeout, tick)
+
+	updateValue("4")
+	assert.Never(func() bool {
+		return historyCount() != 3
+	}, 5*tick, tick)
+
+	err = worker.Stop()
+	assert.NoError(err)
+}
+
+func (s *WorkerTestSuite) TestDelivery() {
+	assert := s.Require()
+
+	conf := testSyncConfig()
+	conf.PublishVersions = false
+	conf.Delivery.AckEnabled = true
+	conf.Delivery.AckTimeout = time.Hour
+
+	worker, err := NewWorker(conf)
+	assert.NoError(err)
+
+	client := &testClient{}
+	worker.client = client
+
+	publishedCount := func() int {
+		client.Lock()
+		defer client.Unlock()
+		return len(client.events)
+	}
+
+	lastEvent := func() messages.Event {
+		client.Lock()
+		defer client.Unlock()
+		return client.events[len(client.events)-1]
+	}
+
+	assertQueued := func(expected int64) {
+		count, err := services.GetEventsCount()
+		assert.NoError(err)
+		assert.Equal(expected, count)
+	}
+
+	newItem := func(id string) {
+		item := &models.Item{ID: id, Name: id, Type: "FakeItem"}
+		assert.NoError(services.CreateItem(item, modifiedByTest))
+	}
+
+	newItem("Delivered00-ID")
+
+	err = worker.Start()
+	assert.NoError(err)
+
+	assert.Eventually(func() bool {
+		return publishedCount() == 1
+	}, timeout, tick)
+
+	// Events stay in the queue until acknowledged
+	firstBatch := lastEvent().BatchID
+	assert.NotEmpty(firstBatch)
+	assertQueued(1)
+
+	// No other batch is delivered while one is in-flight
+	newItem("Delivered01-ID")
+	worker.signalEvent()
+	time.Sleep(5 * tick)
+	assert.Equal(1, publishedCount())
+
+	// Rejected events are delivered again, with newer ones
+	client.eventsAckCb(&messages.EventsAck{BatchID: firstBatch, Success: false, Error: "test"})
+
+	assert.Eventually(func() bool {
+		return publishedCount() == 3
+	}, timeout, tick)
+
+	secondBatch := lastEvent().BatchID
+	assert.NotEqual(firstBatch, secondBatch)
+	assertQueued(2)
+
+	// Late acknowledgements of released batches are ignored
+	client.eventsAckCb(&messages.EventsAck{BatchID: firstBatch, Success: true})
+	assertQueued(2)
+
+	client.eventsAckCb(&messages.EventsAck{BatchID: secondBatch, Success: true})
+	assertQueued(0)
+
+	err = worker.Stop()
+	assert.NoError(err)
+
+	// Events not acknowledged in time are delivered again
+	conf.Delivery.AckTimeout = tick
+	worker, err = NewWorker(conf)
+	assert.NoError(err)
+	worker.client = client
+
+	newItem("Delivered02-ID")
+
+	err = worker.Start()
+	assert.NoError(err)
+
+	assert.Eventually(func() bool {
+		return publishedCount() >= 5
+	}, timeout, tick)
+
+	err = worker.Stop()
+	assert.NoError(err)
+
+	client.Lock()
+	defer client.Unlock()
+
+	assert.Equal("Delivered02-ID", client.events[3].EntityID)
+	assert.Equal(client.events[3].ID, client.events[4].ID)
+	assert.NotEqual(client.events[3].BatchID, client.events[4].BatchID)
+}
+
 func TestWorker(t *testing.T) {
 	suite.Run(t, new(WorkerTestSuite))
 }
@@ -0,0 +1,27 @@
+package types
+
+// ConflictStrategy is the strategy used to resolve conflicts between
+// local edits and changes coming from the server application
+type ConflictStrategy string
+
+const (
+	// ConflictStrategyServerWins always applies server changes
+	ConflictStrategyServerWins ConflictStrategy = "SERVER_WINS"
+	// ConflictStrategyEdgeWins always keeps local changes
+	ConflictStrategyEdgeWins ConflictStrategy = "EDGE_WINS"
+	// ConflictStrategyLastModifiedWins keeps the most recent change, comparing modified_at
+	ConflictStrategyLastModifiedWins ConflictStrategy = "LAST_MODIFIED_WINS"
+	// ConflictStrategyLastSourceWins keeps the most recent change, comparing source_timestamp
+	ConflictStrategyLastSourceWins ConflictStrategy = "LAST_SOURCE_TIMESTAMP_WINS"
+	// ConflictStrategyMergeFields applies server changes only to fields not edited locally
+	ConflictStrategyMergeFields ConflictStrategy = "MERGE_FIELDS"
+)
+
+// ConflictResolution is the outcome of a resolved conflict
+type ConflictResolution string
+
+const (
+	ConflictResolutionServer ConflictResolution = "SERVER"
+	ConflictResolutionEdge   ConflictResolution = "EDGE"
+	ConflictResolutionMerged ConflictResolution = "MERGED"
+)
@@ -6,4 +6,7 @@ const (
 	EventEntityCreated EventType = "ENTITY_CREATED"
 	EventEntityUpdated EventType = "ENTITY_UPDATED"
 	EventEntityDeleted EventType = "ENTITY_DELETED"
+	// EventEntityConflict is published when a conflict between local edits
+	// and server changes is detected
+	EventEntityConflict EventType = "ENTITY_CONFLICT"
 )
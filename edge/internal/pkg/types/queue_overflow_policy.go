@@ -0,0 +1,17 @@
+package types
+
+// QueueOverflowPolicy is the policy applied when the events queue is full
+type QueueOverflowPolicy string
+
+const (
+	// QueueOverflowDropOldest removes the oldest events to make room for new ones
+	QueueOverflowDropOldest QueueOverflowPolicy = "DROP_OLDEST"
+	// QueueOverflowDropDuplicates compacts the events of the same entity,
+	// rejecting new events if the queue is still full.
+	// The queue is compacted at most once every few seconds.
+	QueueOverflowDropDuplicates QueueOverflowPolicy = "DROP_DUPLICATES"
+	// QueueOverflowReject rejects new events, failing the write that triggered them
+	QueueOverflowReject QueueOverflowPolicy = "REJECT"
+	// QueueOverflowSpill moves the oldest events to compressed archive files
+	QueueOverflowSpill QueueOverflowPolicy = "SPILL"
+)